@@ -8,8 +8,12 @@ package main
 
 // Golang std libs
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"os/user"
 	"path"
@@ -77,6 +81,52 @@ func checkRequestStatus(httpstatus, expected int, body []byte) error {
 	return nil
 }
 
+// authRequest performs a request, authenticated by the session
+// token, on the auth APIs exposed at path. The address and port of
+// the service are read from the parent command flags. If body is
+// not nil it's JSON encoded, the response body is returned.
+func authRequest(parent *cobra.Command, method, path string, body interface{}, expected int) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewBuffer(data)
+	}
+
+	// create http request
+	client := &http.Client{}
+	authAddress := viper.GetString(viperLabel(parent, "authaddress"))
+	authPort := viper.GetInt(viperLabel(parent, "authport"))
+	req, err := http.NewRequest(
+		method,
+		fmt.Sprintf("%s:%d%s", authAddress, authPort, path),
+		reader)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the request %s", err.Error())
+	}
+	req.Header.Set(ct.SecurityTokenKey, pss.Token)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	// execute request
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to perform the request cause %s", err.Error())
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	// check for errors
+	err = checkRequestStatus(resp.StatusCode, expected, respBody)
+	if err != nil {
+		return nil, err
+	}
+	return respBody, nil
+}
+
 func initConfig() {
 	usr, err := user.Current()
 	if err != nil {
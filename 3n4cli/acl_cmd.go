@@ -0,0 +1,187 @@
+//
+// 3nigm4 3n4cli package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"fmt"
+	"strings"
+)
+
+// Internal dependencies
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+	"github.com/nexocrew/3nigm4/lib/logger"
+)
+
+// Third party libs
+import (
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// AclCmd manages access permissions of remote resources.
+var AclCmd = &cobra.Command{
+	Use:       "acl",
+	Short:     "Manages remote resources access permissions",
+	Long:      "Updates access permissions of owned remote resources, starting from a reference file, and lists resources shared by other users.",
+	Example:   "3n4cli store acl",
+	ValidArgs: []string{"permission", "add", "remove", "shared"},
+}
+
+// AclPermissionCmd sets the permission type of remote resources.
+var AclPermissionCmd = &cobra.Command{
+	Use:     "permission",
+	Short:   "Sets resources permission type",
+	Long:    "Sets the permission type (0 private, 1 shared, 2 public) of all remote resources pointed by a reference file.",
+	Example: "3n4cli store acl permission -r /tmp/resources.3rf -p 1",
+	RunE:    aclPermission,
+}
+
+// AclAddCmd adds users to the sharing list of remote resources.
+var AclAddCmd = &cobra.Command{
+	Use:     "add",
+	Short:   "Adds sharing users",
+	Long:    "Adds users to the sharing list of all remote resources pointed by a reference file, users can access resources only if the permission type is shared.",
+	Example: "3n4cli store acl add -r /tmp/resources.3rf --sharingusers userB,userC",
+	RunE:    aclAdd,
+}
+
+// AclRemoveCmd removes users from the sharing list of remote
+// resources.
+var AclRemoveCmd = &cobra.Command{
+	Use:     "remove",
+	Short:   "Removes sharing users",
+	Long:    "Removes users from the sharing list of all remote resources pointed by a reference file.",
+	Example: "3n4cli store acl remove -r /tmp/resources.3rf --sharingusers userB",
+	RunE:    aclRemove,
+}
+
+// AclSharedCmd lists resources shared with the logged in user.
+var AclSharedCmd = &cobra.Command{
+	Use:     "shared",
+	Short:   "Lists resources shared with me",
+	Long:    "Lists remote resources other users have shared with the logged in user.",
+	Example: "3n4cli store acl shared",
+	RunE:    aclShared,
+}
+
+// sharingUsersArgument returns the not empty user names passed
+// as comma separated sharing users argument.
+func sharingUsersArgument(cmd *cobra.Command) []string {
+	var users []string
+	for _, user := range strings.Split(viper.GetString(viperLabel(cmd, "sharingusers")), ",") {
+		user = strings.TrimSpace(user)
+		if user != "" {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+// updateReferenceAcl updates the acl of all chunks pointed by the
+// reference file passed as argument to the command.
+func updateReferenceAcl(cmd *cobra.Command, permission *ct.Permission, addUsers, removeUsers []string) error {
+	// check for token presence
+	if pss.authToken() == "" {
+		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
+	}
+
+	// get reference
+	reference, err := loadReferenceFile(viper.GetString(viperLabel(cmd, "referencein")))
+	if err != nil {
+		return err
+	}
+
+	// create new store manager
+	ds, err, errc := newStorageClient()
+	if err != nil {
+		return err
+	}
+	defer ds.Close()
+	go manageAsyncErrors(errc)
+
+	// update resources from reference
+	err = ds.UpdateAcl(reference.ChunksPaths, permission, addUsers, removeUsers)
+	if err != nil {
+		return err
+	}
+
+	log.MessageLog("Successfully updated %d chunks.\n", len(reference.ChunksPaths))
+
+	return nil
+}
+
+// aclPermission sets the permission type of the reference chunks.
+func aclPermission(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	permission := ct.Permission(viper.GetInt(viperLabel(cmd, "permission")))
+	return updateReferenceAcl(cmd, &permission, nil, nil)
+}
+
+// aclAdd adds users to the reference chunks sharing list.
+func aclAdd(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	users := sharingUsersArgument(cmd)
+	if len(users) == 0 {
+		return fmt.Errorf("at least a sharing user is required")
+	}
+	return updateReferenceAcl(cmd, nil, users, nil)
+}
+
+// aclRemove removes users from the reference chunks sharing list.
+func aclRemove(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	users := sharingUsersArgument(cmd)
+	if len(users) == 0 {
+		return fmt.Errorf("at least a sharing user is required")
+	}
+	return updateReferenceAcl(cmd, nil, nil, users)
+}
+
+// aclShared retrieves and shows resources shared with the logged
+// in user.
+func aclShared(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	// check for token presence
+	if pss.authToken() == "" {
+		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
+	}
+
+	// create new store manager
+	ds, err, errc := newStorageClient()
+	if err != nil {
+		return err
+	}
+	defer ds.Close()
+	go manageAsyncErrors(errc)
+
+	resources, err := ds.SharedResources()
+	if err != nil {
+		return err
+	}
+	// create output logger
+	lg := logger.NewLogger(
+		color.New(color.BgBlack, color.FgHiWhite),
+		"",
+		"",
+		false,
+		true,
+	)
+	// print out resources infos
+	lg.Printf("Shared with me (%d resources):\n", len(resources))
+	for _, resource := range resources {
+		lg.Printf("\t%s: owner %s size %.3f Mb created %s\n",
+			resource.ID,
+			resource.Owner,
+			float64(resource.Size)/convMegaByte,
+			resource.Creation.Local().String())
+	}
+
+	return nil
+}
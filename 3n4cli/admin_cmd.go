@@ -0,0 +1,381 @@
+//
+// 3nigm4 3n4cli package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Internal dependencies
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+	"github.com/nexocrew/3nigm4/lib/logger"
+)
+
+// Third party libs
+import (
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	adminUsersPath    = "/v1/admin/users"
+	adminSessionsPath = "/v1/admin/sessions"
+)
+
+// serviceLevels maps the permission level names, used by the
+// services flag, to their values.
+var serviceLevels = map[string]uint{
+	"user":  0,
+	"admin": 1,
+}
+
+// AdminCmd groups the super-admin commands.
+var AdminCmd = &cobra.Command{
+	Use:       "admin",
+	Short:     "Administers users and sessions",
+	Long:      "Manages users and sessions of the authentication service, reserved to super-admins.",
+	Example:   "3n4cli admin",
+	ValidArgs: []string{"user", "sessions"},
+}
+
+// AdminUserCmd manages users.
+var AdminUserCmd = &cobra.Command{
+	Use:       "user",
+	Short:     "Manages users",
+	Long:      "Adds, updates, disables, removes or lists the users of the authentication service.",
+	Example:   "3n4cli admin user",
+	ValidArgs: []string{"add", "update", "disable", "remove", "list"},
+}
+
+// AdminUserAddCmd adds a new user.
+var AdminUserAddCmd = &cobra.Command{
+	Use:     "add",
+	Short:   "Adds a user",
+	Long:    "Adds a new user, the password is asked interactively.",
+	Example: "3n4cli admin user add -u username --fullname \"User Name\" --email user@email.com --services storage:user,ishtm:user",
+	RunE:    adminUserAdd,
+}
+
+// AdminUserUpdateCmd updates an existing user.
+var AdminUserUpdateCmd = &cobra.Command{
+	Use:     "update",
+	Short:   "Updates a user",
+	Long:    "Updates the properties of an existing user, only the specified flags are modified.",
+	Example: "3n4cli admin user update -u username --services storage:admin",
+	RunE:    adminUserUpdate,
+}
+
+// AdminUserDisableCmd disables an existing user.
+var AdminUserDisableCmd = &cobra.Command{
+	Use:     "disable",
+	Short:   "Disables a user",
+	Long:    "Disables an existing user closing all its sessions, the user can be enabled again with the update command.",
+	Example: "3n4cli admin user disable -u username",
+	RunE:    adminUserDisable,
+}
+
+// AdminUserRemoveCmd removes an existing user.
+var AdminUserRemoveCmd = &cobra.Command{
+	Use:     "remove",
+	Short:   "Removes a user",
+	Long:    "Removes an existing user closing all its sessions.",
+	Example: "3n4cli admin user remove -u username",
+	RunE:    adminUserRemove,
+}
+
+// AdminUserListCmd lists users.
+var AdminUserListCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "Lists users",
+	Long:    "Lists all the users or, if a username is specified, only the required one.",
+	Example: "3n4cli admin user list",
+	RunE:    adminUserList,
+}
+
+// AdminSessionsCmd manages sessions.
+var AdminSessionsCmd = &cobra.Command{
+	Use:       "sessions",
+	Short:     "Manages sessions",
+	Long:      "Manages the active sessions of the authentication service.",
+	Example:   "3n4cli admin sessions",
+	ValidArgs: []string{"kick"},
+}
+
+// AdminSessionsKickCmd removes all the active sessions.
+var AdminSessionsKickCmd = &cobra.Command{
+	Use:     "kick",
+	Short:   "Kicks out all sessions",
+	Long:    "Removes all the active sessions, including the one used by the command: a new login is required.",
+	Example: "3n4cli admin sessions kick",
+	RunE:    adminSessionsKick,
+}
+
+// parseServices parses the services flag, formatted as a list of
+// <service>:<level>, in a permissions map.
+func parseServices(services []string) (map[string]uint, error) {
+	result := make(map[string]uint)
+	for _, service := range services {
+		tokens := strings.Split(service, ":")
+		if len(tokens) != 2 ||
+			tokens[0] == "" {
+			return nil, fmt.Errorf("malformed service permission %s, should be <service>:<level>", service)
+		}
+		level, ok := serviceLevels[tokens[1]]
+		if !ok {
+			return nil, fmt.Errorf("unknown permission level %s, should be \"user\" or \"admin\"", tokens[1])
+		}
+		result[tokens[0]] = level
+	}
+	return result, nil
+}
+
+// applyUserFlags sets, in the user, the properties specified by
+// the command flags: if all is false only changed flags are used.
+func applyUserFlags(cmd *cobra.Command, user *ct.AdminUser, all bool) error {
+	flags := cmd.Flags()
+	if all || flagChanged(flags, "fullname") {
+		user.FullName = viper.GetString(viperLabel(cmd, "fullname"))
+	}
+	if all || flagChanged(flags, "email") {
+		user.Email = viper.GetString(viperLabel(cmd, "email"))
+	}
+	if all || flagChanged(flags, "superadmin") {
+		user.SuperAdmin = viper.GetBool(viperLabel(cmd, "superadmin"))
+	}
+	if all || flagChanged(flags, "disabled") {
+		user.Disabled = viper.GetBool(viperLabel(cmd, "disabled"))
+	}
+	if all || flagChanged(flags, "services") {
+		services, err := parseServices(viper.GetStringSlice(viperLabel(cmd, "services")))
+		if err != nil {
+			return err
+		}
+		user.Services = services
+	}
+	return nil
+}
+
+// adminUsername returns the username required by user commands.
+func adminUsername() (string, error) {
+	// check for token presence
+	if pss.Token == "" {
+		return "", fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
+	}
+	username := viper.GetString(viperLabel(AdminUserCmd, "username"))
+	if username == "" {
+		return "", fmt.Errorf("unable to administer a user with nil username")
+	}
+	return username, nil
+}
+
+// getAdminUser retrieves an existing user.
+func getAdminUser(username string) (*ct.AdminUser, error) {
+	respBody, err := authRequest(AdminCmd, "GET", adminUsersPath+"/"+username, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	var user ct.AdminUser
+	err = json.Unmarshal(respBody, &user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// printAdminUser prints out user properties.
+func printAdminUser(lg *logger.Logger, user *ct.AdminUser) {
+	flags := make([]string, 0)
+	if user.SuperAdmin {
+		flags = append(flags, "super-admin")
+	}
+	if user.Disabled {
+		flags = append(flags, "disabled")
+	}
+	if user.TotpEnabled {
+		flags = append(flags, "2fa")
+	}
+	lg.Printf("\t%s: %s <%s> %s\n",
+		user.Username,
+		user.FullName,
+		user.Email,
+		strings.Join(flags, ","))
+	if len(user.Services) != 0 {
+		services := make([]string, 0, len(user.Services))
+		for service, level := range user.Services {
+			name := "user"
+			if level == serviceLevels["admin"] {
+				name = "admin"
+			}
+			services = append(services, service+":"+name)
+		}
+		sort.Strings(services)
+		lg.Printf("\t\tservices %s\n", strings.Join(services, ","))
+	}
+}
+
+// adminUserAdd adds a new user.
+func adminUserAdd(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	username, err := adminUsername()
+	if err != nil {
+		return err
+	}
+
+	user := &ct.AdminUser{
+		Username: username,
+	}
+	err = applyUserFlags(cmd, user, true)
+	if err != nil {
+		return err
+	}
+	pwd, err := readNewPassword()
+	if err != nil {
+		return err
+	}
+	// passwords are composed with the user name
+	user.Password = hexComposedPassword(username, pwd)
+
+	_, err = authRequest(AdminCmd, "POST", adminUsersPath, user, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	log.MessageLog("User %s added.\n", username)
+
+	return nil
+}
+
+// adminUserUpdate updates the properties of an existing user.
+func adminUserUpdate(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	username, err := adminUsername()
+	if err != nil {
+		return err
+	}
+
+	user, err := getAdminUser(username)
+	if err != nil {
+		return err
+	}
+	err = applyUserFlags(cmd, user, false)
+	if err != nil {
+		return err
+	}
+	_, err = authRequest(AdminCmd, "PUT", adminUsersPath+"/"+username, user, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	log.MessageLog("User %s updated.\n", username)
+
+	return nil
+}
+
+// adminUserDisable disables an existing user.
+func adminUserDisable(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	username, err := adminUsername()
+	if err != nil {
+		return err
+	}
+
+	user, err := getAdminUser(username)
+	if err != nil {
+		return err
+	}
+	user.Disabled = true
+	_, err = authRequest(AdminCmd, "PUT", adminUsersPath+"/"+username, user, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	log.MessageLog("User %s disabled.\n", username)
+
+	return nil
+}
+
+// adminUserRemove removes an existing user.
+func adminUserRemove(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	username, err := adminUsername()
+	if err != nil {
+		return err
+	}
+
+	_, err = authRequest(AdminCmd, "DELETE", adminUsersPath+"/"+username, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	log.MessageLog("User %s removed.\n", username)
+
+	return nil
+}
+
+// adminUserList prints out all the users or the required one.
+func adminUserList(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	// check for token presence
+	if pss.Token == "" {
+		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
+	}
+
+	var users []ct.AdminUser
+	username := viper.GetString(viperLabel(AdminUserCmd, "username"))
+	if username != "" {
+		user, err := getAdminUser(username)
+		if err != nil {
+			return err
+		}
+		users = append(users, *user)
+	} else {
+		respBody, err := authRequest(AdminCmd, "GET", adminUsersPath, nil, http.StatusOK)
+		if err != nil {
+			return err
+		}
+		var list ct.AdminUsersResponse
+		err = json.Unmarshal(respBody, &list)
+		if err != nil {
+			return err
+		}
+		users = list.Users
+	}
+	// create output logger
+	lg := logger.NewLogger(
+		color.New(color.BgBlack, color.FgHiWhite),
+		"",
+		"",
+		false,
+		true,
+	)
+	lg.Printf("Users (%d):\n", len(users))
+	for idx := range users {
+		printAdminUser(lg, &users[idx])
+	}
+
+	return nil
+}
+
+// adminSessionsKick removes all the active sessions.
+func adminSessionsKick(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	// check for token presence
+	if pss.Token == "" {
+		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
+	}
+
+	_, err := authRequest(AdminCmd, "DELETE", adminSessionsPath, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	log.MessageLog("All sessions removed, please login again.\n")
+
+	return nil
+}
@@ -0,0 +1,191 @@
+//
+// 3nigm4 3n4cli package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Internal dependencies
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+	"github.com/nexocrew/3nigm4/lib/logger"
+)
+
+// Third party libs
+import (
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	apikeyPath = "/v1/authsession/apikey"
+)
+
+// ApikeyCmd manages the api keys of the logged in user.
+var ApikeyCmd = &cobra.Command{
+	Use:       "apikey",
+	Short:     "Manages api keys",
+	Long:      "Creates, lists or revokes the long lived api keys used by automated clients in place of a session: set the " + apiKeyEnv + " environment variable to use a key.",
+	Example:   "3n4cli apikey",
+	ValidArgs: []string{"create", "list", "revoke"},
+}
+
+// ApikeyCreateCmd creates a new api key.
+var ApikeyCreateCmd = &cobra.Command{
+	Use:     "create",
+	Short:   "Creates an api key",
+	Long:    "Creates a new api key restricted to the required scopes and, optionally, to a set of source addresses and a validity period. The key is shown only once.",
+	Example: "3n4cli apikey create --keyname backup --scopes storage:upload,storage:read --allowedaddresses 10.0.0.0/24 --keyttl 720h",
+	RunE:    apikeyCreate,
+}
+
+// ApikeyListCmd lists the user api keys.
+var ApikeyListCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "Lists api keys",
+	Long:    "Lists the api keys of the logged in user.",
+	Example: "3n4cli apikey list",
+	RunE:    apikeyList,
+}
+
+// ApikeyRevokeCmd revokes an api key.
+var ApikeyRevokeCmd = &cobra.Command{
+	Use:     "revoke",
+	Short:   "Revokes an api key",
+	Long:    "Revokes an api key, it can not be used anymore to access services.",
+	Example: "3n4cli apikey revoke --keyid 0011223344556677",
+	RunE:    apikeyRevoke,
+}
+
+// printApiKeyInfo prints out api key properties.
+func printApiKeyInfo(lg *logger.Logger, info *ct.ApiKeyInfo) {
+	lg.Printf("\t%s: %s scopes %s created %s\n",
+		info.ID,
+		info.Name,
+		strings.Join(info.Scopes, ","),
+		info.Creation.Local().String())
+	if len(info.AllowedAddresses) != 0 {
+		lg.Printf("\t\tallowed from %s\n", strings.Join(info.AllowedAddresses, ","))
+	}
+	if info.Expiration != nil {
+		lg.Printf("\t\texpires %s\n", info.Expiration.Local().String())
+	}
+	if info.LastUsed != nil {
+		lg.Printf("\t\tlast used %s\n", info.LastUsed.Local().String())
+	}
+}
+
+// apikeyCreate creates a new api key printing it out.
+func apikeyCreate(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	// check for token presence
+	if pss.Token == "" {
+		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
+	}
+
+	request := &ct.ApiKeyRequest{
+		Name:             viper.GetString(viperLabel(cmd, "keyname")),
+		Scopes:           viper.GetStringSlice(viperLabel(cmd, "scopes")),
+		AllowedAddresses: viper.GetStringSlice(viperLabel(cmd, "allowedaddresses")),
+	}
+	if request.Name == "" ||
+		len(request.Scopes) == 0 {
+		return fmt.Errorf("name and scopes are required to create an api key")
+	}
+	ttl := viper.GetDuration(viperLabel(cmd, "keyttl"))
+	if ttl < 0 {
+		return fmt.Errorf("invalid negative api key validity")
+	}
+	if ttl != 0 {
+		expiration := time.Now().Add(ttl)
+		request.Expiration = &expiration
+	}
+
+	respBody, err := authRequest(ApikeyCmd, "POST", apikeyPath, request, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	var created ct.ApiKeyResponse
+	err = json.Unmarshal(respBody, &created)
+	if err != nil {
+		return err
+	}
+	// create output logger
+	lg := logger.NewLogger(
+		color.New(color.BgBlack, color.FgHiWhite),
+		"",
+		"",
+		false,
+		true,
+	)
+	lg.Printf("Api key created, store it in a safe place (it can not be retrieved anymore):\n")
+	lg.Printf("\t%s\n", created.Key)
+	printApiKeyInfo(lg, &created.Info)
+
+	return nil
+}
+
+// apikeyList prints out the user api keys.
+func apikeyList(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	// check for token presence
+	if pss.Token == "" {
+		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
+	}
+
+	respBody, err := authRequest(ApikeyCmd, "GET", apikeyPath, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	var list ct.ApiKeysResponse
+	err = json.Unmarshal(respBody, &list)
+	if err != nil {
+		return err
+	}
+	// create output logger
+	lg := logger.NewLogger(
+		color.New(color.BgBlack, color.FgHiWhite),
+		"",
+		"",
+		false,
+		true,
+	)
+	lg.Printf("Api keys (%d):\n", len(list.Keys))
+	for idx := range list.Keys {
+		printApiKeyInfo(lg, &list.Keys[idx])
+	}
+
+	return nil
+}
+
+// apikeyRevoke revokes an api key.
+func apikeyRevoke(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	// check for token presence
+	if pss.Token == "" {
+		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
+	}
+
+	id := viper.GetString(viperLabel(cmd, "keyid"))
+	if id == "" {
+		return fmt.Errorf("unable to revoke an api key with nil id")
+	}
+	_, err := authRequest(ApikeyCmd, "DELETE", apikeyPath+"/"+id, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	log.MessageLog("Api key %s revoked.\n", id)
+
+	return nil
+}
@@ -291,6 +291,48 @@ var am map[string]cliArguments = map[string]cliArguments{
 		usage:     "time to live of the uploaded resource in nanoseconds, if zero no time to live is defined",
 		kind:      Duration,
 	},
+	"singleuse": cliArguments{
+		name:      "singleuse",
+		shorthand: "",
+		value:     false,
+		usage:     "each resource can be downloaded only once using the created link",
+		kind:      Bool,
+	},
+	"references": cliArguments{
+		name:      "references",
+		shorthand: "",
+		value:     "",
+		usage:     "paths of the reference files pointing to the resources that should be preserved, comma separated",
+		kind:      String,
+	},
+	"minage": cliArguments{
+		name:      "minage",
+		shorthand: "",
+		value:     int(time.Hour),
+		usage:     "resources created more recently than this duration are never removed, it protects in progress uploads",
+		kind:      Duration,
+	},
+	"dryrun": cliArguments{
+		name:      "dryrun",
+		shorthand: "",
+		value:     false,
+		usage:     "only lists the resources that would be removed",
+		kind:      Bool,
+	},
+	"since": cliArguments{
+		name:      "since",
+		shorthand: "",
+		value:     0,
+		usage:     "only events occurred in this period, before now, are shown, if zero all retained events are shown",
+		kind:      Duration,
+	},
+	"link": cliArguments{
+		name:      "link",
+		shorthand: "",
+		value:     "",
+		usage:     "anonymous download link token, if defined no login is required",
+		kind:      String,
+	},
 	"permission": cliArguments{
 		name:      "permission",
 		shorthand: "p",
@@ -368,6 +410,97 @@ var am map[string]cliArguments = map[string]cliArguments{
 		usage:     "the ID for a \"ishtm will\" record",
 		kind:      String,
 	},
+	"keyname": cliArguments{
+		name:      "keyname",
+		shorthand: "",
+		value:     "",
+		usage:     "descriptive name of the api key",
+		kind:      String,
+	},
+	"scopes": cliArguments{
+		name:      "scopes",
+		shorthand: "",
+		value:     []string{},
+		usage:     "operations allowed to the api key in the form <service>:<operation> (for example storage:upload or ishtm:*), comma separated",
+		kind:      StringSlice,
+	},
+	"allowedaddresses": cliArguments{
+		name:      "allowedaddresses",
+		shorthand: "",
+		value:     []string{},
+		usage:     "IP addresses or CIDR networks allowed to use the api key, comma separated, if empty any address is allowed",
+		kind:      StringSlice,
+	},
+	"keyttl": cliArguments{
+		name:      "keyttl",
+		shorthand: "",
+		value:     0,
+		usage:     "validity of the api key in nanoseconds, if zero the key never expires",
+		kind:      Duration,
+	},
+	"keyid": cliArguments{
+		name:      "keyid",
+		shorthand: "",
+		value:     "",
+		usage:     "the ID of the api key",
+		kind:      String,
+	},
+	"resettoken": cliArguments{
+		name:      "resettoken",
+		shorthand: "",
+		value:     "",
+		usage:     "the password reset token received by email",
+		kind:      String,
+	},
+	"sessionid": cliArguments{
+		name:      "sessionid",
+		shorthand: "",
+		value:     "",
+		usage:     "the ID of the session, as returned by \"sessions list\"",
+		kind:      String,
+	},
+	"all": cliArguments{
+		name:      "all",
+		shorthand: "",
+		value:     false,
+		usage:     "revoke all the sessions except the one used by the command",
+		kind:      Bool,
+	},
+	"fullname": cliArguments{
+		name:      "fullname",
+		shorthand: "",
+		value:     "",
+		usage:     "the full name of the user",
+		kind:      String,
+	},
+	"email": cliArguments{
+		name:      "email",
+		shorthand: "",
+		value:     "",
+		usage:     "the email address of the user, used to deliver password reset tokens",
+		kind:      String,
+	},
+	"superadmin": cliArguments{
+		name:      "superadmin",
+		shorthand: "",
+		value:     false,
+		usage:     "grant super-admin permissions to the user",
+		kind:      Bool,
+	},
+	"services": cliArguments{
+		name:      "services",
+		shorthand: "",
+		value:     []string{},
+		usage:     "per service permissions of the user, comma separated, as <service>:<level> where level is \"user\" or \"admin\" and service \"all\" applies to any service: users can not access services without a level",
+		kind:      StringSlice,
+	},
+	"disabled": cliArguments{
+		name:      "disabled",
+		shorthand: "",
+		value:     false,
+		usage:     "disable the user, disabled users can not login",
+		kind:      Bool,
+	},
 	"secondary": cliArguments{
 		name:      "secondary",
 		shorthand: "",
@@ -0,0 +1,100 @@
+//
+// 3nigm4 3n4cli package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"fmt"
+	"time"
+)
+
+// Internal dependencies
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+	"github.com/nexocrew/3nigm4/lib/logger"
+)
+
+// Third party libs
+import (
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// AuditCmd lists the audit events about owned remote resources.
+var AuditCmd = &cobra.Command{
+	Use:     "audit",
+	Short:   "Lists resources access events",
+	Long:    "Lists uploads, downloads, deletions, acl updates and denied accesses recorded on remote resources owned by the logged in user. If a reference file is passed only events about its chunks are shown.",
+	Example: "3n4cli store audit -r /tmp/resources.3rf --since 24h",
+	RunE:    audit,
+}
+
+// audit retrieves and shows audit events of owned resources.
+func audit(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	// check for token presence
+	if pss.authToken() == "" {
+		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
+	}
+	var since time.Time
+	if period := viper.GetDuration(viperLabel(cmd, "since")); period > 0 {
+		since = time.Now().Add(-period)
+	}
+
+	// get reference, if any
+	resources := []string{""}
+	if refin := viper.GetString(viperLabel(cmd, "referencein")); refin != "" {
+		reference, err := loadReferenceFile(refin)
+		if err != nil {
+			return err
+		}
+		resources = reference.ChunksPaths
+	}
+
+	// create new store manager
+	ds, err, errc := newStorageClient()
+	if err != nil {
+		return err
+	}
+	defer ds.Close()
+	go manageAsyncErrors(errc)
+
+	var events []ct.AuditEvent
+	for _, resource := range resources {
+		resourceEvents, err := ds.AuditEvents(resource, since, 0)
+		if err != nil {
+			return err
+		}
+		events = append(events, resourceEvents...)
+	}
+	// create output logger
+	lg := logger.NewLogger(
+		color.New(color.BgBlack, color.FgHiWhite),
+		"",
+		"",
+		false,
+		true,
+	)
+	// print out audit events
+	lg.Printf("Audit events (%d events):\n", len(events))
+	for _, event := range events {
+		user := event.User
+		if user == "" {
+			user = "anonymous link"
+		}
+		lg.Printf("\t%s: %s %s by %s (%s) on %s\n",
+			event.Time.Local().String(),
+			event.Action,
+			event.Outcome,
+			user,
+			event.OriginIp,
+			event.Resource)
+	}
+
+	return nil
+}
@@ -81,7 +81,7 @@ const (
 func create(cmd *cobra.Command, args []string) error {
 	verbosePreRunInfos(cmd, args)
 	// check for token presence
-	if pss.Token == "" {
+	if pss.authToken() == "" {
 		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
 	}
 
@@ -136,7 +136,7 @@ func create(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("unable to prepare POST requst cause %s", err.Error())
 	}
-	req.Header.Set(ct.SecurityTokenKey, pss.Token)
+	req.Header.Set(ct.SecurityTokenKey, pss.authToken())
 	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("unable to perform request cause %s", err.Error())
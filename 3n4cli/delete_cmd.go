@@ -19,7 +19,6 @@ import (
 import (
 	crypto3n "github.com/nexocrew/3nigm4/lib/crypto"
 	fm "github.com/nexocrew/3nigm4/lib/filemanager"
-	sc "github.com/nexocrew/3nigm4/lib/storageclient"
 )
 
 // Third party libs
@@ -44,7 +43,7 @@ var DeleteCmd = &cobra.Command{
 func deleteReference(cmd *cobra.Command, args []string) error {
 	verbosePreRunInfos(cmd, args)
 	// check for token presence
-	if pss.Token == "" {
+	if pss.authToken() == "" {
 		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
 	}
 
@@ -55,12 +54,7 @@ func deleteReference(cmd *cobra.Command, args []string) error {
 	}
 
 	// create new store manager
-	ds, err, errc := sc.NewStorageClient(
-		viper.GetString(viperLabel(StoreCmd, "storageaddress")),
-		viper.GetInt(viperLabel(StoreCmd, "storageport")),
-		pss.Token,
-		viper.GetInt(viperLabel(StoreCmd, "workerscount")),
-		viper.GetInt(viperLabel(StoreCmd, "queuesize")))
+	ds, err, errc := newStorageClient()
 	if err != nil {
 		return err
 	}
@@ -40,7 +40,7 @@ var DeleteWillCmd = &cobra.Command{
 func deleteWill(cmd *cobra.Command, args []string) error {
 	verbosePreRunInfos(cmd, args)
 	// check for token presence
-	if pss.Token == "" {
+	if pss.authToken() == "" {
 		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
 	}
 
@@ -92,7 +92,7 @@ func deleteWill(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("unable to prepare DELETE request, cause %s", err.Error())
 	}
-	req.Header.Set(ct.SecurityTokenKey, pss.Token)
+	req.Header.Set(ct.SecurityTokenKey, pss.authToken())
 	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("unable to perform DELETE request, cause %s", err.Error())
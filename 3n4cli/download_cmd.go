@@ -34,7 +34,7 @@ import (
 var DownloadCmd = &cobra.Command{
 	Use:     "download",
 	Short:   "Download a resource",
-	Long:    "Downlaod starting from a local reference file remote resources.",
+	Long:    "Downlaod starting from a local reference file remote resources, an anonymous download link can be used instead of logging in.",
 	Example: "3n4cli store download -M -o /tmp/file.ext -r /tmp/resources.3rf -v",
 	RunE:    download,
 }
@@ -44,8 +44,11 @@ var DownloadCmd = &cobra.Command{
 // from the saved reference file.
 func download(cmd *cobra.Command, args []string) error {
 	verbosePreRunInfos(cmd, args)
-	// check for token presence
-	if pss.Token == "" {
+	// check for token presence, not required using a
+	// download link
+	link := viper.GetString(viperLabel(cmd, "link"))
+	if pss.authToken() == "" &&
+		link == "" {
 		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
 	}
 
@@ -66,12 +69,18 @@ func download(cmd *cobra.Command, args []string) error {
 	}
 
 	// create new store manager
-	ds, err, errc := sc.NewStorageClient(
-		viper.GetString(viperLabel(StoreCmd, "storageaddress")),
-		viper.GetInt(viperLabel(StoreCmd, "storageport")),
-		pss.Token,
-		viper.GetInt(viperLabel(StoreCmd, "workerscount")),
-		viper.GetInt(viperLabel(StoreCmd, "queuesize")))
+	var ds *sc.StorageClient
+	var errc <-chan error
+	if link != "" {
+		ds, err, errc = sc.NewStorageClientWithLink(
+			viper.GetString(viperLabel(StoreCmd, "storageaddress")),
+			viper.GetInt(viperLabel(StoreCmd, "storageport")),
+			link,
+			viper.GetInt(viperLabel(StoreCmd, "workerscount")),
+			viper.GetInt(viperLabel(StoreCmd, "queuesize")))
+	} else {
+		ds, err, errc = newStorageClient()
+	}
 	if err != nil {
 		return err
 	}
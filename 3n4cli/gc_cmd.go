@@ -0,0 +1,138 @@
+//
+// 3nigm4 3n4cli package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Internal dependencies
+import (
+	"github.com/nexocrew/3nigm4/lib/logger"
+)
+
+// Third party libs
+import (
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// GcCmd removes remote resources not referenced by any local
+// reference file.
+var GcCmd = &cobra.Command{
+	Use:     "gc",
+	Short:   "Removes orphaned remote resources",
+	Long:    "Lists all remote resources owned by the logged in user and removes the ones not pointed by any of the passed reference files. Resources created recently are skipped to avoid removing in progress uploads, use the dry run option to only list them.",
+	Example: "3n4cli store gc --references /tmp/a.3rf,/tmp/b.3rf --minage 24h --dryrun",
+	RunE:    gcResources,
+}
+
+// gcResources uses the storage client to remove the remote chunks
+// not referenced by the local reference files.
+func gcResources(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	// check for token presence
+	if pss.authToken() == "" {
+		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
+	}
+	minAge := viper.GetDuration(viperLabel(cmd, "minage"))
+	if minAge < 0 {
+		return fmt.Errorf("minimum age should not be negative")
+	}
+
+	// load references: an empty list would remove every resource
+	// so at least a reference is required.
+	referenced := make(map[string]bool)
+	var references int
+	for _, refin := range strings.Split(viper.GetString(viperLabel(cmd, "references")), ",") {
+		refin = strings.TrimSpace(refin)
+		if refin == "" {
+			continue
+		}
+		reference, err := loadReferenceFile(refin)
+		if err != nil {
+			return err
+		}
+		for _, id := range reference.ChunksPaths {
+			referenced[id] = true
+		}
+		references++
+	}
+	if references == 0 {
+		return fmt.Errorf("at least a reference file is required")
+	}
+
+	// create new store manager
+	ds, err, errc := newStorageClient()
+	if err != nil {
+		return err
+	}
+	defer ds.Close()
+	go manageAsyncErrors(errc)
+
+	// find orphaned resources
+	threshold := time.Now().Add(-minAge)
+	var orphans []string
+	var size int64
+	next := ""
+	for {
+		resources, cursor, err := ds.ListResources(next, 0)
+		if err != nil {
+			return err
+		}
+		for _, resource := range resources {
+			if referenced[resource.ID] ||
+				resource.Creation.After(threshold) {
+				continue
+			}
+			orphans = append(orphans, resource.ID)
+			size += resource.Size
+		}
+		if cursor == "" {
+			break
+		}
+		next = cursor
+	}
+
+	if viper.GetBool(viperLabel(cmd, "dryrun")) {
+		// create output logger
+		lg := logger.NewLogger(
+			color.New(color.BgBlack, color.FgHiWhite),
+			"",
+			"",
+			false,
+			true,
+		)
+		lg.Printf("Orphaned resources (%d resources, %.3f Mb):\n",
+			len(orphans),
+			float64(size)/convMegaByte)
+		for _, id := range orphans {
+			lg.Printf("\t%s\n", id)
+		}
+		return nil
+	}
+	if len(orphans) == 0 {
+		log.MessageLog("No orphaned resources found.\n")
+		return nil
+	}
+
+	// remove orphaned resources
+	err = ds.DeleteChunks("gc", orphans, nil)
+	if err != nil {
+		return err
+	}
+
+	log.MessageLog("Successfully removed %d orphaned chunks (%.3f Mb).\n",
+		len(orphans),
+		float64(size)/convMegaByte)
+
+	return nil
+}
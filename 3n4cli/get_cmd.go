@@ -65,7 +65,7 @@ func formatWillReference(w *ct.WillGetResponse) string {
 func get(cmd *cobra.Command, args []string) error {
 	verbosePreRunInfos(cmd, args)
 	// check for token presence
-	if pss.Token == "" {
+	if pss.authToken() == "" {
 		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
 	}
 
@@ -99,7 +99,7 @@ func get(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("unable to prepare GET request, cause %s", err.Error())
 	}
-	req.Header.Set(ct.SecurityTokenKey, pss.Token)
+	req.Header.Set(ct.SecurityTokenKey, pss.authToken())
 	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("unable to perform GET request, cause %s", err.Error())
@@ -99,6 +99,8 @@ func initStorage() {
 	setArgument(DownloadCmd, "output")
 	bindPFlag(DownloadCmd, "output")
 	bindPFlag(DownloadCmd, "referencein")
+	setArgument(DownloadCmd, "link")
+	bindPFlag(DownloadCmd, "link")
 
 	StoreCmd.AddCommand(InfoCmd)
 	setArgument(InfoCmd, "referencein")
@@ -107,6 +109,54 @@ func initStorage() {
 	StoreCmd.AddCommand(DeleteCmd)
 	setArgument(DeleteCmd, "referencein")
 	bindPFlag(DeleteCmd, "referencein")
+
+	StoreCmd.AddCommand(RenewCmd)
+	setArgument(RenewCmd, "referencein")
+	setArgument(RenewCmd, "timetolive")
+	bindPFlag(RenewCmd, "referencein")
+	bindPFlag(RenewCmd, "timetolive")
+
+	StoreCmd.AddCommand(UsageCmd)
+
+	StoreCmd.AddCommand(LinkCmd)
+	setArgument(LinkCmd, "referencein")
+	setArgument(LinkCmd, "timetolive")
+	setArgument(LinkCmd, "singleuse")
+	bindPFlag(LinkCmd, "referencein")
+	bindPFlag(LinkCmd, "timetolive")
+	bindPFlag(LinkCmd, "singleuse")
+
+	StoreCmd.AddCommand(GcCmd)
+	setArgument(GcCmd, "references")
+	setArgument(GcCmd, "minage")
+	setArgument(GcCmd, "dryrun")
+	bindPFlag(GcCmd, "references")
+	bindPFlag(GcCmd, "minage")
+	bindPFlag(GcCmd, "dryrun")
+
+	StoreCmd.AddCommand(AuditCmd)
+	setArgument(AuditCmd, "referencein")
+	setArgument(AuditCmd, "since")
+	bindPFlag(AuditCmd, "referencein")
+	bindPFlag(AuditCmd, "since")
+
+	StoreCmd.AddCommand(AclCmd)
+	AclCmd.AddCommand(AclPermissionCmd)
+	setArgument(AclPermissionCmd, "referencein")
+	setArgument(AclPermissionCmd, "permission")
+	bindPFlag(AclPermissionCmd, "referencein")
+	bindPFlag(AclPermissionCmd, "permission")
+	AclCmd.AddCommand(AclAddCmd)
+	setArgument(AclAddCmd, "referencein")
+	setArgument(AclAddCmd, "sharingusers")
+	bindPFlag(AclAddCmd, "referencein")
+	bindPFlag(AclAddCmd, "sharingusers")
+	AclCmd.AddCommand(AclRemoveCmd)
+	setArgument(AclRemoveCmd, "referencein")
+	setArgument(AclRemoveCmd, "sharingusers")
+	bindPFlag(AclRemoveCmd, "referencein")
+	bindPFlag(AclRemoveCmd, "sharingusers")
+	AclCmd.AddCommand(AclSharedCmd)
 }
 
 func initAuth() {
@@ -126,6 +176,94 @@ func initAuth() {
 	bindPFlag(LogoutCmd, "authport")
 	LogoutCmd.RunE = logout
 
+	RootCmd.AddCommand(TotpCmd)
+	setArgument(TotpCmd, "authaddress")
+	setArgument(TotpCmd, "authport")
+	bindPFlag(TotpCmd, "authaddress")
+	bindPFlag(TotpCmd, "authport")
+	TotpCmd.AddCommand(TotpEnableCmd)
+	TotpCmd.AddCommand(TotpDisableCmd)
+
+	RootCmd.AddCommand(ApikeyCmd)
+	setArgument(ApikeyCmd, "authaddress")
+	setArgument(ApikeyCmd, "authport")
+	bindPFlag(ApikeyCmd, "authaddress")
+	bindPFlag(ApikeyCmd, "authport")
+	ApikeyCmd.AddCommand(ApikeyCreateCmd)
+	setArgument(ApikeyCreateCmd, "keyname")
+	setArgument(ApikeyCreateCmd, "scopes")
+	setArgument(ApikeyCreateCmd, "allowedaddresses")
+	setArgument(ApikeyCreateCmd, "keyttl")
+	bindPFlag(ApikeyCreateCmd, "keyname")
+	bindPFlag(ApikeyCreateCmd, "scopes")
+	bindPFlag(ApikeyCreateCmd, "allowedaddresses")
+	bindPFlag(ApikeyCreateCmd, "keyttl")
+	ApikeyCmd.AddCommand(ApikeyListCmd)
+	ApikeyCmd.AddCommand(ApikeyRevokeCmd)
+	setArgument(ApikeyRevokeCmd, "keyid")
+	bindPFlag(ApikeyRevokeCmd, "keyid")
+
+	RootCmd.AddCommand(PasswordCmd)
+	setArgument(PasswordCmd, "authaddress")
+	setArgument(PasswordCmd, "authport")
+	setArgument(PasswordCmd, "username")
+	bindPFlag(PasswordCmd, "authaddress")
+	bindPFlag(PasswordCmd, "authport")
+	bindPFlag(PasswordCmd, "username")
+	PasswordCmd.AddCommand(PasswordChangeCmd)
+	PasswordCmd.AddCommand(PasswordResetCmd)
+	PasswordCmd.AddCommand(PasswordCompleteCmd)
+	setArgument(PasswordCompleteCmd, "resettoken")
+	bindPFlag(PasswordCompleteCmd, "resettoken")
+
+	RootCmd.AddCommand(SessionsCmd)
+	setArgument(SessionsCmd, "authaddress")
+	setArgument(SessionsCmd, "authport")
+	bindPFlag(SessionsCmd, "authaddress")
+	bindPFlag(SessionsCmd, "authport")
+	SessionsCmd.AddCommand(SessionsListCmd)
+	SessionsCmd.AddCommand(SessionsRevokeCmd)
+	setArgument(SessionsRevokeCmd, "sessionid")
+	setArgument(SessionsRevokeCmd, "all")
+	bindPFlag(SessionsRevokeCmd, "sessionid")
+	bindPFlag(SessionsRevokeCmd, "all")
+
+	RootCmd.AddCommand(AdminCmd)
+	setArgument(AdminCmd, "authaddress")
+	setArgument(AdminCmd, "authport")
+	bindPFlag(AdminCmd, "authaddress")
+	bindPFlag(AdminCmd, "authport")
+	AdminCmd.AddCommand(AdminUserCmd)
+	setArgument(AdminUserCmd, "username")
+	bindPFlag(AdminUserCmd, "username")
+	AdminUserCmd.AddCommand(AdminUserAddCmd)
+	setArgument(AdminUserAddCmd, "fullname")
+	setArgument(AdminUserAddCmd, "email")
+	setArgument(AdminUserAddCmd, "superadmin")
+	setArgument(AdminUserAddCmd, "services")
+	setArgument(AdminUserAddCmd, "disabled")
+	bindPFlag(AdminUserAddCmd, "fullname")
+	bindPFlag(AdminUserAddCmd, "email")
+	bindPFlag(AdminUserAddCmd, "superadmin")
+	bindPFlag(AdminUserAddCmd, "services")
+	bindPFlag(AdminUserAddCmd, "disabled")
+	AdminUserCmd.AddCommand(AdminUserUpdateCmd)
+	setArgument(AdminUserUpdateCmd, "fullname")
+	setArgument(AdminUserUpdateCmd, "email")
+	setArgument(AdminUserUpdateCmd, "superadmin")
+	setArgument(AdminUserUpdateCmd, "services")
+	setArgument(AdminUserUpdateCmd, "disabled")
+	bindPFlag(AdminUserUpdateCmd, "fullname")
+	bindPFlag(AdminUserUpdateCmd, "email")
+	bindPFlag(AdminUserUpdateCmd, "superadmin")
+	bindPFlag(AdminUserUpdateCmd, "services")
+	bindPFlag(AdminUserUpdateCmd, "disabled")
+	AdminUserCmd.AddCommand(AdminUserDisableCmd)
+	AdminUserCmd.AddCommand(AdminUserRemoveCmd)
+	AdminUserCmd.AddCommand(AdminUserListCmd)
+	AdminCmd.AddCommand(AdminSessionsCmd)
+	AdminSessionsCmd.AddCommand(AdminSessionsKickCmd)
+
 	RootCmd.AddCommand(CreateUserCmd)
 	CreateUserCmd.RunE = createuser
 }
@@ -0,0 +1,71 @@
+//
+// 3nigm4 3n4cli package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"fmt"
+)
+
+// Third party libs
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// LinkCmd creates an anonymous download link for remote resources
+// starting from a reference file.
+var LinkCmd = &cobra.Command{
+	Use:     "link",
+	Short:   "Creates an anonymous download link",
+	Long:    "Creates a signed and expiring download link for public remote resources pointed by a reference, it can be used with the download command by people not registered to the service.",
+	Example: "3n4cli store link -r /tmp/resources.3rf --timetolive 24h --singleuse",
+	RunE:    linkReference,
+}
+
+// linkReference uses the storage client to create a download link
+// for all chunks pointed by a reference file.
+func linkReference(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	// check for token presence
+	if pss.authToken() == "" {
+		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
+	}
+	ttl := viper.GetDuration(viperLabel(cmd, "timetolive"))
+	if ttl <= 0 {
+		return fmt.Errorf("a positive time to live is required to create a link")
+	}
+
+	// get reference
+	reference, err := loadReferenceFile(viper.GetString(viperLabel(cmd, "referencein")))
+	if err != nil {
+		return err
+	}
+
+	// create new store manager
+	ds, err, errc := newStorageClient()
+	if err != nil {
+		return err
+	}
+	defer ds.Close()
+	go manageAsyncErrors(errc)
+
+	// create link for resources from reference
+	link, expiration, err := ds.CreateLink(
+		reference.ChunksPaths,
+		ttl,
+		viper.GetBool(viperLabel(cmd, "singleuse")))
+	if err != nil {
+		return err
+	}
+
+	log.MessageLog("Download link (valid until %s):\n%s\n",
+		expiration.Local().String(),
+		link)
+
+	return nil
+}
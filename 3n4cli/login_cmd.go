@@ -8,6 +8,7 @@ package main
 
 // Golang std libs
 import (
+	"bufio"
 	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
@@ -15,6 +16,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"strings"
 )
 
 // Internal dependencies
@@ -55,73 +58,132 @@ func hexComposedPassword(username string, pwd []byte) string {
 	return hexPwd
 }
 
-// login command let the user authenticate on all available
-// 3nigm4 services, this function will be called before any
-// other to be able to proceed with a valid auth token.
-func login(cmd *cobra.Command, args []string) error {
-	verbosePreRunInfos(cmd, args)
-
-	username := viper.GetString(viperLabel(cmd, "username"))
-	// get user password
-	fmt.Printf("Insert password: ")
-	pwd, err := gopass.GetPasswdMasked()
+// readOtp prompts the user for a two factor authentication code.
+func readOtp() (string, error) {
+	fmt.Printf("Insert two factor authentication code: ")
+	code, err := bufio.NewReader(os.Stdin).ReadString('\n')
 	if err != nil {
-		return err
+		return "", err
 	}
+	return strings.TrimSpace(code), nil
+}
 
-	// prepare request
-	lr := &ct.LoginRequest{
-		Username: username,
-		Password: hexComposedPassword(username, pwd),
-	}
+// requestLogin posts the login request to the auth APIs, exposed
+// at address and port, returning the response status and body.
+func requestLogin(authAddress string, authPort int, lr *ct.LoginRequest) (int, []byte, error) {
 	body, err := json.Marshal(lr)
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
 
 	// create http request
 	client := &http.Client{}
-	// get address and port
-	authAddress := viper.GetString(viperLabel(cmd, "authaddress"))
-	authPort := viper.GetInt(viperLabel(cmd, "authport"))
 	// prepare post request
 	req, err := http.NewRequest(
 		"POST",
 		fmt.Sprintf("%s:%d%s", authAddress, authPort, authorisationPath),
 		bytes.NewBuffer(body))
 	if err != nil {
-		return fmt.Errorf("unable to create the request %s", err.Error())
+		return 0, nil, fmt.Errorf("unable to create the request %s", err.Error())
 	}
 	req.Header.Set("Content-Type", "application/json; charset=utf-8")
 	// execute request
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("unable to perform the request cause %s", err.Error())
+		return 0, nil, fmt.Errorf("unable to perform the request cause %s", err.Error())
 	}
 
 	// get token
 	respBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
 	resp.Body.Close()
+	return resp.StatusCode, respBody, nil
+}
+
+// otpRequired verifies if a login response requires a two factor
+// authentication code.
+func otpRequired(status int, body []byte) bool {
+	if status != http.StatusUnauthorized {
+		return false
+	}
+	var response ct.StandardResponse
+	err := json.Unmarshal(body, &response)
+	if err != nil {
+		return false
+	}
+	return response.Error == ct.OtpRequiredError
+}
+
+// authenticate prompts the user for the password, and the two
+// factor authentication code if required, and logs in on the
+// auth APIs exposed at address and port returning the session
+// token.
+func authenticate(authAddress string, authPort int, username string) (string, error) {
+	// get user password
+	fmt.Printf("Insert password: ")
+	pwd, err := gopass.GetPasswdMasked()
+	if err != nil {
+		return "", err
+	}
+
+	// prepare request
+	lr := &ct.LoginRequest{
+		Username: username,
+		Password: hexComposedPassword(username, pwd),
+	}
+	status, respBody, err := requestLogin(authAddress, authPort, lr)
+	if err != nil {
+		return "", err
+	}
+	if otpRequired(status, respBody) {
+		lr.OTP, err = readOtp()
+		if err != nil {
+			return "", err
+		}
+		status, respBody, err = requestLogin(authAddress, authPort, lr)
+		if err != nil {
+			return "", err
+		}
+	}
 
 	// check for errors
-	err = checkRequestStatus(resp.StatusCode, http.StatusOK, respBody)
+	err = checkRequestStatus(status, http.StatusOK, respBody)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	var loginResponse ct.LoginResponse
 	err = json.Unmarshal(respBody, &loginResponse)
 	if err != nil {
-		return err
+		return "", err
 	}
 	if loginResponse.Token == "" {
-		return fmt.Errorf("returned token is nil, unable to proceed")
+		return "", fmt.Errorf("returned token is nil, unable to proceed")
+	}
+	return loginResponse.Token, nil
+}
+
+// login command let the user authenticate on all available
+// 3nigm4 services, this function will be called before any
+// other to be able to proceed with a valid auth token. Users
+// having two factor authentication enabled are prompted for the
+// code.
+func login(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+
+	username := viper.GetString(viperLabel(cmd, "username"))
+	token, err := authenticate(
+		viper.GetString(viperLabel(cmd, "authaddress")),
+		viper.GetInt(viperLabel(cmd, "authport")),
+		username)
+	if err != nil {
+		return err
 	}
 	// set global token with returned one
-	pss.Token = loginResponse.Token
+	pss.Token = token
+	pss.Username = username
 	pss.refreshLastLogin()
 
 	// if verbose printf token
@@ -0,0 +1,174 @@
+//
+// 3nigm4 3n4cli package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+)
+
+// Internal dependencies
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+// Third party libs
+import (
+	"github.com/howeyc/gopass"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	passwordPath      = "/v1/authsession/password"
+	passwordResetPath = "/v1/authsession/password/reset"
+	minPasswordLength = 8
+)
+
+// PasswordCmd manages user passwords.
+var PasswordCmd = &cobra.Command{
+	Use:       "password",
+	Short:     "Manages passwords",
+	Long:      "Changes the password of the logged in user or resets, using a token received by email, a forgotten one.",
+	Example:   "3n4cli password",
+	ValidArgs: []string{"change", "reset", "complete"},
+}
+
+// PasswordChangeCmd changes the password of the logged in user.
+var PasswordChangeCmd = &cobra.Command{
+	Use:     "change",
+	Short:   "Changes the password",
+	Long:    "Changes the password of the logged in user, the current password is required. All the other user sessions are invalidated.",
+	Example: "3n4cli password change -u username",
+	RunE:    passwordChange,
+}
+
+// PasswordResetCmd issues a password reset token.
+var PasswordResetCmd = &cobra.Command{
+	Use:     "reset",
+	Short:   "Resets a user password",
+	Long:    "Issues a single use password reset token delivered to the user email address, reserved to super-admins.",
+	Example: "3n4cli password reset -u username",
+	RunE:    passwordReset,
+}
+
+// PasswordCompleteCmd sets a new password using a reset token.
+var PasswordCompleteCmd = &cobra.Command{
+	Use:     "complete",
+	Short:   "Completes a password reset",
+	Long:    "Sets a new password using the reset token received by email, a login is not required.",
+	Example: "3n4cli password complete -u username --resettoken 00112233445566778899aabbccddeeff",
+	RunE:    passwordComplete,
+}
+
+// readNewPassword reads, from the terminal, a new password
+// verifying it with a second insertion.
+func readNewPassword() ([]byte, error) {
+	fmt.Printf("Insert new password: ")
+	pwd, err := gopass.GetPasswdMasked()
+	if err != nil {
+		return nil, err
+	}
+	if len(pwd) < minPasswordLength {
+		return nil, fmt.Errorf("password should be at least %d characters long", minPasswordLength)
+	}
+	fmt.Printf("Repeat new password: ")
+	repeated, err := gopass.GetPasswdMasked()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(pwd, repeated) {
+		return nil, fmt.Errorf("passwords do not match")
+	}
+	return pwd, nil
+}
+
+// passwordChange changes the password of the logged in user.
+func passwordChange(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	// check for token presence
+	if pss.Token == "" {
+		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
+	}
+	// passwords are composed with the user name
+	username := viper.GetString(viperLabel(PasswordCmd, "username"))
+	if username == "" {
+		return fmt.Errorf("unable to change the password of a nil username")
+	}
+
+	fmt.Printf("Insert current password: ")
+	pwd, err := gopass.GetPasswdMasked()
+	if err != nil {
+		return err
+	}
+	newPwd, err := readNewPassword()
+	if err != nil {
+		return err
+	}
+	_, err = authRequest(PasswordCmd, "PUT", passwordPath, &ct.PasswordChangeRequest{
+		Password:    hexComposedPassword(username, pwd),
+		NewPassword: hexComposedPassword(username, newPwd),
+	}, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	log.MessageLog("Password changed, other sessions have been closed.\n")
+
+	return nil
+}
+
+// passwordReset issues a password reset token for a user.
+func passwordReset(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	// check for token presence
+	if pss.Token == "" {
+		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
+	}
+	username := viper.GetString(viperLabel(PasswordCmd, "username"))
+	if username == "" {
+		return fmt.Errorf("unable to reset the password of a nil username")
+	}
+
+	_, err := authRequest(PasswordCmd, "POST", passwordResetPath, &ct.PasswordResetRequest{
+		Username: username,
+	}, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	log.MessageLog("Password reset token sent to user %s.\n", username)
+
+	return nil
+}
+
+// passwordComplete sets a new password using a reset token.
+func passwordComplete(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	username := viper.GetString(viperLabel(PasswordCmd, "username"))
+	token := viper.GetString(viperLabel(cmd, "resettoken"))
+	if username == "" ||
+		token == "" {
+		return fmt.Errorf("username and reset token are required to complete a password reset")
+	}
+
+	newPwd, err := readNewPassword()
+	if err != nil {
+		return err
+	}
+	_, err = authRequest(PasswordCmd, "PUT", passwordResetPath, &ct.PasswordResetCompleteRequest{
+		Username:    username,
+		ResetToken:  token,
+		NewPassword: hexComposedPassword(username, newPwd),
+	}, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	log.MessageLog("Password set, please login with the new password.\n")
+
+	return nil
+}
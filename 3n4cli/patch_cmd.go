@@ -40,7 +40,7 @@ var PatchCmd = &cobra.Command{
 func patch(cmd *cobra.Command, args []string) error {
 	verbosePreRunInfos(cmd, args)
 	// check for token presence
-	if pss.Token == "" {
+	if pss.authToken() == "" {
 		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
 	}
 
@@ -89,7 +89,7 @@ func patch(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("unable to prepare PATCH request, cause %s", err.Error())
 	}
-	req.Header.Set(ct.SecurityTokenKey, pss.Token)
+	req.Header.Set(ct.SecurityTokenKey, pss.authToken())
 	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("unable to perform PATCH request, cause %s", err.Error())
@@ -22,7 +22,8 @@ import (
 var pss *storage
 
 const (
-	storageFileName = ".storage" // file name where storage info will be saved (under app root dir).
+	storageFileName = ".storage"      // file name where storage info will be saved (under app root dir);
+	apiKeyEnv       = "3N4ENV_APIKEY" // environment variable used to pass an api key in place of the session.
 )
 
 // storage struct is used to structure the persistant
@@ -30,7 +31,9 @@ const (
 // commands invokations.
 type storage struct {
 	Token     string    `json:"token" xml:"token"`
+	Username  string    `json:"username" xml:"username"` // user of the last login, used to re-authenticate;
 	LastLogin time.Time `json:"lastlogin" xml:"lastlogin"`
+	ApiKey    string    `json:"-" xml:"-"` // never persisted, read from the environment.
 }
 
 // storageFilePath returns the file path of the storage
@@ -58,6 +61,7 @@ func newPersistentStorage() *storage {
 	} else {
 		ps = pss
 	}
+	ps.ApiKey = os.Getenv(apiKeyEnv)
 	return ps
 }
 
@@ -115,3 +119,12 @@ func (ps *storage) invalidateSessionToken() {
 func (ps *storage) refreshLastLogin() {
 	ps.LastLogin = time.Now()
 }
+
+// authToken returns the token used to authenticate requests: the
+// api key, if available, or the session token.
+func (ps *storage) authToken() string {
+	if ps.ApiKey != "" {
+		return ps.ApiKey
+	}
+	return ps.Token
+}
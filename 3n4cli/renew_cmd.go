@@ -0,0 +1,68 @@
+//
+// 3nigm4 3n4cli package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"fmt"
+)
+
+// Third party libs
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// RenewCmd extends the time to live of remote resources starting
+// from a reference file.
+var RenewCmd = &cobra.Command{
+	Use:     "renew",
+	Short:   "Renews remote resources time to live",
+	Long:    "Extends the time to live of remote resources starting from a reference, the new expiration is computed from now. It can be scheduled to keep alive long lived references.",
+	Example: "3n4cli store renew -r /tmp/resources.3rf --timetolive 720h -v",
+	RunE:    renewReference,
+}
+
+// renewReference uses the storage client to remotely extend the
+// time to live of all chunks pointed by a reference file.
+func renewReference(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	// check for token presence
+	if pss.authToken() == "" {
+		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
+	}
+	ttl := viper.GetDuration(viperLabel(cmd, "timetolive"))
+	if ttl <= 0 {
+		return fmt.Errorf("a positive time to live is required to renew resources")
+	}
+
+	// get reference
+	reference, err := loadReferenceFile(viper.GetString(viperLabel(cmd, "referencein")))
+	if err != nil {
+		return err
+	}
+
+	// create new store manager
+	ds, err, errc := newStorageClient()
+	if err != nil {
+		return err
+	}
+	defer ds.Close()
+	go manageAsyncErrors(errc)
+
+	// renew resources from reference
+	expiration, err := ds.RenewChunks(reference.ChunksPaths, ttl)
+	if err != nil {
+		return err
+	}
+
+	log.MessageLog("Successfully renewed %d chunks, new expiration %s.\n",
+		len(reference.ChunksPaths),
+		expiration.Local().String())
+
+	return nil
+}
@@ -0,0 +1,131 @@
+//
+// 3nigm4 3n4cli package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Internal dependencies
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+	"github.com/nexocrew/3nigm4/lib/logger"
+)
+
+// Third party libs
+import (
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	sessionsPath = "/v1/authsession/sessions"
+)
+
+// SessionsCmd manages the sessions of the logged in user.
+var SessionsCmd = &cobra.Command{
+	Use:       "sessions",
+	Short:     "Manages active sessions",
+	Long:      "Lists the active sessions of the logged in user or revokes them, i.e. to lock out a lost or stolen device.",
+	Example:   "3n4cli sessions",
+	ValidArgs: []string{"list", "revoke"},
+}
+
+// SessionsListCmd lists the user sessions.
+var SessionsListCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "Lists active sessions",
+	Long:    "Lists the active sessions of the logged in user with the address and the user agent of the clients that opened them.",
+	Example: "3n4cli sessions list",
+	RunE:    sessionsList,
+}
+
+// SessionsRevokeCmd revokes user sessions.
+var SessionsRevokeCmd = &cobra.Command{
+	Use:     "revoke",
+	Short:   "Revokes sessions",
+	Long:    "Revokes a session of the logged in user or, using the all flag, all of them except the one used by the command.",
+	Example: "3n4cli sessions revoke --sessionid 0011223344556677",
+	RunE:    sessionsRevoke,
+}
+
+// sessionsList prints out the user sessions.
+func sessionsList(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	// check for token presence
+	if pss.Token == "" {
+		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
+	}
+
+	respBody, err := authRequest(SessionsCmd, "GET", sessionsPath, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	var list ct.SessionsResponse
+	err = json.Unmarshal(respBody, &list)
+	if err != nil {
+		return err
+	}
+	// create output logger
+	lg := logger.NewLogger(
+		color.New(color.BgBlack, color.FgHiWhite),
+		"",
+		"",
+		false,
+		true,
+	)
+	lg.Printf("Active sessions (%d):\n", len(list.Sessions))
+	for _, session := range list.Sessions {
+		current := ""
+		if session.Current {
+			current = " (current)"
+		}
+		lg.Printf("\t%s: from %s%s\n", session.ID, session.Address, current)
+		lg.Printf("\t\tuser agent %s\n", session.UserAgent)
+		lg.Printf("\t\tlogin %s last seen %s\n",
+			session.Login.Local().String(),
+			session.LastSeen.Local().String())
+	}
+
+	return nil
+}
+
+// sessionsRevoke revokes a session or all the other ones.
+func sessionsRevoke(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	// check for token presence
+	if pss.Token == "" {
+		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
+	}
+
+	id := viper.GetString(viperLabel(cmd, "sessionid"))
+	all := viper.GetBool(viperLabel(cmd, "all"))
+	if (id == "" && !all) ||
+		(id != "" && all) {
+		return fmt.Errorf("either a session id or the all flag should be specified")
+	}
+	path := sessionsPath
+	if id != "" {
+		path += "/" + id
+	}
+	respBody, err := authRequest(SessionsCmd, "DELETE", path, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	var revoked ct.SessionsRevokeResponse
+	err = json.Unmarshal(respBody, &revoked)
+	if err != nil {
+		return err
+	}
+	log.MessageLog("Revoked %d sessions.\n", revoked.Revoked)
+
+	return nil
+}
@@ -8,6 +8,9 @@ package main
 
 // Golang std libs
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"sync"
 	"time"
@@ -15,6 +18,7 @@ import (
 
 // Internal dependencies
 import (
+	crypto3n "github.com/nexocrew/3nigm4/lib/crypto"
 	fm "github.com/nexocrew/3nigm4/lib/filemanager"
 	sc "github.com/nexocrew/3nigm4/lib/storageclient"
 )
@@ -23,6 +27,7 @@ import (
 import (
 	"github.com/sethgrid/multibar"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // StoreCmd clinet service that connect to the service API
@@ -33,10 +38,15 @@ var StoreCmd = &cobra.Command{
 	Short:     "Store securely data to the cloud",
 	Long:      "Store and manage secured data to the colud. All the encryption routines are executed on the client only encrypted chunks are sended to the server.",
 	Example:   "3n4cli store",
-	ValidArgs: []string{"upload", "download", "delete"},
+	ValidArgs: []string{"upload", "download", "delete", "renew", "usage", "acl", "link", "gc", "audit"},
 	RunE:      store,
 }
 
+// promptMtx serialises the re-authentication prompts, issued by the
+// working queue goroutines, and pauses the progress bars updates
+// while a prompt is shown.
+var promptMtx sync.Mutex
+
 // manageAsyncErrors is a common function used by the various
 // store child commands to manage async returned errors. If
 // an error is returned exit is invoked.
@@ -50,6 +60,91 @@ func manageAsyncErrors(errc <-chan error) {
 	}
 }
 
+// newStorageClient creates a storage client that keeps the
+// actual session alive during long running operations: renewed
+// tokens are saved in the persistant storage.
+func newStorageClient() (*sc.StorageClient, error, <-chan error) {
+	address := viper.GetString(viperLabel(StoreCmd, "storageaddress"))
+	port := viper.GetInt(viperLabel(StoreCmd, "storageport"))
+	var credentials sc.CredentialsProvider
+	if pss.ApiKey != "" {
+		// api keys do not expire with sessions
+		credentials = sc.StaticCredentials(pss.ApiKey)
+	} else {
+		session := sc.NewSessionCredentials(
+			address,
+			port,
+			pss.Token,
+			sc.DefaultRenewInterval,
+			relogin(address, port))
+		session.OnRenew = func(token string) {
+			pss.Token = token
+			pss.refreshLastLogin()
+		}
+		credentials = session
+	}
+	return sc.NewStorageClientWithCredentials(
+		address,
+		port,
+		credentials,
+		viper.GetInt(viperLabel(StoreCmd, "workerscount")),
+		viper.GetInt(viperLabel(StoreCmd, "queuesize")))
+}
+
+// relogin returns the function used to re-authenticate, on the
+// storage service exposed at address and port, the last logged in
+// user when the session can not be refreshed anymore.
+// Concurrent expirations prompt the user only once: requests
+// waiting for the prompt reuse the obtained token.
+func relogin(address string, port int) sc.LoginFunc {
+	return func() (string, error) {
+		expired := pss.Token
+		promptMtx.Lock()
+		defer promptMtx.Unlock()
+		if pss.Token != expired {
+			return pss.Token, nil
+		}
+		if pss.Username == "" {
+			return "", fmt.Errorf("session is expired, please call \"login\" command")
+		}
+		log.WarningLog("Session is expired, re-authenticating user %s.\n", pss.Username)
+		token, err := authenticate(address, port, pss.Username)
+		if err != nil {
+			return "", err
+		}
+		pss.Token = token
+		pss.refreshLastLogin()
+		return token, nil
+	}
+}
+
+// loadReferenceFile reads, decrypts with the user's private key,
+// and decodes the argument reference file.
+func loadReferenceFile(refin string) (*fm.ReferenceFile, error) {
+	// prepare PGP private key
+	privateEntityList, err := checkAndLoadPgpPrivateKey(viper.GetString(viperLabel(StoreCmd, "privatekey")))
+	if err != nil {
+		return nil, err
+	}
+	// get reference
+	encBytes, err := ioutil.ReadFile(refin)
+	if err != nil {
+		return nil, fmt.Errorf("unable to access reference file %s cause %s", refin, err.Error())
+	}
+	// decrypt it
+	refenceBytes, err := crypto3n.OpenPgpDecrypt(encBytes, privateEntityList)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt reference file: %s", err.Error())
+	}
+	// unmarshal it
+	var reference fm.ReferenceFile
+	err = json.Unmarshal(refenceBytes, &reference)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode reference file: %s", err.Error())
+	}
+	return &reference, nil
+}
+
 // progressBarUpdate function should be invoked concurrently to
 // update cli progress bar.
 func progressBarUpdate(ctx *fm.ContextID, ds *sc.StorageClient, pf multibar.ProgressFunc, wg *sync.WaitGroup) {
@@ -63,7 +158,9 @@ func progressBarUpdate(ctx *fm.ContextID, ds *sc.StorageClient, pf multibar.Prog
 			break
 		}
 		// x : 100 = progress : total
+		promptMtx.Lock()
 		pf((100 * status.Done()) / status.TotalUnits())
+		promptMtx.Unlock()
 		if status.Done() == status.TotalUnits() {
 			break
 		}
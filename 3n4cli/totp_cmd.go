@@ -0,0 +1,136 @@
+//
+// 3nigm4 3n4cli package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Internal dependencies
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+	"github.com/nexocrew/3nigm4/lib/logger"
+)
+
+// Third party libs
+import (
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+const (
+	totpPath = "/v1/authsession/totp"
+)
+
+// TotpCmd manages the two factor authentication of the logged in
+// user.
+var TotpCmd = &cobra.Command{
+	Use:       "totp",
+	Short:     "Manages two factor authentication",
+	Long:      "Enables or disables the time based one time passwords required, with the password, to login.",
+	Example:   "3n4cli totp",
+	ValidArgs: []string{"enable", "disable"},
+}
+
+// TotpEnableCmd enrols and enables two factor authentication.
+var TotpEnableCmd = &cobra.Command{
+	Use:     "enable",
+	Short:   "Enables two factor authentication",
+	Long:    "Generates a new secret to be configured in an authenticator app and, after confirming a code, enables two factor authentication returning the recovery codes.",
+	Example: "3n4cli totp enable",
+	RunE:    totpEnable,
+}
+
+// TotpDisableCmd disables two factor authentication.
+var TotpDisableCmd = &cobra.Command{
+	Use:     "disable",
+	Short:   "Disables two factor authentication",
+	Long:    "Disables two factor authentication, a valid code or a recovery code is required.",
+	Example: "3n4cli totp disable",
+	RunE:    totpDisable,
+}
+
+// totpEnable enrols a new secret, shown to the user, and confirms
+// it with a code produced by the authenticator app. The recovery
+// codes are printed out only once.
+func totpEnable(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	// check for token presence
+	if pss.Token == "" {
+		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
+	}
+
+	respBody, err := authRequest(TotpCmd, "POST", totpPath, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	var enrolment ct.TotpEnrolResponse
+	err = json.Unmarshal(respBody, &enrolment)
+	if err != nil {
+		return err
+	}
+	// create output logger
+	lg := logger.NewLogger(
+		color.New(color.BgBlack, color.FgHiWhite),
+		"",
+		"",
+		false,
+		true,
+	)
+	lg.Printf("Configure your authenticator app with:\n")
+	lg.Printf("\tSecret: %s\n", enrolment.Secret)
+	lg.Printf("\tURL: %s\n", enrolment.URL)
+
+	code, err := readOtp()
+	if err != nil {
+		return err
+	}
+	respBody, err = authRequest(TotpCmd, "PUT", totpPath, &ct.TotpCodeRequest{
+		Code: code,
+	}, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	var confirmation ct.TotpConfirmResponse
+	err = json.Unmarshal(respBody, &confirmation)
+	if err != nil {
+		return err
+	}
+	lg.Printf("Two factor authentication enabled, store the following recovery codes in a safe place (each one can be used only once):\n")
+	for _, code := range confirmation.RecoveryCodes {
+		lg.Printf("\t%s\n", code)
+	}
+
+	return nil
+}
+
+// totpDisable disables two factor authentication verifying a code
+// or a recovery code.
+func totpDisable(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	// check for token presence
+	if pss.Token == "" {
+		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
+	}
+
+	code, err := readOtp()
+	if err != nil {
+		return err
+	}
+	_, err = authRequest(TotpCmd, "DELETE", totpPath, &ct.TotpCodeRequest{
+		Code: code,
+	}, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	log.MessageLog("Two factor authentication disabled.\n")
+
+	return nil
+}
@@ -20,7 +20,6 @@ import (
 	ct "github.com/nexocrew/3nigm4/lib/commons"
 	crypto3n "github.com/nexocrew/3nigm4/lib/crypto"
 	fm "github.com/nexocrew/3nigm4/lib/filemanager"
-	sc "github.com/nexocrew/3nigm4/lib/storageclient"
 )
 
 // Third party libs
@@ -50,7 +49,7 @@ var UploadCmd = &cobra.Command{
 func upload(cmd *cobra.Command, args []string) error {
 	verbosePreRunInfos(cmd, args)
 	// check for token presence
-	if pss.Token == "" {
+	if pss.authToken() == "" {
 		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
 	}
 
@@ -97,13 +96,7 @@ func upload(cmd *cobra.Command, args []string) error {
 	}
 
 	// create new store manager
-	ds, err, errc := sc.NewStorageClient(
-		viper.GetString(viperLabel(StoreCmd, "storageaddress")),
-		viper.GetInt(viperLabel(StoreCmd, "storageport")),
-		pss.Token,
-		viper.GetInt(viperLabel(StoreCmd, "workerscount")),
-		viper.GetInt(viperLabel(StoreCmd, "queuesize")),
-	)
+	ds, err, errc := newStorageClient()
 	if err != nil {
 		return err
 	}
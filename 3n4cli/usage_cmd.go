@@ -0,0 +1,76 @@
+//
+// 3nigm4 3n4cli package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"fmt"
+)
+
+// Internal dependencies
+import (
+	"github.com/nexocrew/3nigm4/lib/logger"
+)
+
+// Third party libs
+import (
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// UsageCmd returns the storage usage of the logged in user.
+var UsageCmd = &cobra.Command{
+	Use:     "usage",
+	Short:   "Returns storage usage",
+	Long:    "Retrieves from the storage service the stored bytes and objects of the logged in user with the enforced quotas.",
+	Example: "3n4cli store usage",
+	RunE:    usage,
+}
+
+// usage retrieves and shows the storage usage of the logged in
+// user.
+func usage(cmd *cobra.Command, args []string) error {
+	verbosePreRunInfos(cmd, args)
+	// check for token presence
+	if pss.authToken() == "" {
+		return fmt.Errorf("you are not logged in, please call \"login\" command before invoking any other functionality")
+	}
+
+	// create new store manager
+	ds, err, errc := newStorageClient()
+	if err != nil {
+		return err
+	}
+	defer ds.Close()
+	go manageAsyncErrors(errc)
+
+	usage, err := ds.Usage()
+	if err != nil {
+		return err
+	}
+	// create output logger
+	lg := logger.NewLogger(
+		color.New(color.BgBlack, color.FgHiWhite),
+		"",
+		"",
+		false,
+		true,
+	)
+	// print out usage infos
+	bytesQuota, objectsQuota := "unlimited", "unlimited"
+	if usage.QuotaBytes != 0 {
+		bytesQuota = fmt.Sprintf("%.3f Mb", float64(usage.QuotaBytes)/convMegaByte)
+	}
+	if usage.QuotaObjects != 0 {
+		objectsQuota = fmt.Sprintf("%d", usage.QuotaObjects)
+	}
+	lg.Printf("Storage usage:\n")
+	lg.Printf("\tSize: %.3f Mb of %s\n", float64(usage.Bytes)/convMegaByte, bytesQuota)
+	lg.Printf("\tObjects: %d of %s\n", usage.Objects, objectsQuota)
+
+	return nil
+}
@@ -13,8 +13,10 @@ package main
 
 // Golang std libs
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
+	"sort"
 )
 
 // Internal dependencies
@@ -59,6 +61,7 @@ type mockdb struct {
 	// in memory storage
 	userStorage    map[string]*auth.User
 	sessionStorage map[string]*auth.Session
+	apiKeyStorage  map[string]*auth.ApiKey
 }
 
 func newMockDb(args *auth.DbArgs) *mockdb {
@@ -69,6 +72,7 @@ func newMockDb(args *auth.DbArgs) *mockdb {
 		authDb:         args.AuthDb,
 		userStorage:    make(map[string]*auth.User),
 		sessionStorage: make(map[string]*auth.Session),
+		apiKeyStorage:  make(map[string]*auth.ApiKey),
 	}
 }
 
@@ -79,6 +83,10 @@ func (d *mockdb) Copy() auth.Database {
 func (d *mockdb) Close() {
 }
 
+func (d *mockdb) Ping() error {
+	return nil
+}
+
 func (d *mockdb) GetUser(username string) (*auth.User, error) {
 	user, ok := d.userStorage[username]
 	if !ok {
@@ -96,6 +104,14 @@ func (d *mockdb) SetUser(user *auth.User) error {
 	return nil
 }
 
+func (d *mockdb) UpdateUser(user *auth.User) error {
+	if _, ok := d.userStorage[user.Username]; !ok {
+		return fmt.Errorf("unable to find required %s user", user.Username)
+	}
+	d.userStorage[user.Username] = user
+	return nil
+}
+
 func (d *mockdb) RemoveUser(username string) error {
 	if _, ok := d.userStorage[username]; !ok {
 		return fmt.Errorf("unable to find required %s user", username)
@@ -104,6 +120,19 @@ func (d *mockdb) RemoveUser(username string) error {
 	return nil
 }
 
+func (d *mockdb) ListUsers() ([]auth.User, error) {
+	names := make([]string, 0, len(d.userStorage))
+	for name := range d.userStorage {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	users := make([]auth.User, 0, len(names))
+	for _, name := range names {
+		users = append(users, *d.userStorage[name])
+	}
+	return users, nil
+}
+
 func (d *mockdb) GetSession(token []byte) (*auth.Session, error) {
 	h := hex.EncodeToString(token)
 	session, ok := d.sessionStorage[h]
@@ -132,3 +161,54 @@ func (d *mockdb) RemoveAllSessions() error {
 	d.sessionStorage = make(map[string]*auth.Session)
 	return nil
 }
+
+func (d *mockdb) RemoveUserSessions(username string, except []byte) error {
+	for h, session := range d.sessionStorage {
+		if session.Username == username &&
+			!bytes.Equal(session.Token, except) {
+			delete(d.sessionStorage, h)
+		}
+	}
+	return nil
+}
+
+func (d *mockdb) GetUserSessions(username string) ([]auth.Session, error) {
+	sessions := make([]auth.Session, 0)
+	for _, session := range d.sessionStorage {
+		if session.Username == username {
+			sessions = append(sessions, *session)
+		}
+	}
+	return sessions, nil
+}
+
+func (d *mockdb) GetApiKey(id string) (*auth.ApiKey, error) {
+	key, ok := d.apiKeyStorage[id]
+	if !ok {
+		return nil, fmt.Errorf("unable to find the required %s api key", id)
+	}
+	return key, nil
+}
+
+func (d *mockdb) SetApiKey(key *auth.ApiKey) error {
+	d.apiKeyStorage[key.Id] = key
+	return nil
+}
+
+func (d *mockdb) GetUserApiKeys(username string) ([]auth.ApiKey, error) {
+	keys := make([]auth.ApiKey, 0)
+	for _, key := range d.apiKeyStorage {
+		if key.Username == username {
+			keys = append(keys, *key)
+		}
+	}
+	return keys, nil
+}
+
+func (d *mockdb) RemoveApiKey(id string) error {
+	if _, ok := d.apiKeyStorage[id]; !ok {
+		return fmt.Errorf("unable to find required %s api key", id)
+	}
+	delete(d.apiKeyStorage, id)
+	return nil
+}
@@ -8,16 +8,23 @@ package main
 
 // Golang std libs
 import (
+	"crypto/sha256"
+	"crypto/tls"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/rpc"
+	"os"
 	"strings"
 )
 
 // Internal dependencies
 import (
 	"github.com/nexocrew/3nigm4/lib/auth"
+	"github.com/nexocrew/3nigm4/lib/metrics"
+	"github.com/nexocrew/3nigm4/lib/sender"
+	"github.com/nexocrew/3nigm4/lib/sender/smtp"
 )
 
 // Third party libs
@@ -30,7 +37,7 @@ var ServeCmd = &cobra.Command{
 	Use:     "serve",
 	Short:   "Serve trougth RPC",
 	Long:    "Launch RPC service to expose authentication services.",
-	Example: "authserver serve -d 127.0.0.1:27017 -u dbuser -w dbpwd -a 0.0.0.0 -p 7931 -v",
+	Example: "authserver serve -d 127.0.0.1:27017 -u dbuser -w dbpwd -a 0.0.0.0 -p 7931 -c /tmp/ca.pem -s /tmp/cert.pem -S /tmp/pvkey.pem -v",
 }
 
 func init() {
@@ -40,10 +47,52 @@ func init() {
 	ServeCmd.PersistentFlags().StringVarP(&arguments.dbAuth, "dbauth", "", "admin", "the database auth db")
 	ServeCmd.PersistentFlags().StringVarP(&arguments.address, "address", "a", "0.0.0.0", "the RPC listening address")
 	ServeCmd.PersistentFlags().IntVarP(&arguments.port, "port", "p", 7931, "the RPC listening port")
+	// RPC transport security
+	ServeCmd.PersistentFlags().StringVarP(&arguments.rpcCA, "ca", "c", "", "the PEM file of the CA used to verify clients certificates")
+	ServeCmd.PersistentFlags().StringVarP(&arguments.rpcCertificate, "certificate", "s", "", "the SSL/TLS certificate PEM file path")
+	ServeCmd.PersistentFlags().StringVarP(&arguments.rpcPrivateKey, "privatekey", "S", "", "the SSL/TLS private key PEM file path")
+	ServeCmd.PersistentFlags().BoolVarP(&arguments.insecure, "insecure", "", false, "serve RPC over plain TCP without clients authentication (passwords and tokens are sent in clear text)")
+	// brute-force protection
+	arguments.limits.Register(ServeCmd.PersistentFlags(), "login")
+	// two factor authentication
+	ServeCmd.PersistentFlags().StringVarP(&arguments.totpKeyFile, "totpkeyfile", "", "", "path of the file containing the key used to encrypt users TOTP secrets (if not set the "+envTotpKey+" environment variable is used, if empty two factor authentication is unavailable)")
+	// password reset delivery
+	ServeCmd.PersistentFlags().StringVarP(&arguments.senderAddress, "smtpaddress", "", "", "the smtp service address used to deliver password reset tokens (passwords can not be reset if not set)")
+	ServeCmd.PersistentFlags().IntVarP(&arguments.senderPort, "smtpport", "", 443, "the smtp service port")
+	ServeCmd.PersistentFlags().StringVarP(&arguments.senderAuthUser, "smtpuser", "", "", "the smtp service user name")
+	ServeCmd.PersistentFlags().StringVarP(&arguments.senderAuthPassword, "smtppwd", "", "", "the smtp service password")
+	ServeCmd.PersistentFlags().StringVarP(&arguments.senderEmailAddress, "senderemail", "", "auth@3n4.io", "the email address to be used as sender in password reset messages")
+	ServeCmd.PersistentFlags().StringVarP(&arguments.resetTemplatePath, "resettemplate", "", "", "the email template used for password reset messages")
 	// files parameters
 	ServeCmd.RunE = serve
 }
 
+// envTotpKey is the environment variable defining the TOTP secrets
+// key if no key file is configured.
+const envTotpKey = "NEXO_AUTH_TOTP_KEY"
+
+// totpKeyStartup loads the key used to encrypt users TOTP secrets
+// from the configured file or, if not defined, from the
+// NEXO_AUTH_TOTP_KEY environment variable: the key is not accepted
+// as command line argument not to expose it in the processes list
+// and in the shell history. Returns nil if no key is defined.
+func totpKeyStartup(a *args) ([]byte, error) {
+	key := os.Getenv(envTotpKey)
+	if a.totpKeyFile != "" {
+		data, err := ioutil.ReadFile(a.totpKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read TOTP key file: %s", err.Error())
+		}
+		key = string(data)
+	}
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return nil, nil
+	}
+	hashedKey := sha256.Sum256([]byte(key))
+	return hashedKey[:], nil
+}
+
 // This var is used to permitt to switch to mock db implementation
 // in unit-tests, do not mess with it for other reasons.
 // The default, production targeting, implementation uses Mongodb
@@ -74,6 +123,30 @@ func mgoStartup(arguments *args) (auth.Database, error) {
 	return mgodb, nil
 }
 
+// This var is used to permitt to switch to mock sender
+// implementation in unit-tests, do not mess with it for other
+// reasons. The default, production targeting, implementation uses
+// SMTP as server protocol.
+var senderStartup func(*args) (sender.Sender, error) = smtpStartup
+
+// smtpStartup creates the SMTP sender used to deliver password
+// reset tokens, if no smtp address is configured returns nil.
+func smtpStartup(a *args) (sender.Sender, error) {
+	if a.senderAddress == "" {
+		return nil, nil
+	}
+	if _, err := os.Stat(a.resetTemplatePath); err != nil {
+		return nil, fmt.Errorf("unable to access password reset template: %s", err.Error())
+	}
+	return smtpmail.NewSmtpSender(
+		a.senderAddress,
+		a.senderAuthUser,
+		a.senderAuthPassword,
+		a.resetTemplatePath,
+		a.senderPort,
+	), nil
+}
+
 // serve command expose a RPC service that exposes all authentication
 // related function to the outside.
 func serve(cmd *cobra.Command, args []string) error {
@@ -87,22 +160,78 @@ func serve(cmd *cobra.Command, args []string) error {
 	// set global db
 	auth.SetGlobalDbClient(db)
 	defer auth.CloseGlobalDbClient()
+	// set login brute-force protection
+	auth.SetLoginLimiter(arguments.limits.NewLimiter(log))
+	// set two factor authentication secrets key
+	totpKey, err := totpKeyStartup(&arguments)
+	if err != nil {
+		return err
+	}
+	if totpKey != nil {
+		auth.SetTotpKey(totpKey)
+	} else {
+		log.WarningLog("No TOTP key set, two factor authentication is unavailable.\n")
+	}
+	// set password reset delivery
+	deliverer, err := senderStartup(&arguments)
+	if err != nil {
+		return err
+	}
+	if deliverer != nil {
+		auth.SetResetSender(deliverer, arguments.senderEmailAddress)
+	} else {
+		log.WarningLog("No smtp service set, password reset is unavailable.\n")
+	}
 
 	// register RPC calls
 	login := new(auth.Login)
 	rpc.Register(login)
 	sessionauth := new(auth.SessionAuth)
 	rpc.Register(sessionauth)
-
-	address := fmt.Sprintf("%s:%d", arguments.address, arguments.port)
-	log.MessageLog("Ready to serve via tcp on address %s.\n", address)
-
-	// start listening
+	health := new(auth.Health)
+	rpc.Register(health)
+	twofactor := new(auth.TwoFactor)
+	rpc.Register(twofactor)
+	apikeys := new(auth.ApiKeys)
+	rpc.Register(apikeys)
+
+	// start listening: metrics are exposed on the same listener
 	rpc.HandleHTTP()
-	listener, err := net.Listen("tcp", address)
+	http.Handle("/metrics", metrics.Handler())
+	listener, err := listen(&arguments)
 	if err != nil {
 		return fmt.Errorf("unable to start rpc service %s", err.Error())
 	}
 
 	return http.Serve(listener, nil)
 }
+
+// listen creates the RPC service listener: connections are
+// protected with TLS, requiring clients certificates, unless
+// the insecure mode is explicitly selected.
+func listen(a *args) (net.Listener, error) {
+	address := fmt.Sprintf("%s:%d", a.address, a.port)
+	if a.insecure {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		log.WarningLog("Ready to serve via tcp on address %s (no SSL/TLS this can produce security risks).\n", address)
+		return listener, nil
+	}
+
+	config, err := auth.ServerTLSConfig(&auth.TLSArgs{
+		CA:          a.rpcCA,
+		Certificate: a.rpcCertificate,
+		PrivateKey:  a.rpcPrivateKey,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to configure TLS (use insecure flag to serve plain TCP): %s", err.Error())
+	}
+	listener, err := tls.Listen("tcp", address, config)
+	if err != nil {
+		return nil, err
+	}
+	log.MessageLog("Ready to serve via tcp with TLS on address %s.\n", address)
+	return listener, nil
+}
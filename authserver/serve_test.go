@@ -9,8 +9,11 @@ package main
 // Std Golang libs
 import (
 	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
 	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"net/rpc"
 	"os"
 	"strings"
@@ -90,19 +93,46 @@ func prepareMockDb(db *mockdb) error {
 	return nil
 }
 
+// clientTLSConfig is used by tests to connect to the RPC service.
+var clientTLSConfig *tls.Config
+
 func TestMain(m *testing.M) {
 	// start up logging facility
 	log = logger.NewLogFacility("authserver", true, true)
 
+	// RPC transport certificates
+	dir, err := ioutil.TempDir("", "authserver")
+	if err != nil {
+		log.CriticalLog("Unable to create temporary directory: %s.\n", err.Error())
+		os.Exit(1)
+	}
+	tlsFiles, err := itm.GenerateTLSFiles(dir)
+	if err != nil {
+		log.CriticalLog("Unable to create test certificates: %s.\n", err.Error())
+		os.Exit(1)
+	}
+	clientTLSConfig, err = auth.ClientTLSConfig(&auth.TLSArgs{
+		CA:          tlsFiles.CA,
+		Certificate: tlsFiles.ClientCertificate,
+		PrivateKey:  tlsFiles.ClientPrivateKey,
+	})
+	if err != nil {
+		log.CriticalLog("Unable to configure client TLS: %s.\n", err.Error())
+		os.Exit(1)
+	}
+
 	arguments = args{
-		verbose:     true,
-		colored:     true,
-		dbAddresses: fmt.Sprintf("%s:%d", itm.S().DbAddress(), itm.S().DbPort()),
-		dbUsername:  itm.S().DbUserName(),
-		dbPassword:  itm.S().DbPassword(),
-		dbAuth:      itm.S().DbAuth(),
-		address:     "127.0.0.1",
-		port:        17743,
+		verbose:        true,
+		colored:        true,
+		dbAddresses:    fmt.Sprintf("%s:%d", itm.S().DbAddress(), itm.S().DbPort()),
+		dbUsername:     itm.S().DbUserName(),
+		dbPassword:     itm.S().DbPassword(),
+		dbAuth:         itm.S().DbAuth(),
+		address:        "127.0.0.1",
+		port:           17743,
+		rpcCA:          tlsFiles.CA,
+		rpcCertificate: tlsFiles.ServerCertificate,
+		rpcPrivateKey:  tlsFiles.ServerPrivateKey,
 	}
 	databaseStartup = mockStartup
 
@@ -155,13 +185,15 @@ func TestMain(m *testing.M) {
 		time.Sleep(50 * time.Millisecond)
 	}
 
-	os.Exit(m.Run())
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
 }
 
 func TestRPCServe(t *testing.T) {
 	// test RPC calls
 	address := fmt.Sprintf("%s:%d", arguments.address, arguments.port)
-	client, err := rpc.DialHTTP("tcp", address)
+	client, err := auth.DialRpc(address, clientTLSConfig)
 	if err != nil {
 		t.Fatalf("Unable to connect to RPC server: %s.\n", err.Error())
 	}
@@ -221,7 +253,7 @@ func TestRPCServe(t *testing.T) {
 func TestRPCServeSuperAdmin(t *testing.T) {
 	// test RPC calls
 	address := fmt.Sprintf("%s:%d", arguments.address, arguments.port)
-	client, err := rpc.DialHTTP("tcp", address)
+	client, err := auth.DialRpc(address, clientTLSConfig)
 	if err != nil {
 		t.Fatalf("Unable to connect to RPC server: %s.\n", err.Error())
 	}
@@ -293,3 +325,69 @@ func TestRPCServeSuperAdmin(t *testing.T) {
 		t.Fatalf("After resetting all session also super admin session should be gone.\n")
 	}
 }
+
+func TestRPCServeRequiresClientCertificate(t *testing.T) {
+	address := fmt.Sprintf("%s:%d", arguments.address, arguments.port)
+	// plain connections are refused
+	client, err := rpc.DialHTTP("tcp", address)
+	if err == nil {
+		client.Close()
+		t.Fatalf("Plain TCP connections should be refused.\n")
+	}
+	// clients without a certificate are refused
+	config := clientTLSConfig.Clone()
+	config.Certificates = nil
+	client, err = auth.DialRpc(address, config)
+	if err == nil {
+		client.Close()
+		t.Fatalf("Clients without certificate should be refused.\n")
+	}
+}
+
+func TestTotpKeyStartup(t *testing.T) {
+	os.Setenv(envTotpKey, "environmentkey")
+	defer os.Unsetenv(envTotpKey)
+	key, err := totpKeyStartup(&args{})
+	if err != nil {
+		t.Fatalf("Unable to load TOTP key: %s.\n", err.Error())
+	}
+	expected := sha256.Sum256([]byte("environmentkey"))
+	if !bytes.Equal(key, expected[:]) {
+		t.Fatalf("Unexpected TOTP key from the environment.\n")
+	}
+
+	// the key file has precedence over the environment
+	file, err := ioutil.TempFile("", "totpkey")
+	if err != nil {
+		t.Fatalf("Unable to create key file: %s.\n", err.Error())
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("filekey\n")
+	file.Close()
+	key, err = totpKeyStartup(&args{
+		totpKeyFile: file.Name(),
+	})
+	if err != nil {
+		t.Fatalf("Unable to load TOTP key: %s.\n", err.Error())
+	}
+	expected = sha256.Sum256([]byte("filekey"))
+	if !bytes.Equal(key, expected[:]) {
+		t.Fatalf("Unexpected TOTP key from file.\n")
+	}
+
+	// missing files are refused
+	_, err = totpKeyStartup(&args{
+		totpKeyFile: file.Name() + ".missing",
+	})
+	if err == nil {
+		t.Fatalf("Missing key file should be refused.\n")
+	}
+
+	// two factor authentication is disabled without a key
+	os.Unsetenv(envTotpKey)
+	key, err = totpKeyStartup(&args{})
+	if err != nil ||
+		key != nil {
+		t.Fatalf("Unexpected TOTP key without configuration.\n")
+	}
+}
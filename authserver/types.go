@@ -6,6 +6,11 @@
 
 package main
 
+// Internal dependencies
+import (
+	"github.com/nexocrew/3nigm4/lib/ratelimit"
+)
+
 // Arguments management struct.
 type args struct {
 	// server basic args
@@ -19,4 +24,20 @@ type args struct {
 	// service
 	address string
 	port    int
+	// RPC transport security
+	rpcCA          string
+	rpcCertificate string
+	rpcPrivateKey  string
+	insecure       bool
+	// brute-force protection
+	limits ratelimit.Flags
+	// two factor authentication
+	totpKeyFile string
+	// password reset delivery
+	senderAddress      string
+	senderPort         int
+	senderAuthUser     string
+	senderAuthPassword string
+	senderEmailAddress string
+	resetTemplatePath  string
 }
@@ -10,6 +10,7 @@ package main
 import (
 	"encoding/hex"
 	"fmt"
+	"net/http"
 	"os"
 	"reflect"
 	"strings"
@@ -22,6 +23,7 @@ import (
 	ct "github.com/nexocrew/3nigm4/lib/ishtm/commons"
 	ishtmdb "github.com/nexocrew/3nigm4/lib/ishtm/db"
 	"github.com/nexocrew/3nigm4/lib/ishtm/will"
+	"github.com/nexocrew/3nigm4/lib/metrics"
 	"github.com/nexocrew/3nigm4/lib/sender"
 	"github.com/nexocrew/3nigm4/lib/sender/smtp"
 )
@@ -62,6 +64,7 @@ func init() {
 	RunCmd.PersistentFlags().Uint32VarP(&arguments.processScheduleMinutes, "processwait", "", 3, "defines the wait time for the processing routine iteration in minutes")
 	RunCmd.PersistentFlags().Uint32VarP(&arguments.dispatchScheduleMinutes, "dispatchtime", "", 5, "defines the wait time in looping for dispatching email messages produced by the processing routine in minutes")
 	RunCmd.PersistentFlags().Uint32VarP(&arguments.cleanupScheduleMinutes, "cleanuptime", "", 30, "run at defined intervals the cleanup function that remove email messages from the database in minutes")
+	RunCmd.PersistentFlags().StringVarP(&arguments.metricsAddress, "metrics", "", "", "the address used to expose Prometheus metrics (disabled if empty)")
 	RunCmd.PersistentFlags().BoolVarP(&arguments.now, "now", "", false, "for debugging execute routine every 10 seconds")
 	// files parameters
 	RunCmd.RunE = run
@@ -123,6 +126,19 @@ const (
 	minToleratedDuration = 1
 )
 
+// Dispatcher metrics.
+var (
+	processedWills = metrics.NewCounter(
+		"ishtm_processed_wills_total",
+		"Number of delivered wills processed producing email messages.")
+	sentEmails = metrics.NewCounter(
+		"ishtm_sent_emails_total",
+		"Number of successfully sent email messages.")
+	failedEmails = metrics.NewCounter(
+		"ishtm_failed_emails_total",
+		"Number of email messages that failed to be sent.")
+)
+
 // procArgs processing func used arguments.
 type procArgs struct {
 	database     ct.Database
@@ -193,6 +209,7 @@ func processEmails(genericArgs interface{}) error {
 		if err != nil {
 			return err
 		}
+		processedWills.Inc()
 	}
 	return nil
 }
@@ -226,6 +243,7 @@ func sendingAsync(genericArgs interface{}) error {
 		AttachmentName,
 	)
 	if err != nil {
+		failedEmails.Inc()
 		args.errorChan <- fmt.Errorf("error sending email: %s", err.Error())
 		args.message.Sended = false
 		// restore mail status by restoring sended
@@ -234,7 +252,9 @@ func sendingAsync(genericArgs interface{}) error {
 		if err != nil {
 			return err
 		}
+		return nil
 	}
+	sentEmails.Inc()
 	return nil
 }
 
@@ -331,6 +351,19 @@ func run(cmd *cobra.Command, args []string) error {
 	// startup sender
 	sender := senderStartup(&arguments)
 
+	// expose metrics
+	metrics.RegisterQueue("dispatcher", workingQueue)
+	if arguments.metricsAddress != "" {
+		http.Handle("/metrics", metrics.Handler())
+		go func() {
+			log.MessageLog("Exposing metrics on address %s.\n", arguments.metricsAddress)
+			err := http.ListenAndServe(arguments.metricsAddress, nil)
+			if err != nil {
+				log.ErrorLog("Unable to expose metrics: %s.\n", err.Error())
+			}
+		}()
+	}
+
 	// timers
 	var processingSchedule, dispatchSchedule, cleanupSchedule *time.Ticker
 	// chan used to async block schedule ops.
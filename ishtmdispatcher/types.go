@@ -27,6 +27,8 @@ type args struct {
 	processScheduleMinutes  uint32
 	dispatchScheduleMinutes uint32
 	cleanupScheduleMinutes  uint32
+	// metrics listening address
+	metricsAddress string
 	// debugging
 	now bool
 }
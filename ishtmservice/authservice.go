@@ -8,50 +8,94 @@ package main
 
 // Golang std pkgs
 import (
+	"crypto/tls"
 	"fmt"
-	"net/rpc"
+	"time"
 )
 
 // Internal pkgs
 import (
 	"github.com/nexocrew/3nigm4/lib/auth"
+	"github.com/nexocrew/3nigm4/lib/metrics"
 )
 
 // AuthClient is the interface used to interact
 // with authentication services.
 type AuthClient interface {
-	Login(string, string) ([]byte, error)                          // manage user's login;
+	Login(string, string, string, string, string) ([]byte, error)  // manage user's login, with an optional two factor code;
 	Logout([]byte) ([]byte, error)                                 // manage user's logout;
+	Refresh([]byte) ([]byte, error)                                // renew a still valid session;
 	AuthoriseAndGetInfo([]byte) (*auth.UserInfoResponseArg, error) // returns authenticated user infos or an error;
+	Ping() error                                                   // verifies the auth service is able to serve requests;
 	Close() error                                                  // closes eventual connections.
 }
 
+// Auth RPC client metrics.
+var (
+	authRpcDuration = metrics.NewHistogram(
+		"auth_rpc_duration_seconds",
+		"Latency of auth service RPC calls by method.",
+		nil,
+		"method")
+	authRpcErrors = metrics.NewCounter(
+		"auth_rpc_errors_total",
+		"Number of failed auth service RPC calls by method.",
+		"method")
+)
+
+// authCacheSize is the maximum number of user infos cached by
+// the RPC client.
+const authCacheSize = 4096
+
 // AuthRpc implements the RPC default client for
 // the 3nigm4 auth service.
 type AuthRpc struct {
-	client *rpc.Client
+	client *auth.RpcClient
+	cache  *auth.UserInfoCache
 }
 
 // NewAuthRpc creates a new instance of the RPC
-// client used to interact with the auth service.
-func NewAuthRpc(addr string, port int) (*AuthRpc, error) {
+// client used to interact with the auth service. The
+// connections are protected with TLS using the argument
+// config, if nil plain TCP connections are used. At max
+// poolSize connections are established and, if cacheTtl
+// is not zero, authorised users infos are cached for
+// cacheTtl.
+func NewAuthRpc(addr string, port int, config *tls.Config, poolSize int, cacheTtl time.Duration) (*AuthRpc, error) {
 	address := fmt.Sprintf("%s:%d", addr, port)
-	rawClient, err := rpc.DialHTTP("tcp", address)
+	client, err := auth.NewRpcClient(address, config, poolSize)
 	if err != nil {
 		return nil, err
 	}
 	return &AuthRpc{
-		client: rawClient,
+		client: client,
+		cache:  auth.NewUserInfoCache(cacheTtl, authCacheSize),
 	}, nil
 }
 
-// Login grant access to users, over RPC, using username and password.
-func (a *AuthRpc) Login(username string, password string) ([]byte, error) {
+// call invokes the argument RPC method measuring its latency.
+func (a *AuthRpc) call(method string, args interface{}, reply interface{}) error {
+	start := time.Now()
+	err := a.client.Call(method, args, reply)
+	authRpcDuration.ObserveSince(start, method)
+	if err != nil {
+		authRpcErrors.Inc(method)
+	}
+	return err
+}
+
+// Login grant access to users, over RPC, using username and password
+// plus, if two factor authentication is enabled, the otp code. The
+// client address and user agent are recorded in the session.
+func (a *AuthRpc) Login(username, password, otp, address, userAgent string) ([]byte, error) {
 	// perform login on RPC service
 	var loginResponse auth.LoginResponseArg
-	err := a.client.Call("Login.Login", &auth.LoginRequestArg{
-		Username: username,
-		Password: password,
+	err := a.call("Login.Login", &auth.LoginRequestArg{
+		Username:  username,
+		Password:  password,
+		OTP:       otp,
+		Address:   address,
+		UserAgent: userAgent,
 	}, &loginResponse)
 	if err != nil {
 		return nil, err
@@ -59,32 +103,81 @@ func (a *AuthRpc) Login(username string, password string) ([]byte, error) {
 	return loginResponse.Token, nil
 }
 
-// Logout remove actual active sessions over RPC.
+// Logout remove actual active sessions over RPC. The cached token
+// infos are invalidated once the session is removed, not to be
+// cached again by concurrent requests.
 func (a *AuthRpc) Logout(token []byte) ([]byte, error) {
 	var logoutResponse auth.LogoutResponseArg
-	err := a.client.Call("Login.Logout", &auth.LogoutRequestArg{
+	err := a.call("Login.Logout", &auth.LogoutRequestArg{
 		Token: token,
 	}, &logoutResponse)
+	a.cache.Invalidate(token)
 	if err != nil {
 		return nil, err
 	}
 	return logoutResponse.Invalidated, nil
 }
 
-// AuthoriseAndGetInfo if the token is valid returns info about
-// the associated user over RPC service.
+// Refresh renews a still valid session over RPC, returning the
+// new session token: the old one is invalidated, as its cached
+// infos once the session is replaced.
+func (a *AuthRpc) Refresh(token []byte) ([]byte, error) {
+	var refreshResponse auth.LoginResponseArg
+	err := a.call("Login.Refresh", &auth.RefreshRequestArg{
+		Token: token,
+	}, &refreshResponse)
+	a.cache.Invalidate(token)
+	if err != nil {
+		return nil, err
+	}
+	return refreshResponse.Token, nil
+}
+
+// AuthoriseAndGetInfo if the token, a session token or an api key,
+// is valid returns info about the associated user over RPC service,
+// recently authorised tokens are served from the cache, if enabled.
 func (a *AuthRpc) AuthoriseAndGetInfo(token []byte) (*auth.UserInfoResponseArg, error) {
+	if info, ok := a.cache.Get(token); ok {
+		return info, nil
+	}
+	method := "SessionAuth.UserInfo"
+	if auth.IsApiKey(token) {
+		method = "ApiKeys.UserInfo"
+	}
 	// verify token and retrieve user infos
 	var authResponse auth.UserInfoResponseArg
-	err := a.client.Call("SessionAuth.UserInfo", &auth.AuthenticateRequestArg{
+	err := a.call(method, &auth.AuthenticateRequestArg{
 		Token: token,
 	}, &authResponse)
 	if err != nil {
 		return nil, err
 	}
+	a.cache.Set(token, &authResponse)
 	return &authResponse, nil
 }
 
+// KickOutAllSessions removes, over RPC, all the active sessions of
+// the user owning the argument token.
+func (a *AuthRpc) KickOutAllSessions(token []byte) error {
+	info, err := a.AuthoriseAndGetInfo(token)
+	if err != nil {
+		return err
+	}
+	var kickResponse auth.VoidResponseArg
+	err = a.call("SessionAuth.KickOutAllSessions", &auth.AuthenticateRequestArg{
+		Token: token,
+	}, &kickResponse)
+	a.cache.InvalidateUser(info.Username)
+	return err
+}
+
+// Ping verifies, over RPC, that the auth service is able to serve
+// requests.
+func (a *AuthRpc) Ping() error {
+	var pingResponse auth.VoidResponseArg
+	return a.call("Health.Ping", &auth.PingRequestArg{}, &pingResponse)
+}
+
 // Close closes RPC connection.
 func (a *AuthRpc) Close() error {
 	return a.client.Close()
@@ -32,7 +32,7 @@ var (
 		Permissions: &auth.Permissions{
 			SuperAdmin: false,
 			Services: map[string]auth.Level{
-				"storage": auth.LevelUser,
+				ishtmServiceName: auth.LevelUser,
 			},
 		},
 		LastSeen: time.Now(),
@@ -54,7 +54,7 @@ func newAuthMock() (*authMock, error) {
 	}, nil
 }
 
-func (a *authMock) Login(username string, password string) ([]byte, error) {
+func (a *authMock) Login(username, password, otp, address, userAgent string) ([]byte, error) {
 	if password != a.credentials[username] {
 		return nil, fmt.Errorf("wrong credentials")
 	}
@@ -71,6 +71,20 @@ func (a *authMock) Logout(token []byte) ([]byte, error) {
 	return token, nil
 }
 
+func (a *authMock) Refresh(token []byte) ([]byte, error) {
+	info, ok := a.sessions[hex.EncodeToString(token)]
+	if !ok {
+		return nil, fmt.Errorf("wrong session token")
+	}
+	refreshed, err := ct.RandomBytesForLen(32)
+	if err != nil {
+		return nil, err
+	}
+	delete(a.sessions, hex.EncodeToString(token))
+	a.sessions[hex.EncodeToString(refreshed)] = info
+	return refreshed, nil
+}
+
 func (a *authMock) AuthoriseAndGetInfo(token []byte) (*auth.UserInfoResponseArg, error) {
 	info, ok := a.sessions[hex.EncodeToString(token)]
 	if !ok {
@@ -79,6 +93,10 @@ func (a *authMock) AuthoriseAndGetInfo(token []byte) (*auth.UserInfoResponseArg,
 	return info, nil
 }
 
+func (a *authMock) Ping() error {
+	return nil
+}
+
 func (a *authMock) Close() error {
 	return nil
 }
@@ -12,6 +12,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
@@ -22,6 +23,7 @@ import (
 	"github.com/nexocrew/3nigm4/lib/auth"
 	ct "github.com/nexocrew/3nigm4/lib/commons"
 	wl "github.com/nexocrew/3nigm4/lib/ishtm/will"
+	"github.com/nexocrew/3nigm4/lib/ratelimit"
 )
 
 // Third party pkgs
@@ -46,10 +48,63 @@ func riseError(status int, msg string, w http.ResponseWriter, ipa string) {
 	}
 }
 
-// authoriseGettingUserInfos authorises the provided token
-// and return user associated data. If returns a nil value
-// it means something went wrong.
-func authoriseGettingUserInfos(authToken string) (*auth.UserInfoResponseArg, error) {
+// riseLimitError rises a too many requests error advising the
+// client, if possible, about when to retry.
+func riseLimitError(err error, w http.ResponseWriter, ipa string) {
+	if limitErr, ok := err.(*ratelimit.LimitError); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limitErr.RetryAfter.Seconds()))))
+	}
+	riseError(http.StatusTooManyRequests,
+		err.Error(), w,
+		ipa)
+}
+
+// Scopes required to api keys, session tokens are authorised to
+// perform any operation.
+const (
+	createScope = "ishtm:create"
+	readScope   = "ishtm:read"
+	pingScope   = "ishtm:ping"
+	deleteScope = "ishtm:delete"
+)
+
+// ishtmServiceName is the key used to look up ishtm permissions in
+// the user's permissions.
+const ishtmServiceName = "ishtm"
+
+// serviceAuthoriser verifies users permission levels on the ishtm
+// service.
+var serviceAuthoriser = auth.NewServiceAuthoriser(ishtmServiceName)
+
+// authenticateRequest authenticates requests for the service
+// middleware: api keys scopes are verified by each handler.
+func authenticateRequest(r *http.Request) (*auth.UserInfoResponseArg, error) {
+	authResponse, err := requestUserInfos(r)
+	if err != nil {
+		return nil, err
+	}
+	err = authResponse.Authorise("", r.RemoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	return authResponse, nil
+}
+
+// refuseRequest writes the response of requests refused by the
+// service middleware.
+func refuseRequest(status int, msg string, w http.ResponseWriter, r *http.Request) {
+	riseError(status, msg, w, r.RemoteAddr)
+}
+
+// requestUserInfos returns the user's infos associated to the
+// token, a session token or an api key, provided in the request
+// headers. User's infos already authorised by the service
+// middleware are reused.
+func requestUserInfos(r *http.Request) (*auth.UserInfoResponseArg, error) {
+	if authResponse := auth.RequestUserInfo(r); authResponse != nil {
+		return authResponse, nil
+	}
+	authToken := r.Header.Get(ct.SecurityTokenKey)
 	if authToken == "" {
 		return nil, fmt.Errorf("authorisation token is nil")
 	}
@@ -58,7 +113,25 @@ func authoriseGettingUserInfos(authToken string) (*auth.UserInfoResponseArg, err
 	if err != nil {
 		return nil, fmt.Errorf("authorisation token is malformed (%s)", err.Error())
 	}
-	authResponse, err := authClient.AuthoriseAndGetInfo(token)
+	return authClient.AuthoriseAndGetInfo(token)
+}
+
+// authoriseGettingUserInfos authorises the token, a session token
+// or an api key, provided in the request headers and return user
+// associated data. Api keys are verified to allow the required
+// scope from the request remote address and users to be granted
+// a level on the service. If returns a nil value it means
+// something went wrong.
+func authoriseGettingUserInfos(r *http.Request, scope string) (*auth.UserInfoResponseArg, error) {
+	authResponse, err := requestUserInfos(r)
+	if err != nil {
+		return nil, err
+	}
+	err = authResponse.Authorise(scope, r.RemoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	err = serviceAuthoriser.Authorise(authResponse, auth.LevelUser)
 	if err != nil {
 		return nil, err
 	}
@@ -89,14 +162,36 @@ func login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// check for brute-force attempts
+	userKey := ratelimit.UserKey(requestBody.Username)
+	addressKey := ratelimit.AddressKey(r.RemoteAddr)
+	reservation, err := loginLimiter.Reserve(userKey, addressKey)
+	if err != nil {
+		riseLimitError(err, w, r.RemoteAddr)
+		return
+	}
+	defer reservation.Cancel()
+
 	// perform login on auth service
-	token, err := authClient.Login(requestBody.Username, requestBody.Password)
+	token, err := authClient.Login(requestBody.Username, requestBody.Password, requestBody.OTP, r.RemoteAddr, r.UserAgent())
+	if auth.IsOtpRequired(err) {
+		// not a failure: the client should retry with the code
+		riseError(http.StatusUnauthorized,
+			ct.OtpRequiredError, w,
+			r.RemoteAddr)
+		return
+	}
 	if err != nil {
+		reservation.Failure()
 		riseError(http.StatusUnauthorized,
 			"unable to login with provided credentials", w,
 			r.RemoteAddr)
 		return
 	}
+	// only user's failures are forgotten: a valid login should
+	// not reset failures produced, from the same address, on
+	// other accounts.
+	reservation.Success(userKey)
 	// return the session token
 	w.WriteHeader(http.StatusOK)
 	err = json.NewEncoder(w).Encode(
@@ -144,6 +239,43 @@ func logout(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// refresh renews, redirecting to auth service, a still valid
+// user's session returning a new session token. It's used by
+// clients to avoid session expiration during long operations.
+func refresh(w http.ResponseWriter, r *http.Request) {
+	authToken := r.Header.Get(ct.SecurityTokenKey)
+	if authToken == "" {
+		riseError(http.StatusBadRequest,
+			"auth token is nil", w,
+			r.RemoteAddr)
+		return
+	}
+
+	rawToken, err := hex.DecodeString(authToken)
+	if err != nil {
+		riseError(http.StatusBadRequest,
+			"auth token is malformed", w,
+			r.RemoteAddr)
+		return
+	}
+	token, err := authClient.Refresh(rawToken)
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			"unable to refresh session with provided credentials", w,
+			r.RemoteAddr)
+		return
+	}
+	// return the new session token
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(
+		&ct.LoginResponse{
+			Token: hex.EncodeToString(token),
+		})
+	if err != nil {
+		panic(err)
+	}
+}
+
 var (
 	additionalExpiration = 24 * time.Hour
 )
@@ -152,7 +284,7 @@ var (
 func postWill(w http.ResponseWriter, r *http.Request) {
 	// authorise and get user's info
 	// extract token from headers
-	userInfo, err := authoriseGettingUserInfos(r.Header.Get(ct.SecurityTokenKey))
+	userInfo, err := authoriseGettingUserInfos(r, createScope)
 	if err != nil {
 		riseError(http.StatusUnauthorized,
 			err.Error(), w,
@@ -247,14 +379,17 @@ func getWill(w http.ResponseWriter, r *http.Request) {
 
 	var userInfo *auth.UserInfoResponseArg
 	var authKey []byte
+	var reservation *ratelimit.Reservation
 	var err error
+	willKey := ratelimit.Key("will", id)
+	addressKey := ratelimit.AddressKey(r.RemoteAddr)
 	/* get query parameters */
 	query := r.URL.Query()
 	key, ok := query["deliverykey"]
 	if !ok {
 		// authorise and get user's info
 		// extract token from headers
-		userInfo, err = authoriseGettingUserInfos(r.Header.Get(ct.SecurityTokenKey))
+		userInfo, err = authoriseGettingUserInfos(r, readScope)
 		if err != nil {
 			riseError(http.StatusUnauthorized,
 				err.Error(), w,
@@ -262,7 +397,15 @@ func getWill(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	} else {
+		// check for brute-force attempts
+		reservation, err = deliveryLimiter.Reserve(willKey, addressKey)
+		if err != nil {
+			riseLimitError(err, w, r.RemoteAddr)
+			return
+		}
+		defer reservation.Cancel()
 		if len(key) < 1 || len(key[0]) == 0 {
+			reservation.Failure()
 			riseError(http.StatusUnauthorized,
 				"unable to read delivery key", w,
 				r.RemoteAddr)
@@ -270,6 +413,7 @@ func getWill(w http.ResponseWriter, r *http.Request) {
 		}
 		authKey, err = hex.DecodeString(key[0])
 		if err != nil {
+			reservation.Failure()
 			riseError(http.StatusUnauthorized,
 				"unable to read delivery key", w,
 				r.RemoteAddr)
@@ -292,11 +436,13 @@ func getWill(w http.ResponseWriter, r *http.Request) {
 		// check for delivery key and manage get for
 		// recipients.
 		if bytes.Compare(will.DeliveryKey, authKey) != 0 {
+			reservation.Failure()
 			riseError(http.StatusUnauthorized,
 				"unable to authosize delivery key", w,
 				r.RemoteAddr)
 			return
 		}
+		reservation.Success(willKey)
 	} else if userInfo != nil {
 		// check for logged user
 		if userInfo.Username != will.Owner.Name {
@@ -372,7 +518,7 @@ func patchWill(w http.ResponseWriter, r *http.Request) {
 
 	// authorise and get user's info
 	// extract token from headers
-	userInfo, err := authoriseGettingUserInfos(r.Header.Get(ct.SecurityTokenKey))
+	userInfo, err := authoriseGettingUserInfos(r, pingScope)
 	if err != nil {
 		riseError(http.StatusUnauthorized,
 			err.Error(), w,
@@ -397,6 +543,16 @@ func patchWill(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// check for brute-force attempts
+	willKey := ratelimit.Key("will", id)
+	addressKey := ratelimit.AddressKey(r.RemoteAddr)
+	reservation, err := otpLimiter.Reserve(willKey, addressKey)
+	if err != nil {
+		riseLimitError(err, w, r.RemoteAddr)
+		return
+	}
+	defer reservation.Cancel()
+
 	// validate OTP
 	err = will.VerifyOtp(
 		willRequest.Index,
@@ -404,11 +560,13 @@ func patchWill(w http.ResponseWriter, r *http.Request) {
 		willRequest.SecondaryKey,
 	)
 	if err != nil {
+		reservation.Failure()
 		riseError(http.StatusUnauthorized,
 			fmt.Sprintf("unable to authorize the request %s", err.Error()), w,
 			r.RemoteAddr)
 		return
 	}
+	reservation.Success(willKey)
 
 	err = will.Refresh()
 	if err != nil {
@@ -456,7 +614,7 @@ func deleteWill(w http.ResponseWriter, r *http.Request) {
 
 	// authorise and get user's info
 	// extract token from headers
-	userInfo, err := authoriseGettingUserInfos(r.Header.Get(ct.SecurityTokenKey))
+	userInfo, err := authoriseGettingUserInfos(r, deleteScope)
 	if err != nil {
 		riseError(http.StatusUnauthorized,
 			err.Error(), w,
@@ -513,6 +671,16 @@ func deleteWill(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// check for brute-force attempts
+	willKey := ratelimit.Key("will", id)
+	addressKey := ratelimit.AddressKey(r.RemoteAddr)
+	reservation, err := otpLimiter.Reserve(willKey, addressKey)
+	if err != nil {
+		riseLimitError(err, w, r.RemoteAddr)
+		return
+	}
+	defer reservation.Cancel()
+
 	// validate OTP
 	err = will.VerifyOtp(
 		idx,
@@ -520,11 +688,13 @@ func deleteWill(w http.ResponseWriter, r *http.Request) {
 		secondaryk,
 	)
 	if err != nil {
+		reservation.Failure()
 		riseError(http.StatusUnauthorized,
 			fmt.Sprintf("unable to authorize the request %s", err.Error()), w,
 			r.RemoteAddr)
 		return
 	}
+	reservation.Success(willKey)
 
 	err = dbSession.RemoveWill(id)
 	if err != nil {
@@ -0,0 +1,111 @@
+//
+// 3nigm4 ishtmservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 14/09/2016
+//
+
+package main
+
+// Golang std pkgs
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Internal pkgs
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+// healthCheckTimeout is the maximum time waited for a single
+// dependency check.
+const healthCheckTimeout = 5 * time.Second
+
+// shuttingDown is set when the service starts a graceful shutdown,
+// from then on it's reported as not ready.
+var shuttingDown int32
+
+// runHealthChecks concurrently executes the argument checks
+// returning the errors of the failing ones.
+func runHealthChecks(checks map[string]func() error) map[string]string {
+	var mtx sync.Mutex
+	failures := make(map[string]string)
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func() error) {
+			defer wg.Done()
+			result := make(chan error, 1)
+			go func() {
+				result <- check()
+			}()
+			var err error
+			select {
+			case err = <-result:
+			case <-time.After(healthCheckTimeout):
+				err = fmt.Errorf("timeout after %s", healthCheckTimeout.String())
+			}
+			if err != nil {
+				mtx.Lock()
+				failures[name] = err.Error()
+				mtx.Unlock()
+			}
+		}(name, check)
+	}
+	wg.Wait()
+	return failures
+}
+
+// getLive reports that the service process is up and serving
+// requests, dependencies are not checked.
+func getLive(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(&ct.HealthResponse{
+		Status: ct.AckResponse,
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// getReady reports if the service is able to serve requests
+// verifying the database and the auth RPC service. Not ready
+// services return a 503 status code.
+func getReady(w http.ResponseWriter, r *http.Request) {
+	var failures map[string]string
+	if atomic.LoadInt32(&shuttingDown) != 0 {
+		failures = map[string]string{
+			"service": "shutting down",
+		}
+	} else {
+		failures = runHealthChecks(map[string]func() error{
+			"database": func() error {
+				dbSession := db.Copy()
+				defer dbSession.Close()
+				return dbSession.Ping()
+			},
+			"auth": func() error {
+				return authClient.Ping()
+			},
+		})
+	}
+
+	response := ct.HealthResponse{
+		Status: ct.AckResponse,
+	}
+	status := http.StatusOK
+	if len(failures) != 0 {
+		response.Status = ct.NakResponse
+		response.Checks = failures
+		status = http.StatusServiceUnavailable
+	}
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		panic(err)
+	}
+}
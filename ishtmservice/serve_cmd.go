@@ -8,17 +8,27 @@ package main
 
 // Golang std pkgs
 import (
+	"context"
 	"crypto/sha256"
+	"crypto/tls"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 // Internal pkgs
 import (
+	"github.com/nexocrew/3nigm4/lib/auth"
 	ct "github.com/nexocrew/3nigm4/lib/ishtm/commons"
 	ishtmdb "github.com/nexocrew/3nigm4/lib/ishtm/db"
 	"github.com/nexocrew/3nigm4/lib/ishtm/will"
+	"github.com/nexocrew/3nigm4/lib/metrics"
+	"github.com/nexocrew/3nigm4/lib/ratelimit"
 )
 
 // Third party pkgs
@@ -56,6 +66,16 @@ func init() {
 	// auth RPC service
 	ServeCmd.PersistentFlags().StringVarP(&arguments.authServiceAddress, "authaddr", "A", "", "the authorisation RPC service address")
 	ServeCmd.PersistentFlags().IntVarP(&arguments.authServicePort, "authport", "P", 7931, "the authorisation RPC service port")
+	ServeCmd.PersistentFlags().StringVarP(&arguments.authCA, "authca", "", "", "the PEM file of the CA used to verify the authorisation RPC service certificate")
+	ServeCmd.PersistentFlags().StringVarP(&arguments.authCertificate, "authcert", "", "", "the client certificate PEM file presented to the authorisation RPC service")
+	ServeCmd.PersistentFlags().StringVarP(&arguments.authPrivateKey, "authkey", "", "", "the client certificate private key PEM file")
+	ServeCmd.PersistentFlags().BoolVarP(&arguments.authInsecure, "authinsecure", "", false, "connect to the authorisation RPC service over plain TCP (passwords and tokens are sent in clear text)")
+	ServeCmd.PersistentFlags().IntVarP(&arguments.authPoolSize, "authpool", "", 4, "maximum number of connections to the authorisation RPC service")
+	ServeCmd.PersistentFlags().Uint32VarP(&arguments.authCacheSeconds, "authcache", "", 0, "time, in seconds, authorised users infos are cached avoiding authorisation RPC calls (0 disables the cache)")
+	// brute-force protection
+	arguments.limits.Register(ServeCmd.PersistentFlags(), "login, delivery key and OTP")
+	// graceful shutdown
+	ServeCmd.PersistentFlags().Uint32VarP(&arguments.shutdownTimeoutSeconds, "shutdowntimeout", "", 30, "maximum time, in seconds, waited for in flight requests on shutdown")
 	// files parameters
 	ServeCmd.RunE = serve
 }
@@ -106,18 +126,92 @@ var authClientStartup func(*args) (AuthClient, error) = rpcClientStartup
 // rpcClientStartup creates an RPC client and returns it if no error
 // encountered. It manage a success log if all went right.
 func rpcClientStartup(a *args) (AuthClient, error) {
-	client, err := NewAuthRpc(arguments.authServiceAddress, arguments.authServicePort)
+	var config *tls.Config
+	if a.authInsecure {
+		log.WarningLog("Connecting to auth RPC service without SSL/TLS, this can produce security risks.\n")
+	} else {
+		var err error
+		config, err = auth.ClientTLSConfig(&auth.TLSArgs{
+			CA:          a.authCA,
+			Certificate: a.authCertificate,
+			PrivateKey:  a.authPrivateKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to configure TLS (use authinsecure flag to connect via plain TCP): %s", err.Error())
+		}
+	}
+	client, err := NewAuthRpc(
+		a.authServiceAddress,
+		a.authServicePort,
+		config,
+		a.authPoolSize,
+		time.Duration(a.authCacheSeconds)*time.Second)
 	if err != nil {
 		return nil, err
 	}
 
 	log.MessageLog("Initialised connection with auth RPC service: %s:%d.\n",
-		arguments.authServiceAddress,
-		arguments.authServicePort)
+		a.authServiceAddress,
+		a.authServicePort)
 
 	return client, nil
 }
 
+// Global brute-force protection limiters: login failures are
+// counted per username and origin ip address, delivery keys and
+// OTPs failures per will id and origin ip address.
+var (
+	loginLimiter    *ratelimit.Limiter
+	deliveryLimiter *ratelimit.Limiter
+	otpLimiter      *ratelimit.Limiter
+)
+
+// publicRoutes are the names of the routes not requiring users to
+// be granted a level on the service: login, wills retrieval using a
+// delivery key and health checks.
+var publicRoutes = []string{
+	"login",
+	"delivery",
+	"ping",
+	"live",
+	"ready",
+	"metrics",
+}
+
+// newRouter creates the service router: all the routes, but the
+// public ones, are reserved to users granted a level on the ishtm
+// service.
+func newRouter() (*mux.Router, error) {
+	route := mux.NewRouter()
+	// define auth routes
+	route.HandleFunc("/v1/authsession", login).Methods("POST").Name("login")
+	route.HandleFunc("/v1/authsession", logout).Methods("DELETE")
+	route.HandleFunc("/v1/authsession", refresh).Methods("PUT")
+	// exposed routes to manage ishtm will
+	route.HandleFunc("/v1/ishtm/will", postWill).Methods("POST")
+	route.HandleFunc("/v1/ishtm/will/{willid:[A-Fa-f0-9]+}", getWill).Methods("GET").Queries("deliverykey", "{deliverykey}").Name("delivery")
+	route.HandleFunc("/v1/ishtm/will/{willid:[A-Fa-f0-9]+}", getWill).Methods("GET")
+	route.HandleFunc("/v1/ishtm/will/{willid:[A-Fa-f0-9]+}", patchWill).Methods("PATCH")
+	route.HandleFunc("/v1/ishtm/will/{willid:[A-Fa-f0-9]+}", deleteWill).Methods("DELETE")
+	// utility routes
+	route.HandleFunc("/v1/ping", getPing).Methods("GET").Name("ping")
+	// health routes: liveness and readiness, checking dependencies.
+	route.HandleFunc("/v1/health/live", getLive).Methods("GET").Name("live")
+	route.HandleFunc("/v1/health/ready", getReady).Methods("GET").Name("ready")
+	// metrics route: exposes service metrics in Prometheus format.
+	route.Handle("/metrics", metrics.Handler()).Methods("GET").Name("metrics")
+	// users not granted a level on the service are refused on all
+	// non public routes.
+	err := auth.ProtectRouter(
+		route,
+		serviceAuthoriser.Middleware(auth.LevelUser, authenticateRequest, refuseRequest),
+		publicRoutes...)
+	if err != nil {
+		return nil, err
+	}
+	return route, nil
+}
+
 // serve command expose a REST API.
 func serve(cmd *cobra.Command, args []string) error {
 	printLogo()
@@ -143,43 +237,81 @@ func serve(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("unable to start RPC client connection: %s", err.Error())
 	}
 	defer authClient.Close()
+	// startup brute-force protection
+	loginLimiter = arguments.limits.NewLimiter(log)
+	deliveryLimiter = arguments.limits.NewLimiter(log)
+	otpLimiter = arguments.limits.NewLimiter(log)
 
 	// create router
-	route := mux.NewRouter()
-	// define auth routes
-	route.HandleFunc("/v1/authsession", login).Methods("POST")
-	route.HandleFunc("/v1/authsession", logout).Methods("DELETE")
-	// exposed routes to manage ishtm will
-	route.HandleFunc("/v1/ishtm/will", postWill).Methods("POST")
-	route.HandleFunc("/v1/ishtm/will/{willid:[A-Fa-f0-9]+}", getWill).Methods("GET")
-	route.HandleFunc("/v1/ishtm/will/{willid:[A-Fa-f0-9]+}", patchWill).Methods("PATCH")
-	route.HandleFunc("/v1/ishtm/will/{willid:[A-Fa-f0-9]+}", deleteWill).Methods("DELETE")
-	// utility routes
-	route.HandleFunc("/v1/ping", getPing).Methods("GET")
-	// root routes
-	http.Handle("/", route)
+	route, err := newRouter()
+	if err != nil {
+		return err
+	}
+	// root routes: requests are counted and timed by route.
+	http.Handle("/", metrics.InstrumentRouter(route))
 
 	serviceAddress := fmt.Sprintf("%s:%d", arguments.address, arguments.port)
-	// init http or https connection
-	if arguments.SslCertificate != "" &&
-		arguments.SslPrivateKey != "" {
-		log.MessageLog("Starting listening with TLS on address %s.\n", serviceAddress)
+	server := &http.Server{
+		Addr: serviceAddress,
+	}
+	serverErrors := make(chan error, 1)
+	go func() {
+		serverErrors <- listenAndServe(server, &arguments)
+	}()
+
+	// wait for termination signals
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	select {
+	case err = <-serverErrors:
+		return err
+	case sig := <-signals:
+		log.MessageLog("Received %s signal, shutting down.\n", sig.String())
+	}
+	shutdown(server, time.Duration(arguments.shutdownTimeoutSeconds)*time.Second)
+	return nil
+}
+
+// listenAndServe starts the http or https, if certificates are
+// configured, server returning when it's closed.
+func listenAndServe(server *http.Server, a *args) error {
+	if a.SslCertificate != "" &&
+		a.SslPrivateKey != "" {
+		log.MessageLog("Starting listening with TLS on address %s.\n", server.Addr)
 		// set up SSL/TLS
-		err = http.ListenAndServeTLS(
-			serviceAddress,
-			arguments.SslCertificate,
-			arguments.SslPrivateKey,
-			nil)
-		if err != nil {
-			return fmt.Errorf("https unable to listen and serve on address: %s cause error: %s", serviceAddress, err.Error())
-		}
-	} else {
-		log.WarningLog("Starting listening on address %s (no SSL/TLS this can produce security risks).\n", serviceAddress)
-		// plain https
-		err = http.ListenAndServe(serviceAddress, nil)
-		if err != nil {
-			return fmt.Errorf("http unable to listen and serve on address: %s cause error: %s", serviceAddress, err.Error())
+		err := server.ListenAndServeTLS(
+			a.SslCertificate,
+			a.SslPrivateKey)
+		if err != nil &&
+			err != http.ErrServerClosed {
+			return fmt.Errorf("https unable to listen and serve on address: %s cause error: %s", server.Addr, err.Error())
 		}
+		return nil
+	}
+	log.WarningLog("Starting listening on address %s (no SSL/TLS this can produce security risks).\n", server.Addr)
+	// plain https
+	err := server.ListenAndServe()
+	if err != nil &&
+		err != http.ErrServerClosed {
+		return fmt.Errorf("http unable to listen and serve on address: %s cause error: %s", server.Addr, err.Error())
 	}
 	return nil
 }
+
+// shutdown gracefully stops the service: new requests are refused
+// while in flight ones are completed within the argument timeout.
+// Resources are then released by serve deferred calls.
+func shutdown(server *http.Server, timeout time.Duration) {
+	atomic.StoreInt32(&shuttingDown, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// stop accepting requests
+	err := server.Shutdown(ctx)
+	if err != nil {
+		log.ErrorLog("Unable to complete in flight requests: %s.\n", err.Error())
+		return
+	}
+	log.MessageLog("Service gracefully stopped.\n")
+}
@@ -15,6 +15,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -22,6 +23,7 @@ import (
 
 // Internal dependencies.
 import (
+	"github.com/nexocrew/3nigm4/lib/auth"
 	ct "github.com/nexocrew/3nigm4/lib/commons"
 	crypto3n4 "github.com/nexocrew/3nigm4/lib/crypto"
 	ishtmct "github.com/nexocrew/3nigm4/lib/ishtm/commons"
@@ -34,6 +36,7 @@ import (
 // Third party packages
 import (
 	"github.com/gokyle/hotp"
+	"github.com/gorilla/mux"
 )
 
 var (
@@ -199,6 +202,82 @@ func TestPing(t *testing.T) {
 	}
 }
 
+func TestServicePermissions(t *testing.T) {
+	rawToken, err := ct.RandomBytesForLen(32)
+	if err != nil {
+		t.Fatalf("Unable to generate token: %s.\n", err.Error())
+	}
+	token := hex.EncodeToString(rawToken)
+	// storage only account
+	mock := authClient.(*authMock)
+	mock.sessions[token] = &auth.UserInfoResponseArg{
+		Username: "userB",
+		Permissions: &auth.Permissions{
+			Services: map[string]auth.Level{
+				"storage": auth.LevelAdmin,
+			},
+		},
+	}
+	defer delete(mock.sessions, token)
+
+	// collect all the routes, but the public ones
+	router, err := newRouter()
+	if err != nil {
+		t.Fatalf("Unable to create router: %s.\n", err.Error())
+	}
+	public := make(map[string]bool)
+	for _, name := range publicRoutes {
+		public[name] = true
+	}
+	variables := regexp.MustCompile(`{[^}]+}`)
+	var requests []*http.Request
+	err = router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		path := variables.ReplaceAllString(template, "0a1b2c")
+		for _, method := range []string{"GET", "POST", "PUT", "PATCH", "DELETE"} {
+			req, err := http.NewRequest(
+				method,
+				fmt.Sprintf("http://%s:%d%s", mockServiceAddress, mockServicePort, path),
+				bytes.NewBufferString("{}"))
+			if err != nil {
+				return err
+			}
+			var match mux.RouteMatch
+			if router.Match(req, &match) &&
+				match.Route == route &&
+				!public[route.GetName()] {
+				requests = append(requests, req)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unable to walk routes: %s.\n", err.Error())
+	}
+	if len(requests) != 6 {
+		t.Fatalf("Having %d protected routes expecting 6.\n", len(requests))
+	}
+
+	client := &http.Client{}
+	for _, req := range requests {
+		req.Header.Set(ct.SecurityTokenKey, token)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Unable to perform %s %s request on server: %s.\n", req.Method, req.URL.Path, err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("Users without ishtm permissions should be refused on %s %s, returned %d but expected %d.\n", req.Method, req.URL.Path, resp.StatusCode, http.StatusForbidden)
+		}
+	}
+	if _, ok := mock.sessions[token]; !ok {
+		t.Fatalf("Refused requests should not reach handlers.\n")
+	}
+}
+
 func TestWillPost(t *testing.T) {
 	// Login the user
 	loginBody := ct.LoginRequest{
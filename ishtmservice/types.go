@@ -6,6 +6,11 @@
 
 package main
 
+// Internal pkgs
+import (
+	"github.com/nexocrew/3nigm4/lib/ratelimit"
+)
+
 // Arguments management struct.
 type args struct {
 	// server basic args
@@ -25,6 +30,16 @@ type args struct {
 	// auth rpc service
 	authServiceAddress string
 	authServicePort    int
+	authCA             string
+	authCertificate    string
+	authPrivateKey     string
+	authInsecure       bool
+	authPoolSize       int
+	authCacheSeconds   uint32
 	// encryption keys
 	encryptionKey string
+	// brute-force protection
+	limits ratelimit.Flags
+	// graceful shutdown
+	shutdownTimeoutSeconds uint32
 }
@@ -0,0 +1,392 @@
+//
+// 3nigm4 auth package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+// API keys are long lived credentials, used by automated
+// clients, accepted by services in place of session tokens. Keys
+// are restricted to a set of scopes, in the form
+// <service>:<operation>, and optionally to source addresses and
+// an expiration time: services must verify them, for each
+// request, using UserInfoResponseArg.Authorise.
+//
+
+package auth
+
+// Golang std libs
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Internal dependencies
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+const (
+	apiKeyIdSize     = 8  // public identifier size in bytes;
+	apiKeySecretSize = 32 // secret size in bytes;
+	kMaxApiKeys      = 32 // maximum number of keys per user.
+)
+
+// kLastUsedResolution is the resolution of the api keys last used
+// time stamp: it's not updated more frequently to avoid a database
+// write for each authorised request.
+const kLastUsedResolution = time.Minute
+
+// apiKeyPrefix distinguishes api keys from session tokens.
+var apiKeyPrefix = []byte("3n4k")
+
+// scopeRegexp validates scopes: the operation can be a wildcard
+// matching all the operations of a service.
+var scopeRegexp = regexp.MustCompile(`^[a-z0-9]+:([a-z0-9]+|\*)$`)
+
+// IsApiKey verifies if a token, passed to services in place of a
+// session token, is an api key.
+func IsApiKey(token []byte) bool {
+	return len(token) == len(apiKeyPrefix)+apiKeyIdSize+apiKeySecretSize &&
+		bytes.HasPrefix(token, apiKeyPrefix)
+}
+
+// splitApiKey returns the identifier and the secret of an api key.
+func splitApiKey(token []byte) (string, []byte) {
+	id := token[len(apiKeyPrefix) : len(apiKeyPrefix)+apiKeyIdSize]
+	return hex.EncodeToString(id), token[len(apiKeyPrefix)+apiKeyIdSize:]
+}
+
+// generateApiKey creates a new api key returning its identifier,
+// the key to be released to the user and the secret hash to be
+// stored.
+func generateApiKey() (string, []byte, []byte, error) {
+	random, err := ct.RandomBytesForLen(apiKeyIdSize + apiKeySecretSize)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	token := append(append([]byte{}, apiKeyPrefix...), random...)
+	id, secret := splitApiKey(token)
+	hash := sha256.Sum256(secret)
+	return id, token, hash[:], nil
+}
+
+// ValidScope verifies the format of a scope.
+func ValidScope(scope string) bool {
+	return scopeRegexp.MatchString(scope)
+}
+
+// parseAllowedAddress parses an allowed address, either an IP or
+// a CIDR network.
+func parseAllowedAddress(address string) (*net.IPNet, error) {
+	if strings.Contains(address, "/") {
+		_, network, err := net.ParseCIDR(address)
+		return network, err
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %s", address)
+	}
+	bits := 8 * net.IPv4len
+	if ip.To4() == nil {
+		bits = 8 * net.IPv6len
+	}
+	return &net.IPNet{
+		IP:   ip,
+		Mask: net.CIDRMask(bits, bits),
+	}, nil
+}
+
+// addressAllowed verifies if a remote address, in the host:port
+// form or a plain IP, matches one of the allowed ones.
+func addressAllowed(allowed []string, remote string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		host = remote
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, address := range allowed {
+		network, err := parseAllowedAddress(address)
+		if err != nil {
+			continue
+		}
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope verifies if the authorised credential allows the
+// argument scope: sessions allow any scope while api keys must
+// include it, or a wildcard for its service. An empty scope is
+// allowed to any credential.
+func (u *UserInfoResponseArg) HasScope(scope string) bool {
+	if u.ApiKey == "" ||
+		scope == "" {
+		return true
+	}
+	service := strings.SplitN(scope, ":", 2)[0]
+	for _, allowed := range u.Scopes {
+		if allowed == scope ||
+			allowed == service+":*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorise verifies that the authorised credential can be used,
+// from the argument remote address, for an operation requiring
+// scope. Sessions are always authorised, api keys are verified
+// against their expiration, allowed addresses and scopes.
+func (u *UserInfoResponseArg) Authorise(scope string, remote string) error {
+	if u.ApiKey == "" {
+		return nil
+	}
+	if !u.Expiration.IsZero() &&
+		time.Now().After(u.Expiration) {
+		return fmt.Errorf("api key is expired")
+	}
+	if !addressAllowed(u.AllowedAddresses, remote) {
+		return fmt.Errorf("api key not allowed from address %s", remote)
+	}
+	if !u.HasScope(scope) {
+		return fmt.Errorf("api key not allowed to perform %s operations", scope)
+	}
+	return nil
+}
+
+// ApiKeys RPC required custom type, exposes api keys management
+// and authorisation functions. Keys can be managed only using a
+// session token.
+type ApiKeys int
+
+// ApiKeyCreateRequestArg request to create a new api key.
+type ApiKeyCreateRequestArg struct {
+	Token            []byte    // the session token;
+	Name             string    // descriptive key name;
+	Scopes           []string  // allowed operations;
+	AllowedAddresses []string  // allowed source IPs or CIDR networks, all if empty;
+	Expiration       time.Time // expiration time, never if zero.
+}
+
+// ApiKeyCreateResponseArg returns the created key, it's not
+// retrievable anymore after this call.
+type ApiKeyCreateResponseArg struct {
+	Key  []byte // the api key;
+	Info ApiKey // key properties, the hash is never returned.
+}
+
+// ApiKeyListResponseArg returns the user api keys.
+type ApiKeyListResponseArg struct {
+	Keys []ApiKey // keys properties, hashes are never returned.
+}
+
+// ApiKeyRevokeRequestArg request to revoke an api key.
+type ApiKeyRevokeRequestArg struct {
+	Token []byte // the session token;
+	Id    string // the api key identifier.
+}
+
+// Create RPC exposed function creates a new api key for the
+// session user.
+func (k *ApiKeys) Create(args *ApiKeyCreateRequestArg, response *ApiKeyCreateResponseArg) error {
+	// check for session
+	if dbclient == nil {
+		return fmt.Errorf("invalid db session, unable to proceed")
+	}
+	client := dbclient.Copy()
+	defer client.Close()
+
+	// check for arguments
+	if args == nil {
+		return fmt.Errorf("invalid nil token data")
+	}
+	if args.Name == "" {
+		return fmt.Errorf("invalid nil api key name")
+	}
+	if len(args.Scopes) == 0 {
+		return fmt.Errorf("at least a scope is required")
+	}
+	for _, scope := range args.Scopes {
+		if !ValidScope(scope) {
+			return fmt.Errorf("invalid scope %s, expecting <service>:<operation>", scope)
+		}
+	}
+	for _, address := range args.AllowedAddresses {
+		if _, err := parseAllowedAddress(address); err != nil {
+			return fmt.Errorf("invalid allowed address %s", address)
+		}
+	}
+	now := time.Now()
+	if !args.Expiration.IsZero() &&
+		!args.Expiration.After(now) {
+		return fmt.Errorf("expiration time should be in the future")
+	}
+
+	user, err := sessionUser(client, args.Token)
+	if err != nil {
+		return err
+	}
+	keys, err := client.GetUserApiKeys(user.Username)
+	if err != nil {
+		return fmt.Errorf("unable to get api keys: %s", err.Error())
+	}
+	if len(keys) >= kMaxApiKeys {
+		return fmt.Errorf("maximum number of api keys (%d) reached", kMaxApiKeys)
+	}
+
+	id, token, hash, err := generateApiKey()
+	if err != nil {
+		return err
+	}
+	key := &ApiKey{
+		Id:               id,
+		Username:         user.Username,
+		Name:             args.Name,
+		Hash:             hash,
+		Scopes:           args.Scopes,
+		AllowedAddresses: args.AllowedAddresses,
+		CreationTime:     now,
+		Expiration:       args.Expiration,
+	}
+	err = client.SetApiKey(key)
+	if err != nil {
+		return fmt.Errorf("unable to save api key: %s", err.Error())
+	}
+
+	response.Key = token
+	response.Info = *key
+	response.Info.Hash = nil
+	return nil
+}
+
+// List RPC exposed function returns the api keys of the session
+// user.
+func (k *ApiKeys) List(args *AuthenticateRequestArg, response *ApiKeyListResponseArg) error {
+	// check for session
+	if dbclient == nil {
+		return fmt.Errorf("invalid db session, unable to proceed")
+	}
+	client := dbclient.Copy()
+	defer client.Close()
+
+	if args == nil {
+		return fmt.Errorf("invalid nil token data")
+	}
+	user, err := sessionUser(client, args.Token)
+	if err != nil {
+		return err
+	}
+	keys, err := client.GetUserApiKeys(user.Username)
+	if err != nil {
+		return fmt.Errorf("unable to get api keys: %s", err.Error())
+	}
+	for idx := range keys {
+		keys[idx].Hash = nil
+	}
+
+	response.Keys = keys
+	return nil
+}
+
+// Revoke RPC exposed function removes an api key owned by the
+// session user.
+func (k *ApiKeys) Revoke(args *ApiKeyRevokeRequestArg, response *VoidResponseArg) error {
+	// check for session
+	if dbclient == nil {
+		return fmt.Errorf("invalid db session, unable to proceed")
+	}
+	client := dbclient.Copy()
+	defer client.Close()
+
+	if args == nil {
+		return fmt.Errorf("invalid nil token data")
+	}
+	user, err := sessionUser(client, args.Token)
+	if err != nil {
+		return err
+	}
+	key, err := client.GetApiKey(args.Id)
+	if err != nil ||
+		key.Username != user.Username {
+		return fmt.Errorf("unable to find api key %s", args.Id)
+	}
+	err = client.RemoveApiKey(args.Id)
+	if err != nil {
+		return fmt.Errorf("unable to remove api key: %s", err.Error())
+	}
+	return nil
+}
+
+// UserInfo RPC exposed function verifies an api key and returns
+// the owner data, as the SessionAuth.UserInfo function, plus the
+// key restrictions to be enforced by the service.
+func (k *ApiKeys) UserInfo(args *AuthenticateRequestArg, response *UserInfoResponseArg) error {
+	// check for session
+	if dbclient == nil {
+		return fmt.Errorf("invalid db session, unable to proceed")
+	}
+	client := dbclient.Copy()
+	defer client.Close()
+
+	// check for arguments
+	if args == nil ||
+		!IsApiKey(args.Token) {
+		return fmt.Errorf("invalid api key")
+	}
+	id, secret := splitApiKey(args.Token)
+	key, err := client.GetApiKey(id)
+	if err != nil {
+		return fmt.Errorf("unable to get required api key %s: %s", id, err.Error())
+	}
+	hash := sha256.Sum256(secret)
+	if subtle.ConstantTimeCompare(hash[:], key.Hash) != 1 {
+		return fmt.Errorf("invalid api key")
+	}
+	now := time.Now()
+	if !key.Expiration.IsZero() &&
+		now.After(key.Expiration) {
+		return fmt.Errorf("api key is expired")
+	}
+	user, err := client.GetUser(key.Username)
+	if err != nil {
+		return fmt.Errorf("unable to get required user %s: %s", key.Username, err.Error())
+	}
+	if user.IsDisabled == true {
+		return fmt.Errorf("user is disabled, unable to proceed")
+	}
+
+	response.Username = user.Username
+	response.Email = user.Email
+	response.FullName = user.FullName
+	response.Permissions = &user.Permissions
+	response.LastSeen = key.LastUsedTime
+	response.ApiKey = key.Id
+	response.Scopes = key.Scopes
+	response.AllowedAddresses = key.AllowedAddresses
+	response.Expiration = key.Expiration
+
+	// update last used
+	if now.Sub(key.LastUsedTime) < kLastUsedResolution {
+		return nil
+	}
+	key.LastUsedTime = now
+	err = client.SetApiKey(key)
+	if err != nil {
+		return fmt.Errorf("unable to update api key last used time stamp: %s", err.Error())
+	}
+	return nil
+}
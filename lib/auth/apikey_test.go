@@ -0,0 +1,261 @@
+//
+// 3nigm4 auth package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package auth
+
+// Golang std libs
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidScope(t *testing.T) {
+	var testCases = []struct {
+		scope string
+		valid bool
+	}{
+		{"storage:upload", true},
+		{"ishtm:ping", true},
+		{"storage:*", true},
+		{"storage", false},
+		{"storage:", false},
+		{":upload", false},
+		{"*:*", false},
+		{"Storage:Upload", false},
+	}
+	for idx, tc := range testCases {
+		if ValidScope(tc.scope) != tc.valid {
+			t.Fatalf("Test case %d: scope %s validity should be %v.\n", idx, tc.scope, tc.valid)
+		}
+	}
+}
+
+func TestUserInfoAuthorise(t *testing.T) {
+	session := &UserInfoResponseArg{
+		Username: "userA",
+	}
+	if err := session.Authorise("storage:delete", "10.0.0.1:1234"); err != nil {
+		t.Fatalf("Sessions should be always authorised: %s.\n", err.Error())
+	}
+
+	key := &UserInfoResponseArg{
+		Username:         "userA",
+		ApiKey:           "0011223344556677",
+		Scopes:           []string{"storage:upload", "ishtm:*"},
+		AllowedAddresses: []string{"192.168.1.0/24", "10.0.0.1", "::1"},
+		Expiration:       time.Now().Add(time.Hour),
+	}
+	var testCases = []struct {
+		scope   string
+		address string
+		allowed bool
+	}{
+		{"storage:upload", "192.168.1.20:4000", true},
+		{"storage:upload", "10.0.0.1:4000", true},
+		{"storage:upload", "[::1]:4000", true},
+		{"storage:upload", "10.0.0.2:4000", false},
+		{"storage:upload", "malformed", false},
+		{"storage:delete", "10.0.0.1:4000", false},
+		{"ishtm:ping", "10.0.0.1:4000", true},
+		{"", "10.0.0.1:4000", true},
+	}
+	for idx, tc := range testCases {
+		err := key.Authorise(tc.scope, tc.address)
+		if (err == nil) != tc.allowed {
+			t.Fatalf("Test case %d: authorisation of %s from %s should be %v (%v).\n", idx, tc.scope, tc.address, tc.allowed, err)
+		}
+	}
+
+	key.Expiration = time.Now().Add(-time.Minute)
+	if err := key.Authorise("storage:upload", "10.0.0.1:4000"); err == nil {
+		t.Fatalf("Expired keys should not be authorised.\n")
+	}
+}
+
+func TestApiKeysLifecycle(t *testing.T) {
+	// startup mock and global vars
+	dbclient = newMockDb(&DbArgs{
+		Addresses: strings.Split("127.0.0.1:27017,192.168.0.1:27017", ","),
+		User:      "username",
+		Password:  "password",
+		AuthDb:    "admin",
+	})
+
+	// add test user
+	hash, err := bcryptPassword("passwordA")
+	if err != nil {
+		t.Fatalf("Unable to produce bcrypted password: %s.\n", err.Error())
+	}
+	err = dbclient.SetUser(&User{
+		Username:       "userA",
+		FullName:       "user A",
+		Email:          "userA@email.com",
+		HashedPassword: hash,
+	})
+	if err != nil {
+		t.Fatalf("Unable to set user: %s.\n", err.Error())
+	}
+	defer dbclient.RemoveUser("userA")
+
+	var l Login
+	session := &LoginResponseArg{}
+	err = l.Login(&LoginRequestArg{
+		Username: "userA",
+		Password: "passwordA",
+	}, session)
+	if err != nil {
+		t.Fatalf("Unable to login user: %s.\n", err.Error())
+	}
+
+	var k ApiKeys
+	// invalid requests
+	invalid := []*ApiKeyCreateRequestArg{
+		&ApiKeyCreateRequestArg{Token: session.Token, Scopes: []string{"storage:upload"}},
+		&ApiKeyCreateRequestArg{Token: session.Token, Name: "backup"},
+		&ApiKeyCreateRequestArg{Token: session.Token, Name: "backup", Scopes: []string{"upload"}},
+		&ApiKeyCreateRequestArg{Token: session.Token, Name: "backup", Scopes: []string{"storage:upload"}, AllowedAddresses: []string{"nowhere"}},
+		&ApiKeyCreateRequestArg{Token: session.Token, Name: "backup", Scopes: []string{"storage:upload"}, Expiration: time.Now().Add(-time.Hour)},
+	}
+	for idx, args := range invalid {
+		err = k.Create(args, &ApiKeyCreateResponseArg{})
+		if err == nil {
+			t.Fatalf("Invalid request %d should be refused.\n", idx)
+		}
+	}
+
+	created := &ApiKeyCreateResponseArg{}
+	err = k.Create(&ApiKeyCreateRequestArg{
+		Token:            session.Token,
+		Name:             "backup",
+		Scopes:           []string{"storage:upload"},
+		AllowedAddresses: []string{"127.0.0.1"},
+	}, created)
+	if err != nil {
+		t.Fatalf("Unable to create api key: %s.\n", err.Error())
+	}
+	if !IsApiKey(created.Key) ||
+		IsApiKey(session.Token) {
+		t.Fatalf("Api keys should be distinguishable from session tokens.\n")
+	}
+	if created.Info.Hash != nil {
+		t.Fatalf("Hash should never be returned.\n")
+	}
+	stored, err := dbclient.GetApiKey(created.Info.Id)
+	if err != nil {
+		t.Fatalf("Unable to get stored key: %s.\n", err.Error())
+	}
+	if strings.Contains(string(stored.Hash), string(created.Key[len(apiKeyPrefix)+apiKeyIdSize:])) {
+		t.Fatalf("Key secret should not be stored.\n")
+	}
+
+	// keys can not manage keys
+	err = k.Create(&ApiKeyCreateRequestArg{
+		Token:  created.Key,
+		Name:   "other",
+		Scopes: []string{"storage:upload"},
+	}, &ApiKeyCreateResponseArg{})
+	if err == nil {
+		t.Fatalf("Api keys should not be usable to create keys.\n")
+	}
+
+	// authorisation
+	info := &UserInfoResponseArg{}
+	err = k.UserInfo(&AuthenticateRequestArg{
+		Token: created.Key,
+	}, info)
+	if err != nil {
+		t.Fatalf("Unable to authorise api key: %s.\n", err.Error())
+	}
+	if info.Username != "userA" ||
+		info.ApiKey != created.Info.Id ||
+		len(info.Scopes) != 1 {
+		t.Fatalf("Unexpected user info %v.\n", info)
+	}
+	if err = info.Authorise("storage:upload", "127.0.0.1:9000"); err != nil {
+		t.Fatalf("Key should be authorised: %s.\n", err.Error())
+	}
+	if stored.LastUsedTime.IsZero() {
+		t.Fatalf("Last used time should be updated.\n")
+	}
+	// last used time stamp is updated at most once per resolution
+	lastUsed := stored.LastUsedTime.Add(-2 * kLastUsedResolution)
+	stored.LastUsedTime = lastUsed
+	dbclient.SetApiKey(stored)
+	for i := 0; i < 2; i++ {
+		err = k.UserInfo(&AuthenticateRequestArg{
+			Token: created.Key,
+		}, &UserInfoResponseArg{})
+		if err != nil {
+			t.Fatalf("Unable to authorise api key: %s.\n", err.Error())
+		}
+		stored, err = dbclient.GetApiKey(created.Info.Id)
+		if err != nil {
+			t.Fatalf("Unable to get stored key: %s.\n", err.Error())
+		}
+		if i == 0 {
+			if !stored.LastUsedTime.After(lastUsed) {
+				t.Fatalf("Last used time should be updated.\n")
+			}
+			lastUsed = stored.LastUsedTime.Add(-time.Second)
+			stored.LastUsedTime = lastUsed
+			dbclient.SetApiKey(stored)
+		} else if !stored.LastUsedTime.Equal(lastUsed) {
+			t.Fatalf("Last used time should not be updated within %s.\n", kLastUsedResolution)
+		}
+	}
+	tampered := append([]byte{}, created.Key...)
+	tampered[len(tampered)-1] ^= 0xff
+	err = k.UserInfo(&AuthenticateRequestArg{
+		Token: tampered,
+	}, &UserInfoResponseArg{})
+	if err == nil {
+		t.Fatalf("Tampered keys should be refused.\n")
+	}
+	var s SessionAuth
+	err = s.UserInfo(&AuthenticateRequestArg{
+		Token: created.Key,
+	}, &UserInfoResponseArg{})
+	if err == nil {
+		t.Fatalf("Api keys should not be accepted as sessions.\n")
+	}
+
+	// list
+	list := &ApiKeyListResponseArg{}
+	err = k.List(&AuthenticateRequestArg{
+		Token: session.Token,
+	}, list)
+	if err != nil {
+		t.Fatalf("Unable to list api keys: %s.\n", err.Error())
+	}
+	if len(list.Keys) != 1 ||
+		list.Keys[0].Name != "backup" ||
+		list.Keys[0].Hash != nil {
+		t.Fatalf("Unexpected api keys list %v.\n", list.Keys)
+	}
+
+	// revoke
+	err = k.Revoke(&ApiKeyRevokeRequestArg{
+		Token: session.Token,
+		Id:    "unknown",
+	}, &VoidResponseArg{})
+	if err == nil {
+		t.Fatalf("Unknown keys should not be revoked.\n")
+	}
+	err = k.Revoke(&ApiKeyRevokeRequestArg{
+		Token: session.Token,
+		Id:    created.Info.Id,
+	}, &VoidResponseArg{})
+	if err != nil {
+		t.Fatalf("Unable to revoke api key: %s.\n", err.Error())
+	}
+	err = k.UserInfo(&AuthenticateRequestArg{
+		Token: created.Key,
+	}, &UserInfoResponseArg{})
+	if err == nil {
+		t.Fatalf("Revoked keys should be refused.\n")
+	}
+}
@@ -30,9 +30,11 @@ const (
 	kDatabaseName              = "authentication"
 	kUsersCollectionName       = "users"
 	kSessionsCollectionName    = "session"
+	kApiKeysCollectionName     = "apikeys"
 	kEnvDatabaseName           = "NEXO_AUTH_DATABASE"
 	kEnvUsersCollectionName    = "NEXO_AUTH_USERS_COLLECTION"
 	kEnvSessionsCollectionName = "NEXO_AUTH_SESSIONS_COLLECTION"
+	kEnvApiKeysCollectionName  = "NEXO_AUTH_APIKEYS_COLLECTION"
 	kMaxSessionExistance       = 24 * time.Hour
 )
 
@@ -52,15 +54,25 @@ type Database interface {
 	// db client related functions
 	Copy() Database // retain the db client in a multi-coroutine environment;
 	Close()         // release the client;
+	Ping() error    // verify the db is reachable;
 	// user behaviour
 	GetUser(string) (*User, error) // gets a user struct from an argument username;
 	SetUser(*User) error           // creates a new user in the db;
+	UpdateUser(*User) error        // replaces an existing user record;
 	RemoveUser(string) error       // remove an user from the db;
+	ListUsers() ([]User, error)    // returns all the registered users;
 	// session behaviour
-	GetSession([]byte) (*Session, error) // search for a session in the db;
-	SetSession(*Session) error           // insert a session in the db;
-	RemoveSession([]byte) error          // remove an existing session;
-	RemoveAllSessions() error            // remove all sessions in the db.
+	GetSession([]byte) (*Session, error)       // search for a session in the db;
+	SetSession(*Session) error                 // insert a session in the db;
+	RemoveSession([]byte) error                // remove an existing session;
+	RemoveAllSessions() error                  // remove all sessions in the db;
+	RemoveUserSessions(string, []byte) error   // remove all the user sessions except, if not nil, the argument one;
+	GetUserSessions(string) ([]Session, error) // returns all the sessions of a user;
+	// api keys behaviour
+	GetApiKey(string) (*ApiKey, error)       // search for an api key by id;
+	SetApiKey(*ApiKey) error                 // insert or update an api key;
+	GetUserApiKeys(string) ([]ApiKey, error) // returns all the api keys of a user;
+	RemoveApiKey(string) error               // remove an existing api key.
 }
 
 // Mongodb database, wrapping mgo session
@@ -71,6 +83,7 @@ type Mongodb struct {
 	database           string
 	usersCollection    string
 	sessionsCollection string
+	apiKeysCollection  string
 }
 
 // composeDbAddress compose a string starting from dbArgs slice.
@@ -115,6 +128,12 @@ func MgoSession(args *DbArgs) (*Mongodb, error) {
 	} else {
 		db.usersCollection = kUsersCollectionName
 	}
+	env = os.Getenv(kEnvApiKeysCollectionName)
+	if env != "" {
+		db.apiKeysCollection = env
+	} else {
+		db.apiKeysCollection = kApiKeysCollectionName
+	}
 	// connect to db
 	return db, nil
 }
@@ -126,6 +145,7 @@ func (d *Mongodb) Copy() Database {
 		database:           d.database,
 		usersCollection:    d.usersCollection,
 		sessionsCollection: d.sessionsCollection,
+		apiKeysCollection:  d.apiKeysCollection,
 	}
 }
 
@@ -134,6 +154,11 @@ func (d *Mongodb) Close() {
 	d.session.Close()
 }
 
+// Ping verifies that the db is reachable.
+func (d *Mongodb) Ping() error {
+	return d.session.Ping()
+}
+
 // GetUser get user strucutre from a given username, if
 // something wrong returns an error.
 func (d *Mongodb) GetUser(username string) (*User, error) {
@@ -166,6 +191,30 @@ func (d *Mongodb) SetUser(user *User) error {
 	return nil
 }
 
+// UpdateUser replaces the record of an existing user, unlike
+// SetUser fields having zero values are removed.
+func (d *Mongodb) UpdateUser(user *User) error {
+	selector := bson.M{
+		"username": user.Username,
+	}
+	err := d.session.DB(d.database).C(d.usersCollection).Update(selector, user)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ListUsers returns all the registered users sorted by
+// username.
+func (d *Mongodb) ListUsers() ([]User, error) {
+	var users []User
+	err := d.session.DB(d.database).C(d.usersCollection).Find(bson.M{}).Sort("username").All(&users)
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
 // RemoveUser remove an existing user from the db.
 func (d *Mongodb) RemoveUser(username string) error {
 	// build query
@@ -237,6 +286,99 @@ func (d *Mongodb) RemoveAllSessions() error {
 	return nil
 }
 
+// RemoveUserSessions removes all the sessions of a user except,
+// if not nil, the one having the except token.
+func (d *Mongodb) RemoveUserSessions(username string, except []byte) error {
+	// build query
+	selector := bson.M{
+		"username": bson.M{"$eq": username},
+	}
+	if except != nil {
+		selector["token"] = bson.M{"$ne": except}
+	}
+	// perform db remove all
+	_, err := d.session.DB(d.database).C(d.sessionsCollection).RemoveAll(selector)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetUserSessions returns all the sessions of a user, sorted by
+// login time.
+func (d *Mongodb) GetUserSessions(username string) ([]Session, error) {
+	// build query
+	selector := bson.M{
+		"username": bson.M{"$eq": username},
+	}
+	// perform db query
+	var sessions []Session
+	err := d.session.DB(d.database).C(d.sessionsCollection).Find(selector).Sort("login_ts").All(&sessions)
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// GetApiKey returns the api key having the argument id.
+func (d *Mongodb) GetApiKey(id string) (*ApiKey, error) {
+	// build query
+	selector := bson.M{
+		"id": bson.M{"$eq": id},
+	}
+	// perform db query
+	var key ApiKey
+	err := d.session.DB(d.database).C(d.apiKeysCollection).Find(selector).One(&key)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// SetApiKey adds or updates an api key.
+func (d *Mongodb) SetApiKey(key *ApiKey) error {
+	selector := bson.M{
+		"id": key.Id,
+	}
+	update := bson.M{
+		"$set": key,
+	}
+	_, err := d.session.DB(d.database).C(d.apiKeysCollection).Upsert(selector, update)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetUserApiKeys returns all the api keys owned by a user.
+func (d *Mongodb) GetUserApiKeys(username string) ([]ApiKey, error) {
+	// build query
+	selector := bson.M{
+		"username": bson.M{"$eq": username},
+	}
+	// perform db query
+	var keys []ApiKey
+	err := d.session.DB(d.database).C(d.apiKeysCollection).Find(selector).Sort("creation_ts").All(&keys)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RemoveApiKey removes an api key from the db.
+func (d *Mongodb) RemoveApiKey(id string) error {
+	// build query
+	selector := bson.M{
+		"id": bson.M{"$eq": id},
+	}
+	// perform db remove
+	err := d.session.DB(d.database).C(d.apiKeysCollection).Remove(selector)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 // EnsureMongodbIndexes assign mongodb indexes to the right
 // collections, this should be done only the first time the
 // collection is created.
@@ -285,5 +427,25 @@ func (d *Mongodb) EnsureMongodbIndexes() error {
 	if err != nil {
 		return err
 	}
+	apiKeyIndex := mgo.Index{
+		Key:        []string{"id"},
+		Unique:     true,
+		Background: true,
+		Sparse:     false,
+	}
+	userApiKeyIndex := mgo.Index{
+		Key:        []string{"username"},
+		Unique:     false,
+		Background: true,
+		Sparse:     false,
+	}
+	err = d.session.DB(d.database).C(d.apiKeysCollection).EnsureIndex(apiKeyIndex)
+	if err != nil {
+		return err
+	}
+	err = d.session.DB(d.database).C(d.apiKeysCollection).EnsureIndex(userApiKeyIndex)
+	if err != nil {
+		return err
+	}
 	return nil
 }
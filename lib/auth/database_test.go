@@ -13,8 +13,10 @@ package auth
 
 // Golang std libs
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
+	"sort"
 )
 
 type mockdb struct {
@@ -25,6 +27,7 @@ type mockdb struct {
 	// in memory storage
 	userStorage    map[string]*User
 	sessionStorage map[string]*Session
+	apiKeyStorage  map[string]*ApiKey
 }
 
 func newMockDb(args *DbArgs) *mockdb {
@@ -35,6 +38,7 @@ func newMockDb(args *DbArgs) *mockdb {
 		authDb:         args.AuthDb,
 		userStorage:    make(map[string]*User),
 		sessionStorage: make(map[string]*Session),
+		apiKeyStorage:  make(map[string]*ApiKey),
 	}
 }
 
@@ -45,6 +49,10 @@ func (d *mockdb) Copy() Database {
 func (d *mockdb) Close() {
 }
 
+func (d *mockdb) Ping() error {
+	return nil
+}
+
 func (d *mockdb) GetUser(username string) (*User, error) {
 	user, ok := d.userStorage[username]
 	if !ok {
@@ -62,6 +70,14 @@ func (d *mockdb) SetUser(user *User) error {
 	return nil
 }
 
+func (d *mockdb) UpdateUser(user *User) error {
+	if _, ok := d.userStorage[user.Username]; !ok {
+		return fmt.Errorf("unable to find required %s user", user.Username)
+	}
+	d.userStorage[user.Username] = user
+	return nil
+}
+
 func (d *mockdb) RemoveUser(username string) error {
 	if _, ok := d.userStorage[username]; !ok {
 		return fmt.Errorf("unable to find required %s user", username)
@@ -70,6 +86,19 @@ func (d *mockdb) RemoveUser(username string) error {
 	return nil
 }
 
+func (d *mockdb) ListUsers() ([]User, error) {
+	names := make([]string, 0, len(d.userStorage))
+	for name := range d.userStorage {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	users := make([]User, 0, len(names))
+	for _, name := range names {
+		users = append(users, *d.userStorage[name])
+	}
+	return users, nil
+}
+
 func (d *mockdb) GetSession(token []byte) (*Session, error) {
 	h := hex.EncodeToString(token)
 	session, ok := d.sessionStorage[h]
@@ -98,3 +127,54 @@ func (d *mockdb) RemoveAllSessions() error {
 	d.sessionStorage = make(map[string]*Session)
 	return nil
 }
+
+func (d *mockdb) RemoveUserSessions(username string, except []byte) error {
+	for h, session := range d.sessionStorage {
+		if session.Username == username &&
+			!bytes.Equal(session.Token, except) {
+			delete(d.sessionStorage, h)
+		}
+	}
+	return nil
+}
+
+func (d *mockdb) GetUserSessions(username string) ([]Session, error) {
+	sessions := make([]Session, 0)
+	for _, session := range d.sessionStorage {
+		if session.Username == username {
+			sessions = append(sessions, *session)
+		}
+	}
+	return sessions, nil
+}
+
+func (d *mockdb) GetApiKey(id string) (*ApiKey, error) {
+	key, ok := d.apiKeyStorage[id]
+	if !ok {
+		return nil, fmt.Errorf("unable to find the required %s api key", id)
+	}
+	return key, nil
+}
+
+func (d *mockdb) SetApiKey(key *ApiKey) error {
+	d.apiKeyStorage[key.Id] = key
+	return nil
+}
+
+func (d *mockdb) GetUserApiKeys(username string) ([]ApiKey, error) {
+	keys := make([]ApiKey, 0)
+	for _, key := range d.apiKeyStorage {
+		if key.Username == username {
+			keys = append(keys, *key)
+		}
+	}
+	return keys, nil
+}
+
+func (d *mockdb) RemoveApiKey(id string) error {
+	if _, ok := d.apiKeyStorage[id]; !ok {
+		return fmt.Errorf("unable to find required %s api key", id)
+	}
+	delete(d.apiKeyStorage, id)
+	return nil
+}
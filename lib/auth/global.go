@@ -13,12 +13,32 @@ import (
 	"sync"
 )
 
+// Internal dependencies
+import (
+	"github.com/nexocrew/3nigm4/lib/ratelimit"
+	"github.com/nexocrew/3nigm4/lib/sender"
+)
+
 // Global vars protecting mutex.
 var mtx sync.Mutex
 
 // Runtime allocated global base database instance.
 var dbclient Database
 
+// Runtime allocated login attempts limiter, if nil attempts are
+// not limited.
+var loginLimiter *ratelimit.Limiter
+
+// Runtime allocated key used to encrypt TOTP secrets, if nil two
+// factor authentication can not be enrolled.
+var totpKey []byte
+
+// Runtime allocated sender, and its sender address, used to
+// deliver password reset tokens: if nil passwords can not be
+// reset.
+var resetSender sender.Sender
+var resetSenderAddress string
+
 // SetGlobalDbClient must be called to set the global db client,
 // that implements the Database interface, to be used by RPC
 // exposed functions. This function must be always invoked before
@@ -35,3 +55,29 @@ func CloseGlobalDbClient() {
 	dbclient.Close()
 	mtx.Unlock()
 }
+
+// SetLoginLimiter sets the limiter used to protect the Login RPC
+// from brute-force attacks: failed attempts are counted per
+// username.
+func SetLoginLimiter(limiter *ratelimit.Limiter) {
+	mtx.Lock()
+	loginLimiter = limiter
+	mtx.Unlock()
+}
+
+// SetTotpKey sets the AES256 key used to encrypt users TOTP
+// secrets, it must be 32 bytes long.
+func SetTotpKey(key []byte) {
+	mtx.Lock()
+	totpKey = key
+	mtx.Unlock()
+}
+
+// SetResetSender sets the sender used to deliver password reset
+// tokens to users, from the argument email address.
+func SetResetSender(s sender.Sender, fromAddress string) {
+	mtx.Lock()
+	resetSender = s
+	resetSenderAddress = fromAddress
+	mtx.Unlock()
+}
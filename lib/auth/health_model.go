@@ -0,0 +1,35 @@
+//
+// 3nigm4 auth package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package auth
+
+// Golang std libs
+import (
+	"fmt"
+)
+
+// Health RPC required custom type (using int arbitrarely).
+type Health int
+
+// PingRequestArg empty ping request.
+type PingRequestArg struct{}
+
+// Ping RPC exposed function verifies that the service is able
+// to serve requests reaching its database.
+func (h *Health) Ping(args *PingRequestArg, response *VoidResponseArg) error {
+	// check for session
+	if dbclient == nil {
+		return fmt.Errorf("invalid db session, unable to proceed")
+	}
+	client := dbclient.Copy()
+	defer client.Close()
+
+	err := client.Ping()
+	if err != nil {
+		return fmt.Errorf("unable to reach the database: %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+//
+// 3nigm4 auth package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package auth
+
+// Golang std libs
+import (
+	"strings"
+	"testing"
+)
+
+func TestHealthPing(t *testing.T) {
+	var h Health
+	dbclient = nil
+	err := h.Ping(&PingRequestArg{}, &VoidResponseArg{})
+	if err == nil {
+		t.Fatalf("Ping should fail without a db client.\n")
+	}
+
+	dbclient = newMockDb(&DbArgs{
+		Addresses: strings.Split("127.0.0.1:27017,192.168.0.1:27017", ","),
+		User:      "username",
+		Password:  "password",
+		AuthDb:    "admin",
+	})
+	err = h.Ping(&PingRequestArg{}, &VoidResponseArg{})
+	if err != nil {
+		t.Fatalf("Unable to ping: %s.\n", err.Error())
+	}
+}
@@ -0,0 +1,113 @@
+//
+// 3nigm4 auth package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package auth
+
+// Golang std libs
+import (
+	"sync"
+	"time"
+)
+
+// cachedInfo is a cached user info with its expiration time.
+type cachedInfo struct {
+	info    *UserInfoResponseArg
+	expires time.Time
+}
+
+// UserInfoCache keeps, for a short time, the user infos returned
+// by successful authorisations to avoid an auth service round trip
+// for each request. Sessions closed by other services are
+// recognised only when cached entries expire. A nil UserInfoCache
+// caches nothing.
+type UserInfoCache struct {
+	mtx     sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[string]cachedInfo
+}
+
+// NewUserInfoCache creates a cache retaining at max size entries
+// for the ttl duration, if ttl is zero nil is returned.
+func NewUserInfoCache(ttl time.Duration, size int) *UserInfoCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &UserInfoCache{
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[string]cachedInfo),
+	}
+}
+
+// Get returns the cached user info associated to a session token.
+func (c *UserInfoCache) Get(token []byte) (*UserInfoResponseArg, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	entry, ok := c.entries[string(token)]
+	if !ok {
+		return nil, false
+	}
+	if !entry.expires.After(time.Now()) {
+		delete(c.entries, string(token))
+		return nil, false
+	}
+	return entry.info, true
+}
+
+// Set caches the user info associated to a session token, if the
+// cache is full expired entries are removed and, if still full,
+// the info is not cached.
+func (c *UserInfoCache) Set(token []byte, info *UserInfoResponseArg) {
+	if c == nil {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	now := time.Now()
+	if len(c.entries) >= c.size {
+		for key, entry := range c.entries {
+			if !entry.expires.After(now) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= c.size {
+			return
+		}
+	}
+	c.entries[string(token)] = cachedInfo{
+		info:    info,
+		expires: now.Add(c.ttl),
+	}
+}
+
+// Invalidate removes a session token from the cache.
+func (c *UserInfoCache) Invalidate(token []byte) {
+	if c == nil {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	delete(c.entries, string(token))
+}
+
+// InvalidateUser removes all the sessions of a user from the
+// cache.
+func (c *UserInfoCache) InvalidateUser(username string) {
+	if c == nil {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for key, entry := range c.entries {
+		if entry.info.Username == username {
+			delete(c.entries, key)
+		}
+	}
+}
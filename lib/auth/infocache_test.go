@@ -0,0 +1,70 @@
+//
+// 3nigm4 auth package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package auth
+
+// Golang std libs
+import (
+	"testing"
+	"time"
+)
+
+func TestUserInfoCache(t *testing.T) {
+	if NewUserInfoCache(0, 10) != nil {
+		t.Fatalf("Zero ttl should disable the cache.\n")
+	}
+	// nil caches are usable
+	var disabled *UserInfoCache
+	disabled.Set([]byte("token"), &UserInfoResponseArg{})
+	if _, ok := disabled.Get([]byte("token")); ok {
+		t.Fatalf("Nil cache should not return infos.\n")
+	}
+	disabled.Invalidate([]byte("token"))
+	disabled.InvalidateUser("userA")
+
+	cache := NewUserInfoCache(50*time.Millisecond, 3)
+	userA := &UserInfoResponseArg{Username: "userA"}
+	userB := &UserInfoResponseArg{Username: "userB"}
+	cache.Set([]byte("tokenA1"), userA)
+	cache.Set([]byte("tokenA2"), userA)
+	cache.Set([]byte("tokenB"), userB)
+	// full cache
+	cache.Set([]byte("tokenB2"), userB)
+	if _, ok := cache.Get([]byte("tokenB2")); ok {
+		t.Fatalf("Full cache should not accept new entries.\n")
+	}
+	if info, ok := cache.Get([]byte("tokenA1")); !ok ||
+		info.Username != "userA" {
+		t.Fatalf("Unexpected cached info: %v.\n", info)
+	}
+
+	cache.Invalidate([]byte("tokenA1"))
+	if _, ok := cache.Get([]byte("tokenA1")); ok {
+		t.Fatalf("Invalidated token should not be cached.\n")
+	}
+	cache.InvalidateUser("userA")
+	if _, ok := cache.Get([]byte("tokenA2")); ok {
+		t.Fatalf("Invalidated user should not be cached.\n")
+	}
+	if _, ok := cache.Get([]byte("tokenB")); !ok {
+		t.Fatalf("Other users should be still cached.\n")
+	}
+
+	// expiration
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := cache.Get([]byte("tokenB")); ok {
+		t.Fatalf("Expired entries should not be returned.\n")
+	}
+	cache.Set([]byte("tokenA1"), userA)
+	cache.Set([]byte("tokenA2"), userA)
+	cache.Set([]byte("tokenB"), userB)
+	time.Sleep(60 * time.Millisecond)
+	// expired entries are removed when full
+	cache.Set([]byte("tokenB2"), userB)
+	if _, ok := cache.Get([]byte("tokenB2")); !ok {
+		t.Fatalf("Expired entries should be replaced.\n")
+	}
+}
@@ -0,0 +1,94 @@
+//
+// 3nigm4 auth package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+// Services http middleware: services wrap all their routes, but
+// the explicitly public ones, with the ServiceAuthoriser middleware
+// so that users not granted a level on the service are refused
+// before reaching any handler.
+//
+
+package auth
+
+// Golang std libs
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Third party libs
+import (
+	"github.com/gorilla/mux"
+)
+
+// RequestAuthenticator authenticates an http request, using the
+// credentials it carries, returning the authorised user's infos.
+type RequestAuthenticator func(r *http.Request) (*UserInfoResponseArg, error)
+
+// RefusalWriter writes the response of a refused request.
+type RefusalWriter func(status int, msg string, w http.ResponseWriter, r *http.Request)
+
+// contextKey is the type of the keys used to store values in the
+// requests context.
+type contextKey int
+
+const (
+	// userInfoKey is the request context key of the user's infos
+	// authorised by the service middleware.
+	userInfoKey contextKey = iota
+)
+
+// Middleware returns an http middleware refusing, before reaching
+// the wrapped handler, requests that can not be authenticated (with
+// 401) and requests of users not granted at least the argument
+// level on the service (with 403). Authorised user's infos are
+// available to the wrapped handler using RequestUserInfo.
+func (s *ServiceAuthoriser) Middleware(level Level, authenticate RequestAuthenticator, refuse RefusalWriter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			info, err := authenticate(r)
+			if err != nil {
+				refuse(http.StatusUnauthorized, err.Error(), w, r)
+				return
+			}
+			err = s.Authorise(info, level)
+			if err != nil {
+				refuse(http.StatusForbidden, err.Error(), w, r)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userInfoKey, info)))
+		})
+	}
+}
+
+// RequestUserInfo returns the user's infos authorised by the
+// service middleware, nil if the request has not been authorised
+// by it.
+func RequestUserInfo(r *http.Request) *UserInfoResponseArg {
+	info, _ := r.Context().Value(userInfoKey).(*UserInfoResponseArg)
+	return info
+}
+
+// ProtectRouter wraps, with the argument middleware, the handlers
+// of all the routes registered in the router but the public ones,
+// identified by their name. It must be called once all the routes
+// have been registered: routes added later are not protected.
+func ProtectRouter(router *mux.Router, middleware func(http.Handler) http.Handler, public ...string) error {
+	exempt := make(map[string]bool)
+	for _, name := range public {
+		if router.Get(name) == nil {
+			return fmt.Errorf("public route %s is not registered", name)
+		}
+		exempt[name] = true
+	}
+	return router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if exempt[route.GetName()] ||
+			route.GetHandler() == nil {
+			return nil
+		}
+		route.Handler(middleware(route.GetHandler()))
+		return nil
+	})
+}
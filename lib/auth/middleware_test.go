@@ -0,0 +1,90 @@
+//
+// 3nigm4 auth package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package auth
+
+// Golang std libs
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Third party libs
+import (
+	"github.com/gorilla/mux"
+)
+
+func TestProtectRouter(t *testing.T) {
+	users := map[string]*UserInfoResponseArg{
+		"storage": {
+			Username:    "userA",
+			Permissions: &Permissions{Services: map[string]Level{"storage": LevelUser}},
+		},
+		"ishtm": {
+			Username:    "userB",
+			Permissions: &Permissions{Services: map[string]Level{"ishtm": LevelAdmin}},
+		},
+	}
+	authenticate := func(r *http.Request) (*UserInfoResponseArg, error) {
+		info, ok := users[r.Header.Get("token")]
+		if !ok {
+			return nil, fmt.Errorf("unknown token")
+		}
+		return info, nil
+	}
+	refuse := func(status int, msg string, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if info := RequestUserInfo(r); info != nil {
+			w.Header().Set("username", info.Username)
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/public", handler).Methods("GET").Name("public")
+	router.HandleFunc("/private/{id}", handler).Methods("GET")
+	router.HandleFunc("/private/{id}", handler).Methods("DELETE")
+	middleware := NewServiceAuthoriser("storage").Middleware(LevelUser, authenticate, refuse)
+	if err := ProtectRouter(router, middleware, "unknown"); err == nil {
+		t.Fatalf("Unknown public routes should be refused.\n")
+	}
+	if err := ProtectRouter(router, middleware, "public"); err != nil {
+		t.Fatalf("Unable to protect router: %s.\n", err.Error())
+	}
+
+	testCases := []struct {
+		method   string
+		path     string
+		token    string
+		status   int
+		username string
+	}{
+		{"GET", "/public", "", http.StatusOK, ""},
+		{"GET", "/public", "ishtm", http.StatusOK, ""},
+		{"GET", "/private/a", "", http.StatusUnauthorized, ""},
+		{"DELETE", "/private/a", "unknown", http.StatusUnauthorized, ""},
+		{"GET", "/private/a", "ishtm", http.StatusForbidden, ""},
+		{"DELETE", "/private/a", "ishtm", http.StatusForbidden, ""},
+		{"GET", "/private/a", "storage", http.StatusOK, "userA"},
+		{"DELETE", "/private/a", "storage", http.StatusOK, "userA"},
+	}
+	for idx, tc := range testCases {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.Header.Set("token", tc.token)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != tc.status {
+			t.Fatalf("Test case %d: having status %d expecting %d.\n", idx, recorder.Code, tc.status)
+		}
+		if recorder.Header().Get("username") != tc.username {
+			t.Fatalf("Test case %d: unexpected authorised user %s.\n", idx, recorder.Header().Get("username"))
+		}
+	}
+}
@@ -0,0 +1,286 @@
+//
+// 3nigm4 auth package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+// Password management: users can change their password providing
+// the current one while super-admins can issue single use reset
+// tokens, delivered by email with the sender set by
+// SetResetSender, usable to set a new password without knowing
+// the current one.
+//
+
+package auth
+
+// Golang std libs
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Internal dependencies
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+	"github.com/nexocrew/3nigm4/lib/ratelimit"
+)
+
+// Third party libs
+import (
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	kMinPasswordLength  = 8                // minimum length of a new password;
+	kResetTokenSize     = 16               // reset token size in bytes;
+	kResetTokenValidity = 24 * time.Hour   // validity of a reset token;
+	kResetSubject       = "Password reset" // subject of reset emails;
+	kResetAttachment    = "reset.txt"      // name of the reset instructions attachment.
+)
+
+// validPassword verifies a new password.
+func validPassword(password string) error {
+	if len(password) < kMinPasswordLength {
+		return fmt.Errorf("password should be at least %d characters long", kMinPasswordLength)
+	}
+	return nil
+}
+
+// setPassword replaces the user password invalidating any pending
+// reset token.
+func setPassword(user *User, password string) error {
+	err := validPassword(password)
+	if err != nil {
+		return err
+	}
+	hash, err := bcryptPassword(password)
+	if err != nil {
+		return err
+	}
+	user.HashedPassword = hash
+	user.ResetTokenHash = nil
+	user.ResetExpiration = time.Time{}
+	return nil
+}
+
+// resetInstructions returns the plain text instructions, attached
+// to reset emails, to complete a password reset.
+func resetInstructions(username, token string) []byte {
+	return []byte(fmt.Sprintf(
+		"A password reset has been requested for user %s, set a new password with:\n\n\t3n4cli password complete --username %s --resettoken %s\n\nThe token can be used only once and expires in %s.\n",
+		username, username, token, kResetTokenValidity.String()))
+}
+
+// ChangePasswordRequestArg request to change the password of the
+// session user.
+type ChangePasswordRequestArg struct {
+	Token       []byte // the session token;
+	Password    string // the current password;
+	NewPassword string // the new password.
+}
+
+// ChangePassword RPC exposed function replaces the password of
+// the session user, the current one is required. All the user
+// sessions, except the one used by the request, are invalidated.
+func (t *Login) ChangePassword(args *ChangePasswordRequestArg, response *VoidResponseArg) error {
+	// check for session
+	if dbclient == nil {
+		return fmt.Errorf("invalid db session, unable to proceed")
+	}
+	client := dbclient.Copy()
+	defer client.Close()
+
+	// check for arguments
+	if args == nil ||
+		args.Password == "" {
+		return fmt.Errorf("invalid nil password")
+	}
+	user, err := sessionUser(client, args.Token)
+	if err != nil {
+		return err
+	}
+
+	// check for brute-force attempts on the current password
+	mtx.Lock()
+	limiter := loginLimiter
+	mtx.Unlock()
+	key := ratelimit.UserKey(user.Username)
+	reservation, err := limiter.Reserve(key)
+	if err != nil {
+		return err
+	}
+	defer reservation.Cancel()
+	err = bcrypt.CompareHashAndPassword(user.HashedPassword, []byte(args.Password))
+	if err != nil {
+		reservation.Failure()
+		return fmt.Errorf("wrong current password")
+	}
+	reservation.Success(key)
+
+	err = setPassword(user, args.NewPassword)
+	if err != nil {
+		return err
+	}
+	err = client.UpdateUser(user)
+	if err != nil {
+		return fmt.Errorf("unable to update user %s: %s", user.Username, err.Error())
+	}
+	err = client.RemoveUserSessions(user.Username, args.Token)
+	if err != nil {
+		return fmt.Errorf("unable to remove user sessions: %s", err.Error())
+	}
+	return nil
+}
+
+// CompleteResetRequestArg request to set a new password using a
+// reset token.
+type CompleteResetRequestArg struct {
+	Username    string // the user name;
+	ResetToken  string // the hex encoded reset token;
+	NewPassword string // the new password.
+}
+
+// CompletePasswordReset RPC exposed function sets a new password
+// using a reset token issued by ResetPassword. The token can be
+// used only once and all the user sessions are invalidated.
+func (t *Login) CompletePasswordReset(args *CompleteResetRequestArg, response *VoidResponseArg) error {
+	// check for session
+	if dbclient == nil {
+		return fmt.Errorf("invalid db session, unable to proceed")
+	}
+	client := dbclient.Copy()
+	defer client.Close()
+
+	// check for arguments
+	if args == nil ||
+		args.Username == "" ||
+		args.ResetToken == "" {
+		return fmt.Errorf("invalid username and reset token")
+	}
+
+	// check for brute-force attempts
+	mtx.Lock()
+	limiter := loginLimiter
+	mtx.Unlock()
+	key := ratelimit.UserKey(args.Username)
+	reservation, err := limiter.Reserve(key)
+	if err != nil {
+		return err
+	}
+	defer reservation.Cancel()
+
+	user, err := client.GetUser(args.Username)
+	if err != nil {
+		reservation.Failure()
+		return fmt.Errorf("invalid reset token")
+	}
+	hash := sha256.Sum256([]byte(args.ResetToken))
+	if user.ResetTokenHash == nil ||
+		subtle.ConstantTimeCompare(hash[:], user.ResetTokenHash) != 1 {
+		reservation.Failure()
+		return fmt.Errorf("invalid reset token")
+	}
+	if time.Now().After(user.ResetExpiration) {
+		return fmt.Errorf("reset token is expired")
+	}
+	reservation.Success(key)
+
+	err = setPassword(user, args.NewPassword)
+	if err != nil {
+		return err
+	}
+	err = client.UpdateUser(user)
+	if err != nil {
+		return fmt.Errorf("unable to update user %s: %s", user.Username, err.Error())
+	}
+	err = client.RemoveUserSessions(user.Username, nil)
+	if err != nil {
+		return fmt.Errorf("unable to remove user sessions: %s", err.Error())
+	}
+	return nil
+}
+
+// ResetPasswordRequestArg request to reset the password of a user.
+type ResetPasswordRequestArg struct {
+	Token    []byte // the authentication token;
+	Username string // the user whose password should be reset.
+}
+
+// ResetPassword is an RPC exposed function that issues a single
+// use password reset token, replacing any pending one, and
+// delivers it to the user email address. The token is never
+// returned to the caller. Only Super-Admins will be able to use
+// this function.
+func (s *SessionAuth) ResetPassword(args *ResetPasswordRequestArg, response *VoidResponseArg) error {
+	// check for session
+	if dbclient == nil {
+		return fmt.Errorf("invalid db session, unable to proceed")
+	}
+	client := dbclient.Copy()
+	defer client.Close()
+
+	// check for arguments
+	if args == nil ||
+		args.Token == nil {
+		return fmt.Errorf("invalid nil token data")
+	}
+	// check for username
+	if args.Username == "" {
+		return fmt.Errorf("invalid username: unable to process request for nil username")
+	}
+	// get user infos
+	userinfo := UserInfoResponseArg{}
+	err := s.UserInfo(&AuthenticateRequestArg{
+		Token: args.Token,
+	}, &userinfo)
+	if err != nil {
+		return err
+	}
+	// check for superadmin
+	if userinfo.Permissions.SuperAdmin != true {
+		return fmt.Errorf("user not authorised to perform this operation, contact system admin")
+	}
+
+	mtx.Lock()
+	deliverer := resetSender
+	fromAddress := resetSenderAddress
+	mtx.Unlock()
+	if deliverer == nil {
+		return fmt.Errorf("password reset delivery is not configured")
+	}
+	user, err := client.GetUser(args.Username)
+	if err != nil {
+		return fmt.Errorf("unable to get %s user: %s", args.Username, err.Error())
+	}
+	if user.Email == "" {
+		return fmt.Errorf("user %s has no email address", user.Username)
+	}
+
+	raw, err := ct.RandomBytesForLen(kResetTokenSize)
+	if err != nil {
+		return err
+	}
+	token := hex.EncodeToString(raw)
+	hash := sha256.Sum256([]byte(token))
+	now := time.Now()
+	user.ResetTokenHash = hash[:]
+	user.ResetExpiration = now.Add(kResetTokenValidity)
+	err = client.UpdateUser(user)
+	if err != nil {
+		return fmt.Errorf("unable to update user %s: %s", user.Username, err.Error())
+	}
+
+	err = deliverer.SendEmail(&ct.Email{
+		Recipient:   user.Email,
+		Sender:      userinfo.Username,
+		Creation:    now,
+		DeliveryKey: token,
+		Attachment:  resetInstructions(user.Username, token),
+	}, fromAddress, kResetSubject, kResetAttachment)
+	if err != nil {
+		return fmt.Errorf("unable to deliver reset token: %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+//
+// 3nigm4 auth package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package auth
+
+// Golang std libs
+import (
+	"strings"
+	"testing"
+)
+
+// Internal dependencies
+import (
+	sendermock "github.com/nexocrew/3nigm4/lib/sender/mock"
+)
+
+// passwordTestUsers adds a common user and a super-admin
+// returning a login helper.
+func passwordTestUsers(t *testing.T) func(string, string) ([]byte, error) {
+	dbclient = newMockDb(&DbArgs{
+		Addresses: strings.Split("127.0.0.1:27017,192.168.0.1:27017", ","),
+		User:      "username",
+		Password:  "password",
+		AuthDb:    "admin",
+	})
+	for _, user := range []User{
+		User{
+			Username: "userA",
+			Email:    "userA@email.com",
+		},
+		User{
+			Username: "admin",
+			Email:    "admin@email.com",
+			Permissions: Permissions{
+				SuperAdmin: true,
+			},
+		},
+	} {
+		hash, err := bcryptPassword("password" + user.Username)
+		if err != nil {
+			t.Fatalf("Unable to produce bcrypted password: %s.\n", err.Error())
+		}
+		user.HashedPassword = hash
+		err = dbclient.SetUser(&user)
+		if err != nil {
+			t.Fatalf("Unable to set user: %s.\n", err.Error())
+		}
+	}
+
+	var l Login
+	return func(username, password string) ([]byte, error) {
+		response := &LoginResponseArg{}
+		err := l.Login(&LoginRequestArg{
+			Username: username,
+			Password: password,
+		}, response)
+		return response.Token, err
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	login := passwordTestUsers(t)
+	defer dbclient.RemoveUser("userA")
+	defer dbclient.RemoveUser("admin")
+
+	first, err := login("userA", "passworduserA")
+	if err != nil {
+		t.Fatalf("Unable to login user: %s.\n", err.Error())
+	}
+	second, err := login("userA", "passworduserA")
+	if err != nil {
+		t.Fatalf("Unable to login user: %s.\n", err.Error())
+	}
+
+	var l Login
+	invalid := []*ChangePasswordRequestArg{
+		&ChangePasswordRequestArg{Token: first, Password: "wrong", NewPassword: "newpassword"},
+		&ChangePasswordRequestArg{Token: first, Password: "passworduserA", NewPassword: "short"},
+		&ChangePasswordRequestArg{Token: []byte("unknown"), Password: "passworduserA", NewPassword: "newpassword"},
+	}
+	for idx, args := range invalid {
+		if err = l.ChangePassword(args, &VoidResponseArg{}); err == nil {
+			t.Fatalf("Invalid request %d should be refused.\n", idx)
+		}
+	}
+
+	err = l.ChangePassword(&ChangePasswordRequestArg{
+		Token:       first,
+		Password:    "passworduserA",
+		NewPassword: "newpassword",
+	}, &VoidResponseArg{})
+	if err != nil {
+		t.Fatalf("Unable to change password: %s.\n", err.Error())
+	}
+	if _, err = dbclient.GetSession(first); err != nil {
+		t.Fatalf("Requesting session should be preserved: %s.\n", err.Error())
+	}
+	if _, err = dbclient.GetSession(second); err == nil {
+		t.Fatalf("Other sessions should be invalidated.\n")
+	}
+	if _, err = login("userA", "passworduserA"); err == nil {
+		t.Fatalf("Old password should be refused.\n")
+	}
+	if _, err = login("userA", "newpassword"); err != nil {
+		t.Fatalf("Unable to login with new password: %s.\n", err.Error())
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	login := passwordTestUsers(t)
+	defer dbclient.RemoveUser("userA")
+	defer dbclient.RemoveUser("admin")
+
+	userToken, err := login("userA", "passworduserA")
+	if err != nil {
+		t.Fatalf("Unable to login user: %s.\n", err.Error())
+	}
+	adminToken, err := login("admin", "passwordadmin")
+	if err != nil {
+		t.Fatalf("Unable to login admin: %s.\n", err.Error())
+	}
+
+	var s SessionAuth
+	request := &ResetPasswordRequestArg{
+		Token:    adminToken,
+		Username: "userA",
+	}
+	// not configured
+	SetResetSender(nil, "")
+	if err = s.ResetPassword(request, &VoidResponseArg{}); err == nil {
+		t.Fatalf("Reset should fail without a sender.\n")
+	}
+	mock := sendermock.NewMockSender()
+	SetResetSender(mock, "auth@3n4.io")
+	defer SetResetSender(nil, "")
+	// not admin
+	err = s.ResetPassword(&ResetPasswordRequestArg{
+		Token:    userToken,
+		Username: "admin",
+	}, &VoidResponseArg{})
+	if err == nil {
+		t.Fatalf("Common users should not reset passwords.\n")
+	}
+
+	err = s.ResetPassword(request, &VoidResponseArg{})
+	if err != nil {
+		t.Fatalf("Unable to reset password: %s.\n", err.Error())
+	}
+	sent, ok := mock.Sended["userA@email.com"]
+	if !ok {
+		t.Fatalf("Reset token should be delivered to the user.\n")
+	}
+	token := sent.Email.DeliveryKey
+	if sent.FromAddress != "auth@3n4.io" ||
+		!strings.Contains(string(sent.Email.Attachment), token) {
+		t.Fatalf("Unexpected reset message %v.\n", sent)
+	}
+	user, _ := dbclient.GetUser("userA")
+	if strings.Contains(string(user.ResetTokenHash), token) {
+		t.Fatalf("Reset token should be stored hashed.\n")
+	}
+
+	var l Login
+	invalid := []*CompleteResetRequestArg{
+		&CompleteResetRequestArg{Username: "userA", ResetToken: "00112233", NewPassword: "newpassword"},
+		&CompleteResetRequestArg{Username: "admin", ResetToken: token, NewPassword: "newpassword"},
+		&CompleteResetRequestArg{Username: "userA", ResetToken: token, NewPassword: "short"},
+	}
+	for idx, args := range invalid {
+		if err = l.CompletePasswordReset(args, &VoidResponseArg{}); err == nil {
+			t.Fatalf("Invalid request %d should be refused.\n", idx)
+		}
+	}
+	complete := &CompleteResetRequestArg{
+		Username:    "userA",
+		ResetToken:  token,
+		NewPassword: "newpassword",
+	}
+	err = l.CompletePasswordReset(complete, &VoidResponseArg{})
+	if err != nil {
+		t.Fatalf("Unable to complete reset: %s.\n", err.Error())
+	}
+	if _, err = dbclient.GetSession(userToken); err == nil {
+		t.Fatalf("User sessions should be invalidated.\n")
+	}
+	if _, err = login("userA", "newpassword"); err != nil {
+		t.Fatalf("Unable to login with new password: %s.\n", err.Error())
+	}
+	// single use
+	complete.NewPassword = "otherpassword"
+	if err = l.CompletePasswordReset(complete, &VoidResponseArg{}); err == nil {
+		t.Fatalf("Reset tokens should be usable only once.\n")
+	}
+}
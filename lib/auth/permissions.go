@@ -0,0 +1,79 @@
+//
+// 3nigm4 auth package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+// Per service permissions: users are granted a level, LevelUser
+// or LevelAdmin, on each service they can access. Services use a
+// ServiceAuthoriser, configured with their label, to refuse users
+// having no level on them and to reserve administrative functions
+// to LevelAdmin users.
+//
+
+package auth
+
+// Golang std libs
+import (
+	"fmt"
+)
+
+const (
+	// AllServices is the service label granting a level on all
+	// the services.
+	AllServices = "all"
+)
+
+// ServiceLevel returns the level granted on the argument service:
+// the service specific level is used if available, than the one
+// granted on all services. Super-admins are granted LevelAdmin on
+// any service. Returns false if no level is granted.
+func (p *Permissions) ServiceLevel(service string) (Level, bool) {
+	if p == nil {
+		return LevelUser, false
+	}
+	if p.SuperAdmin == true {
+		return LevelAdmin, true
+	}
+	if level, ok := p.Services[service]; ok {
+		return level, true
+	}
+	if level, ok := p.Services[AllServices]; ok {
+		return level, true
+	}
+	return LevelUser, false
+}
+
+// ServiceAuthoriser verifies authorised users permissions on a
+// service.
+type ServiceAuthoriser struct {
+	service string // label of the service.
+}
+
+// NewServiceAuthoriser creates an authoriser for the service having
+// the argument label.
+func NewServiceAuthoriser(service string) *ServiceAuthoriser {
+	return &ServiceAuthoriser{
+		service: service,
+	}
+}
+
+// Service returns the label of the authorised service.
+func (s *ServiceAuthoriser) Service() string {
+	return s.service
+}
+
+// Authorise verifies that the user, as returned by UserInfo, is
+// granted at least the argument level on the service.
+func (s *ServiceAuthoriser) Authorise(info *UserInfoResponseArg, level Level) error {
+	if info == nil {
+		return fmt.Errorf("invalid nil user infos")
+	}
+	granted, ok := info.Permissions.ServiceLevel(s.service)
+	if !ok {
+		return fmt.Errorf("user %s is not allowed to use the %s service", info.Username, s.service)
+	}
+	if granted < level {
+		return fmt.Errorf("user %s is not an administrator of the %s service", info.Username, s.service)
+	}
+	return nil
+}
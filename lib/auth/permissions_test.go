@@ -0,0 +1,51 @@
+//
+// 3nigm4 auth package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package auth
+
+// Golang std libs
+import (
+	"testing"
+)
+
+func TestServiceAuthoriser(t *testing.T) {
+	authoriser := NewServiceAuthoriser("storage")
+	if authoriser.Service() != "storage" {
+		t.Fatalf("Unexpected service %s.\n", authoriser.Service())
+	}
+	testCases := []struct {
+		permissions *Permissions
+		user        bool // authorised as LevelUser;
+		admin       bool // authorised as LevelAdmin.
+	}{
+		{nil, false, false},
+		{&Permissions{}, false, false},
+		{&Permissions{Services: map[string]Level{"ishtm": LevelAdmin}}, false, false},
+		{&Permissions{Services: map[string]Level{"storage": LevelUser}}, true, false},
+		{&Permissions{Services: map[string]Level{"storage": LevelAdmin}}, true, true},
+		{&Permissions{Services: map[string]Level{AllServices: LevelUser}}, true, false},
+		{&Permissions{Services: map[string]Level{AllServices: LevelAdmin}}, true, true},
+		// service specific level has precedence
+		{&Permissions{Services: map[string]Level{AllServices: LevelAdmin, "storage": LevelUser}}, true, false},
+		{&Permissions{Services: map[string]Level{AllServices: LevelUser, "storage": LevelAdmin}}, true, true},
+		{&Permissions{SuperAdmin: true}, true, true},
+	}
+	for idx, tc := range testCases {
+		info := &UserInfoResponseArg{
+			Username:    "userA",
+			Permissions: tc.permissions,
+		}
+		if err := authoriser.Authorise(info, LevelUser); (err == nil) != tc.user {
+			t.Fatalf("Test case %d: unexpected user level authorisation (%v).\n", idx, err)
+		}
+		if err := authoriser.Authorise(info, LevelAdmin); (err == nil) != tc.admin {
+			t.Fatalf("Test case %d: unexpected admin level authorisation (%v).\n", idx, err)
+		}
+	}
+	if authoriser.Authorise(nil, LevelUser) == nil {
+		t.Fatalf("Nil user infos should not be authorised.\n")
+	}
+}
@@ -0,0 +1,148 @@
+//
+// 3nigm4 auth package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+// RPC client used by services to reach the auth server:
+// broken connections, for example after an auth server
+// restart, are transparently replaced.
+//
+
+package auth
+
+// Golang std libs
+import (
+	"crypto/tls"
+	"fmt"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// Reconnection backoff bounds: after a failed connection attempt
+// new ones are refused for a delay doubled at each further failure.
+var (
+	rpcBackoffBase = 250 * time.Millisecond
+	rpcBackoffMax  = 30 * time.Second
+)
+
+// RpcClient is an auth service RPC client keeping a small pool of
+// connections, usable by different goroutines simultaneously.
+// Connections found broken are discarded and re-established, with
+// an exponential backoff, when required.
+type RpcClient struct {
+	address string
+	config  *tls.Config
+	mtx     sync.Mutex
+	slots   []*rpc.Client // pooled connections, nil if not established;
+	next    int           // next slot to be used;
+	retryAt time.Time     // connections are not attempted before this time;
+	backoff time.Duration // delay applied after the next failed attempt;
+	closed  bool
+}
+
+// NewRpcClient creates a client connecting to the auth service at
+// address, using TLS if config is not nil, with at max poolSize
+// connections. The first connection is established immediately to
+// report configuration errors.
+func NewRpcClient(address string, config *tls.Config, poolSize int) (*RpcClient, error) {
+	if poolSize < 1 {
+		poolSize = 1
+	}
+	client, err := DialRpc(address, config)
+	if err != nil {
+		return nil, err
+	}
+	c := &RpcClient{
+		address: address,
+		config:  config,
+		slots:   make([]*rpc.Client, poolSize),
+		backoff: rpcBackoffBase,
+	}
+	c.slots[0] = client
+	return c, nil
+}
+
+// conn returns the connection of the next slot, establishing it if
+// required and not prevented by the backoff.
+func (c *RpcClient) conn() (int, *rpc.Client, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.closed {
+		return 0, nil, rpc.ErrShutdown
+	}
+	idx := c.next
+	c.next = (c.next + 1) % len(c.slots)
+	if c.slots[idx] != nil {
+		return idx, c.slots[idx], nil
+	}
+
+	now := time.Now()
+	if now.Before(c.retryAt) {
+		return 0, nil, fmt.Errorf("auth service unavailable, retrying in %s", c.retryAt.Sub(now).String())
+	}
+	client, err := DialRpc(c.address, c.config)
+	if err != nil {
+		c.retryAt = now.Add(c.backoff)
+		c.backoff *= 2
+		if c.backoff > rpcBackoffMax {
+			c.backoff = rpcBackoffMax
+		}
+		return 0, nil, fmt.Errorf("unable to connect to auth service: %s", err.Error())
+	}
+	c.retryAt = time.Time{}
+	c.backoff = rpcBackoffBase
+	c.slots[idx] = client
+	return idx, client, nil
+}
+
+// discard closes a broken connection freeing its slot.
+func (c *RpcClient) discard(idx int, client *rpc.Client) {
+	c.mtx.Lock()
+	if c.slots[idx] == client {
+		c.slots[idx] = nil
+	}
+	c.mtx.Unlock()
+	client.Close()
+}
+
+// Call invokes the named RPC function. Calls refused by a shut
+// down connection, that so never reached the server, are retried
+// on a new connection; other transport errors are returned after
+// discarding the connection.
+func (c *RpcClient) Call(method string, args interface{}, reply interface{}) error {
+	for attempt := 0; ; attempt++ {
+		idx, client, err := c.conn()
+		if err != nil {
+			return err
+		}
+		err = client.Call(method, args, reply)
+		if _, ok := err.(rpc.ServerError); ok ||
+			err == nil {
+			return err
+		}
+		c.discard(idx, client)
+		if err != rpc.ErrShutdown ||
+			attempt == len(c.slots) {
+			return err
+		}
+	}
+}
+
+// Close closes all the pooled connections.
+func (c *RpcClient) Close() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.closed = true
+	var err error
+	for idx, client := range c.slots {
+		if client == nil {
+			continue
+		}
+		if e := client.Close(); e != nil && err == nil {
+			err = e
+		}
+		c.slots[idx] = nil
+	}
+	return err
+}
@@ -0,0 +1,136 @@
+//
+// 3nigm4 auth package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package auth
+
+// Golang std libs
+import (
+	"net"
+	"net/http"
+	"net/rpc"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stoppableListener tracks accepted connections to be able to
+// simulate a server shutdown.
+type stoppableListener struct {
+	net.Listener
+	mtx   sync.Mutex
+	conns []net.Conn
+}
+
+func (l *stoppableListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.mtx.Lock()
+	l.conns = append(l.conns, conn)
+	l.mtx.Unlock()
+	return conn, nil
+}
+
+func (l *stoppableListener) stop() {
+	l.Listener.Close()
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	for _, conn := range l.conns {
+		conn.Close()
+	}
+}
+
+// startEchoServer serves the Echo RPC type at address.
+func startEchoServer(t *testing.T, address string) *stoppableListener {
+	server := rpc.NewServer()
+	server.Register(new(Echo))
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatalf("Unable to listen: %s.\n", err.Error())
+	}
+	stoppable := &stoppableListener{
+		Listener: listener,
+	}
+	go http.Serve(stoppable, server)
+	return stoppable
+}
+
+func TestRpcClientReconnection(t *testing.T) {
+	rpcBackoffBase = 50 * time.Millisecond
+	listener := startEchoServer(t, "127.0.0.1:0")
+	address := listener.Addr().String()
+
+	client, err := NewRpcClient(address, nil, 2)
+	if err != nil {
+		t.Fatalf("Unable to create client: %s.\n", err.Error())
+	}
+	defer client.Close()
+	echo := func() error {
+		message := "message"
+		var reply string
+		err := client.Call("Echo.Echo", &message, &reply)
+		if err == nil &&
+			reply != message {
+			t.Fatalf("Having %s expecting %s.\n", reply, message)
+		}
+		return err
+	}
+	for idx := 0; idx < 4; idx++ {
+		if err = echo(); err != nil {
+			t.Fatalf("Unable to call RPC function: %s.\n", err.Error())
+		}
+	}
+	// server errors do not affect connections
+	var reply string
+	err = client.Call("Echo.Missing", &reply, &reply)
+	if _, ok := err.(rpc.ServerError); !ok {
+		t.Fatalf("Expecting a server error: %v.\n", err)
+	}
+
+	// server restart
+	listener.stop()
+	time.Sleep(50 * time.Millisecond)
+	if err = echo(); err == nil {
+		t.Fatalf("Calls should fail while the server is down.\n")
+	}
+	// reconnection attempts are delayed
+	if err = echo(); err == nil ||
+		!strings.Contains(err.Error(), "retrying") {
+		t.Fatalf("Expecting a backoff error: %v.\n", err)
+	}
+	listener = startEchoServer(t, address)
+	defer listener.stop()
+	for idx := 0; ; idx++ {
+		if err = echo(); err == nil {
+			break
+		}
+		if idx == 100 {
+			t.Fatalf("Client has not reconnected: %s.\n", err.Error())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for idx := 0; idx < 4; idx++ {
+		if err = echo(); err != nil {
+			t.Fatalf("Unable to call RPC function: %s.\n", err.Error())
+		}
+	}
+
+	client.Close()
+	if err = echo(); err != rpc.ErrShutdown {
+		t.Fatalf("Closed client should return %v: %v.\n", rpc.ErrShutdown, err)
+	}
+}
+
+func TestRpcClientUnavailable(t *testing.T) {
+	listener := startEchoServer(t, "127.0.0.1:0")
+	address := listener.Addr().String()
+	listener.stop()
+	if _, err := NewRpcClient(address, nil, 1); err == nil {
+		t.Fatalf("Client should not be created without a reachable server.\n")
+	}
+}
@@ -87,6 +87,27 @@ func (s *SessionAuth) Authenticate(args *AuthenticateRequestArg, response *Authe
 	return nil
 }
 
+// sessionUser returns the user owning a valid session token, api
+// keys are not accepted.
+func sessionUser(client Database, token []byte) (*User, error) {
+	if token == nil {
+		return nil, fmt.Errorf("invalid nil token data")
+	}
+	var s SessionAuth
+	authResponse := AuthenticateResponseArg{}
+	err := s.Authenticate(&AuthenticateRequestArg{
+		Token: token,
+	}, &authResponse)
+	if err != nil {
+		return nil, err
+	}
+	user, err := client.GetUser(authResponse.Username)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get required user %s: %s", authResponse.Username, err.Error())
+	}
+	return user, nil
+}
+
 // UserInfoResponseArg the returned authenticated  user
 // data.
 type UserInfoResponseArg struct {
@@ -94,7 +115,12 @@ type UserInfoResponseArg struct {
 	FullName    string       // the user full name;
 	Email       string       // the user email address;
 	Permissions *Permissions // user associated permissions;
-	LastSeen    time.Time    // last seen info.
+	LastSeen    time.Time    // last seen info;
+	// api key restrictions, see Authorise
+	ApiKey           string    // identifier of the authorising api key, empty for sessions;
+	Scopes           []string  // operations allowed to the api key;
+	AllowedAddresses []string  // source addresses allowed to use the api key;
+	Expiration       time.Time // api key expiration time, never if zero.
 }
 
 // UserInfo RPC exposed function verify a session token
@@ -144,14 +170,35 @@ func (s *SessionAuth) UserInfo(args *AuthenticateRequestArg, response *UserInfoR
 
 // UpserUserRequestArg request to upsert user data.
 type UpserUserRequestArg struct {
-	Token []byte // the authentication token;
-	User  User   // the user record to be updated.
+	Token    []byte // the authentication token;
+	User     User   // the user record to be updated;
+	Password string // plaintext password, if not empty replaces the user's one.
+}
+
+// mergeUser prepares the record of an existing user to be replaced
+// with the upserted one: the password, if not provided, and the
+// data not managed by admins are preserved.
+func mergeUser(upserted, existing *User) {
+	if upserted.HashedPassword == nil {
+		upserted.HashedPassword = existing.HashedPassword
+	}
+	if upserted.Permissions.Quotas == nil {
+		upserted.Permissions.Quotas = existing.Permissions.Quotas
+	}
+	upserted.TotpSecret = existing.TotpSecret
+	upserted.TotpEnabled = existing.TotpEnabled
+	upserted.TotpLastStep = existing.TotpLastStep
+	upserted.RecoveryCodes = existing.RecoveryCodes
+	upserted.ResetTokenHash = existing.ResetTokenHash
+	upserted.ResetExpiration = existing.ResetExpiration
 }
 
 // UpsertUser is an RPC exposed function used to add or update a user in
 // the authentication database. If the user is not already present it'll
-// be added, otherwise it will be updated. Only Super-Admins will be able
-// to use this function.
+// be added, otherwise it will be updated preserving, if not provided, the
+// password and the two factor authentication data. The sessions of
+// disabled users are removed. Only Super-Admins will be able to use this
+// function.
 func (s *SessionAuth) UpsertUser(args *UpserUserRequestArg, response *VoidResponseArg) error {
 	// check for session
 	if dbclient == nil {
@@ -177,10 +224,36 @@ func (s *SessionAuth) UpsertUser(args *UpserUserRequestArg, response *VoidRespon
 	if userinfo.Permissions.SuperAdmin != true {
 		return fmt.Errorf("user not authorised to perform this operation, contact system admin")
 	}
+	if args.User.Username == "" {
+		return fmt.Errorf("invalid username: unable to process request for nil username")
+	}
 	// do actual job
-	err = client.SetUser(&args.User)
+	user := args.User
+	if args.Password != "" {
+		hash, err := bcryptPassword(args.Password)
+		if err != nil {
+			return err
+		}
+		user.HashedPassword = hash
+	}
+	existing, err := client.GetUser(user.Username)
+	if err == nil {
+		mergeUser(&user, existing)
+		err = client.UpdateUser(&user)
+	} else {
+		if user.HashedPassword == nil {
+			return fmt.Errorf("a password is required to add user %s", user.Username)
+		}
+		err = client.SetUser(&user)
+	}
 	if err != nil {
-		return fmt.Errorf("unable to upsert user %s: %s", args.User.Username, err.Error())
+		return fmt.Errorf("unable to upsert user %s: %s", user.Username, err.Error())
+	}
+	if user.IsDisabled == true {
+		err = client.RemoveUserSessions(user.Username, nil)
+		if err != nil {
+			return fmt.Errorf("unable to remove user sessions: %s", err.Error())
+		}
 	}
 
 	return nil
@@ -229,6 +302,10 @@ func (s *SessionAuth) RemoveUser(args *RemoveUserRequestArg, response *VoidRespo
 	if err != nil {
 		return fmt.Errorf("unable to remove user: %s", err.Error())
 	}
+	err = client.RemoveUserSessions(args.Username, nil)
+	if err != nil {
+		return fmt.Errorf("unable to remove user sessions: %s", err.Error())
+	}
 
 	return nil
 }
@@ -268,3 +345,68 @@ func (s *SessionAuth) KickOutAllSessions(args *AuthenticateRequestArg, response
 
 	return nil
 }
+
+// ListUsersRequestArg request to list registered users.
+type ListUsersRequestArg struct {
+	Token    []byte // the authentication token;
+	Username string // if not empty only the required user is returned.
+}
+
+// ListUsersResponseArg returns registered users, passwords and
+// two factor authentication secrets are never returned.
+type ListUsersResponseArg struct {
+	Users []User // the users records.
+}
+
+// ListUsers is an RPC exposed function that returns the users
+// registered in the authentication db, or a single one if
+// required. Only Super-Admins will be able to use this function.
+func (s *SessionAuth) ListUsers(args *ListUsersRequestArg, response *ListUsersResponseArg) error {
+	// check for session
+	if dbclient == nil {
+		return fmt.Errorf("invalid db session, unable to proceed")
+	}
+	client := dbclient.Copy()
+	defer client.Close()
+
+	// check for arguments
+	if args == nil ||
+		args.Token == nil {
+		return fmt.Errorf("invalid nil token data")
+	}
+	// get user infos
+	userinfo := UserInfoResponseArg{}
+	err := s.UserInfo(&AuthenticateRequestArg{
+		Token: args.Token,
+	}, &userinfo)
+	if err != nil {
+		return err
+	}
+	// check for superadmin
+	if userinfo.Permissions.SuperAdmin != true {
+		return fmt.Errorf("user not authorised to perform this operation, contact system admin")
+	}
+	// do actual job
+	var users []User
+	if args.Username != "" {
+		user, err := client.GetUser(args.Username)
+		if err != nil {
+			return fmt.Errorf("unable to get required user %s: %s", args.Username, err.Error())
+		}
+		users = []User{*user}
+	} else {
+		users, err = client.ListUsers()
+		if err != nil {
+			return fmt.Errorf("unable to list users: %s", err.Error())
+		}
+	}
+	for idx := range users {
+		users[idx].HashedPassword = nil
+		users[idx].TotpSecret = nil
+		users[idx].RecoveryCodes = nil
+		users[idx].ResetTokenHash = nil
+	}
+
+	response.Users = users
+	return nil
+}
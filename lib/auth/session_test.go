@@ -476,3 +476,128 @@ func TestSessionKickOutSessions(t *testing.T) {
 		t.Fatalf("Unexpected number of sessions, having %d expecting %d.\n", len(mock.sessionStorage), 0)
 	}
 }
+
+func TestSessionAdministerUsers(t *testing.T) {
+	// startup mock and global vars
+	dbclient = newMockDb(&DbArgs{
+		Addresses: strings.Split("127.0.0.1:27017,192.168.0.1:27017", ","),
+		User:      "username",
+		Password:  "password",
+		AuthDb:    "admin",
+	})
+
+	// add test admin
+	hash, err := bcryptPassword("passwordA")
+	if err != nil {
+		t.Fatalf("Unable to produce bcrypted password: %s.\n", err.Error())
+	}
+	err = dbclient.SetUser(&User{
+		Username:       "userA",
+		HashedPassword: hash,
+		Permissions: Permissions{
+			SuperAdmin: true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unable to set user: %s.\n", err.Error())
+	}
+	defer dbclient.RemoveUser("userA")
+
+	var l Login
+	login := func(username, password string) ([]byte, error) {
+		response := &LoginResponseArg{}
+		err := l.Login(&LoginRequestArg{
+			Username: username,
+			Password: password,
+		}, response)
+		return response.Token, err
+	}
+	token, err := login("userA", "passwordA")
+	if err != nil {
+		t.Fatalf("Unable to login user: %s.\n", err.Error())
+	}
+
+	var s SessionAuth
+	// new users require a password
+	err = s.UpsertUser(&UpserUserRequestArg{
+		Token: token,
+		User: User{
+			Username: "userB",
+		},
+	}, &VoidResponseArg{})
+	if err == nil {
+		t.Fatalf("Users without password should not be added.\n")
+	}
+	err = s.UpsertUser(&UpserUserRequestArg{
+		Token: token,
+		User: User{
+			Username: "userB",
+			FullName: "user B",
+		},
+		Password: "passwordB",
+	}, &VoidResponseArg{})
+	if err != nil {
+		t.Fatalf("Unable to add user: %s.\n", err.Error())
+	}
+	defer dbclient.RemoveUser("userB")
+	userToken, err := login("userB", "passwordB")
+	if err != nil {
+		t.Fatalf("Unable to login added user: %s.\n", err.Error())
+	}
+	created, _ := dbclient.GetUser("userB")
+	created.TotpSecret = []byte("secret")
+	dbclient.UpdateUser(created)
+
+	// updates preserve password and two factor data
+	err = s.UpsertUser(&UpserUserRequestArg{
+		Token: token,
+		User: User{
+			Username:   "userB",
+			FullName:   "user B updated",
+			IsDisabled: true,
+		},
+	}, &VoidResponseArg{})
+	if err != nil {
+		t.Fatalf("Unable to update user: %s.\n", err.Error())
+	}
+	updated, _ := dbclient.GetUser("userB")
+	if updated.FullName != "user B updated" ||
+		updated.HashedPassword == nil ||
+		updated.TotpSecret == nil {
+		t.Fatalf("Unexpected updated user %v.\n", updated)
+	}
+	// disabled users sessions are removed
+	if _, err = dbclient.GetSession(userToken); err == nil {
+		t.Fatalf("Disabled user sessions should be removed.\n")
+	}
+
+	// list
+	list := &ListUsersResponseArg{}
+	err = s.ListUsers(&ListUsersRequestArg{
+		Token: token,
+	}, list)
+	if err != nil {
+		t.Fatalf("Unable to list users: %s.\n", err.Error())
+	}
+	if len(list.Users) != 2 ||
+		list.Users[0].Username != "userA" ||
+		list.Users[1].HashedPassword != nil ||
+		list.Users[1].TotpSecret != nil {
+		t.Fatalf("Unexpected users list %v.\n", list.Users)
+	}
+	err = s.ListUsers(&ListUsersRequestArg{
+		Token:    token,
+		Username: "userB",
+	}, list)
+	if err != nil {
+		t.Fatalf("Unable to get user: %s.\n", err.Error())
+	}
+	if len(list.Users) != 1 ||
+		list.Users[0].Username != "userB" {
+		t.Fatalf("Unexpected users list %v.\n", list.Users)
+	}
+	// the stored record is not affected
+	if updated.HashedPassword == nil {
+		t.Fatalf("Stored password hash should be preserved.\n")
+	}
+}
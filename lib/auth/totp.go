@@ -0,0 +1,335 @@
+//
+// 3nigm4 auth package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+// Optional two factor authentication: users enrolling a time
+// based one time password (RFC 6238) generator must provide, at
+// login, a valid code or one of the single use recovery codes
+// released at enrolment. The generator secret is stored
+// encrypted with the key set by SetTotpKey.
+//
+
+package auth
+
+// Golang std libs
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Internal dependencies
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+	crypto3n4 "github.com/nexocrew/3nigm4/lib/crypto"
+)
+
+// Third party libs
+import (
+	"github.com/gokyle/hotp"
+)
+
+const (
+	totpStep          = 30 * time.Second // validity period of a code;
+	totpDigits        = 6                // digits of a code;
+	totpWindow        = 1                // tolerated clock skew, in steps;
+	totpSecretSize    = 20               // secret size in bytes;
+	totpIssuer        = "3nigm4"         // issuer reported to authenticator apps;
+	recoveryCodes     = 10               // recovery codes released at enrolment;
+	recoveryCodeBytes = 5                // recovery code entropy in bytes.
+)
+
+// totpSalt is the salt embedded in encrypted secrets: no key
+// derivation is performed so it's never used.
+var totpSalt = []byte("00000111")
+
+// ErrOtpRequired is returned by the Login RPC when the password
+// is correct but the user enabled two factor authentication and
+// no code has been provided.
+var ErrOtpRequired = errors.New("two factor authentication code required")
+
+// IsOtpRequired verifies if an error, eventually returned over
+// RPC, is ErrOtpRequired.
+func IsOtpRequired(err error) bool {
+	return err != nil &&
+		err.Error() == ErrOtpRequired.Error()
+}
+
+// encryptTotpSecret encrypts a generator secret with the global
+// TOTP key.
+func encryptTotpSecret(secret []byte) ([]byte, error) {
+	mtx.Lock()
+	key := totpKey
+	mtx.Unlock()
+	if key == nil {
+		return nil, fmt.Errorf("two factor authentication is not configured")
+	}
+	return crypto3n4.AesEncrypt(key, totpSalt, secret, crypto3n4.CBC)
+}
+
+// decryptTotpSecret decrypts a generator secret encrypted by
+// encryptTotpSecret.
+func decryptTotpSecret(encrypted []byte) ([]byte, error) {
+	mtx.Lock()
+	key := totpKey
+	mtx.Unlock()
+	if key == nil {
+		return nil, fmt.Errorf("two factor authentication is not configured")
+	}
+	return crypto3n4.AesDecrypt(key, encrypted, crypto3n4.CBC)
+}
+
+// totpCounter returns the time step including t.
+func totpCounter(t time.Time) int64 {
+	return t.Unix() / int64(totpStep/time.Second)
+}
+
+// totpCode computes the code of a time step.
+func totpCode(secret []byte, counter int64) string {
+	return hotp.NewHOTP(secret, uint64(counter), totpDigits).OTP()
+}
+
+// totpUrl returns the otpauth URL used to configure authenticator
+// apps.
+func totpUrl(username string, secret []byte) string {
+	v := url.Values{}
+	v.Add("secret", base32.StdEncoding.EncodeToString(secret))
+	v.Add("issuer", totpIssuer)
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     fmt.Sprintf("%s:%s", totpIssuer, username),
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
+
+// verifyTotp checks a code against the steps around now, codes
+// of steps already used (not after last) are refused to prevent
+// replays. Returns the matching step.
+func verifyTotp(secret []byte, code string, last int64, now time.Time) (int64, bool) {
+	current := totpCounter(now)
+	for counter := current - totpWindow; counter <= current+totpWindow; counter++ {
+		if counter <= last {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, counter)), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// hashRecoveryCode returns the stored form of a recovery code.
+func hashRecoveryCode(code string) []byte {
+	normalised := strings.ToLower(strings.Replace(code, "-", "", -1))
+	hash := sha256.Sum256([]byte(normalised))
+	return hash[:]
+}
+
+// generateRecoveryCodes creates new recovery codes returning them
+// in plain text, to be shown to the user, and hashed, to be
+// stored.
+func generateRecoveryCodes() ([]string, [][]byte, error) {
+	plain := make([]string, 0, recoveryCodes)
+	hashed := make([][]byte, 0, recoveryCodes)
+	for idx := 0; idx < recoveryCodes; idx++ {
+		raw, err := ct.RandomBytesForLen(recoveryCodeBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(raw)
+		code = code[:len(code)/2] + "-" + code[len(code)/2:]
+		plain = append(plain, code)
+		hashed = append(hashed, hashRecoveryCode(code))
+	}
+	return plain, hashed, nil
+}
+
+// verifySecondFactor verifies a TOTP or a recovery code for a
+// user with two factor authentication enabled. The user record is
+// updated, marking the code as used, and should be saved if no
+// error is returned.
+func verifySecondFactor(user *User, code string, now time.Time) error {
+	if code == "" {
+		return ErrOtpRequired
+	}
+	secret, err := decryptTotpSecret(user.TotpSecret)
+	if err != nil {
+		return fmt.Errorf("unable to decrypt two factor secret: %s", err.Error())
+	}
+	if counter, ok := verifyTotp(secret, code, user.TotpLastStep, now); ok {
+		user.TotpLastStep = counter
+		return nil
+	}
+	hash := hashRecoveryCode(code)
+	for idx, stored := range user.RecoveryCodes {
+		if subtle.ConstantTimeCompare(stored, hash) == 1 {
+			user.RecoveryCodes = append(user.RecoveryCodes[:idx], user.RecoveryCodes[idx+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid two factor authentication code")
+}
+
+// TwoFactor RPC required custom type, exposes the two factor
+// authentication management functions.
+type TwoFactor int
+
+// TotpEnrolResponseArg returns the generator secret to be
+// configured in the user authenticator app.
+type TotpEnrolResponseArg struct {
+	Secret string // base32 encoded secret;
+	URL    string // otpauth URL, usable to produce a QR code.
+}
+
+// TotpCodeRequestArg is a request authenticated by a session
+// token and confirmed by a TOTP or recovery code.
+type TotpCodeRequestArg struct {
+	Token []byte // the authentication token;
+	Code  string // the one time password or a recovery code.
+}
+
+// TotpConfirmResponseArg returns the single use recovery codes.
+type TotpConfirmResponseArg struct {
+	RecoveryCodes []string // plain text recovery codes.
+}
+
+// Enrol RPC exposed function generates a new TOTP secret for the
+// session user. Two factor authentication is enabled only after
+// confirming a code produced with it, till then the login is not
+// affected. Users having it already enabled must disable it first.
+func (t *TwoFactor) Enrol(args *AuthenticateRequestArg, response *TotpEnrolResponseArg) error {
+	// check for session
+	if dbclient == nil {
+		return fmt.Errorf("invalid db session, unable to proceed")
+	}
+	client := dbclient.Copy()
+	defer client.Close()
+
+	if args == nil {
+		return fmt.Errorf("invalid nil token data")
+	}
+	user, err := sessionUser(client, args.Token)
+	if err != nil {
+		return err
+	}
+	if user.TotpEnabled == true {
+		return fmt.Errorf("two factor authentication already enabled")
+	}
+
+	secret, err := ct.RandomBytesForLen(totpSecretSize)
+	if err != nil {
+		return err
+	}
+	encrypted, err := encryptTotpSecret(secret)
+	if err != nil {
+		return err
+	}
+	user.TotpSecret = encrypted
+	user.TotpLastStep = 0
+	user.RecoveryCodes = nil
+	err = client.UpdateUser(user)
+	if err != nil {
+		return fmt.Errorf("unable to update user %s: %s", user.Username, err.Error())
+	}
+
+	response.Secret = base32.StdEncoding.EncodeToString(secret)
+	response.URL = totpUrl(user.Username, secret)
+	return nil
+}
+
+// Confirm RPC exposed function enables two factor authentication
+// verifying a code produced with the enrolled secret. The
+// recovery codes, usable in place of a TOTP code if the generator
+// is lost, are returned only by this call.
+func (t *TwoFactor) Confirm(args *TotpCodeRequestArg, response *TotpConfirmResponseArg) error {
+	// check for session
+	if dbclient == nil {
+		return fmt.Errorf("invalid db session, unable to proceed")
+	}
+	client := dbclient.Copy()
+	defer client.Close()
+
+	if args == nil ||
+		args.Code == "" {
+		return fmt.Errorf("invalid two factor authentication code")
+	}
+	user, err := sessionUser(client, args.Token)
+	if err != nil {
+		return err
+	}
+	if user.TotpEnabled == true {
+		return fmt.Errorf("two factor authentication already enabled")
+	}
+	if user.TotpSecret == nil {
+		return fmt.Errorf("two factor authentication not enrolled")
+	}
+
+	secret, err := decryptTotpSecret(user.TotpSecret)
+	if err != nil {
+		return fmt.Errorf("unable to decrypt two factor secret: %s", err.Error())
+	}
+	counter, ok := verifyTotp(secret, args.Code, user.TotpLastStep, time.Now())
+	if !ok {
+		return fmt.Errorf("invalid two factor authentication code")
+	}
+	plain, hashed, err := generateRecoveryCodes()
+	if err != nil {
+		return err
+	}
+	user.TotpEnabled = true
+	user.TotpLastStep = counter
+	user.RecoveryCodes = hashed
+	err = client.UpdateUser(user)
+	if err != nil {
+		return fmt.Errorf("unable to update user %s: %s", user.Username, err.Error())
+	}
+
+	response.RecoveryCodes = plain
+	return nil
+}
+
+// Disable RPC exposed function disables two factor authentication
+// removing the secret and the recovery codes, a valid TOTP or
+// recovery code is required.
+func (t *TwoFactor) Disable(args *TotpCodeRequestArg, response *VoidResponseArg) error {
+	// check for session
+	if dbclient == nil {
+		return fmt.Errorf("invalid db session, unable to proceed")
+	}
+	client := dbclient.Copy()
+	defer client.Close()
+
+	if args == nil {
+		return fmt.Errorf("invalid nil token data")
+	}
+	user, err := sessionUser(client, args.Token)
+	if err != nil {
+		return err
+	}
+	if user.TotpEnabled != true {
+		return fmt.Errorf("two factor authentication not enabled")
+	}
+	err = verifySecondFactor(user, args.Code, time.Now())
+	if err != nil {
+		return err
+	}
+
+	user.TotpEnabled = false
+	user.TotpSecret = nil
+	user.TotpLastStep = 0
+	user.RecoveryCodes = nil
+	err = client.UpdateUser(user)
+	if err != nil {
+		return fmt.Errorf("unable to update user %s: %s", user.Username, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+//
+// 3nigm4 auth package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package auth
+
+// Golang std libs
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTotpCode(t *testing.T) {
+	// RFC 6238 SHA1 test vectors truncated to 6 digits
+	secret := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, expected := range vectors {
+		code := totpCode(secret, totpCounter(time.Unix(unix, 0)))
+		if code != expected {
+			t.Fatalf("Unexpected code at %d having %s expecting %s.\n", unix, code, expected)
+		}
+	}
+}
+
+func TestVerifyTotp(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111109, 0)
+	current := totpCounter(now)
+
+	for _, counter := range []int64{current - 1, current, current + 1} {
+		step, ok := verifyTotp(secret, totpCode(secret, counter), 0, now)
+		if !ok {
+			t.Fatalf("Code of step %d should be accepted.\n", counter)
+		}
+		if step != counter {
+			t.Fatalf("Unexpected step having %d expecting %d.\n", step, counter)
+		}
+	}
+	if _, ok := verifyTotp(secret, totpCode(secret, current+2), 0, now); ok {
+		t.Fatalf("Codes out of the tolerated window should be refused.\n")
+	}
+	// replayed codes
+	if _, ok := verifyTotp(secret, totpCode(secret, current), current, now); ok {
+		t.Fatalf("Already used codes should be refused.\n")
+	}
+	if _, ok := verifyTotp(secret, totpCode(secret, current+1), current, now); !ok {
+		t.Fatalf("Codes of following steps should be accepted.\n")
+	}
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	// startup mock and global vars
+	dbclient = newMockDb(&DbArgs{
+		Addresses: strings.Split("127.0.0.1:27017,192.168.0.1:27017", ","),
+		User:      "username",
+		Password:  "password",
+		AuthDb:    "admin",
+	})
+	key := sha256.Sum256([]byte("totptestkey"))
+	SetTotpKey(key[:])
+	defer SetTotpKey(nil)
+
+	// add test user
+	hash, err := bcryptPassword("passwordA")
+	if err != nil {
+		t.Fatalf("Unable to produce bcrypted password: %s.\n", err.Error())
+	}
+	err = dbclient.SetUser(&User{
+		Username:       "userA",
+		FullName:       "user A",
+		Email:          "userA@email.com",
+		HashedPassword: hash,
+	})
+	if err != nil {
+		t.Fatalf("Unable to set user: %s.\n", err.Error())
+	}
+	defer dbclient.RemoveUser("userA")
+
+	var l Login
+	login := func(otp string) (*LoginResponseArg, error) {
+		response := &LoginResponseArg{}
+		err := l.Login(&LoginRequestArg{
+			Username: "userA",
+			Password: "passwordA",
+			OTP:      otp,
+		}, response)
+		return response, err
+	}
+	response, err := login("")
+	if err != nil {
+		t.Fatalf("Unable to login user: %s.\n", err.Error())
+	}
+	token := response.Token
+
+	// enrol
+	var tf TwoFactor
+	enrol := &TotpEnrolResponseArg{}
+	err = tf.Enrol(&AuthenticateRequestArg{
+		Token: token,
+	}, enrol)
+	if err != nil {
+		t.Fatalf("Unable to enrol: %s.\n", err.Error())
+	}
+	if !strings.HasPrefix(enrol.URL, "otpauth://totp/3nigm4:userA?") {
+		t.Fatalf("Unexpected URL %s.\n", enrol.URL)
+	}
+	secret, err := base32.StdEncoding.DecodeString(enrol.Secret)
+	if err != nil {
+		t.Fatalf("Unable to decode secret: %s.\n", err.Error())
+	}
+	user, _ := dbclient.GetUser("userA")
+	if len(user.TotpSecret) == 0 ||
+		strings.Contains(string(user.TotpSecret), string(secret)) {
+		t.Fatalf("Secret should be stored encrypted.\n")
+	}
+	// not confirmed enrolments do not affect login
+	if _, err = login(""); err != nil {
+		t.Fatalf("Unconfirmed enrolment should not require codes: %s.\n", err.Error())
+	}
+
+	// confirm
+	current := totpCounter(time.Now())
+	confirm := &TotpConfirmResponseArg{}
+	err = tf.Confirm(&TotpCodeRequestArg{
+		Token: token,
+		Code:  "000000x",
+	}, confirm)
+	if err == nil {
+		t.Fatalf("Invalid codes should not confirm the enrolment.\n")
+	}
+	err = tf.Confirm(&TotpCodeRequestArg{
+		Token: token,
+		Code:  totpCode(secret, current),
+	}, confirm)
+	if err != nil {
+		t.Fatalf("Unable to confirm: %s.\n", err.Error())
+	}
+	if len(confirm.RecoveryCodes) != recoveryCodes {
+		t.Fatalf("Unexpected recovery codes having %d expecting %d.\n", len(confirm.RecoveryCodes), recoveryCodes)
+	}
+
+	// login requires a code
+	_, err = login("")
+	if !IsOtpRequired(err) {
+		t.Fatalf("Missing code should be reported, having %v.\n", err)
+	}
+	if _, err = login("123"); err == nil || IsOtpRequired(err) {
+		t.Fatalf("Wrong codes should be refused.\n")
+	}
+	// the confirmation code can not be replayed
+	if _, err = login(totpCode(secret, current)); err == nil {
+		t.Fatalf("Already used codes should be refused.\n")
+	}
+	if _, err = login(totpCode(secret, current+1)); err != nil {
+		t.Fatalf("Unable to login with valid code: %s.\n", err.Error())
+	}
+	// recovery codes are single use
+	recovery := strings.ToUpper(confirm.RecoveryCodes[0])
+	if _, err = login(recovery); err != nil {
+		t.Fatalf("Unable to login with recovery code: %s.\n", err.Error())
+	}
+	if _, err = login(recovery); err == nil {
+		t.Fatalf("Recovery codes should be usable only once.\n")
+	}
+	if len(user.RecoveryCodes) != recoveryCodes-1 {
+		t.Fatalf("Unexpected recovery codes having %d expecting %d.\n", len(user.RecoveryCodes), recoveryCodes-1)
+	}
+
+	// already enabled
+	err = tf.Enrol(&AuthenticateRequestArg{
+		Token: token,
+	}, enrol)
+	if err == nil {
+		t.Fatalf("Enrolment should be refused if already enabled.\n")
+	}
+
+	// disable
+	err = tf.Disable(&TotpCodeRequestArg{
+		Token: token,
+		Code:  "",
+	}, &VoidResponseArg{})
+	if err == nil {
+		t.Fatalf("Disabling should require a code.\n")
+	}
+	err = tf.Disable(&TotpCodeRequestArg{
+		Token: token,
+		Code:  confirm.RecoveryCodes[1],
+	}, &VoidResponseArg{})
+	if err != nil {
+		t.Fatalf("Unable to disable: %s.\n", err.Error())
+	}
+	user, _ = dbclient.GetUser("userA")
+	if user.TotpEnabled ||
+		user.TotpSecret != nil ||
+		user.RecoveryCodes != nil {
+		t.Fatalf("Two factor data should be removed.\n")
+	}
+	if _, err = login(""); err != nil {
+		t.Fatalf("Unable to login after disabling: %s.\n", err.Error())
+	}
+}
+
+func TestTwoFactorWithoutKey(t *testing.T) {
+	SetTotpKey(nil)
+	if _, err := encryptTotpSecret([]byte("secret")); err == nil {
+		t.Fatalf("Secrets should not be encrypted without a key.\n")
+	}
+}
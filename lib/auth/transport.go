@@ -0,0 +1,138 @@
+//
+// 3nigm4 auth package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+// RPC transport is protected with TLS: the auth server
+// verifies client certificates, issued by a trusted CA, so
+// that only known services can invoke RPC functions.
+//
+
+package auth
+
+// Golang std libs
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/rpc"
+	"time"
+)
+
+// TLSArgs are the files required to establish mutually
+// authenticated RPC connections.
+type TLSArgs struct {
+	CA          string // PEM file of the CA certificates used to verify peers;
+	Certificate string // PEM file of the certificate presented to peers;
+	PrivateKey  string // PEM file of the certificate private key.
+}
+
+// loadTLSArgs loads the certificate and the CA pool referred by
+// the arguments.
+func loadTLSArgs(args *TLSArgs) (*tls.Certificate, *x509.CertPool, error) {
+	if args == nil ||
+		args.CA == "" ||
+		args.Certificate == "" ||
+		args.PrivateKey == "" {
+		return nil, nil, fmt.Errorf("CA, certificate and private key files are required")
+	}
+	certificate, err := tls.LoadX509KeyPair(args.Certificate, args.PrivateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to load certificate: %s", err.Error())
+	}
+	pem, err := ioutil.ReadFile(args.CA)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read CA file: %s", err.Error())
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, nil, fmt.Errorf("no valid certificate found in CA file %s", args.CA)
+	}
+	return &certificate, pool, nil
+}
+
+// ServerTLSConfig returns the configuration used by the auth
+// server: clients must present a certificate signed by the CA.
+func ServerTLSConfig(args *TLSArgs) (*tls.Config, error) {
+	certificate, pool, err := loadTLSArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{*certificate},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ClientTLSConfig returns the configuration used by auth RPC
+// clients: the server certificate is verified using the CA and
+// the client certificate is presented to the server.
+func ClientTLSConfig(args *TLSArgs) (*tls.Config, error) {
+	certificate, pool, err := loadTLSArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{*certificate},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// rpcConnected is the status returned by net/rpc HTTP handlers
+// when a connection is accepted.
+const rpcConnected = "200 Connected to Go RPC"
+
+// rpcDialTimeout bounds the time required to establish RPC
+// connections.
+var rpcDialTimeout = 10 * time.Second
+
+// DialRpc connects to an RPC server, registered with
+// rpc.HandleHTTP, at the argument address. If config is nil a
+// plain TCP connection is used, otherwise the connection is
+// protected with TLS.
+func DialRpc(address string, config *tls.Config) (*rpc.Client, error) {
+	dialer := &net.Dialer{
+		Timeout: rpcDialTimeout,
+	}
+	var conn net.Conn
+	var err error
+	if config == nil {
+		conn, err = dialer.Dial("tcp", address)
+	} else {
+		// the server name is required to verify the certificate
+		if config.ServerName == "" {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return nil, err
+			}
+			config = config.Clone()
+			config.ServerName = host
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, config)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// same handshake performed by rpc.DialHTTP
+	conn.SetDeadline(time.Now().Add(rpcDialTimeout))
+	io.WriteString(conn, "CONNECT "+rpc.DefaultRPCPath+" HTTP/1.0\n\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.Status != rpcConnected {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected HTTP response: %s", resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	return rpc.NewClient(conn), nil
+}
@@ -0,0 +1,114 @@
+//
+// 3nigm4 auth package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package auth
+
+// Golang std libs
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/rpc"
+	"os"
+	"testing"
+)
+
+// Internal dependencies
+import (
+	"github.com/nexocrew/3nigm4/lib/itm"
+)
+
+// Echo RPC type used to test transports.
+type Echo int
+
+// Echo returns the argument message.
+func (e *Echo) Echo(args *string, reply *string) error {
+	*reply = *args
+	return nil
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "authtransport")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %s.\n", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	files, err := itm.GenerateTLSFiles(dir)
+	if err != nil {
+		t.Fatalf("Unable to create test certificates: %s.\n", err.Error())
+	}
+
+	// wrong arguments
+	for _, args := range []*TLSArgs{
+		nil,
+		&TLSArgs{Certificate: files.ClientCertificate, PrivateKey: files.ClientPrivateKey},
+		&TLSArgs{CA: files.CA, Certificate: files.ClientCertificate, PrivateKey: files.ServerPrivateKey},
+		&TLSArgs{CA: files.ClientPrivateKey, Certificate: files.ClientCertificate, PrivateKey: files.ClientPrivateKey},
+	} {
+		if _, err := ClientTLSConfig(args); err == nil {
+			t.Fatalf("Wrong arguments should produce an error: %+v.\n", args)
+		}
+	}
+
+	serverConfig, err := ServerTLSConfig(&TLSArgs{
+		CA:          files.CA,
+		Certificate: files.ServerCertificate,
+		PrivateKey:  files.ServerPrivateKey,
+	})
+	if err != nil {
+		t.Fatalf("Unable to create server config: %s.\n", err.Error())
+	}
+	if serverConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatalf("Server should require client certificates.\n")
+	}
+	clientConfig, err := ClientTLSConfig(&TLSArgs{
+		CA:          files.CA,
+		Certificate: files.ClientCertificate,
+		PrivateKey:  files.ClientPrivateKey,
+	})
+	if err != nil {
+		t.Fatalf("Unable to create client config: %s.\n", err.Error())
+	}
+
+	// serve RPC over TLS
+	server := rpc.NewServer()
+	server.Register(new(Echo))
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatalf("Unable to listen: %s.\n", err.Error())
+	}
+	defer listener.Close()
+	go http.Serve(listener, server)
+	address := listener.Addr().String()
+
+	client, err := DialRpc(address, clientConfig)
+	if err != nil {
+		t.Fatalf("Unable to connect: %s.\n", err.Error())
+	}
+	defer client.Close()
+	message := "message"
+	var reply string
+	err = client.Call("Echo.Echo", &message, &reply)
+	if err != nil {
+		t.Fatalf("Unable to call RPC function: %s.\n", err.Error())
+	}
+	if reply != message {
+		t.Fatalf("Having %s expecting %s.\n", reply, message)
+	}
+
+	// clients not presenting a certificate are refused
+	anonymous := clientConfig.Clone()
+	anonymous.Certificates = nil
+	if client, err := DialRpc(address, anonymous); err == nil {
+		client.Close()
+		t.Fatalf("Clients without certificate should be refused.\n")
+	}
+	// plain connections are refused
+	if client, err := DialRpc(address, nil); err == nil {
+		client.Close()
+		t.Fatalf("Plain connections should be refused.\n")
+	}
+}
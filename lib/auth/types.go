@@ -26,7 +26,15 @@ const (
 // a special bool flag will be setted.
 type Permissions struct {
 	SuperAdmin bool             `bson:"superadmin,omitempty" json:"superadmin,omitempty"` // special user that have all permissions on all services;
-	Services   map[string]Level `bson:"services" json:"services"`                         // permissions organised per service, the "all" can be used for generalised behaviour.
+	Services   map[string]Level `bson:"services" json:"services"`                         // permissions organised per service, the "all" can be used for generalised behaviour;
+	Quotas     map[string]Quota `bson:"quotas,omitempty" json:"quotas,omitempty"`         // resource quotas organised per service, the "all" can be used for generalised behaviour.
+}
+
+// Quota describes the resources a user can allocate on a
+// service, zero values stand for no limit.
+type Quota struct {
+	Bytes   int64 `bson:"bytes,omitempty" json:"bytes,omitempty"`     // maximum stored bytes;
+	Objects int64 `bson:"objects,omitempty" json:"objects,omitempty"` // maximum stored objects.
 }
 
 // User struct identify a registered
@@ -37,15 +45,40 @@ type User struct {
 	HashedPassword []byte      `bson:"pwdhash"`            // hashed password;
 	Email          string      `bson:"email,omitempty"`    // user's verified email;
 	Permissions    Permissions `bson:"permissions"`        // the permissions associated to the user;
-	IsDisabled     bool        `bson:"disabled"`           // user active (true) or not (false).
+	IsDisabled     bool        `bson:"disabled"`           // user active (true) or not (false);
+	// two factor authentication
+	TotpSecret    []byte   `bson:"totpsecret,omitempty"`    // encrypted TOTP secret, set at enrolment;
+	TotpEnabled   bool     `bson:"totpenabled,omitempty"`   // TOTP code required at login;
+	TotpLastStep  int64    `bson:"totplaststep,omitempty"`  // time step of the last accepted code;
+	RecoveryCodes [][]byte `bson:"recoverycodes,omitempty"` // hashed single use recovery codes;
+	// password reset
+	ResetTokenHash  []byte    `bson:"resethash,omitempty"` // hash of the pending password reset token;
+	ResetExpiration time.Time `bson:"reset_ts,omitempty"`  // expiration of the pending password reset token.
 }
 
 // Session contains information about loggedin
 // for authenticated users.
 type Session struct {
-	Token        []byte        `bson:"token"`       // token for the session;
-	Username     string        `bson:"username"`    // username associated to session;
-	LoginTime    time.Time     `bson:"login_ts"`    // timestamp of login time for this session;
-	LastSeenTime time.Time     `bson:"lastseen_ts"` // last call to an API done by the user;
-	TimeToLive   time.Duration `bson:"timetolive"`  // time of validity of the session.
+	Token        []byte        `bson:"token"`               // token for the session;
+	Username     string        `bson:"username"`            // username associated to session;
+	LoginTime    time.Time     `bson:"login_ts"`            // timestamp of login time for this session;
+	LastSeenTime time.Time     `bson:"lastseen_ts"`         // last call to an API done by the user;
+	Address      string        `bson:"address,omitempty"`   // address of the client opening the session;
+	UserAgent    string        `bson:"useragent,omitempty"` // user agent of the client opening the session;
+	TimeToLive   time.Duration `bson:"timetolive"`          // time of validity of the session.
+}
+
+// ApiKey is a long lived credential, usable in place of a session
+// token, restricted to a set of scopes and, optionally, of source
+// addresses. Only the hash of the key secret is stored.
+type ApiKey struct {
+	Id               string    `bson:"id"`                      // public key identifier;
+	Username         string    `bson:"username"`                // key owner;
+	Name             string    `bson:"name"`                    // descriptive name assigned by the owner;
+	Hash             []byte    `bson:"hash"`                    // hash of the key secret;
+	Scopes           []string  `bson:"scopes"`                  // allowed operations in the form <service>:<operation>;
+	AllowedAddresses []string  `bson:"allowedaddrs,omitempty"`  // allowed source IPs or CIDR networks, all if empty;
+	CreationTime     time.Time `bson:"creation_ts"`             // creation time stamp;
+	Expiration       time.Time `bson:"expiration_ts,omitempty"` // expiration time, never if zero;
+	LastUsedTime     time.Time `bson:"lastused_ts,omitempty"`   // last successful authorisation.
 }
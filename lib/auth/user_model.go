@@ -16,6 +16,11 @@ import (
 	"time"
 )
 
+// Internal dependencies
+import (
+	"github.com/nexocrew/3nigm4/lib/ratelimit"
+)
+
 // Third party libs
 import (
 	"golang.org/x/crypto/bcrypt"
@@ -69,7 +74,11 @@ type Login int
 // LoginRequestArg define the RPC request struct
 type LoginRequestArg struct {
 	Username string // the authenticating username;
-	Password string // plaintext password.
+	Password string // plaintext password;
+	OTP      string // TOTP or recovery code, required if two factor authentication is enabled;
+	// client informations, recorded in the session
+	Address   string // address of the client;
+	UserAgent string // user agent of the client.
 }
 
 // LoginResponseArg the returned login structure
@@ -80,7 +89,9 @@ type LoginResponseArg struct {
 
 // Login RPC exposed functions it's create a session token
 // after verifying that the username and password are already
-// registered in the system.
+// registered in the system. Users having two factor
+// authentication enabled must provide a valid code too: if
+// missing ErrOtpRequired is returned.
 func (t *Login) Login(args *LoginRequestArg, response *LoginResponseArg) error {
 	// check for session
 	if dbclient == nil {
@@ -96,9 +107,21 @@ func (t *Login) Login(args *LoginRequestArg, response *LoginResponseArg) error {
 		return fmt.Errorf("invalid username and password")
 	}
 
+	// check for brute-force attempts
+	mtx.Lock()
+	limiter := loginLimiter
+	mtx.Unlock()
+	key := ratelimit.UserKey(args.Username)
+	reservation, err := limiter.Reserve(key)
+	if err != nil {
+		return err
+	}
+	defer reservation.Cancel()
+
 	// query for user
 	reference, err := client.GetUser(args.Username)
 	if err != nil {
+		reservation.Failure()
 		return fmt.Errorf("unable to get %s user: %s", args.Username, err.Error())
 	}
 	if reference.IsDisabled == true {
@@ -106,8 +129,25 @@ func (t *Login) Login(args *LoginRequestArg, response *LoginResponseArg) error {
 	}
 	err = bcrypt.CompareHashAndPassword(reference.HashedPassword, []byte(args.Password))
 	if err != nil {
+		reservation.Failure()
 		return fmt.Errorf("user not authenticated: %s", err.Error())
 	}
+	if reference.TotpEnabled == true {
+		err = verifySecondFactor(reference, args.OTP, time.Now())
+		if err == ErrOtpRequired {
+			return err
+		}
+		if err != nil {
+			reservation.Failure()
+			return fmt.Errorf("user not authenticated: %s", err.Error())
+		}
+		// mark the code as used
+		err = client.UpdateUser(reference)
+		if err != nil {
+			return fmt.Errorf("unable to update user %s: %s", reference.Username, err.Error())
+		}
+	}
+	reservation.Success(key)
 
 	// create session token
 	token, err := generateSessionToken(reference.Username)
@@ -121,6 +161,8 @@ func (t *Login) Login(args *LoginRequestArg, response *LoginResponseArg) error {
 		Username:     reference.Username,
 		LoginTime:    now,
 		LastSeenTime: now,
+		Address:      args.Address,
+		UserAgent:    args.UserAgent,
 		TimeToLive:   time.Duration(kTimeToLive) * time.Minute,
 	})
 	if err != nil {
@@ -143,8 +185,9 @@ type LogoutResponseArg struct {
 	Invalidated []byte
 }
 
-// Logout RPC exposed function logout a user, starting from a valid active
-// session and remove all opened session related to that user.
+// Logout RPC exposed function logout a user removing the argument
+// session, other sessions of the same user are not affected: use
+// SessionAuth.RevokeSessions to remove them.
 func (t *Login) Logout(args *LogoutRequestArg, response *LogoutResponseArg) error {
 	// check for session
 	if dbclient == nil {
@@ -168,3 +211,74 @@ func (t *Login) Logout(args *LogoutRequestArg, response *LogoutResponseArg) erro
 	response.Invalidated = args.Token
 	return nil
 }
+
+// RefreshRequestArg is the request passed to renew an
+// active session.
+type RefreshRequestArg struct {
+	Token []byte // the still valid session token to be renewed.
+}
+
+// Refresh RPC exposed function renews a still valid session: a
+// new token is generated and the old one is invalidated. Expired
+// sessions can not be refreshed, in that case the user should
+// login again.
+func (t *Login) Refresh(args *RefreshRequestArg, response *LoginResponseArg) error {
+	// check for session
+	if dbclient == nil {
+		return fmt.Errorf("invalid db session, unable to proceed")
+	}
+	client := dbclient.Copy()
+	defer client.Close()
+
+	if args == nil ||
+		args.Token == nil {
+		return fmt.Errorf("invalid session token")
+	}
+
+	// find session in the db
+	session, err := client.GetSession(args.Token)
+	if err != nil {
+		return fmt.Errorf("unable to get required session: %s", err.Error())
+	}
+	// validate time vars
+	now := time.Now()
+	if sessionTimeValid(&now, &session.LastSeenTime, session.TimeToLive) == false {
+		client.RemoveSession(args.Token)
+		return fmt.Errorf("session is expired")
+	}
+	// verify that the user is still enabled
+	reference, err := client.GetUser(session.Username)
+	if err != nil {
+		return fmt.Errorf("unable to get %s user: %s", session.Username, err.Error())
+	}
+	if reference.IsDisabled == true {
+		client.RemoveSession(args.Token)
+		return fmt.Errorf("user is disabled, unable to proceed")
+	}
+
+	// create the new session token
+	token, err := generateSessionToken(reference.Username)
+	if err != nil {
+		return err
+	}
+	err = client.SetSession(&Session{
+		Token:        token,
+		Username:     reference.Username,
+		LoginTime:    session.LoginTime,
+		LastSeenTime: now,
+		Address:      session.Address,
+		UserAgent:    session.UserAgent,
+		TimeToLive:   time.Duration(kTimeToLive) * time.Minute,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to save session: %s", err.Error())
+	}
+	// remove the old session
+	err = client.RemoveSession(args.Token)
+	if err != nil {
+		return fmt.Errorf("unable to remove refreshed session: %s", err.Error())
+	}
+
+	response.Token = token
+	return nil
+}
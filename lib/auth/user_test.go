@@ -8,11 +8,18 @@ package auth
 
 // Golang std libs
 import (
+	"bytes"
+	"encoding/hex"
 	"strings"
 	"testing"
 	"time"
 )
 
+// Internal dependencies
+import (
+	"github.com/nexocrew/3nigm4/lib/ratelimit"
+)
+
 func TestLoginRegularUser(t *testing.T) {
 	// startup mock and global vars
 	dbclient = newMockDb(&DbArgs{
@@ -136,6 +143,69 @@ func TestLoginIvalidUser(t *testing.T) {
 	}
 }
 
+func TestLoginThrottled(t *testing.T) {
+	// startup mock and global vars
+	dbclient = newMockDb(&DbArgs{
+		Addresses: strings.Split("127.0.0.1:27017,192.168.0.1:27017", ","),
+		User:      "username",
+		Password:  "password",
+		AuthDb:    "admin",
+	})
+	var lockedOut string
+	SetLoginLimiter(ratelimit.NewLimiter(ratelimit.Config{
+		LockoutAttempts: 2,
+		LockoutDuration: time.Hour,
+	}, func(key string, failures int, until time.Time) {
+		lockedOut = key
+	}))
+	defer SetLoginLimiter(nil)
+
+	// add test user
+	hash, err := bcryptPassword("passwordA")
+	if err != nil {
+		t.Fatalf("Unable to produce bcrypted password: %s.\n", err.Error())
+	}
+	err = dbclient.SetUser(&User{
+		Username:       "userA",
+		FullName:       "user A",
+		Email:          "userA@email.com",
+		IsDisabled:     false,
+		HashedPassword: hash,
+	})
+	if err != nil {
+		t.Fatalf("Unable to set user: %s.\n", err.Error())
+	}
+	defer dbclient.RemoveUser("userA")
+
+	// a successful login resets failures
+	var l Login
+	var testCases = []struct {
+		password string
+		success  bool
+	}{
+		{"wrong", false},
+		{"passwordA", true},
+		{"wrong", false},
+		{"wrong", false},
+		{"passwordA", false},
+	}
+	for idx, tc := range testCases {
+		err = l.Login(&LoginRequestArg{
+			Username: "userA",
+			Password: tc.password,
+		}, &LoginResponseArg{})
+		if (err == nil) != tc.success {
+			t.Fatalf("Test case %d: unexpected login result %v.\n", idx, err)
+		}
+	}
+	if _, ok := err.(*ratelimit.LimitError); !ok {
+		t.Fatalf("Locked out user should return a limit error, having %v.\n", err)
+	}
+	if lockedOut != ratelimit.UserKey("userA") {
+		t.Fatalf("Unexpected locked out key %s.\n", lockedOut)
+	}
+}
+
 func TestLoginDisabledUser(t *testing.T) {
 	// startup mock and global vars
 	dbclient = newMockDb(&DbArgs{
@@ -243,3 +313,84 @@ func TestLoginAndLogoutOnRegularUser(t *testing.T) {
 		t.Fatalf("Session should not be present but is still there.\n")
 	}
 }
+
+func TestLoginAndRefreshOnRegularUser(t *testing.T) {
+	// startup mock and global vars
+	mock := newMockDb(&DbArgs{
+		Addresses: strings.Split("127.0.0.1:27017,192.168.0.1:27017", ","),
+		User:      "username",
+		Password:  "password",
+		AuthDb:    "admin",
+	})
+	dbclient = mock
+
+	// add test user
+	hash, err := bcryptPassword("passwordA")
+	if err != nil {
+		t.Fatalf("Unable to produce bcrypted password: %s.\n", err.Error())
+	}
+	err = dbclient.SetUser(&User{
+		Username:       "userA",
+		FullName:       "user A",
+		Email:          "userA@email.com",
+		IsDisabled:     false,
+		HashedPassword: hash,
+		Permissions: Permissions{
+			SuperAdmin: false,
+			Services: map[string]Level{
+				"test": LevelAdmin,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unable to set user: %s.\n", err.Error())
+	}
+	defer dbclient.RemoveUser("userA")
+
+	// login func
+	var l Login
+	loginResponse := &LoginResponseArg{}
+	err = l.Login(&LoginRequestArg{
+		Username: "userA",
+		Password: "passwordA",
+	}, loginResponse)
+	if err != nil {
+		t.Fatalf("Unable to login user: %s.\n", err.Error())
+	}
+
+	// refresh
+	refreshResponse := &LoginResponseArg{}
+	err = l.Refresh(&RefreshRequestArg{
+		Token: loginResponse.Token,
+	}, refreshResponse)
+	if err != nil {
+		t.Fatalf("Unable to refresh session: %s.\n", err.Error())
+	}
+	if refreshResponse.Token == nil ||
+		len(refreshResponse.Token) == 0 {
+		t.Fatalf("Unexpected token, should be not nil.\n")
+	}
+	if bytes.Compare(refreshResponse.Token, loginResponse.Token) == 0 {
+		t.Fatalf("Refreshed token should be different from the original one.\n")
+	}
+	if _, err = dbclient.GetSession(loginResponse.Token); err == nil {
+		t.Fatalf("Old session should not be present but is still there.\n")
+	}
+	if _, err = dbclient.GetSession(refreshResponse.Token); err != nil {
+		t.Fatalf("Refreshed session should be present: %s.\n", err.Error())
+	}
+
+	// expired sessions can not be refreshed
+	now := time.Now()
+	mock.sessionStorage[hex.EncodeToString(refreshResponse.Token)].LastSeenTime = time.Unix(now.Unix()-(16*60), 0)
+	expiredResponse := &LoginResponseArg{}
+	err = l.Refresh(&RefreshRequestArg{
+		Token: refreshResponse.Token,
+	}, expiredResponse)
+	if err == nil {
+		t.Fatalf("Refresh of an expired session should fail.\n")
+	}
+	if expiredResponse.Token != nil {
+		t.Fatalf("Token response should be nil.\n")
+	}
+}
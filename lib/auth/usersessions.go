@@ -0,0 +1,151 @@
+//
+// 3nigm4 auth package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+// User sessions management: users can list their active sessions,
+// together with the address and user agent of the clients that
+// opened them, and revoke one of them or all the others (i.e. to
+// lock out a stolen device). Sessions are identified by an hash of
+// their token that is never returned.
+//
+
+package auth
+
+// Golang std libs
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+const (
+	kSessionIdSize = 8 // size, in bytes, of session identifiers.
+)
+
+// sessionId returns the public identifier of a session token.
+func sessionId(token []byte) string {
+	hash := sha256.Sum256(token)
+	return hex.EncodeToString(hash[:kSessionIdSize])
+}
+
+// SessionInfo describes an active session without exposing its
+// token.
+type SessionInfo struct {
+	Id           string    // session identifier;
+	Address      string    // address of the client opening the session;
+	UserAgent    string    // user agent of the client opening the session;
+	LoginTime    time.Time // login time stamp;
+	LastSeenTime time.Time // last call to an API done with the session;
+	Expiration   time.Time // expiration time if not used anymore;
+	Current      bool      // true for the session used by the request.
+}
+
+// ListSessionsResponseArg returns the active sessions of a user.
+type ListSessionsResponseArg struct {
+	Sessions []SessionInfo // user sessions sorted by login time.
+}
+
+// ListSessions RPC exposed function returns the active sessions
+// of the user owning the session token: expired sessions are not
+// returned. Api keys are not accepted.
+func (s *SessionAuth) ListSessions(args *AuthenticateRequestArg, response *ListSessionsResponseArg) error {
+	// check for session
+	if dbclient == nil {
+		return fmt.Errorf("invalid db session, unable to proceed")
+	}
+	client := dbclient.Copy()
+	defer client.Close()
+
+	// check for arguments
+	if args == nil {
+		return fmt.Errorf("invalid nil token data")
+	}
+	user, err := sessionUser(client, args.Token)
+	if err != nil {
+		return err
+	}
+	sessions, err := client.GetUserSessions(user.Username)
+	if err != nil {
+		return fmt.Errorf("unable to get user sessions: %s", err.Error())
+	}
+
+	now := time.Now()
+	response.Sessions = make([]SessionInfo, 0, len(sessions))
+	for idx := range sessions {
+		session := &sessions[idx]
+		if sessionTimeValid(&now, &session.LastSeenTime, session.TimeToLive) == false {
+			continue
+		}
+		response.Sessions = append(response.Sessions, SessionInfo{
+			Id:           sessionId(session.Token),
+			Address:      session.Address,
+			UserAgent:    session.UserAgent,
+			LoginTime:    session.LoginTime,
+			LastSeenTime: session.LastSeenTime,
+			Expiration:   session.LastSeenTime.Add(session.TimeToLive),
+			Current:      bytes.Equal(session.Token, args.Token),
+		})
+	}
+	return nil
+}
+
+// RevokeSessionsRequestArg request to revoke user sessions.
+type RevokeSessionsRequestArg struct {
+	Token     []byte // the session token;
+	SessionId string // identifier of the session to be revoked;
+	All       bool   // revoke all the sessions except the requesting one.
+}
+
+// RevokeSessionsResponseArg returns the number of revoked sessions.
+type RevokeSessionsResponseArg struct {
+	Revoked int // number of removed sessions.
+}
+
+// RevokeSessions RPC exposed function removes one of the sessions
+// of the user owning the session token or, if All is true, all of
+// them except the requesting one. Api keys are not accepted.
+func (s *SessionAuth) RevokeSessions(args *RevokeSessionsRequestArg, response *RevokeSessionsResponseArg) error {
+	// check for session
+	if dbclient == nil {
+		return fmt.Errorf("invalid db session, unable to proceed")
+	}
+	client := dbclient.Copy()
+	defer client.Close()
+
+	// check for arguments
+	if args == nil ||
+		(args.SessionId == "" && args.All == false) {
+		return fmt.Errorf("invalid request: a session id or all sessions should be specified")
+	}
+	user, err := sessionUser(client, args.Token)
+	if err != nil {
+		return err
+	}
+	sessions, err := client.GetUserSessions(user.Username)
+	if err != nil {
+		return fmt.Errorf("unable to get user sessions: %s", err.Error())
+	}
+
+	if args.All == true {
+		err = client.RemoveUserSessions(user.Username, args.Token)
+		if err != nil {
+			return fmt.Errorf("unable to remove user sessions: %s", err.Error())
+		}
+		response.Revoked = len(sessions) - 1
+		return nil
+	}
+	for idx := range sessions {
+		if sessionId(sessions[idx].Token) == args.SessionId {
+			err = client.RemoveSession(sessions[idx].Token)
+			if err != nil {
+				return fmt.Errorf("unable to remove session: %s", err.Error())
+			}
+			response.Revoked = 1
+			return nil
+		}
+	}
+	return fmt.Errorf("unable to find session %s", args.SessionId)
+}
@@ -0,0 +1,137 @@
+//
+// 3nigm4 auth package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package auth
+
+// Golang std libs
+import (
+	"testing"
+)
+
+func TestUserSessions(t *testing.T) {
+	passwordTestUsers(t)
+	defer dbclient.RemoveUser("userA")
+	defer dbclient.RemoveUser("admin")
+
+	var l Login
+	login := func(username, address, userAgent string) []byte {
+		response := &LoginResponseArg{}
+		err := l.Login(&LoginRequestArg{
+			Username:  username,
+			Password:  "password" + username,
+			Address:   address,
+			UserAgent: userAgent,
+		}, response)
+		if err != nil {
+			t.Fatalf("Unable to login user: %s.\n", err.Error())
+		}
+		return response.Token
+	}
+	laptop := login("userA", "10.0.0.1:1234", "3n4cli laptop")
+	desktop := login("userA", "10.0.0.2:1234", "3n4cli desktop")
+	phone := login("userA", "10.0.0.3:1234", "3n4cli phone")
+	admin := login("admin", "10.0.0.4:1234", "3n4cli")
+
+	var s SessionAuth
+	var list ListSessionsResponseArg
+	err := s.ListSessions(&AuthenticateRequestArg{
+		Token: desktop,
+	}, &list)
+	if err != nil {
+		t.Fatalf("Unable to list sessions: %s.\n", err.Error())
+	}
+	if len(list.Sessions) != 3 {
+		t.Fatalf("Unexpected number of sessions %d, expecting 3.\n", len(list.Sessions))
+	}
+	var laptopId string
+	for _, info := range list.Sessions {
+		switch info.UserAgent {
+		case "3n4cli laptop":
+			laptopId = info.Id
+			if info.Address != "10.0.0.1:1234" ||
+				info.Current {
+				t.Fatalf("Unexpected laptop session %v.\n", info)
+			}
+		case "3n4cli desktop":
+			if !info.Current {
+				t.Fatalf("Requesting session should be marked as current.\n")
+			}
+		case "3n4cli phone":
+		default:
+			t.Fatalf("Unexpected session %v.\n", info)
+		}
+		if info.Expiration.Before(info.LastSeenTime) {
+			t.Fatalf("Unexpected expiration %v.\n", info)
+		}
+	}
+
+	// sessions of other users can not be revoked
+	err = s.RevokeSessions(&RevokeSessionsRequestArg{
+		Token:     admin,
+		SessionId: laptopId,
+	}, &RevokeSessionsResponseArg{})
+	if err == nil {
+		t.Fatalf("Sessions of other users should not be revoked.\n")
+	}
+	err = s.RevokeSessions(&RevokeSessionsRequestArg{
+		Token: desktop,
+	}, &RevokeSessionsResponseArg{})
+	if err == nil {
+		t.Fatalf("Requests without session id should be refused.\n")
+	}
+
+	// revoke one
+	var revoked RevokeSessionsResponseArg
+	err = s.RevokeSessions(&RevokeSessionsRequestArg{
+		Token:     desktop,
+		SessionId: laptopId,
+	}, &revoked)
+	if err != nil {
+		t.Fatalf("Unable to revoke session: %s.\n", err.Error())
+	}
+	if revoked.Revoked != 1 {
+		t.Fatalf("Unexpected revoked sessions %d, expecting 1.\n", revoked.Revoked)
+	}
+	if _, err = dbclient.GetSession(laptop); err == nil {
+		t.Fatalf("Revoked session should be removed.\n")
+	}
+	if _, err = dbclient.GetSession(phone); err != nil {
+		t.Fatalf("Other sessions should be preserved: %s.\n", err.Error())
+	}
+
+	// revoke all the others
+	err = s.RevokeSessions(&RevokeSessionsRequestArg{
+		Token: desktop,
+		All:   true,
+	}, &revoked)
+	if err != nil {
+		t.Fatalf("Unable to revoke sessions: %s.\n", err.Error())
+	}
+	if _, err = dbclient.GetSession(phone); err == nil {
+		t.Fatalf("Other sessions should be removed.\n")
+	}
+	if _, err = dbclient.GetSession(desktop); err != nil {
+		t.Fatalf("Requesting session should be preserved: %s.\n", err.Error())
+	}
+	if _, err = dbclient.GetSession(admin); err != nil {
+		t.Fatalf("Sessions of other users should be preserved: %s.\n", err.Error())
+	}
+
+	// logout removes only the argument session
+	var logout LogoutResponseArg
+	err = l.Logout(&LogoutRequestArg{
+		Token: desktop,
+	}, &logout)
+	if err != nil {
+		t.Fatalf("Unable to logout: %s.\n", err.Error())
+	}
+	err = s.ListSessions(&AuthenticateRequestArg{
+		Token: desktop,
+	}, &list)
+	if err == nil {
+		t.Fatalf("Logged out sessions should not list sessions.\n")
+	}
+}
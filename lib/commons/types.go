@@ -22,7 +22,12 @@ import (
 
 // Shared key values
 const (
-	SecurityTokenKey = "3x4-Security-Token" // header key used to pass security token coded as hexadecimal.
+	SecurityTokenKey = "3x4-Security-Token" // header key used to pass security token coded as hexadecimal;
+	TransferTokenKey = "3x4-Transfer-Token" // header key used to pass the transfer token, obtained with a batch, of a streamed chunk;
+	CheckSumKey      = "3x4-Checksum"       // header key used to pass the SHA256 checksum, coded as hexadecimal, of a streamed chunk;
+	TimeToLiveKey    = "3x4-Time-To-Live"   // header key used to pass the time to live of an uploaded chunk (duration string);
+	PermissionKey    = "3x4-Permission"     // header key used to pass the permission of an uploaded chunk;
+	SharingUsersKey  = "3x4-Sharing-Users"  // header key used to pass comma separated users enabled to access a shared chunk.
 )
 
 // CheckSum of the uploaded file for later verify.
@@ -48,20 +53,147 @@ const (
 	NakResponse = "NAK" // Not acknowledged message.
 )
 
+// HealthResponse is returned by health check APIs: the status is
+// ACK if all the checked components are available, otherwise it's
+// NAK and the failing components are reported with their errors.
+type HealthResponse struct {
+	Status string            `json:"status"`           // status string;
+	Checks map[string]string `json:"checks,omitempty"` // failing components errors.
+}
+
 // LoginRequest is used by the login API to recieve user's
 // credentials, should always be used on an SSL/TLS
 // protected session.
 type LoginRequest struct {
-	Username string `json:"username"` // the user name;
-	Password string `json:"password"` // the password used by the user.
+	Username string `json:"username"`      // the user name;
+	Password string `json:"password"`      // the password used by the user;
+	OTP      string `json:"otp,omitempty"` // two factor authentication code, if enabled.
 }
 
+// OtpRequiredError is the error returned, with an unauthorised
+// status, by the login API if the user enabled two factor
+// authentication and no code has been provided.
+const OtpRequiredError = "two factor authentication code required"
+
 // LoginResponse is used to respond with a session token
 // to a login JSON request.
 type LoginResponse struct {
 	Token string `json:"token"` // the session token.
 }
 
+// TotpEnrolResponse returns the TOTP secret generated at two
+// factor authentication enrolment.
+type TotpEnrolResponse struct {
+	Secret string `json:"secret"` // base32 encoded secret;
+	URL    string `json:"url"`    // otpauth URL, usable by authenticator apps.
+}
+
+// TotpCodeRequest is used to confirm or disable two factor
+// authentication with a TOTP or recovery code.
+type TotpCodeRequest struct {
+	Code string `json:"code"` // TOTP or recovery code.
+}
+
+// TotpConfirmResponse returns the single use recovery codes
+// released when two factor authentication is enabled.
+type TotpConfirmResponse struct {
+	RecoveryCodes []string `json:"recoverycodes"` // plain text recovery codes.
+}
+
+// ApiKeyRequest is used to create a new API key, usable in place
+// of a session token, restricted to the required scopes.
+type ApiKeyRequest struct {
+	Name             string     `json:"name"`                       // descriptive key name;
+	Scopes           []string   `json:"scopes"`                     // allowed operations in the form <service>:<operation>;
+	AllowedAddresses []string   `json:"allowedaddresses,omitempty"` // allowed source IPs or CIDR networks, all if empty;
+	Expiration       *time.Time `json:"expiration,omitempty"`       // expiration time, never if nil.
+}
+
+// ApiKeyInfo describes an existing API key.
+type ApiKeyInfo struct {
+	ID               string     `json:"id"`                         // public key identifier;
+	Name             string     `json:"name"`                       // descriptive key name;
+	Scopes           []string   `json:"scopes"`                     // allowed operations;
+	AllowedAddresses []string   `json:"allowedaddresses,omitempty"` // allowed source addresses;
+	Creation         time.Time  `json:"creation"`                   // creation time;
+	Expiration       *time.Time `json:"expiration,omitempty"`       // expiration time, if any;
+	LastUsed         *time.Time `json:"lastused,omitempty"`         // last usage time, if any.
+}
+
+// ApiKeyResponse returns a newly created API key: it can not be
+// retrieved anymore.
+type ApiKeyResponse struct {
+	Key  string     `json:"key"`  // the API key coded as hexadecimal;
+	Info ApiKeyInfo `json:"info"` // the key properties.
+}
+
+// ApiKeysResponse lists the API keys of a user.
+type ApiKeysResponse struct {
+	Keys []ApiKeyInfo `json:"keys"` // the keys properties.
+}
+
+// PasswordChangeRequest is used to change the password of the
+// session user.
+type PasswordChangeRequest struct {
+	Password    string `json:"password"`    // the current password;
+	NewPassword string `json:"newpassword"` // the new password.
+}
+
+// PasswordResetRequest is used by super-admins to issue a password
+// reset token, delivered by email, to a user.
+type PasswordResetRequest struct {
+	Username string `json:"username"` // the user whose password should be reset.
+}
+
+// PasswordResetCompleteRequest is used to set a new password with
+// a reset token.
+type PasswordResetCompleteRequest struct {
+	Username    string `json:"username"`    // the user name;
+	ResetToken  string `json:"resettoken"`  // the reset token received by email;
+	NewPassword string `json:"newpassword"` // the new password.
+}
+
+// AdminUser describes a registered user to super-admins, the
+// password is only used to add users or replace their password and
+// is never returned.
+type AdminUser struct {
+	Username    string          `json:"username"`              // the user name;
+	FullName    string          `json:"fullname,omitempty"`    // complete full name;
+	Email       string          `json:"email,omitempty"`       // user's email address;
+	Password    string          `json:"password,omitempty"`    // the new password, never returned;
+	SuperAdmin  bool            `json:"superadmin,omitempty"`  // user having all permissions on all services;
+	Services    map[string]uint `json:"services,omitempty"`    // permission levels per service (0 user, 1 admin);
+	Disabled    bool            `json:"disabled,omitempty"`    // disabled users can not login;
+	TotpEnabled bool            `json:"totpenabled,omitempty"` // two factor authentication enabled, read only.
+}
+
+// AdminUsersResponse lists registered users.
+type AdminUsersResponse struct {
+	Users []AdminUser `json:"users"` // the users.
+}
+
+// SessionInfo describes an active session of the user, the
+// session token is never returned.
+type SessionInfo struct {
+	ID         string    `json:"id"`                  // session identifier;
+	Address    string    `json:"address,omitempty"`   // address of the client opening the session;
+	UserAgent  string    `json:"useragent,omitempty"` // user agent of the client opening the session;
+	Login      time.Time `json:"login"`               // login time;
+	LastSeen   time.Time `json:"lastseen"`            // last usage time;
+	Expiration time.Time `json:"expiration"`          // expiration time if not used anymore;
+	Current    bool      `json:"current,omitempty"`   // true for the session used by the request.
+}
+
+// SessionsResponse lists the active sessions of the user.
+type SessionsResponse struct {
+	Sessions []SessionInfo `json:"sessions"` // the sessions.
+}
+
+// SessionsRevokeResponse returns the number of revoked sessions.
+type SessionsRevokeResponse struct {
+	Revoked int `json:"revoked"` // number of revoked sessions.
+}
+
 // LogoutResponse returns the invalidated session token
 // to REST API calls.
 type LogoutResponse struct {
@@ -71,7 +203,7 @@ type LogoutResponse struct {
 // JobPostRequest body for the POST job API that creates a new
 // async job evaluating the value of the "Command" property.
 type JobPostRequest struct {
-	Command   string            `json:"command"`   // the command type, available commands are: "UPLOAD", "DOWNLOAD", "DELETE";
+	Command   string            `json:"command"`   // the command type, available commands are: "UPLOAD", "DOWNLOAD", "DELETE", "STAT";
 	Arguments *CommandArguments `json:"arguments"` // command arguments.
 }
 
@@ -83,6 +215,7 @@ type CommandArguments struct {
 	ResourceID string `json:"resourceid"` // id assigned to the chunk (required for all commands);
 	// upload specifics
 	Data         []byte        `json:"data,omitempty"`       // the data blob to be uploaded (required for upload);
+	CheckSum     []byte        `json:"checksum,omitempty"`   // SHA256 digest of the data blob, if present verified before storing it (upload only);
 	TimeToLive   time.Duration `json:"ttl,omitempty"`        // required time to live for the data chunk;
 	Permission   Permission    `json:"permission,omitempty"` // the type of enforced permission (required for upload);
 	SharingUsers []string      `json:"sharing,omitempty"`    // usernames of users enabled to access the file (only in case of Shared permission type).
@@ -102,7 +235,175 @@ type JobGetRequest struct {
 	Complete bool     `json:"complete"`           // the upload/download/delete tx has been completed;
 	Error    string   `json:"error,omitempty"`    // error description, if any;
 	Data     []byte   `json:"data,omitempty"`     // returned requested bytes;
-	CheckSum CheckSum `json:"checksum,omitempty"` // data related checksum if any.
+	CheckSum CheckSum `json:"checksum,omitempty"` // data related checksum if any;
+	Size     int      `json:"size,omitempty"`     // size of the resource (stat commands).
+}
+
+// MaxBatchJobs is the maximum number of jobs that can be created,
+// or verified, with a single batch request.
+const MaxBatchJobs = 256
+
+// BatchJobRequest body for the POST batch API that creates several
+// async jobs at once, authorising the user only once. Uploads and
+// downloads are not executed as jobs: a transfer token is returned
+// to stream the chunk data on the chunk routes.
+type BatchJobRequest struct {
+	Jobs []JobPostRequest `json:"jobs"` // jobs to be created (at most MaxBatchJobs).
+}
+
+// BatchJobResult reports the outcome of the creation of a single
+// job of a batch.
+type BatchJobResult struct {
+	ResourceID    string `json:"resourceid"`              // id of the resource the job refers to;
+	JobID         string `json:"jobid,omitempty"`         // id of the created job, if any;
+	TransferToken string `json:"transfertoken,omitempty"` // token authorising the streamed transfer of the chunk, if any;
+	Status        int    `json:"status"`                  // http status code of the job creation (202 if created, 200 if the transfer is authorised);
+	Error         string `json:"error,omitempty"`         // error description, if any.
+}
+
+// BatchJobResponse the returned message from the POST batch API,
+// results are in the same order of the requested jobs.
+type BatchJobResponse struct {
+	Jobs []BatchJobResult `json:"jobs"` // per job results.
+}
+
+// BatchStatusRequest body for the batch status API used to verify
+// several jobs at once.
+type BatchStatusRequest struct {
+	JobIDs []string `json:"jobids"` // ids of the jobs to be verified (at most MaxBatchJobs).
+}
+
+// BatchJobStatus reports the status of a single job of a batch, the
+// job is completed when the status is 200 (202 if still processing).
+type BatchJobStatus struct {
+	JobID  string         `json:"jobid"`            // the verified job id;
+	Status int            `json:"status"`           // http status code of the verification;
+	Error  string         `json:"error,omitempty"`  // verification error description, if any;
+	Result *JobGetRequest `json:"result,omitempty"` // job result, if completed.
+}
+
+// BatchStatusResponse the returned message from the batch status
+// API, statuses are in the same order of the requested jobs.
+type BatchStatusResponse struct {
+	Jobs []BatchJobStatus `json:"jobs"` // per job statuses.
+}
+
+// RenewRequest body for the renew API that extends the time to
+// live of a list of resources owned by the caller.
+type RenewRequest struct {
+	ResourceIDs []string      `json:"resourceids"` // ids of the resources to be renewed;
+	TimeToLive  time.Duration `json:"ttl"`         // new time to live, starting from the renew time.
+}
+
+// RenewResponse returns the outcome of a renew request: renewed
+// resources are listed with the new expiration time while failures
+// are reported per resource.
+type RenewResponse struct {
+	Renewed    []string          `json:"renewed,omitempty"` // ids of correctly renewed resources;
+	Expiration time.Time         `json:"expiration"`        // new expiration time of renewed resources;
+	Failed     map[string]string `json:"failed,omitempty"`  // error descriptions of not renewed resources.
+}
+
+// UsageResponse returns the storage usage of the caller and the
+// enforced quotas (zero quotas stand for no limit).
+type UsageResponse struct {
+	Bytes        int64 `json:"bytes"`        // stored bytes;
+	Objects      int64 `json:"objects"`      // stored objects;
+	QuotaBytes   int64 `json:"quotabytes"`   // maximum storable bytes;
+	QuotaObjects int64 `json:"quotaobjects"` // maximum storable objects.
+}
+
+// AclRequest body for the acl API that updates the access
+// permissions of a list of resources owned by the caller. Only
+// the not nil properties are applied.
+type AclRequest struct {
+	ResourceIDs []string    `json:"resourceids"`          // ids of the resources to be updated;
+	Permission  *Permission `json:"permission,omitempty"` // the new permission type, if any;
+	AddUsers    []string    `json:"add,omitempty"`        // usernames to be added to the sharing users;
+	RemoveUsers []string    `json:"remove,omitempty"`     // usernames to be removed from the sharing users.
+}
+
+// AclResponse returns the outcome of an acl update request:
+// failures are reported per resource.
+type AclResponse struct {
+	Updated []string          `json:"updated,omitempty"` // ids of correctly updated resources;
+	Failed  map[string]string `json:"failed,omitempty"`  // error descriptions of not updated resources.
+}
+
+// ResourceInfo describes a stored resource without exposing
+// its content.
+type ResourceInfo struct {
+	ID           string        `json:"id"`                   // the resource id;
+	Owner        string        `json:"owner"`                // the user who uploaded the resource;
+	Size         int64         `json:"size"`                 // the size of the stored data;
+	Permission   Permission    `json:"permission"`           // the enforced permission type;
+	SharingUsers []string      `json:"sharing,omitempty"`    // users enabled to access the resource;
+	Creation     time.Time     `json:"creation"`             // time of the upload;
+	TimeToLive   time.Duration `json:"ttl,omitempty"`        // time to live of the resource, if any;
+	Expiration   *time.Time    `json:"expiration,omitempty"` // expiration time, if any;
+	Complete     bool          `json:"complete"`             // the upload has been completed.
+}
+
+// SharedResponse lists the resources shared with the caller
+// by other users.
+type SharedResponse struct {
+	Resources []ResourceInfo `json:"resources"` // shared resources.
+}
+
+// ResourcesResponse returns a page of the resources owned by the
+// caller: the Next property, if not empty, should be passed to
+// retrieve the following page.
+type ResourcesResponse struct {
+	Resources []ResourceInfo `json:"resources"`      // owned resources;
+	Next      string         `json:"next,omitempty"` // cursor of the following page, if any.
+}
+
+// LinkRequest body for the link API that creates a signed and
+// expiring token usable to anonymously download a list of Public
+// resources owned by the caller.
+type LinkRequest struct {
+	ResourceIDs []string      `json:"resourceids"`         // ids of the resources reachable with the link;
+	TimeToLive  time.Duration `json:"ttl"`                 // validity of the link;
+	SingleUse   bool          `json:"singleuse,omitempty"` // each resource can be downloaded only once.
+}
+
+// LinkResponse returns the created download token.
+type LinkResponse struct {
+	Token      string    `json:"token"`      // the signed download token;
+	Expiration time.Time `json:"expiration"` // the token expiration time.
+}
+
+// Audited operations on stored resources.
+const (
+	AuditUpload   = "upload"   // resource upload;
+	AuditDownload = "download" // resource download (also via links);
+	AuditDelete   = "delete"   // resource removal;
+	AuditAcl      = "acl"      // access permissions update.
+)
+
+// Outcomes of audited operations.
+const (
+	AuditSuccess = "success" // the operation has been performed;
+	AuditDenied  = "denied"  // the caller is not authorised to access the resource;
+	AuditFailure = "failure" // the operation failed for other reasons.
+)
+
+// AuditEvent records an operation performed on a stored resource,
+// it's returned to the resource owner by the audit API.
+type AuditEvent struct {
+	Resource string    `json:"resource"`         // the target resource id;
+	Owner    string    `json:"owner"`            // the resource owner;
+	User     string    `json:"user,omitempty"`   // the caller, empty for anonymous link downloads;
+	Action   string    `json:"action"`           // the performed operation;
+	Outcome  string    `json:"outcome"`          // the operation outcome;
+	OriginIp string    `json:"ipaddr,omitempty"` // the caller address;
+	Time     time.Time `json:"time"`             // time of the event.
+}
+
+// AuditResponse lists audit events about resources owned by the
+// caller, most recent first.
+type AuditResponse struct {
+	Events []AuditEvent `json:"events"` // audit events.
 }
 
 // OpResult this struct represent the status of an async
@@ -118,6 +419,7 @@ type OpResult struct {
 	ID        string // file id string;
 	RequestID string // request (tx) id string (not file id);
 	Data      []byte // downloaded data, if any;
+	Size      int64  // size of the stored object (stat operations);
 	Error     error  // setted if an error was produced fro the upload instruction.
 }
 
@@ -25,6 +25,7 @@ type Database interface {
 	// db client related functions
 	Copy() Database // retain the db client in a multi-coroutine environment;
 	Close()         // release the client;
+	Ping() error    // verify the db is reachable;
 	// job behaviour
 	GetWills(string) ([]w.Will, error) // list wills for owner's username.
 	GetWill(string) (*w.Will, error)   // gets a will struct from an argument jobID;
@@ -97,6 +97,11 @@ func (d *Mongodb) Close() {
 	d.session.Close()
 }
 
+// Ping verifies that the db is reachable.
+func (d *Mongodb) Ping() error {
+	return d.session.Ping()
+}
+
 // GetWills retrieve all wills related to a specified user.
 func (d *Mongodb) GetWills(owner string) ([]will.Will, error) {
 	// build query
@@ -59,6 +59,10 @@ func (d *Mockdb) Copy() ct.Database {
 func (d *Mockdb) Close() {
 }
 
+func (d *Mockdb) Ping() error {
+	return nil
+}
+
 func (d *Mockdb) GetWills(owner string) ([]will.Will, error) {
 	result := make([]will.Will, 0)
 	for _, value := range d.willsStorage {
@@ -0,0 +1,133 @@
+//
+// 3nigm4 itm package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package itm
+
+// Go default packages
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// TLSFiles are the PEM files, generated by GenerateTLSFiles, used
+// to test mutually authenticated TLS connections.
+type TLSFiles struct {
+	CA                string // the CA certificate;
+	ServerCertificate string // certificate valid for localhost and 127.0.0.1;
+	ServerPrivateKey  string // server certificate private key;
+	ClientCertificate string // client certificate;
+	ClientPrivateKey  string // client certificate private key.
+}
+
+// writePem writes a single PEM block to a file.
+func writePem(path, blockType string, der []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return pem.Encode(file, &pem.Block{
+		Type:  blockType,
+		Bytes: der,
+	})
+}
+
+// issueCertificate creates a new key pair and a certificate signed
+// by the parent (self signed if parent is nil) saving them in the
+// argument files.
+func issueCertificate(template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, certPath, keyPath string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = writePem(certPath, "CERTIFICATE", der)
+	if err != nil {
+		return nil, nil, err
+	}
+	if keyPath != "" {
+		keyDer, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = writePem(keyPath, "EC PRIVATE KEY", keyDer)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return certificate, key, nil
+}
+
+// GenerateTLSFiles creates, in the argument directory, a test CA
+// and the server and client certificates it signs.
+func GenerateTLSFiles(dir string) (*TLSFiles, error) {
+	files := &TLSFiles{
+		CA:                filepath.Join(dir, "ca.pem"),
+		ServerCertificate: filepath.Join(dir, "server.pem"),
+		ServerPrivateKey:  filepath.Join(dir, "server.key"),
+		ClientCertificate: filepath.Join(dir, "client.pem"),
+		ClientPrivateKey:  filepath.Join(dir, "client.key"),
+	}
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := notBefore.Add(24 * time.Hour)
+
+	ca, caKey, err := issueCertificate(&x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "3nigm4 test CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil, files.CA, "")
+	if err != nil {
+		return nil, err
+	}
+	_, _, err = issueCertificate(&x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey, files.ServerCertificate, files.ServerPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	_, _, err = issueCertificate(&x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "3nigm4 test client"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey, files.ClientCertificate, files.ClientPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
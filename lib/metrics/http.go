@@ -0,0 +1,145 @@
+//
+// 3nigm4 metrics package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package metrics
+
+// Golang std libs
+import (
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// Internal dependencies
+import (
+	wq "github.com/nexocrew/3nigm4/lib/workingqueue"
+)
+
+// Third party libs
+import (
+	"github.com/gorilla/mux"
+)
+
+// ContentType is the content type of the exposed metrics.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// unmatchedRoute is the route label used for requests not matching
+// any registered route.
+const unmatchedRoute = "unmatched"
+
+// Metrics shared by all HTTP services.
+var (
+	httpRequests = NewCounter(
+		"http_requests_total",
+		"Number of served HTTP requests by route, method and status code.",
+		"route", "method", "code")
+	httpDuration = NewHistogram(
+		"http_request_duration_seconds",
+		"Latency of served HTTP requests by route and method.",
+		nil,
+		"route", "method")
+)
+
+var startTime = time.Now()
+
+func init() {
+	// runtime metrics
+	NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", nil, func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	NewGaugeFunc("go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", nil, func() float64 {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		return float64(stats.Alloc)
+	})
+	NewGaugeFunc("go_memstats_sys_bytes", "Number of bytes obtained from the system.", nil, func() float64 {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		return float64(stats.Sys)
+	})
+	NewGaugeFunc("process_start_time_seconds", "Start time of the process since unix epoch in seconds.", nil, func() float64 {
+		return float64(startTime.Unix())
+	})
+}
+
+// Handler returns the HTTP handler exposing the metrics of the
+// default registry.
+func Handler() http.Handler {
+	return HandlerFor(DefaultRegistry)
+}
+
+// HandlerFor returns the HTTP handler exposing the metrics of the
+// argument registry.
+func HandlerFor(r *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		w.WriteHeader(http.StatusOK)
+		r.WriteTo(w)
+	})
+}
+
+// statusRecorder records the status code written by handlers.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// InstrumentRouter wraps a router counting requests and measuring
+// their latency. Requests are labelled with the matched route path
+// template to avoid exposing resources ids.
+func InstrumentRouter(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		route := unmatchedRoute
+		var match mux.RouteMatch
+		if router.Match(r, &match) &&
+			match.Route != nil {
+			if template, err := match.Route.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		recorder := &statusRecorder{
+			ResponseWriter: w,
+			status:         http.StatusOK,
+		}
+		router.ServeHTTP(recorder, r)
+		httpDuration.ObserveSince(start, route, r.Method)
+		httpRequests.Inc(route, r.Method, strconv.Itoa(recorder.status))
+	})
+}
+
+// Queue is implemented by components exposing working queue
+// activity metrics.
+type Queue interface {
+	Stats() wq.Stats
+}
+
+// RegisterQueue registers, in the default registry, the activity
+// metrics of a working queue labelled with the argument name.
+func RegisterQueue(name string, q Queue) {
+	labels := Labels{"queue": name}
+	NewGaugeFunc("workingqueue_workers", "Number of working queue workers.", labels, func() float64 {
+		return float64(q.Stats().Workers)
+	})
+	NewGaugeFunc("workingqueue_queued_jobs", "Number of jobs waiting for an available worker.", labels, func() float64 {
+		return float64(q.Stats().Queued)
+	})
+	NewGaugeFunc("workingqueue_busy_workers", "Number of workers processing a job.", labels, func() float64 {
+		return float64(q.Stats().Busy)
+	})
+	NewCounterFunc("workingqueue_processed_jobs_total", "Number of successfully processed jobs.", labels, func() float64 {
+		return float64(q.Stats().Processed)
+	})
+	NewCounterFunc("workingqueue_failed_jobs_total", "Number of jobs that returned an error.", labels, func() float64 {
+		return float64(q.Stats().Failed)
+	})
+}
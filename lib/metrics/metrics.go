@@ -0,0 +1,496 @@
+//
+// 3nigm4 metrics package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+// Package metrics implements a minimal, concurrent safe, metrics
+// registry exposed using the Prometheus text format. Counters,
+// gauges and histograms, optionally partitioned by labels, are
+// supported together with function based metrics used to sample
+// values owned by other components (like working queues).
+package metrics
+
+// Golang std libs
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metric types as defined by the Prometheus text format.
+const (
+	counterType   = "counter"
+	gaugeType     = "gauge"
+	histogramType = "histogram"
+)
+
+// DefBuckets are the default histogram buckets, in seconds, tailored
+// to measure network services latencies.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// sample is a single exposed value.
+type sample struct {
+	suffix string  // suffix added to the family name (histograms);
+	labels string  // formatted labels;
+	value  float64 // sampled value.
+}
+
+// family is implemented by all metrics managed by the registry.
+type family interface {
+	name() string      // the metric name;
+	help() string      // the metric description;
+	kind() string      // the metric type;
+	samples() []sample // actual values.
+}
+
+// Registry groups metrics to be exposed together.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string][]family
+	names    []string
+}
+
+// NewRegistry creates a new empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make(map[string][]family),
+	}
+}
+
+// DefaultRegistry is the registry used by package level constructors
+// and exposed by Handler.
+var DefaultRegistry = NewRegistry()
+
+// register adds a metric to the registry: metrics sharing the same
+// name are exposed as a single family and must have the same type.
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	registered, ok := r.families[f.name()]
+	if ok && registered[0].kind() != f.kind() {
+		panic(fmt.Sprintf("metric %s already registered as %s", f.name(), registered[0].kind()))
+	}
+	if !ok {
+		r.names = append(r.names, f.name())
+		sort.Strings(r.names)
+	}
+	r.families[f.name()] = append(registered, f)
+}
+
+// WriteTo writes all registered metrics, using the Prometheus text
+// format, to the argument writer.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	names := make([]string, len(r.names))
+	copy(names, r.names)
+	families := make(map[string][]family, len(r.families))
+	for name, registered := range r.families {
+		families[name] = registered
+	}
+	r.mu.Unlock()
+
+	buf := new(bytes.Buffer)
+	for _, name := range names {
+		registered := families[name]
+		fmt.Fprintf(buf, "# HELP %s %s\n", name, escapeHelp(registered[0].help()))
+		fmt.Fprintf(buf, "# TYPE %s %s\n", name, registered[0].kind())
+		for _, f := range registered {
+			for _, s := range f.samples() {
+				fmt.Fprintf(buf, "%s%s%s %s\n", name, s.suffix, s.labels, formatValue(s.value))
+			}
+		}
+	}
+	return buf.WriteTo(w)
+}
+
+// escapeHelp escapes help strings as required by the text format.
+func escapeHelp(help string) string {
+	help = strings.Replace(help, "\\", "\\\\", -1)
+	return strings.Replace(help, "\n", "\\n", -1)
+}
+
+// escapeLabel escapes label values as required by the text format.
+func escapeLabel(value string) string {
+	value = strings.Replace(value, "\\", "\\\\", -1)
+	value = strings.Replace(value, "\n", "\\n", -1)
+	return strings.Replace(value, "\"", "\\\"", -1)
+}
+
+// formatValue formats a sample value.
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// formatLabels composes the labels of a sample, extra is appended
+// as is (used for histograms buckets).
+func formatLabels(names, values []string, extra string) string {
+	var pairs []string
+	for idx, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", name, escapeLabel(values[idx])))
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// desc contains the common properties of all metrics.
+type desc struct {
+	metricName string
+	metricHelp string
+	labelNames []string
+}
+
+func (d *desc) name() string {
+	return d.metricName
+}
+
+func (d *desc) help() string {
+	return d.metricHelp
+}
+
+// key returns the key used to store a labelled series verifying
+// the number of passed label values.
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labelNames) {
+		panic(fmt.Sprintf("metric %s requires %d label values, having %d", d.metricName, len(d.labelNames), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// values is a set of labelled values used by counters and gauges.
+type values struct {
+	desc
+	mu     sync.Mutex
+	series map[string]float64
+	labels map[string][]string
+}
+
+func newValues(name, help string, labelNames []string) values {
+	return values{
+		desc: desc{
+			metricName: name,
+			metricHelp: help,
+			labelNames: labelNames,
+		},
+		series: make(map[string]float64),
+		labels: make(map[string][]string),
+	}
+}
+
+func (v *values) add(delta float64, set bool, labelValues []string) {
+	key := v.key(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.labels[key]; !ok {
+		v.labels[key] = append([]string(nil), labelValues...)
+	}
+	if set {
+		v.series[key] = delta
+		return
+	}
+	v.series[key] += delta
+}
+
+func (v *values) samples() []sample {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	samples := make([]sample, 0, len(keys))
+	for _, key := range keys {
+		samples = append(samples, sample{
+			labels: formatLabels(v.labelNames, v.labels[key], ""),
+			value:  v.series[key],
+		})
+	}
+	return samples
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	values
+}
+
+// NewCounter creates a counter, partitioned by the argument label
+// names, registered in the default registry.
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	return DefaultRegistry.NewCounter(name, help, labelNames...)
+}
+
+// NewCounter creates a counter registered in the registry.
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+	c := &Counter{
+		values: newValues(name, help, labelNames),
+	}
+	r.register(c)
+	return c
+}
+
+func (c *Counter) kind() string {
+	return counterType
+}
+
+// Inc increments by one the counter identified by label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.add(1, false, labelValues)
+}
+
+// Add adds a non negative value to the counter identified by label
+// values.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("counter %s can not decrease", c.metricName))
+	}
+	c.add(delta, false, labelValues)
+}
+
+// Gauge is a value that can arbitrarily go up and down.
+type Gauge struct {
+	values
+}
+
+// NewGauge creates a gauge, partitioned by the argument label names,
+// registered in the default registry.
+func NewGauge(name, help string, labelNames ...string) *Gauge {
+	return DefaultRegistry.NewGauge(name, help, labelNames...)
+}
+
+// NewGauge creates a gauge registered in the registry.
+func (r *Registry) NewGauge(name, help string, labelNames ...string) *Gauge {
+	g := &Gauge{
+		values: newValues(name, help, labelNames),
+	}
+	r.register(g)
+	return g
+}
+
+func (g *Gauge) kind() string {
+	return gaugeType
+}
+
+// Set sets the value of the gauge identified by label values.
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.add(value, true, labelValues)
+}
+
+// Add adds a, possibly negative, value to the gauge identified by
+// label values.
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.add(delta, false, labelValues)
+}
+
+// histogramSeries is a single labelled histogram.
+type histogramSeries struct {
+	labels  []string
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// Histogram samples observations counting them in buckets.
+type Histogram struct {
+	desc
+	bounds []float64
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+// NewHistogram creates an histogram, with the argument buckets upper
+// bounds (DefBuckets if nil), registered in the default registry.
+func NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	return DefaultRegistry.NewHistogram(name, help, buckets, labelNames...)
+}
+
+// NewHistogram creates an histogram registered in the registry.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+	h := &Histogram{
+		desc: desc{
+			metricName: name,
+			metricHelp: help,
+			labelNames: labelNames,
+		},
+		bounds: bounds,
+		series: make(map[string]*histogramSeries),
+	}
+	r.register(h)
+	return h
+}
+
+func (h *Histogram) kind() string {
+	return histogramType
+}
+
+// Observe adds an observation to the histogram identified by label
+// values.
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{
+			labels:  append([]string(nil), labelValues...),
+			buckets: make([]uint64, len(h.bounds)),
+		}
+		h.series[key] = s
+	}
+	for idx, bound := range h.bounds {
+		if value <= bound {
+			s.buckets[idx]++
+			break
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+// ObserveSince adds, as observation, the seconds elapsed since the
+// argument time.
+func (h *Histogram) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *Histogram) samples() []sample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var samples []sample
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for idx, bound := range h.bounds {
+			cumulative += s.buckets[idx]
+			samples = append(samples, sample{
+				suffix: "_bucket",
+				labels: formatLabels(h.labelNames, s.labels, fmt.Sprintf("le=\"%s\"", formatValue(bound))),
+				value:  float64(cumulative),
+			})
+		}
+		labels := formatLabels(h.labelNames, s.labels, "")
+		samples = append(samples,
+			sample{
+				suffix: "_bucket",
+				labels: formatLabels(h.labelNames, s.labels, "le=\"+Inf\""),
+				value:  float64(s.count),
+			},
+			sample{
+				suffix: "_sum",
+				labels: labels,
+				value:  s.sum,
+			},
+			sample{
+				suffix: "_count",
+				labels: labels,
+				value:  float64(s.count),
+			})
+	}
+	return samples
+}
+
+// funcMetric samples its value invoking a function when exposed.
+type funcMetric struct {
+	desc
+	metricKind  string
+	labelValues []string
+	function    func() float64
+}
+
+func (f *funcMetric) kind() string {
+	return f.metricKind
+}
+
+func (f *funcMetric) samples() []sample {
+	return []sample{
+		sample{
+			labels: formatLabels(f.labelNames, f.labelValues, ""),
+			value:  f.function(),
+		},
+	}
+}
+
+// Labels are constant labels associated to function based metrics,
+// several function metrics can share the same name if they have
+// different labels.
+type Labels map[string]string
+
+// split returns sorted label names and values.
+func (l Labels) split() ([]string, []string) {
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	values := make([]string, 0, len(l))
+	for _, name := range names {
+		values = append(values, l[name])
+	}
+	return names, values
+}
+
+// NewGaugeFunc registers, in the default registry, a gauge whose
+// value is returned by the argument function.
+func NewGaugeFunc(name, help string, labels Labels, function func() float64) {
+	DefaultRegistry.NewGaugeFunc(name, help, labels, function)
+}
+
+// NewGaugeFunc registers a function based gauge in the registry.
+func (r *Registry) NewGaugeFunc(name, help string, labels Labels, function func() float64) {
+	r.registerFunc(name, help, gaugeType, labels, function)
+}
+
+// NewCounterFunc registers, in the default registry, a counter whose
+// value is returned by the argument function (that must return
+// monotonically increasing values).
+func NewCounterFunc(name, help string, labels Labels, function func() float64) {
+	DefaultRegistry.NewCounterFunc(name, help, labels, function)
+}
+
+// NewCounterFunc registers a function based counter in the registry.
+func (r *Registry) NewCounterFunc(name, help string, labels Labels, function func() float64) {
+	r.registerFunc(name, help, counterType, labels, function)
+}
+
+func (r *Registry) registerFunc(name, help, kind string, labels Labels, function func() float64) {
+	names, values := labels.split()
+	r.register(&funcMetric{
+		desc: desc{
+			metricName: name,
+			metricHelp: help,
+			labelNames: names,
+		},
+		metricKind:  kind,
+		labelValues: values,
+		function:    function,
+	})
+}
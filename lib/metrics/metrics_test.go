@@ -0,0 +1,159 @@
+//
+// 3nigm4 metrics package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+package metrics
+
+// Golang stdlib
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Internal dependencies
+import (
+	wq "github.com/nexocrew/3nigm4/lib/workingqueue"
+)
+
+// Third party libs
+import (
+	"github.com/gorilla/mux"
+)
+
+func exposed(r *Registry, t *testing.T) string {
+	buf := new(bytes.Buffer)
+	_, err := r.WriteTo(buf)
+	if err != nil {
+		t.Fatalf("Unable to write metrics: %s.\n", err.Error())
+	}
+	return buf.String()
+}
+
+func checkLines(output string, lines []string, t *testing.T) {
+	for _, line := range lines {
+		if !strings.Contains(output, line+"\n") {
+			t.Fatalf("Missing line %q in:\n%s", line, output)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	counter := r.NewCounter("test_requests_total", "Test requests.", "route")
+	gauge := r.NewGauge("test_temperature", "Test \"temperature\".")
+	histogram := r.NewHistogram("test_duration_seconds", "Test durations.", []float64{1, 0.1}, "op")
+	r.NewGaugeFunc("test_queue", "Test queue.", Labels{"queue": "a"}, func() float64 { return 3 })
+	r.NewGaugeFunc("test_queue", "Test queue.", Labels{"queue": "b"}, func() float64 { return 4 })
+
+	counter.Inc("/v1/a")
+	counter.Add(2, "/v1/a")
+	counter.Inc("/v1/\"b\"")
+	gauge.Set(10)
+	gauge.Add(-2.5)
+	histogram.Observe(0.05, "get")
+	histogram.Observe(0.5, "get")
+	histogram.Observe(5, "get")
+
+	checkLines(exposed(r, t), []string{
+		"# HELP test_requests_total Test requests.",
+		"# TYPE test_requests_total counter",
+		"test_requests_total{route=\"/v1/a\"} 3",
+		"test_requests_total{route=\"/v1/\\\"b\\\"\"} 1",
+		"# TYPE test_temperature gauge",
+		"test_temperature 7.5",
+		"# TYPE test_duration_seconds histogram",
+		"test_duration_seconds_bucket{op=\"get\",le=\"0.1\"} 1",
+		"test_duration_seconds_bucket{op=\"get\",le=\"1\"} 2",
+		"test_duration_seconds_bucket{op=\"get\",le=\"+Inf\"} 3",
+		"test_duration_seconds_sum{op=\"get\"} 5.55",
+		"test_duration_seconds_count{op=\"get\"} 3",
+		"test_queue{queue=\"a\"} 3",
+		"test_queue{queue=\"b\"} 4",
+	}, t)
+}
+
+func TestRegistryMisuse(t *testing.T) {
+	r := NewRegistry()
+	counter := r.NewCounter("test_total", "Test.", "label")
+	var testCases = []func(){
+		func() { counter.Inc() },
+		func() { counter.Add(-1, "value") },
+		func() { r.NewGauge("test_total", "Test.") },
+	}
+	for idx, tc := range testCases {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("Test case %d: expecting panic.\n", idx)
+				}
+			}()
+			tc()
+		}()
+	}
+}
+
+type mockQueue struct {
+	stats wq.Stats
+}
+
+func (m *mockQueue) Stats() wq.Stats {
+	return m.stats
+}
+
+func TestInstrumentRouter(t *testing.T) {
+	RegisterQueue("testqueue", &mockQueue{
+		stats: wq.Stats{Workers: 4, Queued: 2, Busy: 1, Processed: 10, Failed: 3},
+	})
+
+	route := mux.NewRouter()
+	route.HandleFunc("/v1/item/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}).Methods("PUT")
+	route.Handle("/metrics", Handler()).Methods("GET")
+	server := httptest.NewServer(InstrumentRouter(route))
+	defer server.Close()
+
+	client := &http.Client{}
+	for _, id := range []string{"first", "second"} {
+		req, err := http.NewRequest("PUT", server.URL+"/v1/item/"+id, nil)
+		if err != nil {
+			t.Fatalf("Unable to prepare request: %s.\n", err.Error())
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Unable to perform request: %s.\n", err.Error())
+		}
+		resp.Body.Close()
+	}
+	resp, err := http.Get(server.URL + "/missing")
+	if err != nil {
+		t.Fatalf("Unable to perform request: %s.\n", err.Error())
+	}
+	resp.Body.Close()
+
+	resp, err = http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("Unable to retrieve metrics: %s.\n", err.Error())
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("Content-Type") != ContentType {
+		t.Fatalf("Unexpected content type %s.\n", resp.Header.Get("Content-Type"))
+	}
+	checkLines(string(body), []string{
+		"http_requests_total{route=\"/v1/item/{id}\",method=\"PUT\",code=\"201\"} 2",
+		"http_requests_total{route=\"unmatched\",method=\"GET\",code=\"404\"} 1",
+		"http_request_duration_seconds_count{route=\"/v1/item/{id}\",method=\"PUT\"} 2",
+		"workingqueue_workers{queue=\"testqueue\"} 4",
+		"workingqueue_queued_jobs{queue=\"testqueue\"} 2",
+		"workingqueue_busy_workers{queue=\"testqueue\"} 1",
+		"workingqueue_processed_jobs_total{queue=\"testqueue\"} 10",
+		"workingqueue_failed_jobs_total{queue=\"testqueue\"} 3",
+		"# TYPE go_goroutines gauge",
+	}, t)
+}
@@ -0,0 +1,62 @@
+//
+// 3nigm4 ratelimit package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package ratelimit
+
+// Golang std libs
+import (
+	"time"
+)
+
+// Third party libs
+import (
+	"github.com/spf13/pflag"
+)
+
+// Flags holds the limits configurable, by command line flags, by
+// the services exposing brute-force protected functions.
+type Flags struct {
+	ThrottleAttempts     int    // failed attempts tolerated before delaying further attempts;
+	ThrottleDelaySeconds uint32 // delay, in seconds, applied to the first exceeding attempt;
+	LockoutAttempts      int    // failed attempts causing a temporary lockout;
+	LockoutMinutes       uint32 // lockout duration and failures inactivity window, in minutes.
+}
+
+// Register adds the limits flags to the argument flag set, attempts
+// describes the protected attempts in the flags usage (for example
+// "login").
+func (f *Flags) Register(flags *pflag.FlagSet, attempts string) {
+	flags.IntVarP(&f.ThrottleAttempts, "throttleattempts", "", 3, attempts+" failed attempts tolerated before delaying further attempts")
+	flags.Uint32VarP(&f.ThrottleDelaySeconds, "throttledelay", "", 1, "delay, in seconds, applied to attempts exceeding the tolerated ones (doubled at each further failure)")
+	flags.IntVarP(&f.LockoutAttempts, "lockoutattempts", "", 10, attempts+" failed attempts causing a temporary lockout (0 disables it)")
+	flags.Uint32VarP(&f.LockoutMinutes, "lockoutminutes", "", 15, "lockout duration in minutes, failures are forgotten after the same inactivity time")
+}
+
+// Config returns the limiter config corresponding to the flags.
+func (f *Flags) Config() Config {
+	lockout := time.Duration(f.LockoutMinutes) * time.Minute
+	return Config{
+		FreeAttempts:    f.ThrottleAttempts,
+		BaseDelay:       time.Duration(f.ThrottleDelaySeconds) * time.Second,
+		MaxDelay:        lockout,
+		LockoutAttempts: f.LockoutAttempts,
+		LockoutDuration: lockout,
+		Window:          lockout,
+	}
+}
+
+// WarningLogger is implemented by the services log facilities.
+type WarningLogger interface {
+	WarningLog(format string, arg ...interface{}) (int, error)
+}
+
+// NewLimiter creates a limiter, enforcing the configured limits,
+// that logs lockout events as warnings.
+func (f *Flags) NewLimiter(log WarningLogger) *Limiter {
+	return NewLimiter(f.Config(), func(key string, failures int, until time.Time) {
+		log.WarningLog("Locked out %s after %d failed attempts until %s.\n", key, failures, until.Format(time.RFC3339))
+	})
+}
@@ -0,0 +1,356 @@
+//
+// 3nigm4 ratelimit package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+// Package ratelimit implements a brute-force protection component
+// shared by services accepting secrets (passwords, delivery keys,
+// OTPs). Failed attempts are counted per key (username, origin ip
+// address, resource id): after a number of tolerated failures any
+// further attempt is delayed with an exponential backoff and, when
+// too many failures are recorded, the key is temporarily locked.
+package ratelimit
+
+// Golang std libs
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Config defines the limits enforced by a Limiter.
+type Config struct {
+	FreeAttempts    int           // failures tolerated before delaying further attempts;
+	BaseDelay       time.Duration // delay after the first exceeding failure, doubled at each further one;
+	MaxDelay        time.Duration // upper bound of the backoff delay (0 means one day);
+	LockoutAttempts int           // failures causing a temporary lockout (0 disables lockouts);
+	LockoutDuration time.Duration // duration of a lockout;
+	Window          time.Duration // inactivity after which failures are forgotten (0 never forgets).
+}
+
+// LockoutHandler is invoked, outside of the limiter lock, every
+// time a key gets locked out.
+type LockoutHandler func(key string, failures int, until time.Time)
+
+// LimitError is returned when an attempt is refused.
+type LimitError struct {
+	Key        string        // the limited key;
+	RetryAfter time.Duration // time to wait before retrying;
+	Locked     bool          // the key is locked out.
+}
+
+// Error returns a description of the error.
+func (e *LimitError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("too many failed attempts, locked out for %s", e.RetryAfter.String())
+	}
+	return fmt.Sprintf("too many failed attempts, retry in %s", e.RetryAfter.String())
+}
+
+// entry tracks the failures of a single key.
+type entry struct {
+	failures    int       // consecutive failures;
+	last        time.Time // time of the last failure;
+	lockedUntil time.Time // end of the lockout, if any.
+}
+
+// Limiter counts failed attempts per key and decides if further
+// attempts should be accepted. A nil Limiter accepts every attempt.
+type Limiter struct {
+	mtx       sync.Mutex
+	config    Config
+	onLockout LockoutHandler
+	entries   map[string]*entry
+	lastPurge time.Time
+	now       func() time.Time
+}
+
+// NewLimiter creates a new limiter enforcing the argument config,
+// onLockout can be nil if lockouts should not be notified.
+func NewLimiter(config Config, onLockout LockoutHandler) *Limiter {
+	return &Limiter{
+		config:    config,
+		onLockout: onLockout,
+		entries:   make(map[string]*entry),
+		now:       time.Now,
+	}
+}
+
+// stale returns true if the entry failures should be forgotten.
+func (l *Limiter) stale(e *entry, now time.Time) bool {
+	if !e.lockedUntil.IsZero() {
+		return !now.Before(e.lockedUntil)
+	}
+	return l.config.Window != 0 &&
+		now.Sub(e.last) > l.config.Window
+}
+
+// defaultMaxDelay is the backoff delay upper bound used if not
+// specified by the config.
+const defaultMaxDelay = 24 * time.Hour
+
+// delay returns the backoff delay, starting from the last failure,
+// to be applied to an entry.
+func (l *Limiter) delay(e *entry) time.Duration {
+	exceeding := e.failures - l.config.FreeAttempts
+	if exceeding <= 0 ||
+		l.config.BaseDelay <= 0 {
+		return 0
+	}
+	maxDelay := l.config.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = defaultMaxDelay
+	}
+	delay := l.config.BaseDelay
+	for idx := 1; idx < exceeding && delay < maxDelay; idx++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
+
+// check verifies that an attempt can be performed for the key, it
+// must be called holding the lock.
+func (l *Limiter) check(key string, now time.Time) error {
+	e, ok := l.entries[key]
+	if !ok ||
+		l.stale(e, now) {
+		return nil
+	}
+	if !e.lockedUntil.IsZero() {
+		return &LimitError{
+			Key:        key,
+			RetryAfter: e.lockedUntil.Sub(now),
+			Locked:     true,
+		}
+	}
+	if next := e.last.Add(l.delay(e)); now.Before(next) {
+		return &LimitError{
+			Key:        key,
+			RetryAfter: next.Sub(now),
+		}
+	}
+	return nil
+}
+
+// Check verifies that an attempt can be performed for all the
+// argument keys, otherwise returns a *LimitError. Notice that
+// concurrent attempts can all pass the check before any failure
+// is recorded, use Reserve to count them.
+func (l *Limiter) Check(keys ...string) error {
+	if l == nil {
+		return nil
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	now := l.now()
+	for _, key := range keys {
+		if err := l.check(key, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockout describes a key locked out by a failure.
+type lockout struct {
+	key      string
+	failures int
+	until    time.Time
+}
+
+// record records a failed attempt for all the argument keys, it
+// must be called holding the lock. Returns the caused lockouts.
+func (l *Limiter) record(keys []string, now time.Time) []lockout {
+	var lockouts []lockout
+	l.purge(now)
+	for _, key := range keys {
+		e, ok := l.entries[key]
+		if !ok ||
+			l.stale(e, now) {
+			e = &entry{}
+			l.entries[key] = e
+		}
+		e.failures++
+		e.last = now
+		if l.config.LockoutAttempts > 0 &&
+			e.failures >= l.config.LockoutAttempts {
+			e.lockedUntil = now.Add(l.config.LockoutDuration)
+			lockouts = append(lockouts, lockout{
+				key:      key,
+				failures: e.failures,
+				until:    e.lockedUntil,
+			})
+		}
+	}
+	return lockouts
+}
+
+// notify invokes the lockout handler, it must be called outside
+// of the lock.
+func (l *Limiter) notify(lockouts []lockout) {
+	if l.onLockout != nil {
+		for _, lo := range lockouts {
+			l.onLockout(lo.key, lo.failures, lo.until)
+		}
+	}
+}
+
+// Failure records a failed attempt for all the argument keys,
+// locking them out if too many failures have been recorded.
+func (l *Limiter) Failure(keys ...string) {
+	if l == nil {
+		return
+	}
+	l.mtx.Lock()
+	lockouts := l.record(keys, l.now())
+	l.mtx.Unlock()
+
+	l.notify(lockouts)
+}
+
+// Reservation is an attempt, reserved by Reserve, that has been
+// already recorded as failed. It should be completed calling
+// Failure or Success, otherwise Cancel restores the recorded
+// failures. A nil Reservation ignores all the calls.
+type Reservation struct {
+	limiter  *Limiter
+	keys     []string
+	lockouts []lockout
+	done     bool
+}
+
+// Reserve atomically verifies that an attempt can be performed for
+// all the argument keys, otherwise returns a *LimitError, and records
+// it as failed: concurrent attempts are limited as if the reserved
+// ones already failed. Lockouts are notified only if the reservation
+// is completed as failed.
+func (l *Limiter) Reserve(keys ...string) (*Reservation, error) {
+	if l == nil {
+		return nil, nil
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	now := l.now()
+	for _, key := range keys {
+		if err := l.check(key, now); err != nil {
+			return nil, err
+		}
+	}
+	return &Reservation{
+		limiter:  l,
+		keys:     keys,
+		lockouts: l.record(keys, now),
+	}, nil
+}
+
+// Failure completes the reservation as failed notifying the caused
+// lockouts, if any.
+func (r *Reservation) Failure() {
+	if r == nil ||
+		r.done {
+		return
+	}
+	r.done = true
+	r.limiter.notify(r.lockouts)
+}
+
+// Success completes the reservation as succeeded: the reserved failure
+// is cancelled and the failures recorded for the argument keys are
+// forgotten.
+func (r *Reservation) Success(forget ...string) {
+	if r == nil ||
+		r.done {
+		return
+	}
+	r.Cancel()
+	r.limiter.Success(forget...)
+}
+
+// Cancel restores the failures recorded before the reservation, if
+// not already completed. It's safe to defer it after Reserve.
+func (r *Reservation) Cancel() {
+	if r == nil ||
+		r.done {
+		return
+	}
+	r.done = true
+	l := r.limiter
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	now := l.now()
+	for _, key := range r.keys {
+		e, ok := l.entries[key]
+		if !ok ||
+			l.stale(e, now) {
+			continue
+		}
+		e.failures--
+		for _, lo := range r.lockouts {
+			if lo.key == key &&
+				e.failures < l.config.LockoutAttempts &&
+				e.lockedUntil.Equal(lo.until) {
+				e.lockedUntil = time.Time{}
+			}
+		}
+		if e.failures <= 0 {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// Success forgets the failures recorded for the argument keys.
+func (l *Limiter) Success(keys ...string) {
+	if l == nil {
+		return
+	}
+	l.mtx.Lock()
+	for _, key := range keys {
+		delete(l.entries, key)
+	}
+	l.mtx.Unlock()
+}
+
+// purgeInterval is the minimum time between stale entries purges.
+const purgeInterval = time.Minute
+
+// purge removes stale entries, it must be called holding the lock.
+func (l *Limiter) purge(now time.Time) {
+	if now.Sub(l.lastPurge) < purgeInterval {
+		return
+	}
+	l.lastPurge = now
+	for key, e := range l.entries {
+		if l.stale(e, now) {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// UserKey returns the key identifying a username.
+func UserKey(username string) string {
+	return Key("user", username)
+}
+
+// AddressKey returns the key identifying the host of an origin
+// address, the port, if present, is ignored.
+func AddressKey(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return Key("ip", host)
+}
+
+// Key returns the key identifying a generic kind of value (for
+// example a resource id).
+func Key(kind, value string) string {
+	return kind + ":" + value
+}
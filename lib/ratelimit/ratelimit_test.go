@@ -0,0 +1,236 @@
+//
+// 3nigm4 ratelimit package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package ratelimit
+
+// Golang std libs
+import (
+	"testing"
+	"time"
+)
+
+// Third party libs
+import (
+	"github.com/spf13/pflag"
+)
+
+// mockClock is a manually advanced clock.
+type mockClock struct {
+	now time.Time
+}
+
+func (c *mockClock) Now() time.Time {
+	return c.now
+}
+
+func newTestLimiter(onLockout LockoutHandler) (*Limiter, *mockClock) {
+	clock := &mockClock{now: time.Date(2016, 6, 16, 12, 0, 0, 0, time.UTC)}
+	limiter := NewLimiter(Config{
+		FreeAttempts:    2,
+		BaseDelay:       time.Second,
+		MaxDelay:        4 * time.Second,
+		LockoutAttempts: 6,
+		LockoutDuration: time.Minute,
+		Window:          time.Hour,
+	}, onLockout)
+	limiter.now = clock.Now
+	return limiter, clock
+}
+
+func checkLimit(l *Limiter, key string, retryAfter time.Duration, locked bool, t *testing.T) {
+	err := l.Check(key)
+	if retryAfter == 0 {
+		if err != nil {
+			t.Fatalf("Unexpected error: %s.\n", err.Error())
+		}
+		return
+	}
+	limitErr, ok := err.(*LimitError)
+	if !ok {
+		t.Fatalf("Expecting limit error but having %v.\n", err)
+	}
+	if limitErr.RetryAfter != retryAfter ||
+		limitErr.Locked != locked ||
+		limitErr.Key != key {
+		t.Fatalf("Unexpected limit error %+v, expecting retry after %s (locked %v).\n", limitErr, retryAfter, locked)
+	}
+}
+
+func TestBackoffAndLockout(t *testing.T) {
+	var lockedKey string
+	var lockedFailures int
+	limiter, clock := newTestLimiter(func(key string, failures int, until time.Time) {
+		lockedKey = key
+		lockedFailures = failures
+	})
+	key := UserKey("userA")
+
+	// tolerated failures
+	limiter.Failure(key)
+	checkLimit(limiter, key, 0, false, t)
+	limiter.Failure(key)
+	checkLimit(limiter, key, 0, false, t)
+	// exponential backoff
+	var testCases = []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+	}
+	for _, delay := range testCases {
+		limiter.Failure(key)
+		checkLimit(limiter, key, delay, false, t)
+		clock.now = clock.now.Add(delay)
+		checkLimit(limiter, key, 0, false, t)
+	}
+	// lockout
+	limiter.Failure(key)
+	if lockedKey != key ||
+		lockedFailures != 6 {
+		t.Fatalf("Unexpected lockout notification for %s after %d failures.\n", lockedKey, lockedFailures)
+	}
+	checkLimit(limiter, key, time.Minute, true, t)
+	// other keys are not affected
+	checkLimit(limiter, UserKey("userB"), 0, false, t)
+	// lockout expiration resets failures
+	clock.now = clock.now.Add(time.Minute)
+	checkLimit(limiter, key, 0, false, t)
+	limiter.Failure(key)
+	checkLimit(limiter, key, 0, false, t)
+}
+
+func TestMultipleKeysAndSuccess(t *testing.T) {
+	limiter, clock := newTestLimiter(nil)
+	user := UserKey("userA")
+	address := AddressKey("10.0.0.1:5678")
+	if address != AddressKey("10.0.0.1:1234") {
+		t.Fatalf("Address keys should not depend on ports.\n")
+	}
+
+	for idx := 0; idx < 3; idx++ {
+		limiter.Failure(user, address)
+	}
+	checkLimit(limiter, address, time.Second, false, t)
+	if err := limiter.Check(UserKey("userB"), address); err == nil {
+		t.Fatalf("Address should be limited for all users.\n")
+	}
+	limiter.Success(user)
+	checkLimit(limiter, user, 0, false, t)
+	checkLimit(limiter, address, time.Second, false, t)
+
+	// failures are forgotten after the window
+	clock.now = clock.now.Add(2 * time.Hour)
+	checkLimit(limiter, address, 0, false, t)
+	limiter.Failure(address)
+	checkLimit(limiter, address, 0, false, t)
+	if len(limiter.entries) != 1 {
+		t.Fatalf("Stale entries should be purged, having %d entries.\n", len(limiter.entries))
+	}
+}
+
+func TestReserve(t *testing.T) {
+	var lockouts int
+	limiter, clock := newTestLimiter(func(key string, failures int, until time.Time) {
+		lockouts++
+	})
+	user := UserKey("userA")
+	address := AddressKey("10.0.0.1:5678")
+
+	// concurrent attempts are counted before completing
+	var reservations []*Reservation
+	for idx := 0; idx < 3; idx++ {
+		r, err := limiter.Reserve(user, address)
+		if err != nil {
+			t.Fatalf("Reservation %d should be accepted: %s.\n", idx, err.Error())
+		}
+		reservations = append(reservations, r)
+	}
+	if _, err := limiter.Reserve(user, address); err == nil {
+		t.Fatalf("Reservations exceeding free attempts should be refused.\n")
+	}
+	checkLimit(limiter, user, time.Second, false, t)
+
+	// cancelled reservations are not counted
+	reservations[2].Cancel()
+	reservations[2].Cancel()
+	checkLimit(limiter, user, 0, false, t)
+	// succeeded ones forget argument keys only
+	reservations[1].Success(user)
+	reservations[1].Cancel()
+	if _, ok := limiter.entries[user]; ok {
+		t.Fatalf("Succeeded reservation should forget user failures.\n")
+	}
+	// the failed one is still counted for the address
+	reservations[0].Failure()
+	reservations[0].Cancel()
+	if limiter.entries[address].failures != 1 {
+		t.Fatalf("Unexpected address failures %d, expecting 1.\n", limiter.entries[address].failures)
+	}
+
+	// lockouts are notified only by failed reservations
+	for idx := 0; idx < 5; idx++ {
+		clock.now = clock.now.Add(10 * time.Second)
+		limiter.Failure(user)
+	}
+	clock.now = clock.now.Add(10 * time.Second)
+	r, err := limiter.Reserve(user)
+	if err != nil {
+		t.Fatalf("Reservation should be accepted: %s.\n", err.Error())
+	}
+	checkLimit(limiter, user, time.Minute, true, t)
+	r.Cancel()
+	checkLimit(limiter, user, 4*time.Second, false, t)
+	if lockouts != 0 {
+		t.Fatalf("Cancelled reservations should not notify lockouts.\n")
+	}
+	clock.now = clock.now.Add(10 * time.Second)
+	r, err = limiter.Reserve(user)
+	if err != nil {
+		t.Fatalf("Reservation should be accepted: %s.\n", err.Error())
+	}
+	r.Failure()
+	checkLimit(limiter, user, time.Minute, true, t)
+	if lockouts != 1 {
+		t.Fatalf("Unexpected lockouts %d, expecting 1.\n", lockouts)
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	var limiter *Limiter
+	limiter.Failure("key")
+	limiter.Success("key")
+	if err := limiter.Check("key"); err != nil {
+		t.Fatalf("Nil limiter should accept all attempts: %s.\n", err.Error())
+	}
+	r, err := limiter.Reserve("key")
+	if err != nil {
+		t.Fatalf("Nil limiter should accept all reservations: %s.\n", err.Error())
+	}
+	r.Failure()
+	r.Success("key")
+	r.Cancel()
+}
+
+func TestFlags(t *testing.T) {
+	var limits Flags
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	limits.Register(flags, "login")
+	err := flags.Parse([]string{"--throttleattempts=5", "--lockoutminutes=30"})
+	if err != nil {
+		t.Fatalf("Unable to parse flags: %s.\n", err.Error())
+	}
+	config := limits.Config()
+	expected := Config{
+		FreeAttempts:    5,
+		BaseDelay:       time.Second,
+		MaxDelay:        30 * time.Minute,
+		LockoutAttempts: 10,
+		LockoutDuration: 30 * time.Minute,
+		Window:          30 * time.Minute,
+	}
+	if config != expected {
+		t.Fatalf("Unexpected config %+v, expecting %+v.\n", config, expected)
+	}
+}
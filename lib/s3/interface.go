@@ -10,7 +10,7 @@ package s3backend
 import (
 	"bytes"
 	"fmt"
-	"net/http"
+	"io"
 	"reflect"
 	"time"
 )
@@ -24,14 +24,24 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
 // Internal dependencies
 import (
 	ct "github.com/nexocrew/3nigm4/lib/commons"
+	"github.com/nexocrew/3nigm4/lib/metrics"
+	sb "github.com/nexocrew/3nigm4/lib/storagebackend"
 	wq "github.com/nexocrew/3nigm4/lib/workingqueue"
 )
 
+// s3Duration measures the latency of S3 requests by operation.
+var s3Duration = metrics.NewHistogram(
+	"s3_operation_duration_seconds",
+	"Latency of S3 requests by operation.",
+	nil,
+	"operation")
+
 // Session struct is composed of several private
 // fields and expose the async mechanism chans.
 type Session struct {
@@ -42,8 +52,8 @@ type Session struct {
 	ErrorChan      chan error       // returned errors;
 	UploadedChan   chan ct.OpResult // status of uploads;
 	DownloadedChan chan ct.OpResult // return chan for async downloaded chunks;
-	DeletedChan    chan ct.OpResult // manage deletion results from wq.
-
+	DeletedChan    chan ct.OpResult // manage deletion results from wq;
+	StatChan       chan ct.OpResult // stat results (size of the stored object).
 }
 
 // NewSession initialise a new S3 session for the file
@@ -69,6 +79,7 @@ func NewSession(endpoint, region, id, secret, token string, workersize, queuesiz
 		UploadedChan:   make(chan ct.OpResult, workersize),
 		DownloadedChan: make(chan ct.OpResult, workersize),
 		DeletedChan:    make(chan ct.OpResult, workersize),
+		StatChan:       make(chan ct.OpResult, workersize),
 	}
 
 	// create working queue
@@ -93,7 +104,6 @@ type args struct {
 	// s3
 	backendSession *Session
 	// put specifics
-	fileType string
 	fileData []byte
 	expires  *time.Time
 	// transaction id
@@ -116,13 +126,12 @@ func upload(a interface{}) error {
 		ACL:           aws.String("private"),
 		Body:          bytes.NewReader(arguments.fileData),
 		ContentLength: aws.Int64(int64(len(arguments.fileData))),
-		ContentType:   aws.String(arguments.fileType),
+		ContentType:   aws.String("application/octet-stream"),
 		Expires:       arguments.expires,
-		Metadata: map[string]*string{
-			"Key": aws.String("MetadataValue"), //required
-		},
 	}
+	start := time.Now()
 	_, err := arguments.backendSession.s3.PutObject(params)
+	s3Duration.ObserveSince(start, "upload")
 	if err != nil {
 		arguments.backendSession.UploadedChan <- ct.OpResult{
 			ID:        arguments.id,
@@ -156,7 +165,9 @@ func delete(a interface{}) error {
 		Key:    aws.String(arguments.id),
 	}
 
+	start := time.Now()
 	_, err := arguments.backendSession.s3.DeleteObject(params)
+	s3Duration.ObserveSince(start, "delete")
 	if err != nil {
 		arguments.backendSession.DeletedChan <- ct.OpResult{
 			ID:        arguments.id,
@@ -190,7 +201,9 @@ func download(a interface{}) error {
 		Key:    aws.String(arguments.id),
 	}
 
+	start := time.Now()
 	response, err := arguments.backendSession.s3.GetObject(params)
+	s3Duration.ObserveSince(start, "download")
 	if err != nil {
 		arguments.backendSession.DownloadedChan <- ct.OpResult{
 			ID:        arguments.id,
@@ -213,6 +226,47 @@ func download(a interface{}) error {
 	return nil
 }
 
+func stat(a interface{}) error {
+	var arguments *args
+	var ok bool
+	if arguments, ok = a.(*args); !ok {
+		// in this case no id can be retrieved, that's
+		// why no upload response is retuned.
+		return fmt.Errorf("unexpected argument type, having %s expecting *args", reflect.TypeOf(a))
+	}
+
+	// head params
+	params := &s3.HeadObjectInput{
+		Bucket: aws.String(arguments.bucketName),
+		Key:    aws.String(arguments.id),
+	}
+
+	start := time.Now()
+	response, err := arguments.backendSession.s3.HeadObject(params)
+	s3Duration.ObserveSince(start, "stat")
+	if err != nil {
+		arguments.backendSession.StatChan <- ct.OpResult{
+			ID:        arguments.id,
+			Error:     err,
+			RequestID: arguments.requestID,
+		}
+		return err
+	}
+
+	var size int64
+	if response.ContentLength != nil {
+		size = *response.ContentLength
+	}
+	// send stat result back to chan
+	arguments.backendSession.StatChan <- ct.OpResult{
+		ID:        arguments.id,
+		Error:     nil,
+		RequestID: arguments.requestID,
+		Size:      size,
+	}
+	return nil
+}
+
 // Delete removes a identified file from a S3 storage bucket.
 func (bs *Session) Delete(bucketName, id, requestid string) {
 	a := &args{
@@ -229,7 +283,6 @@ func (bs *Session) Upload(bucketName, id, requestid string, data []byte, expires
 	a := &args{
 		bucketName:     bucketName,
 		id:             id,
-		fileType:       http.DetectContentType(data),
 		fileData:       data,
 		expires:        expires,
 		backendSession: bs,
@@ -250,3 +303,88 @@ func (bs *Session) Download(bucketName, id, requestid string) {
 
 	bs.workingQueue.SendJob(download, a)
 }
+
+// Stat gets the size of a file stored in a S3 bucket.
+func (bs *Session) Stat(bucketName, id, requestid string) {
+	a := &args{
+		bucketName:     bucketName,
+		id:             id,
+		backendSession: bs,
+		requestID:      requestid,
+	}
+
+	bs.workingQueue.SendJob(stat, a)
+}
+
+// Chans returns the chans used to return async results, it's
+// required to implement the storagebackend.Backend interface.
+func (bs *Session) Chans() *sb.Results {
+	return &sb.Results{
+		ErrorChan:      bs.ErrorChan,
+		UploadedChan:   bs.UploadedChan,
+		DownloadedChan: bs.DownloadedChan,
+		DeletedChan:    bs.DeletedChan,
+		StatChan:       bs.StatChan,
+	}
+}
+
+// Stats returns the working queue activity, it's required to
+// implement the storagebackend.Backend interface.
+func (bs *Session) Stats() wq.Stats {
+	return bs.workingQueue.Stats()
+}
+
+// Drain waits for enqueued operations to complete, it's required
+// to implement the storagebackend.Backend interface.
+func (bs *Session) Drain(timeout time.Duration) error {
+	return bs.workingQueue.Drain(timeout)
+}
+
+// Ping verifies that the argument bucket is reachable.
+func (bs *Session) Ping(bucketName string) error {
+	start := time.Now()
+	_, err := bs.s3.HeadBucket(&s3.HeadBucketInput{
+		Bucket: aws.String(bucketName),
+	})
+	s3Duration.ObserveSince(start, "ping")
+	return err
+}
+
+// UploadStream synchronously sends a data stream to a S3 storage
+// without buffering it in memory: multipart uploads are used when
+// needed. It's intended to be used by API handlers directly piping
+// the request body to the storage.
+func (bs *Session) UploadStream(bucketName, id string, body io.Reader, expires *time.Time) error {
+	uploader := s3manager.NewUploaderWithClient(bs.s3)
+	start := time.Now()
+	_, err := uploader.Upload(&s3manager.UploadInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(id),
+		ACL:         aws.String("private"),
+		Body:        body,
+		ContentType: aws.String("application/octet-stream"),
+		Expires:     expires,
+	})
+	s3Duration.ObserveSince(start, "upload_stream")
+	return err
+}
+
+// DownloadStream synchronously opens a S3 stored file returning a
+// reader on its content and its size. The returned reader must be
+// closed by the caller.
+func (bs *Session) DownloadStream(bucketName, id string) (io.ReadCloser, int64, error) {
+	start := time.Now()
+	response, err := bs.s3.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(id),
+	})
+	s3Duration.ObserveSince(start, "download_stream")
+	if err != nil {
+		return nil, 0, err
+	}
+	var size int64 = -1
+	if response.ContentLength != nil {
+		size = *response.ContentLength
+	}
+	return response.Body, size, nil
+}
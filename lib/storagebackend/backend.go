@@ -0,0 +1,83 @@
+//
+// 3nigm4 storagebackend package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+// Package storagebackend defines the object store interface used
+// by the storage service to persist data chunks and implements
+// local drivers: a filesystem based one, for self hosted
+// deployments, and an in memory one, for tests. The S3 driver is
+// implemented by the s3backend package. All async operations are
+// managed by a concurrent working queue and return their results
+// on chans.
+package storagebackend
+
+// Golang std libs
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Internal dependencies
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+	wq "github.com/nexocrew/3nigm4/lib/workingqueue"
+)
+
+// Results groups the chans used by backends to return the
+// outcome of async operations.
+type Results struct {
+	ErrorChan      chan error       // returned errors;
+	UploadedChan   chan ct.OpResult // status of uploads;
+	DownloadedChan chan ct.OpResult // return chan for async downloaded chunks;
+	DeletedChan    chan ct.OpResult // manage deletion results;
+	StatChan       chan ct.OpResult // stat results (size of the stored object).
+}
+
+// NewResults allocates all the result chans with the argument
+// passed buffer size.
+func NewResults(size int) *Results {
+	return &Results{
+		ErrorChan:      make(chan error, size),
+		UploadedChan:   make(chan ct.OpResult, size),
+		DownloadedChan: make(chan ct.OpResult, size),
+		DeletedChan:    make(chan ct.OpResult, size),
+		StatChan:       make(chan ct.OpResult, size),
+	}
+}
+
+// Backend is the interface implemented by object stores usable
+// by the storage service. Upload, Download, Delete and Stat are
+// async: results are returned on the chans returned by Chans
+// associated with the passed request id. Stream functions are
+// sync and intended to be used directly by API handlers.
+type Backend interface {
+	Upload(bucketName, id, requestid string, data []byte, expires *time.Time) // enqueue an upload;
+	Download(bucketName, id, requestid string)                                // enqueue a download;
+	Delete(bucketName, id, requestid string)                                  // enqueue a deletion;
+	Stat(bucketName, id, requestid string)                                    // enqueue a stat request;
+	UploadStream(bucketName, id string, body io.Reader, expires *time.Time) error
+	DownloadStream(bucketName, id string) (io.ReadCloser, int64, error)
+	Chans() *Results                   // returns async results chans;
+	Stats() wq.Stats                   // returns the working queue activity;
+	Drain(timeout time.Duration) error // waits for enqueued operations to complete;
+	Ping(bucketName string) error      // verifies the storage is reachable;
+	Close()                            // release the backend resources.
+}
+
+// checkObjectName verifies that bucket and id can be safely used
+// by local drivers (no path traversal is permitted).
+func checkObjectName(bucketName, id string) error {
+	for _, name := range []string{bucketName, id} {
+		if name == "" ||
+			name == "." ||
+			name == ".." ||
+			strings.ContainsAny(name, "/\\") {
+			return fmt.Errorf("invalid object name %q", name)
+		}
+	}
+	return nil
+}
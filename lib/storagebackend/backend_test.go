@@ -0,0 +1,155 @@
+//
+// 3nigm4 storagebackend package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package storagebackend
+
+// Golang std libs
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// Internal dependencies
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+const (
+	testBucket  = "testbucket"
+	testTimeout = 5 * time.Second
+)
+
+var testData = []byte("This is a test content used to verify the backend drivers.")
+
+func waitResult(t *testing.T, c chan ct.OpResult) ct.OpResult {
+	select {
+	case result := <-c:
+		return result
+	case <-time.After(testTimeout):
+		t.Fatalf("Timeout waiting for async result.\n")
+	}
+	return ct.OpResult{}
+}
+
+func drainErrors(results *Results) {
+	for range results.ErrorChan {
+	}
+}
+
+func verifyBackend(t *testing.T, backend Backend) {
+	results := backend.Chans()
+	go drainErrors(results)
+
+	// async upload
+	backend.Upload(testBucket, "objectA", "req1", testData, nil)
+	result := waitResult(t, results.UploadedChan)
+	if result.Error != nil {
+		t.Fatalf("Unable to upload: %s.\n", result.Error.Error())
+	}
+	if result.RequestID != "req1" ||
+		result.ID != "objectA" {
+		t.Fatalf("Unexpected result ids: %s %s.\n", result.RequestID, result.ID)
+	}
+
+	// async stat
+	backend.Stat(testBucket, "objectA", "req2")
+	result = waitResult(t, results.StatChan)
+	if result.Error != nil {
+		t.Fatalf("Unable to stat: %s.\n", result.Error.Error())
+	}
+	if result.Size != int64(len(testData)) {
+		t.Fatalf("Unexpected size: having %d expecting %d.\n", result.Size, len(testData))
+	}
+
+	// async download
+	backend.Download(testBucket, "objectA", "req3")
+	result = waitResult(t, results.DownloadedChan)
+	if result.Error != nil {
+		t.Fatalf("Unable to download: %s.\n", result.Error.Error())
+	}
+	if bytes.Compare(result.Data, testData) != 0 {
+		t.Fatalf("Downloaded data differs from uploaded one.\n")
+	}
+
+	// stream upload and download
+	err := backend.UploadStream(testBucket, "objectB", bytes.NewReader(testData), nil)
+	if err != nil {
+		t.Fatalf("Unable to upload stream: %s.\n", err.Error())
+	}
+	body, size, err := backend.DownloadStream(testBucket, "objectB")
+	if err != nil {
+		t.Fatalf("Unable to download stream: %s.\n", err.Error())
+	}
+	data, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil {
+		t.Fatalf("Unable to read stream: %s.\n", err.Error())
+	}
+	if size != int64(len(testData)) ||
+		bytes.Compare(data, testData) != 0 {
+		t.Fatalf("Streamed data differs from uploaded one.\n")
+	}
+
+	// async delete
+	backend.Delete(testBucket, "objectA", "req4")
+	result = waitResult(t, results.DeletedChan)
+	if result.Error != nil {
+		t.Fatalf("Unable to delete: %s.\n", result.Error.Error())
+	}
+	backend.Stat(testBucket, "objectA", "req5")
+	result = waitResult(t, results.StatChan)
+	if result.Error == nil {
+		t.Fatalf("Deleted object should not be found.\n")
+	}
+	backend.Download(testBucket, "objectA", "req6")
+	result = waitResult(t, results.DownloadedChan)
+	if result.Error == nil ||
+		result.Data != nil {
+		t.Fatalf("Deleted object should not be downloadable.\n")
+	}
+
+	// invalid names
+	err = backend.UploadStream(testBucket, "../objectC", bytes.NewReader(testData), nil)
+	if err == nil {
+		t.Fatalf("Path traversing names should be rejected.\n")
+	}
+	_, _, err = backend.DownloadStream("..", "objectB")
+	if err == nil {
+		t.Fatalf("Path traversing buckets should be rejected.\n")
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	backend, err := NewMemorySession(4, 10)
+	if err != nil {
+		t.Fatalf("Unable to create memory backend: %s.\n", err.Error())
+	}
+	defer backend.Close()
+	verifyBackend(t, backend)
+}
+
+func TestFilesystemBackend(t *testing.T) {
+	root, err := ioutil.TempDir("", "3nigm4backend")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s.\n", err.Error())
+	}
+	defer os.RemoveAll(root)
+
+	backend, err := NewFilesystemSession(root, 4, 10)
+	if err != nil {
+		t.Fatalf("Unable to create filesystem backend: %s.\n", err.Error())
+	}
+	defer backend.Close()
+	verifyBackend(t, backend)
+
+	_, err = NewFilesystemSession("", 4, 10)
+	if err == nil {
+		t.Fatalf("Empty root directory should be rejected.\n")
+	}
+}
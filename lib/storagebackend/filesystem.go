@@ -0,0 +1,109 @@
+//
+// 3nigm4 storagebackend package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package storagebackend
+
+// Golang std libs
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// filesystemStore saves objects as files in a local directory,
+// each bucket is a sub directory of the root one.
+type filesystemStore struct {
+	root string
+}
+
+// NewFilesystemSession creates a Backend storing objects in the
+// argument passed root directory (created if not existing).
+func NewFilesystemSession(root string, workersize, queuesize int) (*Session, error) {
+	if root == "" {
+		return nil, fmt.Errorf("filesystem backend root directory is required")
+	}
+	err := os.MkdirAll(root, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create root directory %s: %s", root, err.Error())
+	}
+	return newSession(&filesystemStore{
+		root: root,
+	}, workersize, queuesize)
+}
+
+func (f *filesystemStore) path(bucketName, id string) string {
+	return filepath.Join(f.root, bucketName, id)
+}
+
+// put writes the object in a temporary file renaming it when
+// completed: partially written objects are never visible.
+func (f *filesystemStore) put(bucketName, id string, body io.Reader) (int64, error) {
+	dir := filepath.Join(f.root, bucketName)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return 0, err
+	}
+	tmp, err := ioutil.TempFile(dir, ".upload-")
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(tmp, body)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	err = tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	err = os.Rename(tmp.Name(), f.path(bucketName, id))
+	if err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	return written, nil
+}
+
+func (f *filesystemStore) get(bucketName, id string) (io.ReadCloser, int64, error) {
+	file, err := os.Open(f.path(bucketName, id))
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+func (f *filesystemStore) remove(bucketName, id string) error {
+	return os.Remove(f.path(bucketName, id))
+}
+
+func (f *filesystemStore) size(bucketName, id string) (int64, error) {
+	info, err := os.Stat(f.path(bucketName, id))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// ping verifies that the root directory is still accessible.
+func (f *filesystemStore) ping(bucketName string) error {
+	info, err := os.Stat(f.root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("root %s is not a directory", f.root)
+	}
+	return nil
+}
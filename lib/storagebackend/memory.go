@@ -0,0 +1,80 @@
+//
+// 3nigm4 storagebackend package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package storagebackend
+
+// Golang std libs
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// memoryStore keeps all objects in memory, it's intended to be
+// used in tests: data is lost when the process exits.
+type memoryStore struct {
+	mtx     sync.RWMutex
+	objects map[string][]byte
+}
+
+// NewMemorySession creates a Backend storing objects in memory.
+func NewMemorySession(workersize, queuesize int) (*Session, error) {
+	return newSession(&memoryStore{
+		objects: make(map[string][]byte),
+	}, workersize, queuesize)
+}
+
+func memoryKey(bucketName, id string) string {
+	return bucketName + "/" + id
+}
+
+func (m *memoryStore) put(bucketName, id string, body io.Reader) (int64, error) {
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return 0, err
+	}
+	m.mtx.Lock()
+	m.objects[memoryKey(bucketName, id)] = data
+	m.mtx.Unlock()
+	return int64(len(data)), nil
+}
+
+func (m *memoryStore) get(bucketName, id string) (io.ReadCloser, int64, error) {
+	m.mtx.RLock()
+	data, ok := m.objects[memoryKey(bucketName, id)]
+	m.mtx.RUnlock()
+	if !ok {
+		return nil, 0, fmt.Errorf("object %s not found in bucket %s", id, bucketName)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+}
+
+func (m *memoryStore) remove(bucketName, id string) error {
+	key := memoryKey(bucketName, id)
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if _, ok := m.objects[key]; !ok {
+		return fmt.Errorf("object %s not found in bucket %s", id, bucketName)
+	}
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *memoryStore) size(bucketName, id string) (int64, error) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	data, ok := m.objects[memoryKey(bucketName, id)]
+	if !ok {
+		return 0, fmt.Errorf("object %s not found in bucket %s", id, bucketName)
+	}
+	return int64(len(data)), nil
+}
+
+func (m *memoryStore) ping(bucketName string) error {
+	return nil
+}
@@ -0,0 +1,241 @@
+//
+// 3nigm4 storagebackend package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package storagebackend
+
+// Golang std libs
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+)
+
+// Internal dependencies
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+	wq "github.com/nexocrew/3nigm4/lib/workingqueue"
+)
+
+// store is implemented by local drivers to synchronously access
+// stored objects, async behaviour is provided by Session.
+type store interface {
+	put(bucketName, id string, body io.Reader) (int64, error) // stores an object returning its size;
+	get(bucketName, id string) (io.ReadCloser, int64, error)  // opens an object returning its size;
+	remove(bucketName, id string) error                       // removes an object;
+	size(bucketName, id string) (int64, error)                // returns the size of an object;
+	ping(bucketName string) error                             // verifies the store is reachable.
+}
+
+// Session implements the Backend interface on top of a local
+// store using a working queue to manage async operations.
+type Session struct {
+	store        store
+	workingQueue *wq.WorkingQueue
+	results      *Results
+}
+
+// newSession creates a new session for the argument passed store
+// and starts the working queue.
+func newSession(s store, workersize, queuesize int) (*Session, error) {
+	session := &Session{
+		store:   s,
+		results: NewResults(workersize),
+	}
+	// create working queue
+	session.workingQueue = wq.NewWorkingQueue(workersize, queuesize, session.results.ErrorChan)
+	if err := session.workingQueue.Run(); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Close stops the working queue.
+func (s *Session) Close() {
+	s.workingQueue.Close()
+}
+
+// Chans returns the chans used to return async results.
+func (s *Session) Chans() *Results {
+	return s.results
+}
+
+// Stats returns the working queue activity.
+func (s *Session) Stats() wq.Stats {
+	return s.workingQueue.Stats()
+}
+
+// Drain waits for enqueued operations to complete.
+func (s *Session) Drain(timeout time.Duration) error {
+	return s.workingQueue.Drain(timeout)
+}
+
+// Ping verifies the store is reachable.
+func (s *Session) Ping(bucketName string) error {
+	return s.store.ping(bucketName)
+}
+
+// jobArgs arguments passed to the working queue jobs.
+type jobArgs struct {
+	session    *Session
+	bucketName string
+	id         string
+	data       []byte
+	requestID  string
+}
+
+// castJobArgs verifies the type of the arguments passed to the
+// working queue jobs.
+func castJobArgs(a interface{}) (*jobArgs, error) {
+	arguments, ok := a.(*jobArgs)
+	if !ok {
+		return nil, fmt.Errorf("unexpected argument type, having %s expecting *jobArgs", reflect.TypeOf(a))
+	}
+	return arguments, nil
+}
+
+func upload(a interface{}) error {
+	arguments, err := castJobArgs(a)
+	if err != nil {
+		return err
+	}
+	err = arguments.session.UploadStream(
+		arguments.bucketName,
+		arguments.id,
+		bytes.NewReader(arguments.data),
+		nil)
+	arguments.session.results.UploadedChan <- ct.OpResult{
+		ID:        arguments.id,
+		Error:     err,
+		RequestID: arguments.requestID,
+	}
+	return err
+}
+
+func download(a interface{}) error {
+	arguments, err := castJobArgs(a)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	body, _, err := arguments.session.DownloadStream(arguments.bucketName, arguments.id)
+	if err == nil {
+		buf := new(bytes.Buffer)
+		_, err = buf.ReadFrom(body)
+		body.Close()
+		data = buf.Bytes()
+	}
+	if err != nil {
+		data = nil
+	}
+	arguments.session.results.DownloadedChan <- ct.OpResult{
+		ID:        arguments.id,
+		Error:     err,
+		RequestID: arguments.requestID,
+		Data:      data,
+	}
+	return err
+}
+
+func remove(a interface{}) error {
+	arguments, err := castJobArgs(a)
+	if err != nil {
+		return err
+	}
+	err = checkObjectName(arguments.bucketName, arguments.id)
+	if err == nil {
+		err = arguments.session.store.remove(arguments.bucketName, arguments.id)
+	}
+	arguments.session.results.DeletedChan <- ct.OpResult{
+		ID:        arguments.id,
+		Error:     err,
+		RequestID: arguments.requestID,
+	}
+	return err
+}
+
+func stat(a interface{}) error {
+	arguments, err := castJobArgs(a)
+	if err != nil {
+		return err
+	}
+	var size int64
+	err = checkObjectName(arguments.bucketName, arguments.id)
+	if err == nil {
+		size, err = arguments.session.store.size(arguments.bucketName, arguments.id)
+	}
+	arguments.session.results.StatChan <- ct.OpResult{
+		ID:        arguments.id,
+		Error:     err,
+		RequestID: arguments.requestID,
+		Size:      size,
+	}
+	return err
+}
+
+// Upload enqueues the upload of a data blob, expires is ignored by
+// local drivers (expiration is managed by the storage service).
+func (s *Session) Upload(bucketName, id, requestid string, data []byte, expires *time.Time) {
+	s.workingQueue.SendJob(upload, &jobArgs{
+		session:    s,
+		bucketName: bucketName,
+		id:         id,
+		data:       data,
+		requestID:  requestid,
+	})
+}
+
+// Download enqueues the download of a stored object.
+func (s *Session) Download(bucketName, id, requestid string) {
+	s.workingQueue.SendJob(download, &jobArgs{
+		session:    s,
+		bucketName: bucketName,
+		id:         id,
+		requestID:  requestid,
+	})
+}
+
+// Delete enqueues the deletion of a stored object.
+func (s *Session) Delete(bucketName, id, requestid string) {
+	s.workingQueue.SendJob(remove, &jobArgs{
+		session:    s,
+		bucketName: bucketName,
+		id:         id,
+		requestID:  requestid,
+	})
+}
+
+// Stat enqueues a request for the size of a stored object.
+func (s *Session) Stat(bucketName, id, requestid string) {
+	s.workingQueue.SendJob(stat, &jobArgs{
+		session:    s,
+		bucketName: bucketName,
+		id:         id,
+		requestID:  requestid,
+	})
+}
+
+// UploadStream synchronously stores a data stream.
+func (s *Session) UploadStream(bucketName, id string, body io.Reader, expires *time.Time) error {
+	err := checkObjectName(bucketName, id)
+	if err != nil {
+		return err
+	}
+	_, err = s.store.put(bucketName, id, body)
+	return err
+}
+
+// DownloadStream synchronously opens a stored object returning a
+// reader on its content and its size. The returned reader must be
+// closed by the caller.
+func (s *Session) DownloadStream(bucketName, id string) (io.ReadCloser, int64, error) {
+	err := checkObjectName(bucketName, id)
+	if err != nil {
+		return nil, 0, err
+	}
+	return s.store.get(bucketName, id)
+}
@@ -0,0 +1,339 @@
+// 3nigm4 storageclient package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 15/08/2016
+
+package storageclient
+
+// Std golang dependencies.
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+// Internal dependencies
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+const (
+	// defaultBatchSize is the default maximum number of operations
+	// executed with a single batch request.
+	defaultBatchSize = 32
+)
+
+// batchedCommands are the commands grouped in batches.
+var batchedCommands = map[string]bool{
+	"UPLOAD":   true,
+	"DOWNLOAD": true,
+	"DELETE":   true,
+}
+
+// transferCommands are the batched commands transferring chunk data:
+// the batch only authorises them returning a transfer token for each
+// chunk, data are then streamed one by one on the chunk routes.
+var transferCommands = map[string]bool{
+	"UPLOAD":   true,
+	"DOWNLOAD": true,
+}
+
+// SetBatchSize sets the maximum number of operations grouped in a
+// single batch request, sizes lower than two disable batching.
+// Clients using a download link never use batches.
+func (s *StorageClient) SetBatchSize(size int) {
+	if size > ct.MaxBatchJobs {
+		size = ct.MaxBatchJobs
+	}
+	s.batchSize = size
+}
+
+// batchArgs arguments passed to batch jobs while adding them to the
+// working queue instance.
+type batchArgs struct {
+	client    *StorageClient
+	command   string
+	args      []*ct.CommandArguments
+	requestID string
+	results   chan ct.OpResult
+}
+
+// enqueue adds to the working queue the jobs executing the command
+// on the argument resources: resources are grouped in batches, if
+// enabled and supported by the command, otherwise they're processed
+// one by one.
+func (s *StorageClient) enqueue(command, requestID string, resources []*ct.CommandArguments) {
+	var single func(interface{}) error
+	var results chan ct.OpResult
+	switch command {
+	case "UPLOAD":
+		single, results = upload, s.uplaodChan
+	case "DOWNLOAD":
+		single, results = download, s.downloadChan
+	case "DELETE":
+		single, results = remove, s.deletedChan
+	}
+
+	if s.batchSize < 2 ||
+		s.link != "" ||
+		!batchedCommands[command] {
+		for _, args := range resources {
+			s.workingQueue.SendJob(single, &jobArgs{
+				client:    s,
+				args:      args,
+				requestID: requestID,
+			})
+		}
+		return
+	}
+
+	send := func(group []*ct.CommandArguments) {
+		s.workingQueue.SendJob(batch, &batchArgs{
+			client:    s,
+			command:   command,
+			args:      group,
+			requestID: requestID,
+			results:   results,
+		})
+	}
+	// spread resources between workers, each batch is executed
+	// sequentially by a single worker
+	size := s.batchSize
+	if s.workers > 0 &&
+		(len(resources)+s.workers-1)/s.workers < size {
+		size = (len(resources) + s.workers - 1) / s.workers
+	}
+	var group []*ct.CommandArguments
+	for _, args := range resources {
+		if len(group) == size {
+			send(group)
+			group = nil
+		}
+		group = append(group, args)
+	}
+	if len(group) != 0 {
+		send(group)
+	}
+}
+
+// postBatch creates, with a single request, all the jobs of a batch.
+func postBatch(arguments *batchArgs) (*ct.BatchJobResponse, error) {
+	request := ct.BatchJobRequest{
+		Jobs: make([]ct.JobPostRequest, len(arguments.args)),
+	}
+	for idx, args := range arguments.args {
+		// chunk data are never carried by batches
+		if transferCommands[arguments.command] {
+			args = &ct.CommandArguments{
+				ResourceID: args.ResourceID,
+			}
+		}
+		request.Jobs[idx] = ct.JobPostRequest{
+			Command:   arguments.command,
+			Arguments: args,
+		}
+	}
+	body, err := json.Marshal(&request)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, respBody, err := doAuthenticatedRequest(
+		arguments.client,
+		"POST",
+		fmt.Sprintf("%s:%d%s", arguments.client.address, arguments.client.port, batchPath),
+		body,
+		nil)
+	if err != nil {
+		return nil, err
+	}
+	err = checkRequestStatus(resp.StatusCode, http.StatusOK, respBody)
+	if err != nil {
+		return nil, err
+	}
+
+	var response ct.BatchJobResponse
+	err = json.Unmarshal(respBody, &response)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Jobs) != len(request.Jobs) {
+		return nil, fmt.Errorf("unexpected batch response: having %d jobs expecting %d", len(response.Jobs), len(request.Jobs))
+	}
+	return &response, nil
+}
+
+// getBatchStatus verifies, with a single request, the status of
+// several jobs.
+func getBatchStatus(arguments *batchArgs, jobIDs []string) (*ct.BatchStatusResponse, error) {
+	body, err := json.Marshal(&ct.BatchStatusRequest{
+		JobIDs: jobIDs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, respBody, err := doAuthenticatedRequest(
+		arguments.client,
+		"POST",
+		fmt.Sprintf("%s:%d%s/status", arguments.client.address, arguments.client.port, batchPath),
+		body,
+		nil)
+	if err != nil {
+		return nil, err
+	}
+	err = checkRequestStatus(resp.StatusCode, http.StatusOK, respBody)
+	if err != nil {
+		return nil, err
+	}
+
+	var response ct.BatchStatusResponse
+	err = json.Unmarshal(respBody, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// verifyJobResult checks the result of a completed job.
+func verifyJobResult(resourceID string, result *ct.JobGetRequest) error {
+	if result == nil {
+		return fmt.Errorf("missing result for resource %s", resourceID)
+	}
+	if result.Error != "" {
+		return fmt.Errorf("%s", result.Error)
+	}
+	return nil
+}
+
+// runBatch creates the jobs of a batch and waits for their
+// completion returning the result of each one.
+func runBatch(arguments *batchArgs) []ct.OpResult {
+	results := make([]ct.OpResult, len(arguments.args))
+	for idx, args := range arguments.args {
+		results[idx] = ct.OpResult{
+			RequestID: arguments.requestID,
+			ID:        args.ResourceID,
+		}
+	}
+	failAll := func(pending map[string]int, err error) {
+		for _, idx := range pending {
+			results[idx].Error = err
+		}
+	}
+
+	// create jobs
+	created, err := postBatch(arguments)
+	if err != nil {
+		for idx := range results {
+			results[idx].Error = err
+		}
+		return results
+	}
+	pending := make(map[string]int)
+	for idx, job := range created.Jobs {
+		if job.JobID == "" {
+			results[idx].Error = fmt.Errorf("%s", job.Error)
+			continue
+		}
+		pending[job.JobID] = idx
+	}
+
+	// loop to verify jobs completion
+	for len(pending) != 0 {
+		jobIDs := make([]string, 0, len(pending))
+		for jobID := range pending {
+			jobIDs = append(jobIDs, jobID)
+		}
+		statuses, err := getBatchStatus(arguments, jobIDs)
+		if err != nil {
+			failAll(pending, err)
+			break
+		}
+		for _, status := range statuses.Jobs {
+			idx, ok := pending[status.JobID]
+			if !ok {
+				continue
+			}
+			switch status.Status {
+			case http.StatusAccepted:
+				continue
+			case http.StatusOK:
+				results[idx].Error = verifyJobResult(results[idx].ID, status.Result)
+			default:
+				results[idx].Error = fmt.Errorf("%s", status.Error)
+			}
+			delete(pending, status.JobID)
+		}
+		if len(pending) != 0 {
+			// sleep to avoid spinning on the CPU
+			time.Sleep(verifySleep)
+		}
+	}
+	return results
+}
+
+// runTransferBatch authorises, with a single request, the transfers
+// of a batch and streams the chunks using the returned transfer
+// tokens.
+func runTransferBatch(arguments *batchArgs) []ct.OpResult {
+	results := make([]ct.OpResult, len(arguments.args))
+	for idx, args := range arguments.args {
+		results[idx] = ct.OpResult{
+			RequestID: arguments.requestID,
+			ID:        args.ResourceID,
+		}
+	}
+
+	authorised, err := postBatch(arguments)
+	if err != nil {
+		for idx := range results {
+			results[idx].Error = err
+		}
+		return results
+	}
+	for idx, job := range authorised.Jobs {
+		if job.TransferToken == "" {
+			results[idx].Error = fmt.Errorf("%s", job.Error)
+			continue
+		}
+		chunk := &jobArgs{
+			client:        arguments.client,
+			args:          arguments.args[idx],
+			requestID:     arguments.requestID,
+			transferToken: job.TransferToken,
+		}
+		switch arguments.command {
+		case "UPLOAD":
+			results[idx].Error = putChunk(chunk)
+		case "DOWNLOAD":
+			results[idx].Data, results[idx].Error = getChunk(chunk)
+		}
+	}
+	return results
+}
+
+// batch the job that'll be enqueued in the working queue to perform
+// a batch of operations: a result is produced for each resource.
+func batch(a interface{}) error {
+	var arguments *batchArgs
+	var ok bool
+	if arguments, ok = a.(*batchArgs); !ok {
+		return fmt.Errorf("unexpected argument type, having %s expecting *batchArgs", reflect.TypeOf(a))
+	}
+
+	run := runBatch
+	if transferCommands[arguments.command] {
+		run = runTransferBatch
+	}
+	var err error
+	for _, result := range run(arguments) {
+		if result.Error != nil &&
+			err == nil {
+			err = result.Error
+		}
+		arguments.results <- result
+	}
+	return err
+}
@@ -0,0 +1,273 @@
+// 3nigm4 storageclient package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 15/08/2016
+
+package storageclient
+
+// Std golang dependencies.
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Internal dependencies
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+// mockBatchService implements the batch and chunk routes keeping
+// resources in memory, jobs are reported as in progress at the first
+// check. Transfer tokens are composed by the method and the resource
+// id.
+type mockBatchService struct {
+	mtx       sync.Mutex
+	storage   map[string][]byte
+	jobs      map[string]*ct.JobGetRequest
+	checked   map[string]bool
+	batches   int
+	chunks    int
+	transfers int
+	expired   bool // rejects transfer tokens.
+}
+
+func newMockBatchService() *mockBatchService {
+	return &mockBatchService{
+		storage: make(map[string][]byte),
+		jobs:    make(map[string]*ct.JobGetRequest),
+		checked: make(map[string]bool),
+	}
+}
+
+func (m *mockBatchService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if strings.HasPrefix(r.URL.Path, chunkPath+"/") {
+		m.chunks++
+		id := strings.TrimPrefix(r.URL.Path, chunkPath+"/")
+		if token := r.Header.Get(ct.TransferTokenKey); token != "" {
+			if m.expired ||
+				token != r.Method+id {
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).Encode(&ct.StandardResponse{
+					Status: ct.NakResponse,
+					Error:  "transfer token is not valid",
+				})
+				return
+			}
+			m.transfers++
+		} else if checkTokenPresence(r) != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case "PUT":
+			data, _ := ioutil.ReadAll(r.Body)
+			checksum := sha256.Sum256(data)
+			m.storage[id] = data
+			w.Header().Set(ct.CheckSumKey, hex.EncodeToString(checksum[:]))
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(&ct.StandardResponse{
+				Status: ct.AckResponse,
+			})
+		case "GET":
+			data, ok := m.storage[id]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(&ct.StandardResponse{
+					Status: ct.NakResponse,
+					Error:  "requested file not found",
+				})
+				return
+			}
+			checksum := sha256.Sum256(data)
+			w.Header().Set(ct.CheckSumKey, hex.EncodeToString(checksum[:]))
+			w.WriteHeader(http.StatusOK)
+			w.Write(data)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	if checkTokenPresence(r) != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch r.URL.Path {
+	case batchPath:
+		var request ct.BatchJobRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		m.batches++
+		response := ct.BatchJobResponse{
+			Jobs: make([]ct.BatchJobResult, len(request.Jobs)),
+		}
+		for idx, job := range request.Jobs {
+			id := job.Arguments.ResourceID
+			response.Jobs[idx].ResourceID = id
+			result := &ct.JobGetRequest{
+				Complete: true,
+			}
+			switch job.Command {
+			case "UPLOAD", "DOWNLOAD":
+				_, ok := m.storage[id]
+				switch {
+				case len(job.Arguments.Data) != 0:
+					response.Jobs[idx].Status = http.StatusBadRequest
+					response.Jobs[idx].Error = "chunk data should be streamed"
+				case job.Command == "DOWNLOAD" && !ok:
+					response.Jobs[idx].Status = http.StatusNotFound
+					response.Jobs[idx].Error = "requested file not found"
+				case job.Command == "UPLOAD":
+					response.Jobs[idx].Status = http.StatusOK
+					response.Jobs[idx].TransferToken = "PUT" + id
+				default:
+					response.Jobs[idx].Status = http.StatusOK
+					response.Jobs[idx].TransferToken = "GET" + id
+				}
+				continue
+			case "DELETE":
+				delete(m.storage, id)
+			default:
+				response.Jobs[idx].Status = http.StatusBadRequest
+				response.Jobs[idx].Error = "unknown command"
+				continue
+			}
+			jobID, _ := randomID()
+			m.jobs[jobID] = result
+			response.Jobs[idx].Status = http.StatusAccepted
+			response.Jobs[idx].JobID = jobID
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(&response)
+	case batchPath + "/status":
+		var request ct.BatchStatusRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		response := ct.BatchStatusResponse{
+			Jobs: make([]ct.BatchJobStatus, len(request.JobIDs)),
+		}
+		for idx, jobID := range request.JobIDs {
+			response.Jobs[idx].JobID = jobID
+			result, ok := m.jobs[jobID]
+			switch {
+			case !ok:
+				response.Jobs[idx].Status = http.StatusGone
+				response.Jobs[idx].Error = "job not found"
+			case !m.checked[jobID]:
+				m.checked[jobID] = true
+				response.Jobs[idx].Status = http.StatusAccepted
+			default:
+				delete(m.jobs, jobID)
+				response.Jobs[idx].Status = http.StatusOK
+				response.Jobs[idx].Result = result
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(&response)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestBatchResources(t *testing.T) {
+	service := newMockBatchService()
+	server := httptest.NewServer(service)
+	defer server.Close()
+	addr, port := extractAddressAndPort(server.URL, t)
+	sc, err, _ := NewStorageClient(addr, port, testToken, 2, 10)
+	if err != nil {
+		t.Fatalf("Unable to create a new StorageClient instance: %s.\n", err.Error())
+	}
+	defer sc.Close()
+	sc.SetBatchSize(5)
+
+	// uploads and downloads are authorised by batches and streamed
+	fnames, err := sc.SaveChunks(testFileName, testFileChunks, nil, 0, nil, nil)
+	if err != nil {
+		t.Fatalf("Unable to upload files: %s.\n", err.Error())
+	}
+	for idx, resourceID := range fnames {
+		if !bytes.Equal(service.storage[resourceID], testFileChunks[idx]) {
+			t.Fatalf("Resources at index %d are not equal as expected.\n", idx)
+		}
+	}
+	chunks, err := sc.RetrieveChunks(testFileName, fnames, nil)
+	if err != nil {
+		t.Fatalf("Unable to retrieve files: %s.\n", err.Error())
+	}
+	for idx, chunk := range chunks {
+		if !bytes.Equal(chunk, testFileChunks[idx]) {
+			t.Fatalf("Downloaded resources at index %d are not equal as expected.\n", idx)
+		}
+	}
+	if service.batches != 6 ||
+		service.chunks != 2*len(testFileChunks) ||
+		service.transfers != 2*len(testFileChunks) {
+		t.Fatalf("Having %d batches, %d chunk requests and %d transfers expecting 6, %d and %d.\n",
+			service.batches,
+			service.chunks,
+			service.transfers,
+			2*len(testFileChunks),
+			2*len(testFileChunks))
+	}
+	// rejected transfer tokens fall back to the session
+	service.expired = true
+	chunks, err = sc.RetrieveChunks(testFileName, fnames, nil)
+	if err != nil {
+		t.Fatalf("Unable to retrieve files: %s.\n", err.Error())
+	}
+	for idx, chunk := range chunks {
+		if !bytes.Equal(chunk, testFileChunks[idx]) {
+			t.Fatalf("Downloaded resources at index %d are not equal as expected.\n", idx)
+		}
+	}
+	service.expired = false
+
+	// deletions are batched
+	err = sc.DeleteChunks(testFileName, fnames, nil)
+	if err != nil {
+		t.Fatalf("Unable to delete files: %s.\n", err.Error())
+	}
+	if service.batches != 12 {
+		t.Fatalf("Having %d batches expecting 12.\n", service.batches)
+	}
+	if len(service.storage) != 0 {
+		t.Fatalf("Resources should be removed: %d found.\n", len(service.storage))
+	}
+	// failing jobs are reported per resource
+	err = sc.DeleteChunks(testFileName, fnames[:1], nil)
+	if err != nil {
+		t.Fatalf("Unable to delete missing files: %s.\n", err.Error())
+	}
+	_, err = sc.RetrieveChunks(testFileName, fnames[:1], nil)
+	if err == nil {
+		t.Fatalf("Missing chunks should not be retrieved.\n")
+	}
+}
+
+func TestSetBatchSize(t *testing.T) {
+	sc, err, _ := NewStorageClient("http://127.0.0.1", 8080, testToken, 1, 1)
+	if err != nil {
+		t.Fatalf("Unable to create a new StorageClient instance: %s.\n", err.Error())
+	}
+	defer sc.Close()
+	if sc.batchSize != defaultBatchSize {
+		t.Fatalf("Having batch size %d expecting %d.\n", sc.batchSize, defaultBatchSize)
+	}
+	sc.SetBatchSize(ct.MaxBatchJobs + 1)
+	if sc.batchSize != ct.MaxBatchJobs {
+		t.Fatalf("Having batch size %d expecting %d.\n", sc.batchSize, ct.MaxBatchJobs)
+	}
+}
@@ -0,0 +1,185 @@
+// 3nigm4 storageclient package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 15/08/2016
+
+package storageclient
+
+// Std golang dependencies.
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Internal dependencies
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+const (
+	authsessionPath = "/v1/authsession"
+	// DefaultRenewInterval is the default interval after which a
+	// session token is proactively renewed, it's lower than the
+	// 15 minutes inactivity time to live enforced by the auth
+	// service.
+	DefaultRenewInterval = 10 * time.Minute
+)
+
+// CredentialsProvider is used by the StorageClient to obtain a
+// valid session token before each API call and to renew it when
+// rejected by the service. Implementations must be safe for
+// concurrent use (several workers share the same provider).
+type CredentialsProvider interface {
+	Token() (string, error)       // returns a session token usable for the next request;
+	Renew(string) (string, error) // renews the argument passed rejected token returning a valid one.
+}
+
+// StaticCredentials is a fixed session token that can not be
+// renewed: it preserves the behaviour of clients that manage
+// sessions by themselves.
+type StaticCredentials string
+
+// Token returns the static token.
+func (s StaticCredentials) Token() (string, error) {
+	return string(s), nil
+}
+
+// Renew always fails, static credentials can not be renewed.
+func (s StaticCredentials) Renew(rejected string) (string, error) {
+	return "", fmt.Errorf("static credentials can not be renewed")
+}
+
+// LoginFunc is used by SessionCredentials to re-authenticate the
+// user when the session can not be refreshed anymore (typically
+// because it's already expired). It returns a new session token.
+type LoginFunc func() (string, error)
+
+// SessionCredentials implements a CredentialsProvider that keeps
+// a session alive using the service refresh API: the token is
+// renewed every renewInterval and whenever the service rejects it.
+// If the session can not be refreshed the optional login function
+// is used to re-authenticate.
+type SessionCredentials struct {
+	mtx sync.Mutex
+	// service coordinates
+	address string
+	port    int
+	// session
+	token         string
+	lastRenew     time.Time
+	renewInterval time.Duration
+	login         LoginFunc
+	// OnRenew, if not nil, is invoked every time a new token
+	// is obtained (for example to persist it).
+	OnRenew func(string)
+}
+
+// NewSessionCredentials creates a new session based credentials
+// provider starting from a valid session token. If renewInterval
+// is zero DefaultRenewInterval is used, login can be nil if no
+// re-authentication should be attempted.
+func NewSessionCredentials(address string, port int, token string, renewInterval time.Duration, login LoginFunc) *SessionCredentials {
+	if renewInterval == 0 {
+		renewInterval = DefaultRenewInterval
+	}
+	return &SessionCredentials{
+		address:       address,
+		port:          port,
+		token:         token,
+		lastRenew:     time.Now(),
+		renewInterval: renewInterval,
+		login:         login,
+	}
+}
+
+// refreshToken calls the refresh API returning the new session
+// token.
+func refreshToken(address string, port int, token string) (string, error) {
+	client := &http.Client{}
+	req, err := http.NewRequest(
+		"PUT",
+		fmt.Sprintf("%s:%d%s", address, port, authsessionPath),
+		nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(ct.SecurityTokenKey, token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	err = checkRequestStatus(resp.StatusCode, http.StatusOK, respBody)
+	if err != nil {
+		return "", err
+	}
+	var refreshed ct.LoginResponse
+	err = json.Unmarshal(respBody, &refreshed)
+	if err != nil {
+		return "", err
+	}
+	if refreshed.Token == "" {
+		return "", fmt.Errorf("returned token is nil")
+	}
+	return refreshed.Token, nil
+}
+
+// renew obtains a new token refreshing the actual session or,
+// if not possible, re-authenticating the user. Must be called
+// with the mutex held.
+func (c *SessionCredentials) renew() error {
+	token, err := refreshToken(c.address, c.port, c.token)
+	if err != nil {
+		if c.login == nil {
+			return fmt.Errorf("unable to refresh session: %s", err.Error())
+		}
+		token, err = c.login()
+		if err != nil {
+			return fmt.Errorf("unable to re-authenticate: %s", err.Error())
+		}
+	}
+	c.token = token
+	c.lastRenew = time.Now()
+	if c.OnRenew != nil {
+		c.OnRenew(token)
+	}
+	return nil
+}
+
+// Token returns the actual session token, renewing it if the
+// renew interval elapsed.
+func (c *SessionCredentials) Token() (string, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if time.Since(c.lastRenew) > c.renewInterval {
+		err := c.renew()
+		if err != nil {
+			return "", err
+		}
+	}
+	return c.token, nil
+}
+
+// Renew is invoked when the service rejects a token: if the token
+// has been already renewed by a concurrent request the actual one
+// is returned, otherwise a new one is obtained.
+func (c *SessionCredentials) Renew(rejected string) (string, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if rejected != c.token {
+		return c.token, nil
+	}
+	err := c.renew()
+	if err != nil {
+		return "", err
+	}
+	return c.token, nil
+}
@@ -0,0 +1,209 @@
+// 3nigm4 storageclient package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 15/08/2016
+
+package storageclient
+
+// Std golang dependencies.
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Internal dependencies
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+// mockSession simulates the service session management: only
+// the actual token is accepted and it can be refreshed.
+type mockSession struct {
+	mtx      sync.Mutex
+	token    string
+	refresh  int
+	expired  bool
+	jobCalls int
+}
+
+func (m *mockSession) handler(w http.ResponseWriter, r *http.Request) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	token := r.Header.Get(ct.SecurityTokenKey)
+	if token != m.token ||
+		m.expired {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(
+			ct.StandardResponse{
+				Status: ct.NakResponse,
+				Error:  "invalid session",
+			})
+		return
+	}
+	switch {
+	case r.Method == "PUT" &&
+		r.URL.Path == authsessionPath:
+		id, err := randomID()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		m.token = id
+		m.refresh++
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(
+			ct.LoginResponse{
+				Token: m.token,
+			})
+	case r.Method == "POST" &&
+		r.URL.Path == jobPath:
+		m.jobCalls++
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(
+			&ct.JobPostResponse{
+				JobID: "jobid",
+			})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestStaticCredentials(t *testing.T) {
+	credentials := StaticCredentials(testToken)
+	token, err := credentials.Token()
+	if err != nil {
+		t.Fatalf("Unable to get token: %s.\n", err.Error())
+	}
+	if token != testToken {
+		t.Fatalf("Unexpected token: having %s expecting %s.\n", token, testToken)
+	}
+	_, err = credentials.Renew(token)
+	if err == nil {
+		t.Fatalf("Static credentials should never be renewed.\n")
+	}
+}
+
+func TestSessionCredentialsRenewOnReject(t *testing.T) {
+	session := &mockSession{
+		token: testToken,
+	}
+	server := httptest.NewServer(http.HandlerFunc(session.handler))
+	defer server.Close()
+	addr, port := extractAddressAndPort(server.URL, t)
+
+	credentials := NewSessionCredentials(addr, port, testToken, 0, nil)
+	var persisted string
+	credentials.OnRenew = func(token string) {
+		persisted = token
+	}
+	sc, err, _ := NewStorageClientWithCredentials(addr, port, credentials, 1, 1)
+	if err != nil {
+		t.Fatalf("Unable to create a new StorageClient instance: %s.\n", err.Error())
+	}
+	defer sc.Close()
+
+	// the service rotates the token behind the client
+	session.mtx.Lock()
+	session.token = "rotatedtoken"
+	session.mtx.Unlock()
+	_, err = postGenericJob(&jobArgs{client: sc, args: &ct.CommandArguments{}}, "UPLOAD")
+	if err == nil {
+		t.Fatalf("Request should fail when the session can not be refreshed.\n")
+	}
+	if persisted != "" {
+		t.Fatalf("Token should not be renewed, having %s.\n", persisted)
+	}
+
+	// restore the original token: the first request is accepted
+	session.mtx.Lock()
+	session.token = testToken
+	session.mtx.Unlock()
+	_, err = postGenericJob(&jobArgs{client: sc, args: &ct.CommandArguments{}}, "UPLOAD")
+	if err != nil {
+		t.Fatalf("Unable to post job: %s.\n", err.Error())
+	}
+	if session.refresh != 0 {
+		t.Fatalf("Unexpected refresh calls: having %d expecting 0.\n", session.refresh)
+	}
+
+	// force renew: the rejected token is refreshed and the request
+	// retried
+	token, err := credentials.Renew(testToken)
+	if err != nil {
+		t.Fatalf("Unable to renew token: %s.\n", err.Error())
+	}
+	if token == testToken ||
+		token != persisted {
+		t.Fatalf("Unexpected renewed token %s (persisted %s).\n", token, persisted)
+	}
+	// a concurrent renew of an old token returns the actual one
+	again, err := credentials.Renew(testToken)
+	if err != nil {
+		t.Fatalf("Unable to renew token: %s.\n", err.Error())
+	}
+	if again != token ||
+		session.refresh != 1 {
+		t.Fatalf("Token should not be refreshed twice: having %d refresh calls.\n", session.refresh)
+	}
+}
+
+func TestSessionCredentialsLoginFallback(t *testing.T) {
+	session := &mockSession{
+		token: testToken,
+	}
+	server := httptest.NewServer(http.HandlerFunc(session.handler))
+	defer server.Close()
+	addr, port := extractAddressAndPort(server.URL, t)
+
+	logins := 0
+	login := func() (string, error) {
+		session.mtx.Lock()
+		defer session.mtx.Unlock()
+		logins++
+		session.token = "newsessiontoken"
+		session.expired = false
+		return session.token, nil
+	}
+	credentials := NewSessionCredentials(addr, port, testToken, time.Millisecond, login)
+	sc, err, _ := NewStorageClientWithCredentials(addr, port, credentials, 1, 1)
+	if err != nil {
+		t.Fatalf("Unable to create a new StorageClient instance: %s.\n", err.Error())
+	}
+	defer sc.Close()
+
+	// wait renew interval to proactively refresh the token
+	time.Sleep(5 * time.Millisecond)
+	_, err = postGenericJob(&jobArgs{client: sc, args: &ct.CommandArguments{}}, "UPLOAD")
+	if err != nil {
+		t.Fatalf("Unable to post job: %s.\n", err.Error())
+	}
+	if session.refresh != 1 {
+		t.Fatalf("Unexpected refresh calls: having %d expecting 1.\n", session.refresh)
+	}
+
+	// expired session: refresh fails and login is used
+	session.mtx.Lock()
+	session.expired = true
+	session.mtx.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	_, err = postGenericJob(&jobArgs{client: sc, args: &ct.CommandArguments{}}, "UPLOAD")
+	if err != nil {
+		t.Fatalf("Unable to post job: %s.\n", err.Error())
+	}
+	if logins != 1 {
+		t.Fatalf("Unexpected login calls: having %d expecting 1.\n", logins)
+	}
+	if session.jobCalls != 2 {
+		t.Fatalf("Unexpected job calls: having %d expecting 2.\n", session.jobCalls)
+	}
+}
+
+func TestNilCredentials(t *testing.T) {
+	_, err, _ := NewStorageClientWithCredentials("http://127.0.0.1", 8080, nil, 1, 1)
+	if err == nil {
+		t.Fatalf("Nil credentials should be rejected.\n")
+	}
+}
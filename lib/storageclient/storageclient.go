@@ -10,11 +10,17 @@ package storageclient
 // Std golang dependencies.
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"reflect"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -26,17 +32,29 @@ import (
 )
 
 const (
-	jobPath     = "/v1/storage/job"
-	verifySleep = 500 * time.Millisecond
+	jobPath       = "/v1/storage/job"
+	batchPath     = "/v1/storage/batch"
+	chunkPath     = "/v1/storage/chunk"
+	renewPath     = "/v1/storage/renew"
+	usagePath     = "/v1/storage/usage"
+	aclPath       = "/v1/storage/acl"
+	sharedPath    = "/v1/storage/shared"
+	linkPath      = "/v1/storage/link"
+	resourcesPath = "/v1/storage/resources"
+	auditPath     = "/v1/storage/audit"
+	verifySleep   = 500 * time.Millisecond
 )
 
 // StorageClient is the base structure used to implement the
 // interface methods.
 type StorageClient struct {
 	// service coordinates
-	address string
-	port    int
-	token   string
+	address     string
+	port        int
+	credentials CredentialsProvider
+	link        string
+	batchSize   int
+	workers     int
 	// working queue
 	workingQueue *wq.WorkingQueue
 	ErrorChan    chan error
@@ -50,17 +68,40 @@ type StorageClient struct {
 // NewStorageClient creates a new StorageClient structure and
 // setup all required properties. It'll start the working queue
 // that'll be used to enqueue http API facing jobs. The returned
-// read only chan must be used to check for client errors.
+// read only chan must be used to check for client errors. The
+// passed token is used as is and never renewed, use
+// NewStorageClientWithCredentials to keep the session alive.
 func NewStorageClient(
 	address string,
 	port int,
 	token string,
 	workersize, queuesize int) (*StorageClient, error, <-chan error) {
+	return NewStorageClientWithCredentials(
+		address,
+		port,
+		StaticCredentials(token),
+		workersize,
+		queuesize)
+}
+
+// NewStorageClientWithCredentials creates a new StorageClient
+// obtaining session tokens from the passed credentials provider:
+// rejected tokens are renewed and the request retried once.
+func NewStorageClientWithCredentials(
+	address string,
+	port int,
+	credentials CredentialsProvider,
+	workersize, queuesize int) (*StorageClient, error, <-chan error) {
+	if credentials == nil {
+		return nil, fmt.Errorf("invalid nil credentials provider"), nil
+	}
 	// creates base object
 	sc := &StorageClient{
 		address:      address,
 		port:         port,
-		token:        token,
+		credentials:  credentials,
+		batchSize:    defaultBatchSize,
+		workers:      workersize,
 		ErrorChan:    make(chan error, workersize),
 		downloadChan: make(chan ct.OpResult, workersize),
 		uplaodChan:   make(chan ct.OpResult, workersize),
@@ -78,6 +119,30 @@ func NewStorageClient(
 	return sc, nil, sc.ErrorChan
 }
 
+// NewStorageClientWithLink creates a new StorageClient that
+// downloads resources using an anonymous download link token
+// instead of a session: only RetrieveChunks is usable with it.
+func NewStorageClientWithLink(
+	address string,
+	port int,
+	link string,
+	workersize, queuesize int) (*StorageClient, error, <-chan error) {
+	if link == "" {
+		return nil, fmt.Errorf("invalid empty download link"), nil
+	}
+	sc, err, errc := NewStorageClientWithCredentials(
+		address,
+		port,
+		StaticCredentials(""),
+		workersize,
+		queuesize)
+	if err != nil {
+		return nil, err, nil
+	}
+	sc.link = link
+	return sc, nil, errc
+}
+
 // Close close the active working queue.
 func (s *StorageClient) Close() {
 	s.workingQueue.Close()
@@ -86,9 +151,10 @@ func (s *StorageClient) Close() {
 // jobArgs standard job arguments passed to concurrent jobs while
 // adding them to the working queue instance.
 type jobArgs struct {
-	client    *StorageClient
-	args      *ct.CommandArguments
-	requestID string
+	client        *StorageClient
+	args          *ct.CommandArguments
+	requestID     string
+	transferToken string // optional, obtained with a batch.
 }
 
 // checkRequestStatus check request status and if an anomalous
@@ -110,6 +176,94 @@ func checkRequestStatus(statushttp, expected int, body []byte) error {
 	return nil
 }
 
+// doRequest executes an http request adding the passed headers.
+// Returns the response (whose body has been already consumed) and
+// the read body.
+func doRequest(method, url string, body []byte, header http.Header) (*http.Response, []byte, error) {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, reqBody)
+	if err != nil {
+		return nil, nil, err
+	}
+	for key, values := range header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	// execute request
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	// get body
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp.Body.Close()
+	return resp, respBody, nil
+}
+
+// doAuthenticatedRequest executes an http request adding the session
+// token obtained from the client credentials provider and the passed
+// headers. If the service rejects the token (401 status code) it's
+// renewed and the request retried once. Returns the response (whose
+// body has been already consumed) and the read body.
+func doAuthenticatedRequest(sc *StorageClient, method, url string, body []byte, header http.Header) (*http.Response, []byte, error) {
+	token, err := sc.credentials.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	for retry := 0; ; retry++ {
+		authHeader := http.Header{}
+		for key, values := range header {
+			authHeader[key] = values
+		}
+		if token != "" {
+			authHeader.Set(ct.SecurityTokenKey, token)
+		}
+		resp, respBody, err := doRequest(method, url, body, authHeader)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized &&
+			retry == 0 {
+			token, err = sc.credentials.Renew(token)
+			if err != nil {
+				// return the original service response
+				return resp, respBody, nil
+			}
+			continue
+		}
+		return resp, respBody, nil
+	}
+}
+
+// doChunkRequest executes a chunk transfer request authorising it
+// with the job transfer token, if available, otherwise with the
+// session token. Rejected transfer tokens (i.e. expired) are not
+// fatal: the request is retried using the session.
+func doChunkRequest(arguments *jobArgs, method, url string, body []byte, header http.Header) (*http.Response, []byte, error) {
+	if arguments.transferToken != "" {
+		transferHeader := http.Header{}
+		for key, values := range header {
+			transferHeader[key] = values
+		}
+		transferHeader.Set(ct.TransferTokenKey, arguments.transferToken)
+		resp, respBody, err := doRequest(method, url, body, transferHeader)
+		if err != nil ||
+			resp.StatusCode != http.StatusUnauthorized {
+			return resp, respBody, err
+		}
+	}
+	return doAuthenticatedRequest(arguments.client, method, url, body, header)
+}
+
 // postGenericJob implement a generic POST job operation, can be used for
 // any available command.
 func postGenericJob(arguments *jobArgs, command string) (*ct.JobPostResponse, error) {
@@ -123,28 +277,16 @@ func postGenericJob(arguments *jobArgs, command string) (*ct.JobPostResponse, er
 		return nil, err
 	}
 
-	// create http request
-	client := &http.Client{}
-	req, err := http.NewRequest(
+	// execute http request
+	resp, respBody, err := doAuthenticatedRequest(
+		arguments.client,
 		"POST",
 		fmt.Sprintf("%s:%d%s", arguments.client.address, arguments.client.port, jobPath),
-		bytes.NewBuffer(body))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set(ct.SecurityTokenKey, arguments.client.token)
-	// execute request
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	// get body
-	respBody, err := ioutil.ReadAll(resp.Body)
+		body,
+		nil)
 	if err != nil {
 		return nil, err
 	}
-	resp.Body.Close()
 
 	// check for errors
 	err = checkRequestStatus(resp.StatusCode, http.StatusAccepted, respBody)
@@ -165,28 +307,19 @@ func postGenericJob(arguments *jobArgs, command string) (*ct.JobPostResponse, er
 // request.
 func getGenericJob(arguments *jobArgs, jobID string) (*ct.JobGetRequest, error) {
 	for {
-		client := &http.Client{}
-		req, err := http.NewRequest(
+		resp, getBody, err := doAuthenticatedRequest(
+			arguments.client,
 			"GET",
 			fmt.Sprintf("%s:%d%s/%s",
 				arguments.client.address,
 				arguments.client.port,
 				jobPath,
 				jobID),
+			nil,
 			nil)
 		if err != nil {
 			return nil, err
 		}
-		req.Header.Set(ct.SecurityTokenKey, arguments.client.token)
-		resp, err := client.Do(req)
-		if err != nil {
-			return nil, err
-		}
-		getBody, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return nil, err
-		}
-		resp.Body.Close()
 
 		switch resp.StatusCode {
 		case http.StatusAccepted:
@@ -216,6 +349,101 @@ func getGenericJob(arguments *jobArgs, jobID string) (*ct.JobGetRequest, error)
 	}
 }
 
+// putChunk streams a chunk to the storage service as a raw body
+// passing upload arguments and the data checksum in the headers:
+// the service verifies data against it before storing them and
+// acknowledges the stored checksum.
+func putChunk(arguments *jobArgs) error {
+	checksum := arguments.args.CheckSum
+	if checksum == nil {
+		digest := sha256.Sum256(arguments.args.Data)
+		checksum = digest[:]
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	header.Set(ct.CheckSumKey, hex.EncodeToString(checksum))
+	header.Set(ct.PermissionKey, strconv.Itoa(int(arguments.args.Permission)))
+	if arguments.args.TimeToLive != 0 {
+		header.Set(ct.TimeToLiveKey, arguments.args.TimeToLive.String())
+	}
+	if len(arguments.args.SharingUsers) != 0 {
+		header.Set(ct.SharingUsersKey, strings.Join(arguments.args.SharingUsers, ","))
+	}
+
+	resp, respBody, err := doChunkRequest(
+		arguments,
+		"PUT",
+		fmt.Sprintf("%s:%d%s/%s",
+			arguments.client.address,
+			arguments.client.port,
+			chunkPath,
+			arguments.args.ResourceID),
+		arguments.args.Data,
+		header)
+	if err != nil {
+		return err
+	}
+	err = checkRequestStatus(resp.StatusCode, http.StatusCreated, respBody)
+	if err != nil {
+		return err
+	}
+
+	// verify acknowledged checksum
+	if resp.Header.Get(ct.CheckSumKey) != hex.EncodeToString(checksum) {
+		return fmt.Errorf("checksum not acknowledged for uploaded chunk %s", arguments.args.ResourceID)
+	}
+	return nil
+}
+
+// getChunk retrieves a chunk from the storage service verifying
+// the returned data against the checksum header. If the client
+// has been created with a download link the anonymous route is
+// used.
+func getChunk(arguments *jobArgs) ([]byte, error) {
+	url := fmt.Sprintf("%s:%d%s/%s",
+		arguments.client.address,
+		arguments.client.port,
+		chunkPath,
+		arguments.args.ResourceID)
+	// anonymous download link
+	if arguments.client.link != "" {
+		url = fmt.Sprintf("%s:%d%s/%s/%s",
+			arguments.client.address,
+			arguments.client.port,
+			linkPath,
+			arguments.client.link,
+			arguments.args.ResourceID)
+	}
+	resp, data, err := doChunkRequest(
+		arguments,
+		"GET",
+		url,
+		nil,
+		nil)
+	if err != nil {
+		return nil, err
+	}
+	err = checkRequestStatus(resp.StatusCode, http.StatusOK, data)
+	if err != nil {
+		return nil, err
+	}
+
+	// verify checksum
+	header := resp.Header.Get(ct.CheckSumKey)
+	if header == "" {
+		return nil, fmt.Errorf("missing checksum for downloaded chunk %s", arguments.args.ResourceID)
+	}
+	expected, err := hex.DecodeString(header)
+	if err != nil {
+		return nil, fmt.Errorf("malformed checksum header: %s", err.Error())
+	}
+	checksum := sha256.Sum256(data)
+	if !bytes.Equal(checksum[:], expected) {
+		return nil, fmt.Errorf("checksum mismatch for downloaded chunk %s", arguments.args.ResourceID)
+	}
+	return data, nil
+}
+
 // upload the job that'll be enqueued in the working queue to perform
 // an upload.
 func upload(a interface{}) error {
@@ -227,8 +455,8 @@ func upload(a interface{}) error {
 		return fmt.Errorf("unexpected argument type, having %s expecting *jobArgs", reflect.TypeOf(a))
 	}
 
-	// perform generic post
-	postResponse, err := postGenericJob(arguments, "UPLOAD")
+	// stream chunk to the service
+	err := putChunk(arguments)
 	if err != nil {
 		arguments.client.uplaodChan <- ct.OpResult{
 			RequestID: arguments.requestID,
@@ -238,25 +466,6 @@ func upload(a interface{}) error {
 		return err
 	}
 
-	// loop to verify succesfull request
-	getResponse, err := getGenericJob(arguments, postResponse.JobID)
-	if err != nil {
-		arguments.client.uplaodChan <- ct.OpResult{
-			RequestID: arguments.requestID,
-			ID:        arguments.args.ResourceID,
-			Error:     err,
-		}
-		return err
-	}
-	if getResponse.Error != "" {
-		arguments.client.uplaodChan <- ct.OpResult{
-			RequestID: arguments.requestID,
-			ID:        arguments.args.ResourceID,
-			Error:     fmt.Errorf("%s", getResponse.Error),
-		}
-		return err
-	}
-
 	arguments.client.uplaodChan <- ct.OpResult{
 		RequestID: arguments.requestID,
 		ID:        arguments.args.ResourceID,
@@ -275,19 +484,8 @@ func download(a interface{}) error {
 		return fmt.Errorf("unexpected argument type, having %s expecting *jobArgs", reflect.TypeOf(a))
 	}
 
-	// perform generic post
-	postResponse, err := postGenericJob(arguments, "DOWNLOAD")
-	if err != nil {
-		arguments.client.downloadChan <- ct.OpResult{
-			RequestID: arguments.requestID,
-			ID:        arguments.args.ResourceID,
-			Error:     err,
-		}
-		return err
-	}
-
-	// loop to verify succesfull request
-	getResponse, err := getGenericJob(arguments, postResponse.JobID)
+	// retrieve chunk from the service
+	data, err := getChunk(arguments)
 	if err != nil {
 		arguments.client.downloadChan <- ct.OpResult{
 			RequestID: arguments.requestID,
@@ -296,19 +494,11 @@ func download(a interface{}) error {
 		}
 		return err
 	}
-	if getResponse.Error != "" {
-		arguments.client.downloadChan <- ct.OpResult{
-			RequestID: arguments.requestID,
-			ID:        arguments.args.ResourceID,
-			Error:     fmt.Errorf("%s", getResponse.Error),
-		}
-		return err
-	}
 
 	arguments.client.downloadChan <- ct.OpResult{
 		RequestID: arguments.requestID,
 		ID:        arguments.args.ResourceID,
-		Data:      getResponse.Data,
+		Data:      data,
 	}
 	return nil
 }
@@ -378,6 +568,7 @@ func (s *StorageClient) SaveChunks(filename string, chunks [][]byte, hashedValue
 	s.requests[requestID] = NewRequestStatus(requestID, len(chunks))
 
 	paths := make([]string, len(chunks))
+	resources := make([]*ct.CommandArguments, len(chunks))
 	for idx, chunk := range chunks {
 		id, err := fm.ChunkFileId(filename, idx, hashedValue)
 		if err != nil {
@@ -385,30 +576,27 @@ func (s *StorageClient) SaveChunks(filename string, chunks [][]byte, hashedValue
 		}
 
 		// create args struct
+		checksum := sha256.Sum256(chunk)
 		commandArgs := &ct.CommandArguments{
 			ResourceID: id,
 			Data:       chunk,
+			CheckSum:   checksum[:],
 			TimeToLive: expire,
 		}
 		if permission != nil {
 			commandArgs.Permission = permission.Permission
 			commandArgs.SharingUsers = permission.SharingUsers
 		}
-		ja := &jobArgs{
-			client:    s,
-			args:      commandArgs,
-			requestID: requestID,
-		}
 		// add nil record to request status
 		err = s.requests[requestID].SetStatus(id, false, nil)
 		if err != nil {
 			return nil, err
 		}
-
-		// enqueue on working queue
-		s.workingQueue.SendJob(upload, ja)
+		resources[idx] = commandArgs
 		paths[idx] = id
 	}
+	// enqueue on working queue
+	s.enqueue("UPLOAD", requestID, resources)
 
 	// wait for upload to complete
 	for {
@@ -438,23 +626,19 @@ func (s *StorageClient) RetrieveChunks(filename string, files []string, operatio
 	}
 	s.requests[requestID] = NewRequestStatus(requestID, len(files))
 
-	for _, id := range files {
-		ja := &jobArgs{
-			client: s,
-			args: &ct.CommandArguments{
-				ResourceID: id,
-			},
-			requestID: requestID,
-		}
+	resources := make([]*ct.CommandArguments, len(files))
+	for idx, id := range files {
 		// add nil record to request status
 		err := s.requests[requestID].SetStatus(id, false, nil)
 		if err != nil {
 			return nil, err
 		}
-
-		// enqueue on working queue
-		s.workingQueue.SendJob(download, ja)
+		resources[idx] = &ct.CommandArguments{
+			ResourceID: id,
+		}
 	}
+	// enqueue on working queue
+	s.enqueue("DOWNLOAD", requestID, resources)
 
 	// wait for download to complete
 	for {
@@ -507,23 +691,19 @@ func (s *StorageClient) DeleteChunks(filename string, files []string, operationI
 	}
 	s.requests[requestID] = NewRequestStatus(requestID, len(files))
 
-	for _, id := range files {
-		ja := &jobArgs{
-			client: s,
-			args: &ct.CommandArguments{
-				ResourceID: id,
-			},
-			requestID: requestID,
-		}
+	resources := make([]*ct.CommandArguments, len(files))
+	for idx, id := range files {
 		// add nil record to request status
 		err := s.requests[requestID].SetStatus(id, false, nil)
 		if err != nil {
 			return err
 		}
-
-		// enqueue on working queue
-		s.workingQueue.SendJob(remove, ja)
+		resources[idx] = &ct.CommandArguments{
+			ResourceID: id,
+		}
 	}
+	// enqueue on working queue
+	s.enqueue("DELETE", requestID, resources)
 
 	// wait for download to complete
 	for {
@@ -564,3 +744,287 @@ func (s *StorageClient) ProgressStatus(requestID fm.ContextID) (fm.ProgressStatu
 	}
 	return &value.Progress, nil
 }
+
+// RenewChunks extends, requiring the API frontend, the time to live
+// of all resources composing a file: the new expiration time is
+// computed starting from the request time. Only resources owned by
+// the caller can be renewed. Returns the new expiration time or an
+// error composed by all resources not renewed.
+func (s *StorageClient) RenewChunks(files []string, ttl time.Duration) (time.Time, error) {
+	if len(files) == 0 {
+		return time.Time{}, fmt.Errorf("no resources to renew")
+	}
+	body, err := json.Marshal(&ct.RenewRequest{
+		ResourceIDs: files,
+		TimeToLive:  ttl,
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	resp, respBody, err := doAuthenticatedRequest(
+		s,
+		"POST",
+		fmt.Sprintf("%s:%d%s",
+			s.address,
+			s.port,
+			renewPath),
+		body,
+		header)
+	if err != nil {
+		return time.Time{}, err
+	}
+	err = checkRequestStatus(resp.StatusCode, http.StatusOK, respBody)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var response ct.RenewResponse
+	err = json.Unmarshal(respBody, &response)
+	if err != nil {
+		return time.Time{}, err
+	}
+	// if any error found return a composed error
+	if len(response.Failed) != 0 {
+		errors := make(map[string]error)
+		for id, description := range response.Failed {
+			errors[id] = fmt.Errorf("%s", description)
+		}
+		return response.Expiration, composedError(errors)
+	}
+	return response.Expiration, nil
+}
+
+// Usage returns, requiring the API frontend, the storage usage
+// of the logged in user and the enforced quotas.
+func (s *StorageClient) Usage() (*ct.UsageResponse, error) {
+	resp, respBody, err := doAuthenticatedRequest(
+		s,
+		"GET",
+		fmt.Sprintf("%s:%d%s",
+			s.address,
+			s.port,
+			usagePath),
+		nil,
+		nil)
+	if err != nil {
+		return nil, err
+	}
+	err = checkRequestStatus(resp.StatusCode, http.StatusOK, respBody)
+	if err != nil {
+		return nil, err
+	}
+	var usage ct.UsageResponse
+	err = json.Unmarshal(respBody, &usage)
+	if err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// UpdateAcl updates, requiring the API frontend, the access
+// permissions of all resources composing a file: if not nil the
+// permission type is replaced and the argument users are added
+// and removed from the sharing list. Only resources owned by the
+// caller can be updated.
+func (s *StorageClient) UpdateAcl(files []string, permission *ct.Permission, addUsers, removeUsers []string) error {
+	if len(files) == 0 {
+		return fmt.Errorf("no resources to update")
+	}
+	body, err := json.Marshal(&ct.AclRequest{
+		ResourceIDs: files,
+		Permission:  permission,
+		AddUsers:    addUsers,
+		RemoveUsers: removeUsers,
+	})
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	resp, respBody, err := doAuthenticatedRequest(
+		s,
+		"PUT",
+		fmt.Sprintf("%s:%d%s",
+			s.address,
+			s.port,
+			aclPath),
+		body,
+		header)
+	if err != nil {
+		return err
+	}
+	err = checkRequestStatus(resp.StatusCode, http.StatusOK, respBody)
+	if err != nil {
+		return err
+	}
+	var response ct.AclResponse
+	err = json.Unmarshal(respBody, &response)
+	if err != nil {
+		return err
+	}
+	// if any error found return a composed error
+	if len(response.Failed) != 0 {
+		errors := make(map[string]error)
+		for id, description := range response.Failed {
+			errors[id] = fmt.Errorf("%s", description)
+		}
+		return composedError(errors)
+	}
+	return nil
+}
+
+// SharedResources returns, requiring the API frontend, the
+// resources other users have shared with the logged in user.
+func (s *StorageClient) SharedResources() ([]ct.ResourceInfo, error) {
+	resp, respBody, err := doAuthenticatedRequest(
+		s,
+		"GET",
+		fmt.Sprintf("%s:%d%s",
+			s.address,
+			s.port,
+			sharedPath),
+		nil,
+		nil)
+	if err != nil {
+		return nil, err
+	}
+	err = checkRequestStatus(resp.StatusCode, http.StatusOK, respBody)
+	if err != nil {
+		return nil, err
+	}
+	var response ct.SharedResponse
+	err = json.Unmarshal(respBody, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Resources, nil
+}
+
+// CreateLink creates, requiring the API frontend, an anonymous
+// download link for all resources composing a file: resources
+// must be owned by the caller and Public. If singleUse is true
+// each resource can be downloaded only once. Returns the signed
+// link token and its expiration time.
+func (s *StorageClient) CreateLink(files []string, ttl time.Duration, singleUse bool) (string, time.Time, error) {
+	if len(files) == 0 {
+		return "", time.Time{}, fmt.Errorf("no resources to link")
+	}
+	body, err := json.Marshal(&ct.LinkRequest{
+		ResourceIDs: files,
+		TimeToLive:  ttl,
+		SingleUse:   singleUse,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	resp, respBody, err := doAuthenticatedRequest(
+		s,
+		"POST",
+		fmt.Sprintf("%s:%d%s",
+			s.address,
+			s.port,
+			linkPath),
+		body,
+		header)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	err = checkRequestStatus(resp.StatusCode, http.StatusCreated, respBody)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	var response ct.LinkResponse
+	err = json.Unmarshal(respBody, &response)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return response.Token, response.Expiration, nil
+}
+
+// ListResources returns, requiring the API frontend, a page of the
+// resources owned by the logged in user sorted by id. The after
+// argument is the cursor of the previous page (empty for the first
+// one) while limit is the page size (zero for the server default).
+// The returned cursor is empty when no more pages are available.
+func (s *StorageClient) ListResources(after string, limit int) ([]ct.ResourceInfo, string, error) {
+	query := url.Values{}
+	if after != "" {
+		query.Set("after", after)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	path := fmt.Sprintf("%s:%d%s",
+		s.address,
+		s.port,
+		resourcesPath)
+	if len(query) != 0 {
+		path += "?" + query.Encode()
+	}
+	resp, respBody, err := doAuthenticatedRequest(
+		s,
+		"GET",
+		path,
+		nil,
+		nil)
+	if err != nil {
+		return nil, "", err
+	}
+	err = checkRequestStatus(resp.StatusCode, http.StatusOK, respBody)
+	if err != nil {
+		return nil, "", err
+	}
+	var response ct.ResourcesResponse
+	err = json.Unmarshal(respBody, &response)
+	if err != nil {
+		return nil, "", err
+	}
+	return response.Resources, response.Next, nil
+}
+
+// AuditEvents returns, requiring the API frontend, the audit events
+// about resources owned by the logged in user, most recent first. If
+// resource is not empty only events related to it are returned,
+// since, if not zero, excludes older events and limit, if not zero,
+// defines the maximum number of returned events.
+func (s *StorageClient) AuditEvents(resource string, since time.Time, limit int) ([]ct.AuditEvent, error) {
+	query := url.Values{}
+	if resource != "" {
+		query.Set("resource", resource)
+	}
+	if !since.IsZero() {
+		query.Set("since", since.Format(time.RFC3339))
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	path := fmt.Sprintf("%s:%d%s",
+		s.address,
+		s.port,
+		auditPath)
+	if len(query) != 0 {
+		path += "?" + query.Encode()
+	}
+	resp, respBody, err := doAuthenticatedRequest(
+		s,
+		"GET",
+		path,
+		nil,
+		nil)
+	if err != nil {
+		return nil, err
+	}
+	err = checkRequestStatus(resp.StatusCode, http.StatusOK, respBody)
+	if err != nil {
+		return nil, err
+	}
+	var response ct.AuditResponse
+	err = json.Unmarshal(respBody, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Events, nil
+}
@@ -7,13 +7,19 @@ package storageclient
 // Std golang dependencies.
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 // Internal dependencies
@@ -75,6 +81,33 @@ func mockUploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	switch {
+	case r.Method == "PUT" &&
+		strings.HasPrefix(r.URL.Path, chunkPath):
+		// get message BODY
+		buf := new(bytes.Buffer)
+		buf.ReadFrom(r.Body)
+		body := buf.Bytes()
+		checksum := sha256.Sum256(body)
+		if r.Header.Get(ct.CheckSumKey) != hex.EncodeToString(checksum[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(
+				ct.StandardResponse{
+					Status: ct.NakResponse,
+					Error:  "checksum mismatch",
+				})
+			return
+		}
+		resourceID := strings.TrimPrefix(r.URL.Path, chunkPath+"/")
+		mockServiceStorage.mtx.Lock()
+		mockServiceStorage.storage[resourceID] = body
+		mockServiceStorage.mtx.Unlock()
+
+		w.Header().Set(ct.CheckSumKey, hex.EncodeToString(checksum[:]))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(
+			ct.StandardResponse{
+				Status: ct.AckResponse,
+			})
 	case r.Method == "GET":
 		delayCounters.initCounter(r.URL.Path)
 		// verify value
@@ -140,6 +173,26 @@ func mockDownladHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	switch {
+	case r.Method == "GET" &&
+		strings.HasPrefix(r.URL.Path, chunkPath):
+		resourceID := strings.TrimPrefix(r.URL.Path, chunkPath+"/")
+		mockServiceStorage.mtx.Lock()
+		data, ok := mockServiceStorage.storage[resourceID]
+		mockServiceStorage.mtx.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(
+				ct.StandardResponse{
+					Status: ct.NakResponse,
+					Error:  fmt.Sprintf("unable to find storage resource %s", resourceID),
+				})
+			return
+		}
+		checksum := sha256.Sum256(data)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set(ct.CheckSumKey, hex.EncodeToString(checksum[:]))
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
 	case r.Method == "GET":
 		delayCounters.initCounter(r.URL.Path)
 		// verify value
@@ -330,6 +383,8 @@ func TestUploadResources(t *testing.T) {
 		t.Fatalf("Unable to create a new StorageClient instance: %s.\n", err.Error())
 	}
 	defer sc.Close()
+	// mock servers implement single chunk routes
+	sc.SetBatchSize(1)
 
 	// manage errrors
 	errorCounter := wq.AtomicCounter{}
@@ -386,6 +441,8 @@ func TestDownloadResources(t *testing.T) {
 		t.Fatalf("Unable to create a new StorageClient instance: %s.\n", err.Error())
 	}
 	defer sc.Close()
+	// mock servers implement single chunk routes
+	sc.SetBatchSize(1)
 
 	// manage errrors
 	errorCounter := wq.AtomicCounter{}
@@ -429,6 +486,8 @@ func TestDeleteResources(t *testing.T) {
 		t.Fatalf("Unable to create a new StorageClient instance: %s.\n", err.Error())
 	}
 	defer sc.Close()
+	// mock servers implement single chunk routes
+	sc.SetBatchSize(1)
 
 	// manage errrors
 	errorCounter := wq.AtomicCounter{}
@@ -451,3 +510,405 @@ func TestDeleteResources(t *testing.T) {
 		t.Fatalf("Error counter is not nil, last error: %s.\n", lastError.Error())
 	}
 }
+
+func TestDownloadChunkCorrupted(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checksum := sha256.Sum256([]byte("original content"))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set(ct.CheckSumKey, hex.EncodeToString(checksum[:]))
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("corrupted content"))
+	}))
+	defer server.Close()
+	addr, port := extractAddressAndPort(server.URL, t)
+	sc, err, _ := NewStorageClient(addr, port, testToken, 1, 1)
+	if err != nil {
+		t.Fatalf("Unable to create a new StorageClient instance: %s.\n", err.Error())
+	}
+	defer sc.Close()
+
+	_, err = getChunk(&jobArgs{
+		client: sc,
+		args: &ct.CommandArguments{
+			ResourceID: "corrupted",
+		},
+	})
+	if err == nil {
+		t.Fatalf("Corrupted chunk should be rejected.\n")
+	}
+}
+
+func TestChunkChecksumMissing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(
+				ct.StandardResponse{
+					Status: ct.AckResponse,
+				})
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("unverified content"))
+	}))
+	defer server.Close()
+	addr, port := extractAddressAndPort(server.URL, t)
+	sc, err, _ := NewStorageClient(addr, port, testToken, 1, 1)
+	if err != nil {
+		t.Fatalf("Unable to create a new StorageClient instance: %s.\n", err.Error())
+	}
+	defer sc.Close()
+
+	err = putChunk(&jobArgs{
+		client: sc,
+		args: &ct.CommandArguments{
+			ResourceID: "unacknowledged",
+			Data:       []byte("unverified content"),
+		},
+	})
+	if err == nil {
+		t.Fatalf("Not acknowledged upload should be rejected.\n")
+	}
+	_, err = getChunk(&jobArgs{
+		client: sc,
+		args: &ct.CommandArguments{
+			ResourceID: "unverified",
+		},
+	})
+	if err == nil {
+		t.Fatalf("Chunk without checksum should be rejected.\n")
+	}
+}
+
+func TestRenewResources(t *testing.T) {
+	expiration := time.Now().Add(time.Hour).UTC()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != renewPath ||
+			r.Method != "POST" ||
+			checkTokenPresence(r) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		var request ct.RenewRequest
+		err := json.Unmarshal(body, &request)
+		if err != nil ||
+			request.TimeToLive != time.Hour {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		response := ct.RenewResponse{
+			Expiration: expiration,
+			Failed:     make(map[string]string),
+		}
+		for _, id := range request.ResourceIDs {
+			if strings.HasPrefix(id, "missing") {
+				response.Failed[id] = "requested file not found"
+				continue
+			}
+			response.Renewed = append(response.Renewed, id)
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(&response)
+	}))
+	defer server.Close()
+	addr, port := extractAddressAndPort(server.URL, t)
+	sc, err, _ := NewStorageClient(addr, port, testToken, 1, 1)
+	if err != nil {
+		t.Fatalf("Unable to create a new StorageClient instance: %s.\n", err.Error())
+	}
+	defer sc.Close()
+
+	renewed, err := sc.RenewChunks([]string{"chunk1", "chunk2"}, time.Hour)
+	if err != nil {
+		t.Fatalf("Unable to renew resources: %s.\n", err.Error())
+	}
+	if !renewed.Equal(expiration) {
+		t.Fatalf("Unexpected expiration: having %s expecting %s.\n", renewed.String(), expiration.String())
+	}
+	_, err = sc.RenewChunks([]string{"chunk1", "missing1"}, time.Hour)
+	if err == nil {
+		t.Fatalf("Not renewed resources should return an error.\n")
+	}
+	_, err = sc.RenewChunks(nil, time.Hour)
+	if err == nil {
+		t.Fatalf("Empty resources should be rejected.\n")
+	}
+}
+
+func TestUsage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != usagePath ||
+			r.Method != "GET" ||
+			checkTokenPresence(r) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(&ct.UsageResponse{
+			Bytes:      1024,
+			Objects:    2,
+			QuotaBytes: 4096,
+		})
+	}))
+	defer server.Close()
+	addr, port := extractAddressAndPort(server.URL, t)
+	sc, err, _ := NewStorageClient(addr, port, testToken, 1, 1)
+	if err != nil {
+		t.Fatalf("Unable to create a new StorageClient instance: %s.\n", err.Error())
+	}
+	defer sc.Close()
+
+	usage, err := sc.Usage()
+	if err != nil {
+		t.Fatalf("Unable to get usage: %s.\n", err.Error())
+	}
+	if usage.Bytes != 1024 ||
+		usage.Objects != 2 ||
+		usage.QuotaBytes != 4096 ||
+		usage.QuotaObjects != 0 {
+		t.Fatalf("Unexpected usage: %v.\n", usage)
+	}
+}
+
+func TestAclResources(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if checkTokenPresence(r) != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.URL.Path == aclPath && r.Method == "PUT":
+			body, _ := ioutil.ReadAll(r.Body)
+			var request ct.AclRequest
+			err := json.Unmarshal(body, &request)
+			if err != nil ||
+				request.Permission == nil ||
+				*request.Permission != 1 ||
+				len(request.AddUsers) != 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			response := ct.AclResponse{
+				Failed: make(map[string]string),
+			}
+			for _, id := range request.ResourceIDs {
+				if strings.HasPrefix(id, "missing") {
+					response.Failed[id] = "requested file not found"
+					continue
+				}
+				response.Updated = append(response.Updated, id)
+			}
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(&response)
+		case r.URL.Path == sharedPath && r.Method == "GET":
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(&ct.SharedResponse{
+				Resources: []ct.ResourceInfo{
+					ct.ResourceInfo{ID: "chunk1", Owner: "userB", Permission: 1},
+				},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	addr, port := extractAddressAndPort(server.URL, t)
+	sc, err, _ := NewStorageClient(addr, port, testToken, 1, 1)
+	if err != nil {
+		t.Fatalf("Unable to create a new StorageClient instance: %s.\n", err.Error())
+	}
+	defer sc.Close()
+
+	permission := ct.Permission(1)
+	err = sc.UpdateAcl([]string{"chunk1", "chunk2"}, &permission, []string{"userB"}, nil)
+	if err != nil {
+		t.Fatalf("Unable to update acl: %s.\n", err.Error())
+	}
+	err = sc.UpdateAcl([]string{"chunk1", "missing1"}, &permission, []string{"userB"}, nil)
+	if err == nil {
+		t.Fatalf("Not updated resources should return an error.\n")
+	}
+	resources, err := sc.SharedResources()
+	if err != nil {
+		t.Fatalf("Unable to list shared resources: %s.\n", err.Error())
+	}
+	if len(resources) != 1 ||
+		resources[0].ID != "chunk1" ||
+		resources[0].Owner != "userB" {
+		t.Fatalf("Unexpected shared resources: %v.\n", resources)
+	}
+}
+
+func TestListResources(t *testing.T) {
+	ids := []string{"chunk1", "chunk2", "chunk3"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if checkTokenPresence(r) != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != resourcesPath ||
+			r.Method != "GET" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		after := r.URL.Query().Get("after")
+		var response ct.ResourcesResponse
+		for _, id := range ids {
+			if id > after &&
+				len(response.Resources) < limit {
+				response.Resources = append(response.Resources, ct.ResourceInfo{ID: id})
+			}
+		}
+		if len(response.Resources) == limit {
+			response.Next = response.Resources[limit-1].ID
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(&response)
+	}))
+	defer server.Close()
+	addr, port := extractAddressAndPort(server.URL, t)
+	sc, err, _ := NewStorageClient(addr, port, testToken, 1, 1)
+	if err != nil {
+		t.Fatalf("Unable to create a new StorageClient instance: %s.\n", err.Error())
+	}
+	defer sc.Close()
+
+	var listed []string
+	next := ""
+	for {
+		resources, cursor, err := sc.ListResources(next, 2)
+		if err != nil {
+			t.Fatalf("Unable to list resources: %s.\n", err.Error())
+		}
+		for _, resource := range resources {
+			listed = append(listed, resource.ID)
+		}
+		if cursor == "" {
+			break
+		}
+		next = cursor
+	}
+	if !reflect.DeepEqual(listed, ids) {
+		t.Fatalf("Having resources %v expecting %v.\n", listed, ids)
+	}
+	// missing limit is rejected by the mock server
+	_, _, err = sc.ListResources("", 0)
+	if err == nil {
+		t.Fatalf("Unexpected success without limit.\n")
+	}
+}
+
+func TestAuditEvents(t *testing.T) {
+	since := time.Now().Add(-time.Hour)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if checkTokenPresence(r) != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != auditPath ||
+			r.Method != "GET" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		query := r.URL.Query()
+		if query.Get("resource") != "chunk1" ||
+			query.Get("since") != since.Format(time.RFC3339) ||
+			query.Get("limit") != "10" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(&ct.AuditResponse{
+			Events: []ct.AuditEvent{
+				ct.AuditEvent{Resource: "chunk1", User: "userB", Action: ct.AuditDownload, Outcome: ct.AuditDenied},
+			},
+		})
+	}))
+	defer server.Close()
+	addr, port := extractAddressAndPort(server.URL, t)
+	sc, err, _ := NewStorageClient(addr, port, testToken, 1, 1)
+	if err != nil {
+		t.Fatalf("Unable to create a new StorageClient instance: %s.\n", err.Error())
+	}
+	defer sc.Close()
+
+	events, err := sc.AuditEvents("chunk1", since, 10)
+	if err != nil {
+		t.Fatalf("Unable to get audit events: %s.\n", err.Error())
+	}
+	if len(events) != 1 ||
+		events[0].User != "userB" ||
+		events[0].Outcome != ct.AuditDenied {
+		t.Fatalf("Unexpected audit events: %v.\n", events)
+	}
+	_, err = sc.AuditEvents("chunk2", since, 10)
+	if err == nil {
+		t.Fatalf("Unexpected success with wrong parameters.\n")
+	}
+}
+
+func TestDownloadWithLink(t *testing.T) {
+	content := []byte("linked content")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == linkPath && r.Method == "POST":
+			if checkTokenPresence(r) != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(&ct.LinkResponse{
+				Token: "signedtoken",
+			})
+		case r.URL.Path == linkPath+"/signedtoken/chunk1" && r.Method == "GET":
+			if checkTokenPresence(r) == nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			checksum := sha256.Sum256(content)
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set(ct.CheckSumKey, hex.EncodeToString(checksum[:]))
+			w.WriteHeader(http.StatusOK)
+			w.Write(content)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	addr, port := extractAddressAndPort(server.URL, t)
+	sc, err, _ := NewStorageClient(addr, port, testToken, 1, 1)
+	if err != nil {
+		t.Fatalf("Unable to create a new StorageClient instance: %s.\n", err.Error())
+	}
+	defer sc.Close()
+	link, _, err := sc.CreateLink([]string{"chunk1"}, time.Hour, true)
+	if err != nil {
+		t.Fatalf("Unable to create link: %s.\n", err.Error())
+	}
+
+	lc, err, _ := NewStorageClientWithLink(addr, port, link, 1, 1)
+	if err != nil {
+		t.Fatalf("Unable to create a new StorageClient instance: %s.\n", err.Error())
+	}
+	defer lc.Close()
+	chunks, err := lc.RetrieveChunks(testFileName, []string{"chunk1"}, nil)
+	if err != nil {
+		t.Fatalf("Unable to retrieve chunks: %s.\n", err.Error())
+	}
+	if len(chunks) != 1 ||
+		bytes.Compare(chunks[0], content) != 0 {
+		t.Fatalf("Unexpected downloaded chunks: %v.\n", chunks)
+	}
+
+	_, err, _ = NewStorageClientWithLink(addr, port, "", 1, 1)
+	if err == nil {
+		t.Fatalf("Empty link should be rejected.\n")
+	}
+}
@@ -25,6 +25,9 @@ type worker struct {
 	errorChannel chan error
 	quit         chan bool
 	counter      AtomicCounter
+	started      AtomicCounter
+	busy         AtomicCounter
+	failed       AtomicCounter
 	id           int
 }
 
@@ -67,9 +70,14 @@ func (w *worker) start() {
 				if !jobcOk {
 					jobcClosed = true
 				} else {
-					// worker recived a job
+					// worker recived a job: busy is incremented
+					// first to never account it as idle.
+					w.busy.Add(1)
+					w.started.Add(1)
 					err := job.function(job.args)
+					w.busy.Add(-1)
 					if err != nil {
+						w.failed.Add(1)
 						w.errorChannel <- fmt.Errorf("unable to process job cause %s", err.Error())
 					} else {
 						go func() {
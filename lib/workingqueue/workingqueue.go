@@ -12,11 +12,32 @@
 // auto Ddos creating always new goroutines.
 package workingqueue
 
+// Std golang packages
+import (
+	"fmt"
+	"time"
+)
+
+// drainPollInterval is the interval used to verify if a draining
+// queue completed all its jobs.
+const drainPollInterval = 50 * time.Millisecond
+
 // WorkingQueue base struct used to
 // represent the working queue.
 type WorkingQueue struct {
-	jobQueue   chan job    // A buffered channel that we can send work requests on;
-	dispatcher *dispatcher // The work dispatcher.
+	jobQueue   chan job      // A buffered channel that we can send work requests on;
+	dispatcher *dispatcher   // The work dispatcher;
+	sent       AtomicCounter // The number of enqueued jobs.
+}
+
+// Stats is a snapshot of the working queue activity, it's
+// intended to be used for monitoring purposes.
+type Stats struct {
+	Workers   int   // number of workers;
+	Queued    int64 // jobs waiting for an available worker;
+	Busy      int64 // workers processing a job;
+	Processed int64 // successfully processed jobs;
+	Failed    int64 // jobs that returned an error.
 }
 
 // NewWorkingQueue creates a new wq initialising
@@ -42,6 +63,24 @@ func (w *WorkingQueue) Close() {
 	w.dispatcher.stop()
 }
 
+// Drain waits, up to the argument timeout, for all the enqueued
+// jobs to be processed. It's intended to be used, before closing
+// the queue, to gracefully stop producers.
+func (w *WorkingQueue) Drain(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		stats := w.Stats()
+		if stats.Queued == 0 &&
+			stats.Busy == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("unable to drain queue, %d queued and %d running jobs left", stats.Queued, stats.Busy)
+		}
+		time.Sleep(drainPollInterval)
+	}
+}
+
 // MessageCounter returns the number of produced
 // messages.
 func (w *WorkingQueue) MessageCounter() int64 {
@@ -52,6 +91,25 @@ func (w *WorkingQueue) MessageCounter() int64 {
 	return counter
 }
 
+// Stats returns the actual working queue activity metrics.
+func (w *WorkingQueue) Stats() Stats {
+	stats := Stats{
+		Workers: w.dispatcher.maxWorkers,
+	}
+	var started int64
+	for _, wrk := range w.dispatcher.workers {
+		if wrk == nil {
+			continue
+		}
+		started += wrk.started.Value()
+		stats.Busy += wrk.busy.Value()
+		stats.Processed += wrk.countedJobs()
+		stats.Failed += wrk.failed.Value()
+	}
+	stats.Queued = w.sent.Value() - started
+	return stats
+}
+
 // SendJob enqueue a new job in the
 // producer queue.
 func (w *WorkingQueue) SendJob(payload func(interface{}) error, arguments interface{}) {
@@ -59,6 +117,7 @@ func (w *WorkingQueue) SendJob(payload func(interface{}) error, arguments interf
 		function: payload,
 		args:     arguments,
 	}
+	w.sent.Add(1)
 	go func() {
 		w.jobQueue <- job
 	}()
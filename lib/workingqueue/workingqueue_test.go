@@ -0,0 +1,103 @@
+//
+// 3nigm4 workingqueue package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 06/03/2016
+//
+package workingqueue
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWorkingQueueStats(t *testing.T) {
+	errc := make(chan error, worksNumber)
+	queue := NewWorkingQueue(2, 10, errc)
+	if err := queue.Run(); err != nil {
+		t.Fatalf("Unable to run working queue: %s.\n", err.Error())
+	}
+	defer queue.Close()
+
+	// block workers until released
+	release := make(chan struct{})
+	blocking := func(args interface{}) error {
+		<-release
+		if args != nil {
+			return fmt.Errorf("failing job")
+		}
+		return nil
+	}
+	for idx := 0; idx < 6; idx++ {
+		var args interface{}
+		if idx%2 == 0 {
+			args = idx
+		}
+		queue.SendJob(blocking, args)
+	}
+
+	// wait for workers to be busy
+	var stats Stats
+	for retry := 0; retry < 100; retry++ {
+		stats = queue.Stats()
+		if stats.Busy == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats.Workers != 2 ||
+		stats.Busy != 2 ||
+		stats.Queued != 4 {
+		t.Fatalf("Unexpected stats with busy workers: %+v.\n", stats)
+	}
+
+	// release all jobs
+	close(release)
+	for retry := 0; retry < 100; retry++ {
+		stats = queue.Stats()
+		if stats.Processed+stats.Failed == 6 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats.Busy != 0 ||
+		stats.Queued != 0 ||
+		stats.Processed != 3 ||
+		stats.Failed != 3 {
+		t.Fatalf("Unexpected stats after processing: %+v.\n", stats)
+	}
+}
+
+func TestWorkingQueueDrain(t *testing.T) {
+	errc := make(chan error, worksNumber)
+	queue := NewWorkingQueue(2, 10, errc)
+	if err := queue.Run(); err != nil {
+		t.Fatalf("Unable to run working queue: %s.\n", err.Error())
+	}
+	defer queue.Close()
+
+	release := make(chan struct{})
+	var completed AtomicCounter
+	blocking := func(args interface{}) error {
+		<-release
+		completed.Add(1)
+		return nil
+	}
+	for idx := 0; idx < 4; idx++ {
+		queue.SendJob(blocking, nil)
+	}
+
+	// blocked jobs can not be drained
+	err := queue.Drain(100 * time.Millisecond)
+	if err == nil {
+		t.Fatalf("Drain should fail while jobs are blocked.\n")
+	}
+	close(release)
+	err = queue.Drain(5 * time.Second)
+	if err != nil {
+		t.Fatalf("Unable to drain queue: %s.\n", err.Error())
+	}
+	if completed.Value() != 4 {
+		t.Fatalf("Having %d completed jobs expecting 4.\n", completed.Value())
+	}
+}
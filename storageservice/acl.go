@@ -0,0 +1,233 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Internal libs
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+const (
+	// maxAclResources is the maximum number of resources that
+	// can be updated with a single acl request.
+	maxAclResources = 1000
+	// maxSharedResources is the maximum number of shared
+	// resources returned by a single request.
+	maxSharedResources = 1000
+)
+
+// applyAclRequest updates the argument acl with the properties
+// defined in the request: the permission is replaced, if present,
+// while sharing users are added and removed avoiding duplicates.
+func applyAclRequest(acl *Acl, request *ct.AclRequest) {
+	if request.Permission != nil {
+		acl.Permission = *request.Permission
+	}
+	for _, user := range request.AddUsers {
+		found := false
+		for _, shared := range acl.SharingUsers {
+			if shared == user {
+				found = true
+				break
+			}
+		}
+		if !found && user != "" {
+			acl.SharingUsers = append(acl.SharingUsers, user)
+		}
+	}
+	if len(request.RemoveUsers) != 0 {
+		removed := make(map[string]bool)
+		for _, user := range request.RemoveUsers {
+			removed[user] = true
+		}
+		var users []string
+		for _, shared := range acl.SharingUsers {
+			if !removed[shared] {
+				users = append(users, shared)
+			}
+		}
+		acl.SharingUsers = users
+	}
+}
+
+// updateAcl updates the access permissions of a list of resources
+// owned by the caller: the permission type can be switched and
+// users added or removed from the sharing list. The operation is
+// synchronous and reports the outcome for each resource.
+func updateAcl(w http.ResponseWriter, r *http.Request) {
+	// authorise and get user's info
+	// extract token from headers
+	userInfo, err := authoriseGettingUserInfos(r, shareScope)
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+
+	// get message BODY
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(r.Body)
+	// parse json body
+	var requestBody ct.AclRequest
+	err = json.Unmarshal(buf.Bytes(), &requestBody)
+	if err != nil {
+		riseError(http.StatusBadRequest,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	if len(requestBody.ResourceIDs) == 0 {
+		riseError(http.StatusBadRequest,
+			"resource ids in request body are nil", w,
+			r.RemoteAddr)
+		return
+	}
+	if len(requestBody.ResourceIDs) > maxAclResources {
+		riseError(http.StatusBadRequest,
+			fmt.Sprintf("too many resources, at most %d can be updated at once", maxAclResources), w,
+			r.RemoteAddr)
+		return
+	}
+	if requestBody.Permission == nil &&
+		len(requestBody.AddUsers) == 0 &&
+		len(requestBody.RemoveUsers) == 0 {
+		riseError(http.StatusBadRequest,
+			"no acl update in request body", w,
+			r.RemoteAddr)
+		return
+	}
+	if requestBody.Permission != nil &&
+		(*requestBody.Permission < Private ||
+			*requestBody.Permission > Public) {
+		riseError(http.StatusBadRequest,
+			fmt.Sprintf("unknown permission type %d", *requestBody.Permission), w,
+			r.RemoteAddr)
+		return
+	}
+
+	// retain db
+	dbSession := db.Copy()
+	defer dbSession.Close()
+
+	now := time.Now()
+	response := ct.AclResponse{
+		Failed: make(map[string]string),
+	}
+	for _, id := range requestBody.ResourceIDs {
+		fileLog, err := dbSession.GetFileLog(id)
+		if err != nil ||
+			fileLog.Complete == false {
+			response.Failed[id] = "requested file not found"
+			continue
+		}
+		// strict acl verification: only the file owner is able
+		// to update its permissions.
+		if fileLog.Ownership.Username != userInfo.Username {
+			recordAuditEvent(dbSession, fileLog, userInfo.Username, ct.AuditAcl, ct.AuditDenied, r.RemoteAddr)
+			response.Failed[id] = "you are not authorised to update this resource"
+			continue
+		}
+		if fileLogExpired(fileLog, now) {
+			response.Failed[id] = "requested file is expired"
+			continue
+		}
+		applyAclRequest(&fileLog.Acl, &requestBody)
+		err = dbSession.UpdateFileLog(fileLog)
+		recordAuditEvent(dbSession, fileLog, userInfo.Username, ct.AuditAcl, asyncOutcome(err), r.RemoteAddr)
+		if err != nil {
+			response.Failed[id] = fmt.Sprintf("unable to update file log: %s", err.Error())
+			continue
+		}
+		response.Updated = append(response.Updated, id)
+	}
+
+	// return acl response message
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		panic(err)
+	}
+	if arguments.verbose {
+		log.VerboseLog("Updated acl of %d resources for user %s (%d failures).\n",
+			len(response.Updated),
+			userInfo.Username,
+			len(response.Failed))
+	}
+}
+
+// resourceInfo returns the API representation of a file log.
+func resourceInfo(fl *FileLog) ct.ResourceInfo {
+	info := ct.ResourceInfo{
+		ID:           fl.Id,
+		Owner:        fl.Ownership.Username,
+		Size:         int64(fl.Size),
+		Permission:   fl.Acl.Permission,
+		SharingUsers: fl.Acl.SharingUsers,
+		Creation:     fl.Creation,
+		TimeToLive:   fl.TimeToLive,
+		Complete:     fl.Complete,
+	}
+	if !fl.Expiration.IsZero() {
+		expiration := fl.Expiration
+		info.Expiration = &expiration
+	}
+	return info
+}
+
+// getSharedResources lists the resources other users have shared
+// with the caller, expired resources are not returned.
+func getSharedResources(w http.ResponseWriter, r *http.Request) {
+	// authorise and get user's info
+	// extract token from headers
+	userInfo, err := authoriseGettingUserInfos(r, readScope)
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+
+	// retain db
+	dbSession := db.Copy()
+	defer dbSession.Close()
+	fileLogs, err := dbSession.GetSharedFileLogs(userInfo.Username, maxSharedResources)
+	if err != nil {
+		riseError(http.StatusInternalServerError,
+			fmt.Sprintf("unable to retrieve shared resources: %s", err.Error()), w,
+			r.RemoteAddr)
+		return
+	}
+
+	now := time.Now()
+	response := ct.SharedResponse{
+		Resources: make([]ct.ResourceInfo, 0, len(fileLogs)),
+	}
+	for idx := range fileLogs {
+		if fileLogExpired(&fileLogs[idx], now) ||
+			fileLogs[idx].Ownership.Username == userInfo.Username {
+			continue
+		}
+		response.Resources = append(response.Resources, resourceInfo(&fileLogs[idx]))
+	}
+
+	// return shared resources
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,283 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Internal dependencies.
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+func TestApplyAclRequest(t *testing.T) {
+	shared := Shared
+	var testCases = []struct {
+		acl      Acl
+		request  ct.AclRequest
+		expected Acl
+	}{
+		{
+			Acl{Permission: Private},
+			ct.AclRequest{Permission: &shared, AddUsers: []string{"userB", "userC", "userB"}},
+			Acl{Permission: Shared, SharingUsers: []string{"userB", "userC"}},
+		},
+		{
+			Acl{Permission: Shared, SharingUsers: []string{"userB", "userC"}},
+			ct.AclRequest{RemoveUsers: []string{"userB", "userD"}},
+			Acl{Permission: Shared, SharingUsers: []string{"userC"}},
+		},
+		{
+			Acl{Permission: Shared, SharingUsers: []string{"userB"}},
+			ct.AclRequest{AddUsers: []string{"userB", ""}, RemoveUsers: []string{"userB"}},
+			Acl{Permission: Shared},
+		},
+	}
+	for idx, tc := range testCases {
+		applyAclRequest(&tc.acl, &tc.request)
+		if !reflect.DeepEqual(tc.acl, tc.expected) {
+			t.Fatalf("Test case %d: having %v expecting %v.\n", idx, tc.acl, tc.expected)
+		}
+	}
+}
+
+func TestAclAndSharedResources(t *testing.T) {
+	now := time.Now()
+	files := []*FileLog{
+		{
+			Id:       "aclowned",
+			Bucket:   arguments.s3Bucket,
+			Creation: now,
+			Complete: true,
+			Ownership: Owner{
+				Username: mockUserInfo.Username,
+			},
+		},
+		{
+			Id:       "aclsharedwithme",
+			Bucket:   arguments.s3Bucket,
+			Creation: now,
+			Complete: true,
+			Ownership: Owner{
+				Username: "anotheruser",
+			},
+			Acl: Acl{
+				Permission:   Shared,
+				SharingUsers: []string{mockUserInfo.Username},
+			},
+		},
+		{
+			Id:       "aclprivate",
+			Bucket:   arguments.s3Bucket,
+			Creation: now,
+			Complete: true,
+			Ownership: Owner{
+				Username: "anotheruser",
+			},
+			Acl: Acl{
+				Permission:   Private,
+				SharingUsers: []string{mockUserInfo.Username},
+			},
+		},
+	}
+	for _, fl := range files {
+		err := db.SetFileLog(fl)
+		if err != nil {
+			t.Fatalf("Unable to set file log: %s.\n", err.Error())
+		}
+		defer db.RemoveFileLog(fl.Id)
+	}
+
+	// login
+	loginBody := ct.LoginRequest{
+		Username: mockUserInfo.Username,
+		Password: mockUserPassword,
+	}
+	body, err := json.Marshal(&loginBody)
+	if err != nil {
+		t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+	}
+	client := &http.Client{}
+	req, err := http.NewRequest(
+		"POST",
+		fmt.Sprintf("http://%s:%d/v1/authsession", mockServiceAddress, mockServicePort),
+		bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Unable to prepare the login request: %s.\n", err.Error())
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform login request on server: %s.\n", err.Error())
+	}
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	var session ct.LoginResponse
+	err = json.Unmarshal(respBody, &session)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+
+	aclURL := fmt.Sprintf("http://%s:%d/v1/storage/acl", mockServiceAddress, mockServicePort)
+	// invalid requests
+	unknown := ct.Permission(7)
+	var invalidRequests = []ct.AclRequest{
+		ct.AclRequest{AddUsers: []string{"userB"}},
+		ct.AclRequest{ResourceIDs: []string{"aclowned"}},
+		ct.AclRequest{ResourceIDs: []string{"aclowned"}, Permission: &unknown},
+	}
+	for idx, request := range invalidRequests {
+		body, err = json.Marshal(&request)
+		if err != nil {
+			t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+		}
+		req, err = http.NewRequest("PUT", aclURL, bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatalf("Unable to prepare the acl request: %s.\n", err.Error())
+		}
+		req.Header.Set(ct.SecurityTokenKey, session.Token)
+		resp, err = client.Do(req)
+		if err != nil {
+			t.Fatalf("Unable to perform acl request on server: %s.\n", err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Test case %d: having status %d expecting %d.\n", idx, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+
+	// update acl
+	shared := Shared
+	body, err = json.Marshal(&ct.AclRequest{
+		ResourceIDs: []string{"aclowned", "aclsharedwithme", "aclmissing"},
+		Permission:  &shared,
+		AddUsers:    []string{"userB"},
+	})
+	if err != nil {
+		t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+	}
+	req, err = http.NewRequest("PUT", aclURL, bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Unable to prepare the acl request: %s.\n", err.Error())
+	}
+	req.Header.Set(ct.SecurityTokenKey, session.Token)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform acl request on server: %s.\n", err.Error())
+	}
+	respBody, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Having status %d expecting %d.\n", resp.StatusCode, http.StatusOK)
+	}
+	var aclResponse ct.AclResponse
+	err = json.Unmarshal(respBody, &aclResponse)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+	if len(aclResponse.Updated) != 1 ||
+		aclResponse.Updated[0] != "aclowned" ||
+		len(aclResponse.Failed) != 2 {
+		t.Fatalf("Unexpected acl response: %v.\n", aclResponse)
+	}
+	fl, err := db.GetFileLog("aclowned")
+	if err != nil {
+		t.Fatalf("Unable to find file log: %s.\n", err.Error())
+	}
+	if fl.Acl.Permission != Shared ||
+		len(fl.Acl.SharingUsers) != 1 ||
+		fl.Acl.SharingUsers[0] != "userB" {
+		t.Fatalf("Unexpected acl: %v.\n", fl.Acl)
+	}
+	fl, err = db.GetFileLog("aclsharedwithme")
+	if err != nil {
+		t.Fatalf("Unable to find file log: %s.\n", err.Error())
+	}
+	if len(fl.Acl.SharingUsers) != 1 {
+		t.Fatalf("Not owned resource acl should not be updated: %v.\n", fl.Acl)
+	}
+
+	// shared with me
+	req, err = http.NewRequest(
+		"GET",
+		fmt.Sprintf("http://%s:%d/v1/storage/shared", mockServiceAddress, mockServicePort),
+		nil)
+	if err != nil {
+		t.Fatalf("Unable to prepare the shared request: %s.\n", err.Error())
+	}
+	req.Header.Set(ct.SecurityTokenKey, session.Token)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform shared request on server: %s.\n", err.Error())
+	}
+	respBody, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Having status %d expecting %d.\n", resp.StatusCode, http.StatusOK)
+	}
+	var sharedResponse ct.SharedResponse
+	err = json.Unmarshal(respBody, &sharedResponse)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+	if len(sharedResponse.Resources) != 1 ||
+		sharedResponse.Resources[0].ID != "aclsharedwithme" ||
+		sharedResponse.Resources[0].Owner != "anotheruser" {
+		t.Fatalf("Unexpected shared resources: %v.\n", sharedResponse.Resources)
+	}
+}
+
+func TestAclDeniedJobs(t *testing.T) {
+	fl := &FileLog{
+		Id:       "aclnotowned",
+		Bucket:   arguments.s3Bucket,
+		Creation: time.Now(),
+		Complete: true,
+		Ownership: Owner{
+			Username: "anotheruser",
+		},
+	}
+	err := db.SetFileLog(fl)
+	if err != nil {
+		t.Fatalf("Unable to set file log: %s.\n", err.Error())
+	}
+	defer db.RemoveFileLog(fl.Id)
+
+	status, respBody := serviceRequest(t, "POST", "/v1/authsession", "", &ct.LoginRequest{
+		Username: mockUserInfo.Username,
+		Password: mockUserPassword,
+	})
+	if status != http.StatusOK {
+		t.Fatalf("Unable to login, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+	var session ct.LoginResponse
+	err = json.Unmarshal(respBody, &session)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+
+	// authenticated users denied by the acl are forbidden, not
+	// unauthorised, not to be re-authenticated by clients
+	for _, command := range []string{"DOWNLOAD", "DELETE", "STAT"} {
+		status, _ = serviceRequest(t, "POST", "/v1/storage/job", session.Token, &ct.JobPostRequest{
+			Command: command,
+			Arguments: &ct.CommandArguments{
+				ResourceID: fl.Id,
+			},
+		})
+		if status != http.StatusForbidden {
+			t.Fatalf("%s: having status %d expecting %d.\n", command, status, http.StatusForbidden)
+		}
+	}
+}
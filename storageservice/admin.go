@@ -0,0 +1,267 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+// Administrative APIs: proxy the super-admin functions of the
+// auth service to manage users and sessions. Only session tokens
+// of storage administrators are accepted, super-admin permissions
+// are verified by the auth service.
+//
+
+package main
+
+// Golang std libs
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Internal libs
+import (
+	"github.com/nexocrew/3nigm4/lib/auth"
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+// Third party
+import (
+	"github.com/gorilla/mux"
+)
+
+// adminUser converts a user record, as returned by the auth
+// service, in its REST API representation.
+func adminUser(user *auth.User) ct.AdminUser {
+	result := ct.AdminUser{
+		Username:    user.Username,
+		FullName:    user.FullName,
+		Email:       user.Email,
+		SuperAdmin:  user.Permissions.SuperAdmin,
+		Disabled:    user.IsDisabled,
+		TotpEnabled: user.TotpEnabled,
+	}
+	if len(user.Permissions.Services) != 0 {
+		result.Services = make(map[string]uint)
+		for service, level := range user.Permissions.Services {
+			result.Services[service] = uint(level)
+		}
+	}
+	return result
+}
+
+// authUser converts a REST API user in the record upserted on the
+// auth service.
+func authUser(user *ct.AdminUser) *auth.User {
+	result := &auth.User{
+		Username:   user.Username,
+		FullName:   user.FullName,
+		Email:      user.Email,
+		IsDisabled: user.Disabled,
+		Permissions: auth.Permissions{
+			SuperAdmin: user.SuperAdmin,
+			Services:   make(map[string]auth.Level),
+		},
+	}
+	for service, level := range user.Services {
+		result.Permissions.Services[service] = auth.Level(level)
+	}
+	return result
+}
+
+// adminRequest extracts the session token, verifies the user to be
+// a storage administrator and, if not nil, decodes the JSON body of
+// an administrative request. Rises an error and returns nil if the
+// request is not valid.
+func adminRequest(w http.ResponseWriter, r *http.Request, body interface{}) []byte {
+	rawToken, err := sessionToken(r)
+	if err != nil {
+		riseError(http.StatusBadRequest,
+			err.Error(), w,
+			r.RemoteAddr)
+		return nil
+	}
+	userInfo, err := authClient.AuthoriseAndGetInfo(rawToken)
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			err.Error(), w,
+			r.RemoteAddr)
+		return nil
+	}
+	err = serviceAuthoriser.Authorise(userInfo, auth.LevelAdmin)
+	if err != nil {
+		riseError(http.StatusForbidden,
+			err.Error(), w,
+			r.RemoteAddr)
+		return nil
+	}
+	if body != nil {
+		err = json.NewDecoder(r.Body).Decode(body)
+		if err != nil {
+			riseError(http.StatusBadRequest,
+				err.Error(), w,
+				r.RemoteAddr)
+			return nil
+		}
+	}
+	return rawToken
+}
+
+// listUsers returns all the registered users.
+func listUsers(w http.ResponseWriter, r *http.Request) {
+	rawToken := adminRequest(w, r, nil)
+	if rawToken == nil {
+		return
+	}
+	users, err := authClient.ListUsers(rawToken, "")
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			fmt.Sprintf("unable to list users: %s", err.Error()), w,
+			r.RemoteAddr)
+		return
+	}
+	response := ct.AdminUsersResponse{
+		Users: make([]ct.AdminUser, 0, len(users)),
+	}
+	for idx := range users {
+		response.Users = append(response.Users, adminUser(&users[idx]))
+	}
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// getUser returns a registered user.
+func getUser(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+	rawToken := adminRequest(w, r, nil)
+	if rawToken == nil {
+		return
+	}
+	users, err := authClient.ListUsers(rawToken, username)
+	if err != nil ||
+		len(users) != 1 {
+		riseError(http.StatusNotFound,
+			fmt.Sprintf("unable to get user %s", username), w,
+			r.RemoteAddr)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(adminUser(&users[0]))
+	if err != nil {
+		panic(err)
+	}
+}
+
+// createUser adds a new user, a password is required.
+func createUser(w http.ResponseWriter, r *http.Request) {
+	var request ct.AdminUser
+	rawToken := adminRequest(w, r, &request)
+	if rawToken == nil {
+		return
+	}
+	if request.Username == "" ||
+		request.Password == "" {
+		riseError(http.StatusBadRequest,
+			"username or password in request body are nil", w,
+			r.RemoteAddr)
+		return
+	}
+	if users, err := authClient.ListUsers(rawToken, request.Username); err == nil &&
+		len(users) != 0 {
+		riseError(http.StatusConflict,
+			fmt.Sprintf("user %s already exists", request.Username), w,
+			r.RemoteAddr)
+		return
+	}
+	err := authClient.UpsertUser(rawToken, authUser(&request), request.Password)
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			fmt.Sprintf("unable to add user: %s", err.Error()), w,
+			r.RemoteAddr)
+		return
+	}
+	if arguments.verbose {
+		log.VerboseLog("Added user %s.\n", request.Username)
+	}
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(
+		ct.StandardResponse{
+			Status: ct.AckResponse,
+		})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// updateUser replaces the properties of an existing user, the
+// password is replaced only if present.
+func updateUser(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+	var request ct.AdminUser
+	rawToken := adminRequest(w, r, &request)
+	if rawToken == nil {
+		return
+	}
+	request.Username = username
+	users, err := authClient.ListUsers(rawToken, username)
+	if err != nil ||
+		len(users) != 1 {
+		riseError(http.StatusNotFound,
+			fmt.Sprintf("unable to get user %s", username), w,
+			r.RemoteAddr)
+		return
+	}
+	err = authClient.UpsertUser(rawToken, authUser(&request), request.Password)
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			fmt.Sprintf("unable to update user: %s", err.Error()), w,
+			r.RemoteAddr)
+		return
+	}
+	if arguments.verbose {
+		log.VerboseLog("Updated user %s.\n", username)
+	}
+	ackResponse(w)
+}
+
+// removeUser removes an existing user.
+func removeUser(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+	rawToken := adminRequest(w, r, nil)
+	if rawToken == nil {
+		return
+	}
+	err := authClient.RemoveUser(rawToken, username)
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			fmt.Sprintf("unable to remove user: %s", err.Error()), w,
+			r.RemoteAddr)
+		return
+	}
+	if arguments.verbose {
+		log.VerboseLog("Removed user %s.\n", username)
+	}
+	ackResponse(w)
+}
+
+// kickOutAllSessions removes all the active sessions, including
+// the requesting one.
+func kickOutAllSessions(w http.ResponseWriter, r *http.Request) {
+	rawToken := adminRequest(w, r, nil)
+	if rawToken == nil {
+		return
+	}
+	err := authClient.KickOutAllSessions(rawToken)
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			fmt.Sprintf("unable to remove sessions: %s", err.Error()), w,
+			r.RemoteAddr)
+		return
+	}
+	if arguments.verbose {
+		log.VerboseLog("All sessions removed.\n")
+	}
+	ackResponse(w)
+}
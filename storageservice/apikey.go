@@ -0,0 +1,183 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Internal libs
+import (
+	"github.com/nexocrew/3nigm4/lib/auth"
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+// Third party
+import (
+	"github.com/gorilla/mux"
+)
+
+// apiKeyInfo converts an api key, as returned by the auth
+// service, in its REST API representation.
+func apiKeyInfo(key *auth.ApiKey) ct.ApiKeyInfo {
+	info := ct.ApiKeyInfo{
+		ID:               key.Id,
+		Name:             key.Name,
+		Scopes:           key.Scopes,
+		AllowedAddresses: key.AllowedAddresses,
+		Creation:         key.CreationTime,
+	}
+	if !key.Expiration.IsZero() {
+		expiration := key.Expiration
+		info.Expiration = &expiration
+	}
+	if !key.LastUsedTime.IsZero() {
+		lastUsed := key.LastUsedTime
+		info.LastUsed = &lastUsed
+	}
+	return info
+}
+
+// sessionToken extracts the session token from the request
+// headers: api keys can not be used to manage api keys.
+func sessionToken(r *http.Request) ([]byte, error) {
+	rawToken, err := hex.DecodeString(r.Header.Get(ct.SecurityTokenKey))
+	if err != nil ||
+		len(rawToken) == 0 {
+		return nil, fmt.Errorf("auth token is nil or malformed")
+	}
+	if auth.IsApiKey(rawToken) {
+		return nil, fmt.Errorf("api keys can not be used to manage api keys")
+	}
+	return rawToken, nil
+}
+
+// createApiKey creates, redirecting to auth service, a new api
+// key for the session user. The key is returned only by this call.
+func createApiKey(w http.ResponseWriter, r *http.Request) {
+	rawToken, err := sessionToken(r)
+	if err != nil {
+		riseError(http.StatusBadRequest,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	// parse json body
+	var request ct.ApiKeyRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		riseError(http.StatusBadRequest,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	if request.Name == "" ||
+		len(request.Scopes) == 0 {
+		riseError(http.StatusBadRequest,
+			"name or scopes in request body are nil", w,
+			r.RemoteAddr)
+		return
+	}
+	var expiration time.Time
+	if request.Expiration != nil {
+		expiration = *request.Expiration
+	}
+
+	created, err := authClient.CreateApiKey(&auth.ApiKeyCreateRequestArg{
+		Token:            rawToken,
+		Name:             request.Name,
+		Scopes:           request.Scopes,
+		AllowedAddresses: request.AllowedAddresses,
+		Expiration:       expiration,
+	})
+	if err != nil {
+		riseError(http.StatusBadRequest,
+			fmt.Sprintf("unable to create api key: %s", err.Error()), w,
+			r.RemoteAddr)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(
+		&ct.ApiKeyResponse{
+			Key:  hex.EncodeToString(created.Key),
+			Info: apiKeyInfo(&created.Info),
+		})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// listApiKeys returns, redirecting to auth service, the api keys
+// of the session user.
+func listApiKeys(w http.ResponseWriter, r *http.Request) {
+	rawToken, err := sessionToken(r)
+	if err != nil {
+		riseError(http.StatusBadRequest,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	keys, err := authClient.ListApiKeys(rawToken)
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			fmt.Sprintf("unable to list api keys: %s", err.Error()), w,
+			r.RemoteAddr)
+		return
+	}
+	response := ct.ApiKeysResponse{
+		Keys: make([]ct.ApiKeyInfo, 0, len(keys)),
+	}
+	for idx := range keys {
+		response.Keys = append(response.Keys, apiKeyInfo(&keys[idx]))
+	}
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// revokeApiKey removes, redirecting to auth service, an api key
+// of the session user.
+func revokeApiKey(w http.ResponseWriter, r *http.Request) {
+	// get id from url
+	vars := mux.Vars(r)
+	id, ok := vars["keyid"]
+	if !ok || id == "" {
+		riseError(http.StatusBadRequest,
+			"unable to proceed with nil id", w,
+			r.RemoteAddr)
+		return
+	}
+	rawToken, err := sessionToken(r)
+	if err != nil {
+		riseError(http.StatusBadRequest,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	err = authClient.RevokeApiKey(rawToken, id)
+	if err != nil {
+		riseError(http.StatusNotFound,
+			fmt.Sprintf("unable to revoke api key: %s", err.Error()), w,
+			r.RemoteAddr)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(
+		ct.StandardResponse{
+			Status: ct.AckResponse,
+		})
+	if err != nil {
+		panic(err)
+	}
+}
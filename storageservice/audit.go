@@ -0,0 +1,145 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Internal libs
+import (
+	"github.com/nexocrew/3nigm4/lib/auth"
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+const (
+	// defaultAuditEvents is the number of events returned by an
+	// audit request if no limit is specified.
+	defaultAuditEvents = 100
+	// maxAuditEvents is the maximum number of events returned by
+	// a single audit request.
+	maxAuditEvents = 1000
+)
+
+// recordAuditEvent appends an audit event about the argument
+// resource: failures are only logged to not block the audited
+// operation.
+func recordAuditEvent(session database, fileLog *FileLog, user, action, outcome, originIp string) {
+	err := session.SetAuditEvent(&AuditEvent{
+		Resource: fileLog.Id,
+		Owner:    fileLog.Ownership.Username,
+		User:     user,
+		Action:   action,
+		Outcome:  outcome,
+		OriginIp: originIp,
+		Time:     time.Now(),
+	})
+	if err != nil {
+		log.ErrorLog("Unable to record %s audit event for resource %s: %s.\n", action, fileLog.Id, err.Error())
+	}
+}
+
+// asyncOutcome returns the audit outcome of an async operation.
+func asyncOutcome(err error) string {
+	if err != nil {
+		return ct.AuditFailure
+	}
+	return ct.AuditSuccess
+}
+
+// getAuditEvents lists, most recent first, the audit events about
+// resources owned by the caller. The "resource" query parameter
+// filters events of a single resource, "since" (RFC3339) excludes
+// older events and "limit" defines the maximum number of events.
+// Storage administrators can use the "username" query parameter to
+// list events about resources owned by another user.
+func getAuditEvents(w http.ResponseWriter, r *http.Request) {
+	// authorise and get user's info
+	// extract token from headers
+	userInfo, err := authoriseGettingUserInfos(r, readScope)
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+
+	// get query parameters
+	query := r.URL.Query()
+	owner := userInfo.Username
+	if username := query.Get("username"); username != "" &&
+		username != owner {
+		err = serviceAuthoriser.Authorise(userInfo, auth.LevelAdmin)
+		if err != nil {
+			riseError(http.StatusForbidden,
+				err.Error(), w,
+				r.RemoteAddr)
+			return
+		}
+		owner = username
+	}
+	limit := defaultAuditEvents
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil ||
+			limit <= 0 ||
+			limit > maxAuditEvents {
+			riseError(http.StatusBadRequest,
+				fmt.Sprintf("limit should be a positive number lower than %d", maxAuditEvents), w,
+				r.RemoteAddr)
+			return
+		}
+	}
+	var since time.Time
+	if value := query.Get("since"); value != "" {
+		since, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			riseError(http.StatusBadRequest,
+				fmt.Sprintf("since parameter is malformed (%s)", err.Error()), w,
+				r.RemoteAddr)
+			return
+		}
+	}
+
+	// retain db
+	dbSession := db.Copy()
+	defer dbSession.Close()
+	events, err := dbSession.GetAuditEvents(owner, query.Get("resource"), since, limit)
+	if err != nil {
+		riseError(http.StatusInternalServerError,
+			fmt.Sprintf("unable to retrieve audit events: %s", err.Error()), w,
+			r.RemoteAddr)
+		return
+	}
+
+	response := ct.AuditResponse{
+		Events: make([]ct.AuditEvent, 0, len(events)),
+	}
+	for _, event := range events {
+		response.Events = append(response.Events, ct.AuditEvent{
+			Resource: event.Resource,
+			Owner:    event.Owner,
+			User:     event.User,
+			Action:   event.Action,
+			Outcome:  event.Outcome,
+			OriginIp: event.OriginIp,
+			Time:     event.Time,
+		})
+	}
+
+	// return audit events
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,267 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Internal dependencies.
+import (
+	"github.com/nexocrew/3nigm4/lib/auth"
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+func TestAuditEvents(t *testing.T) {
+	now := time.Now()
+	files := []*FileLog{
+		{
+			Id:       "auditowned",
+			Bucket:   arguments.s3Bucket,
+			Creation: now,
+			Complete: true,
+			Ownership: Owner{
+				Username: mockUserInfo.Username,
+			},
+		},
+		{
+			Id:       "auditnotowned",
+			Bucket:   arguments.s3Bucket,
+			Creation: now,
+			Complete: true,
+			Ownership: Owner{
+				Username: "anotheruser",
+			},
+		},
+	}
+	for _, fl := range files {
+		err := backend.UploadStream(fl.Bucket, fl.Id, bytes.NewBufferString(fileContent), nil)
+		if err != nil {
+			t.Fatalf("Unable to upload file: %s.\n", err.Error())
+		}
+		err = db.SetFileLog(fl)
+		if err != nil {
+			t.Fatalf("Unable to set file log: %s.\n", err.Error())
+		}
+		defer db.RemoveFileLog(fl.Id)
+	}
+
+	// login
+	loginBody := ct.LoginRequest{
+		Username: mockUserInfo.Username,
+		Password: mockUserPassword,
+	}
+	body, err := json.Marshal(&loginBody)
+	if err != nil {
+		t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+	}
+	client := &http.Client{}
+	req, err := http.NewRequest(
+		"POST",
+		fmt.Sprintf("http://%s:%d/v1/authsession", mockServiceAddress, mockServicePort),
+		bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Unable to prepare the login request: %s.\n", err.Error())
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform login request on server: %s.\n", err.Error())
+	}
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	var session ct.LoginResponse
+	err = json.Unmarshal(respBody, &session)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+
+	// download owned and not owned resources
+	var downloads = []struct {
+		id     string
+		status int
+	}{
+		{"auditowned", http.StatusOK},
+		{"auditnotowned", http.StatusForbidden},
+	}
+	for idx, tc := range downloads {
+		req, err = http.NewRequest(
+			"GET",
+			fmt.Sprintf("http://%s:%d/v1/storage/chunk/%s", mockServiceAddress, mockServicePort, tc.id),
+			nil)
+		if err != nil {
+			t.Fatalf("Unable to prepare the download request: %s.\n", err.Error())
+		}
+		req.Header.Set(ct.SecurityTokenKey, session.Token)
+		resp, err = client.Do(req)
+		if err != nil {
+			t.Fatalf("Unable to perform download request on server: %s.\n", err.Error())
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Fatalf("Test case %d: having status %d expecting %d.\n", idx, resp.StatusCode, tc.status)
+		}
+	}
+	// update owned resource acl
+	public := Public
+	body, err = json.Marshal(&ct.AclRequest{
+		ResourceIDs: []string{"auditowned"},
+		Permission:  &public,
+	})
+	if err != nil {
+		t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+	}
+	req, err = http.NewRequest(
+		"PUT",
+		fmt.Sprintf("http://%s:%d/v1/storage/acl", mockServiceAddress, mockServicePort),
+		bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Unable to prepare the acl request: %s.\n", err.Error())
+	}
+	req.Header.Set(ct.SecurityTokenKey, session.Token)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform acl request on server: %s.\n", err.Error())
+	}
+	resp.Body.Close()
+
+	var getAuditAs func(string, string) (int, *ct.AuditResponse)
+	getAudit := func(query string) (int, *ct.AuditResponse) {
+		return getAuditAs(session.Token, query)
+	}
+	getAuditAs = func(token, query string) (int, *ct.AuditResponse) {
+		req, err := http.NewRequest(
+			"GET",
+			fmt.Sprintf("http://%s:%d/v1/storage/audit?%s", mockServiceAddress, mockServicePort, query),
+			nil)
+		if err != nil {
+			t.Fatalf("Unable to prepare the audit request: %s.\n", err.Error())
+		}
+		req.Header.Set(ct.SecurityTokenKey, token)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Unable to perform audit request on server: %s.\n", err.Error())
+		}
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return resp.StatusCode, nil
+		}
+		var audit ct.AuditResponse
+		err = json.Unmarshal(respBody, &audit)
+		if err != nil {
+			t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+		}
+		return resp.StatusCode, &audit
+	}
+
+	// invalid queries
+	var invalidQueries = []string{
+		"limit=0",
+		fmt.Sprintf("limit=%d", maxAuditEvents+1),
+		"since=yesterday",
+	}
+	for idx, query := range invalidQueries {
+		status, _ := getAudit(query)
+		if status != http.StatusBadRequest {
+			t.Fatalf("Test case %d: having status %d expecting %d.\n", idx, status, http.StatusBadRequest)
+		}
+	}
+
+	// owned resource events, most recent first
+	status, audit := getAudit("resource=auditowned")
+	if status != http.StatusOK {
+		t.Fatalf("Having status %d expecting %d.\n", status, http.StatusOK)
+	}
+	var expected = []struct {
+		action  string
+		outcome string
+	}{
+		{ct.AuditAcl, ct.AuditSuccess},
+		{ct.AuditDownload, ct.AuditSuccess},
+	}
+	if len(audit.Events) != len(expected) {
+		t.Fatalf("Having %d events expecting %d: %v.\n", len(audit.Events), len(expected), audit.Events)
+	}
+	for idx, tc := range expected {
+		event := audit.Events[idx]
+		if event.Action != tc.action ||
+			event.Outcome != tc.outcome ||
+			event.User != mockUserInfo.Username ||
+			event.Owner != mockUserInfo.Username {
+			t.Fatalf("Test case %d: unexpected event %v.\n", idx, event)
+		}
+	}
+	status, audit = getAudit("resource=auditowned&limit=1")
+	if status != http.StatusOK {
+		t.Fatalf("Having status %d expecting %d.\n", status, http.StatusOK)
+	}
+	if len(audit.Events) != 1 {
+		t.Fatalf("Having %d events expecting 1.\n", len(audit.Events))
+	}
+	status, audit = getAudit(fmt.Sprintf("resource=auditowned&since=%s", now.Add(time.Hour).Format(time.RFC3339)))
+	if status != http.StatusOK {
+		t.Fatalf("Having status %d expecting %d.\n", status, http.StatusOK)
+	}
+	if len(audit.Events) != 0 {
+		t.Fatalf("Having %d events expecting none.\n", len(audit.Events))
+	}
+
+	// denied access is only visible to the resource owner
+	status, audit = getAudit("resource=auditnotowned")
+	if status != http.StatusOK {
+		t.Fatalf("Having status %d expecting %d.\n", status, http.StatusOK)
+	}
+	if len(audit.Events) != 0 {
+		t.Fatalf("Not owned resource events should not be returned: %v.\n", audit.Events)
+	}
+	events, err := db.GetAuditEvents("anotheruser", "auditnotowned", time.Time{}, maxAuditEvents)
+	if err != nil {
+		t.Fatalf("Unable to get audit events: %s.\n", err.Error())
+	}
+	if len(events) != 1 ||
+		events[0].Action != ct.AuditDownload ||
+		events[0].Outcome != ct.AuditDenied ||
+		events[0].User != mockUserInfo.Username {
+		t.Fatalf("Unexpected denied access events: %v.\n", events)
+	}
+
+	// other users events are reserved to storage administrators
+	status, _ = getAudit("resource=auditnotowned&username=anotheruser")
+	if status != http.StatusForbidden {
+		t.Fatalf("Having status %d expecting %d.\n", status, http.StatusForbidden)
+	}
+	rawAdmin, err := ct.RandomBytesForLen(32)
+	if err != nil {
+		t.Fatalf("Unable to generate token: %s.\n", err.Error())
+	}
+	adminToken := hex.EncodeToString(rawAdmin)
+	authClient.(*authMock).sessions[adminToken] = &auth.UserInfoResponseArg{
+		Username: "storageadmin",
+		Permissions: &auth.Permissions{
+			Services: map[string]auth.Level{
+				storageServiceName: auth.LevelAdmin,
+			},
+		},
+	}
+	defer delete(authClient.(*authMock).sessions, adminToken)
+	status, audit = getAuditAs(adminToken, "resource=auditnotowned&username=anotheruser")
+	if status != http.StatusOK {
+		t.Fatalf("Having status %d expecting %d.\n", status, http.StatusOK)
+	}
+	if len(audit.Events) != 1 ||
+		audit.Events[0].Owner != "anotheruser" {
+		t.Fatalf("Unexpected other user events: %v.\n", audit.Events)
+	}
+}
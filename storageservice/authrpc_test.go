@@ -0,0 +1,172 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/rpc"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Internal dependencies.
+import (
+	"github.com/nexocrew/3nigm4/lib/auth"
+)
+
+// countingAuth RPC service counting user infos requests.
+type countingAuth struct {
+	requests int32
+}
+
+func (c *countingAuth) UserInfo(args *auth.AuthenticateRequestArg, response *auth.UserInfoResponseArg) error {
+	atomic.AddInt32(&c.requests, 1)
+	response.Username = string(args.Token)
+	return nil
+}
+
+func (c *countingAuth) KickOutAllSessions(args *auth.AuthenticateRequestArg, response *auth.VoidResponseArg) error {
+	return nil
+}
+
+func (c *countingAuth) Logout(args *auth.LogoutRequestArg, response *auth.LogoutResponseArg) error {
+	response.Invalidated = args.Token
+	return nil
+}
+
+func (c *countingAuth) Refresh(args *auth.RefreshRequestArg, response *auth.LoginResponseArg) error {
+	response.Token = append([]byte("refreshed"), args.Token...)
+	return nil
+}
+
+func (c *countingAuth) Revoke(args *auth.ApiKeyRevokeRequestArg, response *auth.VoidResponseArg) error {
+	return nil
+}
+
+func (c *countingAuth) ChangePassword(args *auth.ChangePasswordRequestArg, response *auth.VoidResponseArg) error {
+	return nil
+}
+
+func (c *countingAuth) ResetPassword(args *auth.ResetPasswordRequestArg, response *auth.VoidResponseArg) error {
+	return nil
+}
+
+func (c *countingAuth) CompletePasswordReset(args *auth.CompleteResetRequestArg, response *auth.VoidResponseArg) error {
+	return nil
+}
+
+func (c *countingAuth) UpsertUser(args *auth.UpserUserRequestArg, response *auth.VoidResponseArg) error {
+	return nil
+}
+
+func (c *countingAuth) RemoveUser(args *auth.RemoveUserRequestArg, response *auth.VoidResponseArg) error {
+	return nil
+}
+
+func TestAuthRpcCache(t *testing.T) {
+	service := &countingAuth{}
+	server := rpc.NewServer()
+	server.RegisterName("SessionAuth", service)
+	server.RegisterName("Login", service)
+	server.RegisterName("ApiKeys", service)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %s.\n", err.Error())
+	}
+	defer listener.Close()
+	go http.Serve(listener, server)
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+
+	client, err := NewAuthRpc(host, portNumber, nil, 2, time.Minute)
+	if err != nil {
+		t.Fatalf("Unable to create auth client: %s.\n", err.Error())
+	}
+	defer client.Close()
+	authorise := func(token string, expected int32) {
+		info, err := client.AuthoriseAndGetInfo([]byte(token))
+		if err != nil {
+			t.Fatalf("Unable to authorise: %s.\n", err.Error())
+		}
+		if info.Username != token {
+			t.Fatalf("Having user %s expecting %s.\n", info.Username, token)
+		}
+		if requests := atomic.LoadInt32(&service.requests); requests != expected {
+			t.Fatalf("Having %d requests expecting %d.\n", requests, expected)
+		}
+	}
+
+	// authorised users are cached
+	authorise("userA", 1)
+	authorise("userA", 1)
+	authorise("userB", 2)
+	// logout invalidates the session
+	_, err = client.Logout([]byte("userA"))
+	if err != nil {
+		t.Fatalf("Unable to logout: %s.\n", err.Error())
+	}
+	authorise("userA", 3)
+	authorise("userB", 3)
+	// refresh invalidates the replaced session
+	_, err = client.Refresh([]byte("userB"))
+	if err != nil {
+		t.Fatalf("Unable to refresh: %s.\n", err.Error())
+	}
+	authorise("userB", 4)
+	authorise("userA", 4)
+	// kick out invalidates user's sessions
+	err = client.KickOutAllSessions([]byte("userB"))
+	if err != nil {
+		t.Fatalf("Unable to kick out sessions: %s.\n", err.Error())
+	}
+	authorise("userB", 5)
+	authorise("userA", 5)
+	// revoking an api key invalidates user's sessions
+	err = client.RevokeApiKey([]byte("userB"), "keyid")
+	if err != nil {
+		t.Fatalf("Unable to revoke api key: %s.\n", err.Error())
+	}
+	authorise("userB", 6)
+	authorise("userA", 6)
+	// password changes invalidate user's sessions
+	err = client.ChangePassword([]byte("userA"), "password", "newpassword")
+	if err != nil {
+		t.Fatalf("Unable to change password: %s.\n", err.Error())
+	}
+	authorise("userA", 7)
+	authorise("userB", 7)
+	err = client.ResetPassword([]byte("admin"), "userB")
+	if err != nil {
+		t.Fatalf("Unable to reset password: %s.\n", err.Error())
+	}
+	authorise("userB", 8)
+	authorise("userA", 8)
+	err = client.CompletePasswordReset("userA", "resettoken", "newpassword")
+	if err != nil {
+		t.Fatalf("Unable to complete password reset: %s.\n", err.Error())
+	}
+	authorise("userA", 9)
+	authorise("userB", 9)
+	// users administration invalidates the affected user
+	err = client.UpsertUser([]byte("admin"), &auth.User{
+		Username: "userA",
+	}, "")
+	if err != nil {
+		t.Fatalf("Unable to upsert user: %s.\n", err.Error())
+	}
+	authorise("userA", 10)
+	authorise("userB", 10)
+	err = client.RemoveUser([]byte("admin"), "userB")
+	if err != nil {
+		t.Fatalf("Unable to remove user: %s.\n", err.Error())
+	}
+	authorise("userB", 11)
+	authorise("userA", 11)
+}
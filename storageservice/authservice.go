@@ -8,50 +8,109 @@ package main
 
 // Std golang packages
 import (
+	"crypto/tls"
 	"fmt"
-	"net/rpc"
+	"time"
 )
 
 // Internal libs
 import (
 	"github.com/nexocrew/3nigm4/lib/auth"
+	"github.com/nexocrew/3nigm4/lib/metrics"
 )
 
 // AuthClient is the interface used to interact
 // with authentication services.
 type AuthClient interface {
-	Login(string, string) ([]byte, error)                          // manage user's login;
-	Logout([]byte) ([]byte, error)                                 // manage user's logout;
-	AuthoriseAndGetInfo([]byte) (*auth.UserInfoResponseArg, error) // returns authenticated user infos or an error;
-	Close() error                                                  // closes eventual connections.
+	Login(string, string, string, string, string) ([]byte, error)                     // manage user's login, with an optional two factor code;
+	Logout([]byte) ([]byte, error)                                                    // manage user's logout;
+	Refresh([]byte) ([]byte, error)                                                   // renew a still valid session;
+	AuthoriseAndGetInfo([]byte) (*auth.UserInfoResponseArg, error)                    // returns authenticated user infos or an error;
+	TotpEnrol([]byte) (*auth.TotpEnrolResponseArg, error)                             // generates a new two factor authentication secret;
+	TotpConfirm([]byte, string) ([]string, error)                                     // enables two factor authentication returning recovery codes;
+	TotpDisable([]byte, string) error                                                 // disables two factor authentication;
+	CreateApiKey(*auth.ApiKeyCreateRequestArg) (*auth.ApiKeyCreateResponseArg, error) // creates a new api key;
+	ListApiKeys([]byte) ([]auth.ApiKey, error)                                        // lists the user api keys;
+	RevokeApiKey([]byte, string) error                                                // revokes an api key;
+	ChangePassword([]byte, string, string) error                                      // changes the session user password;
+	ResetPassword([]byte, string) error                                               // issues a password reset token for a user;
+	CompletePasswordReset(string, string, string) error                               // sets a new password using a reset token;
+	UpsertUser([]byte, *auth.User, string) error                                      // adds or updates a user, requires a super-admin;
+	RemoveUser([]byte, string) error                                                  // removes a user, requires a super-admin;
+	ListUsers([]byte, string) ([]auth.User, error)                                    // lists all users, or the required one, requires a super-admin;
+	ListSessions([]byte) ([]auth.SessionInfo, error)                                  // lists the session user active sessions;
+	RevokeSessions([]byte, string, bool) (int, error)                                 // revokes one or all the other session user sessions;
+	KickOutAllSessions([]byte) error                                                  // removes all sessions, requires a super-admin;
+	Ping() error                                                                      // verifies the auth service is able to serve requests;
+	Close() error                                                                     // closes eventual connections.
 }
 
+// Auth RPC client metrics.
+var (
+	authRpcDuration = metrics.NewHistogram(
+		"auth_rpc_duration_seconds",
+		"Latency of auth service RPC calls by method.",
+		nil,
+		"method")
+	authRpcErrors = metrics.NewCounter(
+		"auth_rpc_errors_total",
+		"Number of failed auth service RPC calls by method.",
+		"method")
+)
+
+// authCacheSize is the maximum number of user infos cached by
+// the RPC client.
+const authCacheSize = 4096
+
 // AuthRpc implements the RPC default client for
 // the 3nigm4 auth service.
 type AuthRpc struct {
-	client *rpc.Client
+	client *auth.RpcClient
+	cache  *auth.UserInfoCache
 }
 
 // NewAuthRpc creates a new instance of the RPC
-// client used to interact with the auth service.
-func NewAuthRpc(addr string, port int) (*AuthRpc, error) {
+// client used to interact with the auth service. The
+// connections are protected with TLS using the argument
+// config, if nil plain TCP connections are used. At max
+// poolSize connections are established and, if cacheTtl
+// is not zero, authorised users infos are cached for
+// cacheTtl.
+func NewAuthRpc(addr string, port int, config *tls.Config, poolSize int, cacheTtl time.Duration) (*AuthRpc, error) {
 	address := fmt.Sprintf("%s:%d", addr, port)
-	rawClient, err := rpc.DialHTTP("tcp", address)
+	client, err := auth.NewRpcClient(address, config, poolSize)
 	if err != nil {
 		return nil, err
 	}
 	return &AuthRpc{
-		client: rawClient,
+		client: client,
+		cache:  auth.NewUserInfoCache(cacheTtl, authCacheSize),
 	}, nil
 }
 
-// Login grant access to users, over RPC, using username and password.
-func (a *AuthRpc) Login(username string, password string) ([]byte, error) {
+// call invokes the argument RPC method measuring its latency.
+func (a *AuthRpc) call(method string, args interface{}, reply interface{}) error {
+	start := time.Now()
+	err := a.client.Call(method, args, reply)
+	authRpcDuration.ObserveSince(start, method)
+	if err != nil {
+		authRpcErrors.Inc(method)
+	}
+	return err
+}
+
+// Login grant access to users, over RPC, using username and password
+// plus, if two factor authentication is enabled, the otp code. The
+// client address and user agent are recorded in the session.
+func (a *AuthRpc) Login(username, password, otp, address, userAgent string) ([]byte, error) {
 	// perform login on RPC service
 	var loginResponse auth.LoginResponseArg
-	err := a.client.Call("Login.Login", &auth.LoginRequestArg{
-		Username: username,
-		Password: password,
+	err := a.call("Login.Login", &auth.LoginRequestArg{
+		Username:  username,
+		Password:  password,
+		OTP:       otp,
+		Address:   address,
+		UserAgent: userAgent,
 	}, &loginResponse)
 	if err != nil {
 		return nil, err
@@ -59,32 +118,299 @@ func (a *AuthRpc) Login(username string, password string) ([]byte, error) {
 	return loginResponse.Token, nil
 }
 
-// Logout remove actual active sessions over RPC.
+// Logout remove actual active sessions over RPC. The cached token
+// infos are invalidated once the session is removed, not to be
+// cached again by concurrent requests.
 func (a *AuthRpc) Logout(token []byte) ([]byte, error) {
 	var logoutResponse auth.LogoutResponseArg
-	err := a.client.Call("Login.Logout", &auth.LogoutRequestArg{
+	err := a.call("Login.Logout", &auth.LogoutRequestArg{
 		Token: token,
 	}, &logoutResponse)
+	a.cache.Invalidate(token)
 	if err != nil {
 		return nil, err
 	}
 	return logoutResponse.Invalidated, nil
 }
 
-// AuthoriseAndGetInfo if the token is valid returns info about
-// the associated user over RPC service.
+// Refresh renews a still valid session over RPC, returning the
+// new session token: the old one is invalidated, as its cached
+// infos once the session is replaced.
+func (a *AuthRpc) Refresh(token []byte) ([]byte, error) {
+	var refreshResponse auth.LoginResponseArg
+	err := a.call("Login.Refresh", &auth.RefreshRequestArg{
+		Token: token,
+	}, &refreshResponse)
+	a.cache.Invalidate(token)
+	if err != nil {
+		return nil, err
+	}
+	return refreshResponse.Token, nil
+}
+
+// AuthoriseAndGetInfo if the token, a session token or an api key,
+// is valid returns info about the associated user over RPC service,
+// recently authorised tokens are served from the cache, if enabled.
 func (a *AuthRpc) AuthoriseAndGetInfo(token []byte) (*auth.UserInfoResponseArg, error) {
+	if info, ok := a.cache.Get(token); ok {
+		return info, nil
+	}
+	method := "SessionAuth.UserInfo"
+	if auth.IsApiKey(token) {
+		method = "ApiKeys.UserInfo"
+	}
 	// verify token and retrieve user infos
 	var authResponse auth.UserInfoResponseArg
-	err := a.client.Call("SessionAuth.UserInfo", &auth.AuthenticateRequestArg{
+	err := a.call(method, &auth.AuthenticateRequestArg{
 		Token: token,
 	}, &authResponse)
 	if err != nil {
 		return nil, err
 	}
+	a.cache.Set(token, &authResponse)
 	return &authResponse, nil
 }
 
+// KickOutAllSessions removes, over RPC, all the active sessions of
+// the user owning the argument token.
+func (a *AuthRpc) KickOutAllSessions(token []byte) error {
+	info, err := a.AuthoriseAndGetInfo(token)
+	if err != nil {
+		return err
+	}
+	var kickResponse auth.VoidResponseArg
+	err = a.call("SessionAuth.KickOutAllSessions", &auth.AuthenticateRequestArg{
+		Token: token,
+	}, &kickResponse)
+	a.cache.InvalidateUser(info.Username)
+	return err
+}
+
+// TotpEnrol generates, over RPC, a new TOTP secret for the user
+// owning the token: it's enabled only after being confirmed.
+func (a *AuthRpc) TotpEnrol(token []byte) (*auth.TotpEnrolResponseArg, error) {
+	var enrolResponse auth.TotpEnrolResponseArg
+	err := a.call("TwoFactor.Enrol", &auth.AuthenticateRequestArg{
+		Token: token,
+	}, &enrolResponse)
+	if err != nil {
+		return nil, err
+	}
+	return &enrolResponse, nil
+}
+
+// TotpConfirm enables, over RPC, two factor authentication
+// verifying a code produced with the enrolled secret, the single
+// use recovery codes are returned.
+func (a *AuthRpc) TotpConfirm(token []byte, code string) ([]string, error) {
+	var confirmResponse auth.TotpConfirmResponseArg
+	err := a.call("TwoFactor.Confirm", &auth.TotpCodeRequestArg{
+		Token: token,
+		Code:  code,
+	}, &confirmResponse)
+	if err != nil {
+		return nil, err
+	}
+	return confirmResponse.RecoveryCodes, nil
+}
+
+// TotpDisable disables, over RPC, two factor authentication
+// verifying a TOTP or recovery code.
+func (a *AuthRpc) TotpDisable(token []byte, code string) error {
+	var disableResponse auth.VoidResponseArg
+	return a.call("TwoFactor.Disable", &auth.TotpCodeRequestArg{
+		Token: token,
+		Code:  code,
+	}, &disableResponse)
+}
+
+// CreateApiKey creates, over RPC, a new api key for the user
+// owning the session token passed in the request.
+func (a *AuthRpc) CreateApiKey(request *auth.ApiKeyCreateRequestArg) (*auth.ApiKeyCreateResponseArg, error) {
+	var createResponse auth.ApiKeyCreateResponseArg
+	err := a.call("ApiKeys.Create", request, &createResponse)
+	if err != nil {
+		return nil, err
+	}
+	return &createResponse, nil
+}
+
+// ListApiKeys returns, over RPC, the api keys of the user owning
+// the session token.
+func (a *AuthRpc) ListApiKeys(token []byte) ([]auth.ApiKey, error) {
+	var listResponse auth.ApiKeyListResponseArg
+	err := a.call("ApiKeys.List", &auth.AuthenticateRequestArg{
+		Token: token,
+	}, &listResponse)
+	if err != nil {
+		return nil, err
+	}
+	return listResponse.Keys, nil
+}
+
+// RevokeApiKey removes, over RPC, an api key of the user owning
+// the session token. Infos cached for the user are invalidated to
+// deny, immediately, the revoked key.
+func (a *AuthRpc) RevokeApiKey(token []byte, id string) error {
+	info, err := a.AuthoriseAndGetInfo(token)
+	if err != nil {
+		return err
+	}
+	var revokeResponse auth.VoidResponseArg
+	err = a.call("ApiKeys.Revoke", &auth.ApiKeyRevokeRequestArg{
+		Token: token,
+		Id:    id,
+	}, &revokeResponse)
+	if err != nil {
+		return err
+	}
+	a.cache.InvalidateUser(info.Username)
+	return nil
+}
+
+// ListSessions returns, over RPC, the active sessions of the user
+// owning the session token.
+func (a *AuthRpc) ListSessions(token []byte) ([]auth.SessionInfo, error) {
+	var listResponse auth.ListSessionsResponseArg
+	err := a.call("SessionAuth.ListSessions", &auth.AuthenticateRequestArg{
+		Token: token,
+	}, &listResponse)
+	if err != nil {
+		return nil, err
+	}
+	return listResponse.Sessions, nil
+}
+
+// RevokeSessions removes, over RPC, the session having the argument
+// id or, if all is true, all the sessions of the user owning the
+// token except the requesting one. Infos cached for the user are
+// invalidated to deny, immediately, the revoked sessions.
+func (a *AuthRpc) RevokeSessions(token []byte, id string, all bool) (int, error) {
+	info, err := a.AuthoriseAndGetInfo(token)
+	if err != nil {
+		return 0, err
+	}
+	var revokeResponse auth.RevokeSessionsResponseArg
+	err = a.call("SessionAuth.RevokeSessions", &auth.RevokeSessionsRequestArg{
+		Token:     token,
+		SessionId: id,
+		All:       all,
+	}, &revokeResponse)
+	a.cache.InvalidateUser(info.Username)
+	if err != nil {
+		return 0, err
+	}
+	return revokeResponse.Revoked, nil
+}
+
+// ChangePassword replaces, over RPC, the password of the session
+// user: the other user sessions are invalidated, as the infos
+// cached for the user.
+func (a *AuthRpc) ChangePassword(token []byte, password, newPassword string) error {
+	info, err := a.AuthoriseAndGetInfo(token)
+	if err != nil {
+		return err
+	}
+	var changeResponse auth.VoidResponseArg
+	err = a.call("Login.ChangePassword", &auth.ChangePasswordRequestArg{
+		Token:       token,
+		Password:    password,
+		NewPassword: newPassword,
+	}, &changeResponse)
+	if err != nil {
+		return err
+	}
+	a.cache.InvalidateUser(info.Username)
+	return nil
+}
+
+// ResetPassword issues, over RPC, a password reset token delivered
+// to the argument user, infos cached for the user are invalidated.
+// Requires a super-admin session token.
+func (a *AuthRpc) ResetPassword(token []byte, username string) error {
+	var resetResponse auth.VoidResponseArg
+	err := a.call("SessionAuth.ResetPassword", &auth.ResetPasswordRequestArg{
+		Token:    token,
+		Username: username,
+	}, &resetResponse)
+	if err != nil {
+		return err
+	}
+	a.cache.InvalidateUser(username)
+	return nil
+}
+
+// CompletePasswordReset sets, over RPC, a new password using a
+// reset token, infos cached for the user are invalidated.
+func (a *AuthRpc) CompletePasswordReset(username, resetToken, newPassword string) error {
+	var completeResponse auth.VoidResponseArg
+	err := a.call("Login.CompletePasswordReset", &auth.CompleteResetRequestArg{
+		Username:    username,
+		ResetToken:  resetToken,
+		NewPassword: newPassword,
+	}, &completeResponse)
+	if err != nil {
+		return err
+	}
+	a.cache.InvalidateUser(username)
+	return nil
+}
+
+// UpsertUser adds or updates, over RPC, a user: if password is not
+// empty it replaces the user's one. Infos cached for the user are
+// invalidated to apply, immediately, the new permissions. Requires
+// a super-admin session token.
+func (a *AuthRpc) UpsertUser(token []byte, user *auth.User, password string) error {
+	var upsertResponse auth.VoidResponseArg
+	err := a.call("SessionAuth.UpsertUser", &auth.UpserUserRequestArg{
+		Token:    token,
+		User:     *user,
+		Password: password,
+	}, &upsertResponse)
+	if err != nil {
+		return err
+	}
+	a.cache.InvalidateUser(user.Username)
+	return nil
+}
+
+// RemoveUser removes, over RPC, a user and invalidates the infos
+// cached for it. Requires a super-admin session token.
+func (a *AuthRpc) RemoveUser(token []byte, username string) error {
+	var removeResponse auth.VoidResponseArg
+	err := a.call("SessionAuth.RemoveUser", &auth.RemoveUserRequestArg{
+		Token:    token,
+		Username: username,
+	}, &removeResponse)
+	if err != nil {
+		return err
+	}
+	a.cache.InvalidateUser(username)
+	return nil
+}
+
+// ListUsers returns, over RPC, all the registered users or, if
+// username is not empty, only the required one. Requires a
+// super-admin session token.
+func (a *AuthRpc) ListUsers(token []byte, username string) ([]auth.User, error) {
+	var listResponse auth.ListUsersResponseArg
+	err := a.call("SessionAuth.ListUsers", &auth.ListUsersRequestArg{
+		Token:    token,
+		Username: username,
+	}, &listResponse)
+	if err != nil {
+		return nil, err
+	}
+	return listResponse.Users, nil
+}
+
+// Ping verifies, over RPC, that the auth service is able to serve
+// requests.
+func (a *AuthRpc) Ping() error {
+	var pingResponse auth.VoidResponseArg
+	return a.call("Health.Ping", &auth.PingRequestArg{}, &pingResponse)
+}
+
 // Close closes RPC connection.
 func (a *AuthRpc) Close() error {
 	return a.client.Close()
@@ -38,11 +38,20 @@ var (
 		LastSeen: time.Now(),
 	}
 	mockUserPassword = "passwordA"
+	mockTotpCode     = "123456"
+	mockResetToken   = "00112233445566778899aabbccddeeff"
 )
 
 type authMock struct {
 	credentials map[string]string
 	sessions    map[string]*auth.UserInfoResponseArg
+	enrolled    map[string]bool                      // users having a not confirmed TOTP;
+	totp        map[string]bool                      // users having TOTP enabled;
+	apiKeys     map[string]*auth.UserInfoResponseArg // api keys by hex encoded key;
+	keyInfos    map[string]auth.ApiKey               // api keys by id;
+	resets      map[string]string                    // pending reset tokens by user;
+	users       map[string]*auth.User                // users managed by admins;
+	clients     map[string]auth.SessionInfo          // sessions client infos by hex encoded token.
 }
 
 func newAuthMock() (*authMock, error) {
@@ -51,18 +60,46 @@ func newAuthMock() (*authMock, error) {
 			mockUserInfo.Username: mockUserPassword,
 		},
 		sessions: make(map[string]*auth.UserInfoResponseArg),
+		enrolled: make(map[string]bool),
+		totp:     make(map[string]bool),
+		apiKeys:  make(map[string]*auth.UserInfoResponseArg),
+		keyInfos: make(map[string]auth.ApiKey),
+		resets:   make(map[string]string),
+		clients:  make(map[string]auth.SessionInfo),
+		users: map[string]*auth.User{
+			mockUserInfo.Username: &auth.User{
+				Username:    mockUserInfo.Username,
+				FullName:    mockUserInfo.FullName,
+				Email:       mockUserInfo.Email,
+				Permissions: *mockUserInfo.Permissions,
+			},
+		},
 	}, nil
 }
 
-func (a *authMock) Login(username string, password string) ([]byte, error) {
+func (a *authMock) Login(username, password, otp, address, userAgent string) ([]byte, error) {
 	if password != a.credentials[username] {
 		return nil, fmt.Errorf("wrong credentials")
 	}
+	if a.totp[username] {
+		if otp == "" {
+			return nil, auth.ErrOtpRequired
+		}
+		if otp != mockTotpCode {
+			return nil, fmt.Errorf("wrong code")
+		}
+	}
 	token, err := ct.RandomBytesForLen(32)
 	if err != nil {
 		return nil, err
 	}
 	a.sessions[hex.EncodeToString(token)] = mockUserInfo
+	a.clients[hex.EncodeToString(token)] = auth.SessionInfo{
+		Id:        hex.EncodeToString(token[:8]),
+		Address:   address,
+		UserAgent: userAgent,
+		LoginTime: time.Now(),
+	}
 	return token, nil
 }
 
@@ -71,7 +108,28 @@ func (a *authMock) Logout(token []byte) ([]byte, error) {
 	return token, nil
 }
 
+func (a *authMock) Refresh(token []byte) ([]byte, error) {
+	info, ok := a.sessions[hex.EncodeToString(token)]
+	if !ok {
+		return nil, fmt.Errorf("wrong session token")
+	}
+	refreshed, err := ct.RandomBytesForLen(32)
+	if err != nil {
+		return nil, err
+	}
+	delete(a.sessions, hex.EncodeToString(token))
+	a.sessions[hex.EncodeToString(refreshed)] = info
+	return refreshed, nil
+}
+
 func (a *authMock) AuthoriseAndGetInfo(token []byte) (*auth.UserInfoResponseArg, error) {
+	if auth.IsApiKey(token) {
+		info, ok := a.apiKeys[hex.EncodeToString(token)]
+		if !ok {
+			return nil, fmt.Errorf("wrong api key")
+		}
+		return info, nil
+	}
 	info, ok := a.sessions[hex.EncodeToString(token)]
 	if !ok {
 		return nil, fmt.Errorf("wrong session token")
@@ -79,6 +137,277 @@ func (a *authMock) AuthoriseAndGetInfo(token []byte) (*auth.UserInfoResponseArg,
 	return info, nil
 }
 
+func (a *authMock) TotpEnrol(token []byte) (*auth.TotpEnrolResponseArg, error) {
+	info, err := a.AuthoriseAndGetInfo(token)
+	if err != nil {
+		return nil, err
+	}
+	if a.totp[info.Username] {
+		return nil, fmt.Errorf("already enabled")
+	}
+	a.enrolled[info.Username] = true
+	return &auth.TotpEnrolResponseArg{
+		Secret: "MOCKSECRET",
+		URL:    "otpauth://totp/3nigm4:" + info.Username + "?secret=MOCKSECRET",
+	}, nil
+}
+
+func (a *authMock) TotpConfirm(token []byte, code string) ([]string, error) {
+	info, err := a.AuthoriseAndGetInfo(token)
+	if err != nil {
+		return nil, err
+	}
+	if !a.enrolled[info.Username] ||
+		code != mockTotpCode {
+		return nil, fmt.Errorf("wrong code")
+	}
+	delete(a.enrolled, info.Username)
+	a.totp[info.Username] = true
+	return []string{"aaaaa-bbbbb"}, nil
+}
+
+func (a *authMock) TotpDisable(token []byte, code string) error {
+	info, err := a.AuthoriseAndGetInfo(token)
+	if err != nil {
+		return err
+	}
+	if !a.totp[info.Username] ||
+		code != mockTotpCode {
+		return fmt.Errorf("wrong code")
+	}
+	delete(a.totp, info.Username)
+	return nil
+}
+
+func (a *authMock) CreateApiKey(request *auth.ApiKeyCreateRequestArg) (*auth.ApiKeyCreateResponseArg, error) {
+	info, ok := a.sessions[hex.EncodeToString(request.Token)]
+	if !ok {
+		return nil, fmt.Errorf("wrong session token")
+	}
+	if request.Name == "" ||
+		len(request.Scopes) == 0 {
+		return nil, fmt.Errorf("invalid request")
+	}
+	random, err := ct.RandomBytesForLen(40)
+	if err != nil {
+		return nil, err
+	}
+	key := append([]byte("3n4k"), random...)
+	apiKey := auth.ApiKey{
+		Id:               hex.EncodeToString(random[:8]),
+		Username:         info.Username,
+		Name:             request.Name,
+		Scopes:           request.Scopes,
+		AllowedAddresses: request.AllowedAddresses,
+		CreationTime:     time.Now(),
+		Expiration:       request.Expiration,
+	}
+	keyInfo := *info
+	keyInfo.ApiKey = apiKey.Id
+	keyInfo.Scopes = apiKey.Scopes
+	keyInfo.AllowedAddresses = apiKey.AllowedAddresses
+	keyInfo.Expiration = apiKey.Expiration
+	a.apiKeys[hex.EncodeToString(key)] = &keyInfo
+	a.keyInfos[apiKey.Id] = apiKey
+	return &auth.ApiKeyCreateResponseArg{
+		Key:  key,
+		Info: apiKey,
+	}, nil
+}
+
+func (a *authMock) ListApiKeys(token []byte) ([]auth.ApiKey, error) {
+	info, ok := a.sessions[hex.EncodeToString(token)]
+	if !ok {
+		return nil, fmt.Errorf("wrong session token")
+	}
+	keys := make([]auth.ApiKey, 0)
+	for _, key := range a.keyInfos {
+		if key.Username == info.Username {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (a *authMock) RevokeApiKey(token []byte, id string) error {
+	info, ok := a.sessions[hex.EncodeToString(token)]
+	if !ok {
+		return fmt.Errorf("wrong session token")
+	}
+	key, ok := a.keyInfos[id]
+	if !ok ||
+		key.Username != info.Username {
+		return fmt.Errorf("unknown api key")
+	}
+	delete(a.keyInfos, id)
+	for k, v := range a.apiKeys {
+		if v.ApiKey == id {
+			delete(a.apiKeys, k)
+		}
+	}
+	return nil
+}
+
+func (a *authMock) ChangePassword(token []byte, password, newPassword string) error {
+	info, ok := a.sessions[hex.EncodeToString(token)]
+	if !ok {
+		return fmt.Errorf("wrong session token")
+	}
+	if password != a.credentials[info.Username] {
+		return fmt.Errorf("wrong credentials")
+	}
+	a.credentials[info.Username] = newPassword
+	for k, v := range a.sessions {
+		if v.Username == info.Username &&
+			k != hex.EncodeToString(token) {
+			delete(a.sessions, k)
+		}
+	}
+	return nil
+}
+
+func (a *authMock) ResetPassword(token []byte, username string) error {
+	if err := a.superAdmin(token); err != nil {
+		return err
+	}
+	if _, ok := a.credentials[username]; !ok {
+		return fmt.Errorf("unknown user")
+	}
+	a.resets[username] = mockResetToken
+	return nil
+}
+
+func (a *authMock) CompletePasswordReset(username, resetToken, newPassword string) error {
+	if token, ok := a.resets[username]; !ok ||
+		token != resetToken {
+		return fmt.Errorf("invalid reset token")
+	}
+	delete(a.resets, username)
+	a.credentials[username] = newPassword
+	a.removeSessions(username)
+	return nil
+}
+
+func (a *authMock) superAdmin(token []byte) error {
+	info, ok := a.sessions[hex.EncodeToString(token)]
+	if !ok {
+		return fmt.Errorf("wrong session token")
+	}
+	if !info.Permissions.SuperAdmin {
+		return fmt.Errorf("not authorised")
+	}
+	return nil
+}
+
+func (a *authMock) removeSessions(username string) {
+	for k, v := range a.sessions {
+		if v.Username == username {
+			delete(a.sessions, k)
+		}
+	}
+}
+
+func (a *authMock) UpsertUser(token []byte, user *auth.User, password string) error {
+	if err := a.superAdmin(token); err != nil {
+		return err
+	}
+	if _, ok := a.users[user.Username]; !ok &&
+		password == "" {
+		return fmt.Errorf("password required")
+	}
+	if password != "" {
+		a.credentials[user.Username] = password
+	}
+	stored := *user
+	a.users[user.Username] = &stored
+	if user.IsDisabled {
+		a.removeSessions(user.Username)
+	}
+	return nil
+}
+
+func (a *authMock) RemoveUser(token []byte, username string) error {
+	if err := a.superAdmin(token); err != nil {
+		return err
+	}
+	if _, ok := a.users[username]; !ok {
+		return fmt.Errorf("unknown user")
+	}
+	delete(a.users, username)
+	delete(a.credentials, username)
+	a.removeSessions(username)
+	return nil
+}
+
+func (a *authMock) ListUsers(token []byte, username string) ([]auth.User, error) {
+	if err := a.superAdmin(token); err != nil {
+		return nil, err
+	}
+	users := make([]auth.User, 0)
+	for name, user := range a.users {
+		if username == "" ||
+			username == name {
+			users = append(users, *user)
+		}
+	}
+	if username != "" &&
+		len(users) == 0 {
+		return nil, fmt.Errorf("unknown user")
+	}
+	return users, nil
+}
+
+func (a *authMock) KickOutAllSessions(token []byte) error {
+	if err := a.superAdmin(token); err != nil {
+		return err
+	}
+	a.sessions = make(map[string]*auth.UserInfoResponseArg)
+	return nil
+}
+
+func (a *authMock) ListSessions(token []byte) ([]auth.SessionInfo, error) {
+	info, ok := a.sessions[hex.EncodeToString(token)]
+	if !ok {
+		return nil, fmt.Errorf("wrong session token")
+	}
+	sessions := make([]auth.SessionInfo, 0)
+	for k, v := range a.sessions {
+		if v.Username == info.Username {
+			client := a.clients[k]
+			client.Current = k == hex.EncodeToString(token)
+			sessions = append(sessions, client)
+		}
+	}
+	return sessions, nil
+}
+
+func (a *authMock) RevokeSessions(token []byte, id string, all bool) (int, error) {
+	info, ok := a.sessions[hex.EncodeToString(token)]
+	if !ok {
+		return 0, fmt.Errorf("wrong session token")
+	}
+	revoked := 0
+	for k, v := range a.sessions {
+		if v.Username != info.Username ||
+			k == hex.EncodeToString(token) && all {
+			continue
+		}
+		if all || a.clients[k].Id == id {
+			delete(a.sessions, k)
+			revoked++
+		}
+	}
+	if revoked == 0 &&
+		!all {
+		return 0, fmt.Errorf("unknown session")
+	}
+	return revoked, nil
+}
+
+func (a *authMock) Ping() error {
+	return nil
+}
+
 func (a *authMock) Close() error {
 	return nil
 }
@@ -0,0 +1,176 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Internal libs
+import (
+	"github.com/nexocrew/3nigm4/lib/auth"
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+const (
+	// maxBatchBodySize is the maximum size of batch requests
+	// bodies: chunk data are never carried by batches.
+	maxBatchBodySize = 1 << 20
+)
+
+// postBatch creates several async jobs with a single request
+// authorising the user only once. The outcome of each job creation
+// is reported in the response: failing jobs do not prevent the
+// other ones from being created. Uploads and downloads are not
+// executed as jobs: a transfer token is returned for each of them
+// (see createBatchTransfer).
+func postBatch(w http.ResponseWriter, r *http.Request) {
+	// authorise and get user's info
+	userInfo, err := authoriseGettingUserInfos(r, "")
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+
+	// parse json body
+	var batch ct.BatchJobRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxBatchBodySize)
+	err = json.NewDecoder(r.Body).Decode(&batch)
+	if err != nil {
+		riseError(http.StatusBadRequest,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	if len(batch.Jobs) == 0 ||
+		len(batch.Jobs) > ct.MaxBatchJobs {
+		riseError(http.StatusBadRequest,
+			fmt.Sprintf("batches should contain from 1 to %d jobs", ct.MaxBatchJobs), w,
+			r.RemoteAddr)
+		return
+	}
+
+	results := make([]ct.BatchJobResult, len(batch.Jobs))
+	for idx := range batch.Jobs {
+		results[idx] = createBatchJob(r, &batch.Jobs[idx], userInfo)
+	}
+
+	// return batch response message
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(
+		&ct.BatchJobResponse{
+			Jobs: results,
+		})
+	if err != nil {
+		panic(err)
+	}
+	if arguments.verbose {
+		log.VerboseLog("Batch of %d jobs processed for user %s.\n", len(results), userInfo.Username)
+	}
+}
+
+// createBatchJob creates a single job of a batch returning its
+// outcome.
+func createBatchJob(r *http.Request, job *ct.JobPostRequest, userInfo *auth.UserInfoResponseArg) ct.BatchJobResult {
+	if job.Arguments == nil ||
+		job.Arguments.ResourceID == "" {
+		return ct.BatchJobResult{
+			Status: http.StatusBadRequest,
+			Error:  "unable to process requests with nil arguments",
+		}
+	}
+	result := ct.BatchJobResult{
+		ResourceID: job.Arguments.ResourceID,
+	}
+	command, ok := jobCommands[job.Command]
+	if !ok {
+		result.Status = http.StatusBadRequest
+		result.Error = "unknown command"
+		return result
+	}
+	if err := userInfo.Authorise(jobScopes[job.Command], r.RemoteAddr); err != nil {
+		result.Status = http.StatusForbidden
+		result.Error = err.Error()
+		return result
+	}
+	if _, ok := transferCommands[job.Command]; ok {
+		return createBatchTransfer(r, job, userInfo)
+	}
+	jobId, status, err := command(r, job.Arguments, userInfo)
+	result.Status = status
+	if err != nil {
+		result.Error = err.Error()
+		if arguments.verbose {
+			log.ErrorLog("Batch job for %s failed: %s from IP %s.\n", job.Arguments.ResourceID, err.Error(), r.RemoteAddr)
+		}
+		return result
+	}
+	result.JobID = jobId
+	return result
+}
+
+// postBatchStatus verifies several previously created jobs with a
+// single request authorising the user only once. Completed jobs
+// results are returned and their async tx records removed, jobs
+// still in progress are reported with an http.StatusAccepted
+// status code.
+func postBatchStatus(w http.ResponseWriter, r *http.Request) {
+	// authorise and get user's info
+	userInfo, err := authoriseGettingUserInfos(r, "")
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+
+	// parse json body
+	var request ct.BatchStatusRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxBatchBodySize)
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		riseError(http.StatusBadRequest,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	if len(request.JobIDs) == 0 ||
+		len(request.JobIDs) > ct.MaxBatchJobs {
+		riseError(http.StatusBadRequest,
+			fmt.Sprintf("batches should contain from 1 to %d jobs", ct.MaxBatchJobs), w,
+			r.RemoteAddr)
+		return
+	}
+
+	statuses := make([]ct.BatchJobStatus, len(request.JobIDs))
+	for idx, id := range request.JobIDs {
+		result, status, err := jobStatus(id, userInfo)
+		statuses[idx] = ct.BatchJobStatus{
+			JobID:  id,
+			Status: status,
+			Result: result,
+		}
+		if err != nil {
+			statuses[idx].Error = err.Error()
+		}
+	}
+
+	// return batch status message
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(
+		&ct.BatchStatusResponse{
+			Jobs: statuses,
+		})
+	if err != nil {
+		panic(err)
+	}
+}
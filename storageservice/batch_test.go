@@ -0,0 +1,315 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Internal dependencies.
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+func TestStorageBatchJobs(t *testing.T) {
+	// login
+	loginBody := ct.LoginRequest{
+		Username: mockUserInfo.Username,
+		Password: mockUserPassword,
+	}
+	body, err := json.Marshal(&loginBody)
+	if err != nil {
+		t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+	}
+	client := &http.Client{}
+	resp, err := client.Post(
+		fmt.Sprintf("http://%s:%d/v1/authsession", mockServiceAddress, mockServicePort),
+		"application/json",
+		bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Unable to perform login request on server: %s.\n", err.Error())
+	}
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	var session ct.LoginResponse
+	err = json.Unmarshal(respBody, &session)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+
+	post := func(path string, request interface{}, response interface{}) int {
+		body, err := json.Marshal(request)
+		if err != nil {
+			t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+		}
+		req, err := http.NewRequest(
+			"POST",
+			fmt.Sprintf("http://%s:%d%s", mockServiceAddress, mockServicePort, path),
+			bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatalf("Unable to prepare the batch request: %s.\n", err.Error())
+		}
+		req.Header.Set(ct.SecurityTokenKey, session.Token)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Unable to perform batch request on server: %s.\n", err.Error())
+		}
+		defer resp.Body.Close()
+		if response != nil &&
+			resp.StatusCode == http.StatusOK {
+			err = json.NewDecoder(resp.Body).Decode(response)
+			if err != nil {
+				t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+			}
+		}
+		return resp.StatusCode
+	}
+	// runBatch creates the batch jobs and waits for their completion
+	runBatch := func(jobs []ct.JobPostRequest) ([]ct.BatchJobResult, map[string]*ct.JobGetRequest) {
+		var created ct.BatchJobResponse
+		status := post("/v1/storage/batch", &ct.BatchJobRequest{
+			Jobs: jobs,
+		}, &created)
+		if status != http.StatusOK {
+			t.Fatalf("Having status %d expecting %d.\n", status, http.StatusOK)
+		}
+		if len(created.Jobs) != len(jobs) {
+			t.Fatalf("Having %d results expecting %d.\n", len(created.Jobs), len(jobs))
+		}
+		results := make(map[string]*ct.JobGetRequest)
+		var pending []string
+		for _, job := range created.Jobs {
+			if job.JobID != "" {
+				pending = append(pending, job.JobID)
+			}
+		}
+		for retry := 0; len(pending) != 0; retry++ {
+			if retry == 100 {
+				t.Fatalf("Batch jobs not completed: %v.\n", pending)
+			}
+			var statuses ct.BatchStatusResponse
+			status := post("/v1/storage/batch/status", &ct.BatchStatusRequest{
+				JobIDs: pending,
+			}, &statuses)
+			if status != http.StatusOK {
+				t.Fatalf("Having status %d expecting %d.\n", status, http.StatusOK)
+			}
+			pending = nil
+			for _, job := range statuses.Jobs {
+				switch job.Status {
+				case http.StatusAccepted:
+					pending = append(pending, job.JobID)
+				case http.StatusOK:
+					results[job.JobID] = job.Result
+				default:
+					t.Fatalf("Unexpected job %s status %d: %s.\n", job.JobID, job.Status, job.Error)
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return created.Jobs, results
+	}
+
+	// transfer streams a chunk using a transfer token
+	transfer := func(method, id, token string, data []byte) (int, []byte) {
+		req, err := http.NewRequest(
+			method,
+			fmt.Sprintf("http://%s:%d/v1/storage/chunk/%s", mockServiceAddress, mockServicePort, id),
+			bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Unable to prepare the chunk request: %s.\n", err.Error())
+		}
+		req.Header.Set(ct.TransferTokenKey, token)
+		if method == "PUT" {
+			req.Header.Set(ct.CheckSumKey, hex.EncodeToString(fileContentCheckSum[:]))
+			req.Header.Set(ct.PermissionKey, strconv.Itoa(int(Private)))
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Unable to perform chunk request on server: %s.\n", err.Error())
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, body
+	}
+
+	ids := []string{"batcha", "batchb", "batchc"}
+	// uploads are authorised by the batch and streamed
+	var jobs []ct.JobPostRequest
+	for _, id := range ids {
+		jobs = append(jobs, ct.JobPostRequest{
+			Command: "UPLOAD",
+			Arguments: &ct.CommandArguments{
+				ResourceID: id,
+			},
+		})
+	}
+	// failing jobs do not affect the other ones
+	jobs = append(jobs, ct.JobPostRequest{
+		Command: "UPLOAD",
+		Arguments: &ct.CommandArguments{
+			ResourceID: "batchinline",
+			Data:       []byte(fileContent),
+			CheckSum:   fileContentCheckSum[:],
+		},
+	}, ct.JobPostRequest{
+		Command: "UNKNOWN",
+		Arguments: &ct.CommandArguments{
+			ResourceID: "batcha",
+		},
+	}, ct.JobPostRequest{
+		Command: "DOWNLOAD",
+	})
+	created, _ := runBatch(jobs)
+	for idx, id := range ids {
+		if created[idx].ResourceID != id ||
+			created[idx].Status != http.StatusOK ||
+			created[idx].JobID != "" ||
+			created[idx].TransferToken == "" {
+			t.Fatalf("Unexpected upload result: %+v.\n", created[idx])
+		}
+	}
+	for _, job := range created[len(ids):] {
+		if job.Status != http.StatusBadRequest ||
+			job.JobID != "" ||
+			job.TransferToken != "" ||
+			job.Error == "" {
+			t.Fatalf("Unexpected failing job result: %+v.\n", job)
+		}
+	}
+	if _, err := db.GetFileLog("batchinline"); err == nil {
+		t.Fatalf("Inline resource should not be stored.\n")
+	}
+	// tokens are only valid for their resource and method
+	if status, _ := transfer("PUT", ids[1], created[0].TransferToken, []byte(fileContent)); status != http.StatusUnauthorized {
+		t.Fatalf("Having status %d expecting %d.\n", status, http.StatusUnauthorized)
+	}
+	if status, _ := transfer("GET", ids[0], created[0].TransferToken, nil); status != http.StatusUnauthorized {
+		t.Fatalf("Having status %d expecting %d.\n", status, http.StatusUnauthorized)
+	}
+	if status, _ := transfer("PUT", ids[0], created[0].TransferToken[1:], []byte(fileContent)); status != http.StatusUnauthorized {
+		t.Fatalf("Having status %d expecting %d.\n", status, http.StatusUnauthorized)
+	}
+	for idx, id := range ids {
+		status, _ := transfer("PUT", id, created[idx].TransferToken, []byte(fileContent))
+		if status != http.StatusCreated {
+			t.Fatalf("Having status %d expecting %d.\n", status, http.StatusCreated)
+		}
+		fileLog, err := db.GetFileLog(id)
+		if err != nil ||
+			fileLog.Ownership.Username != mockUserInfo.Username {
+			t.Fatalf("Unexpected stored file log for %s.\n", id)
+		}
+	}
+
+	// downloads are streamed while stats are async jobs
+	jobs = nil
+	for _, id := range ids {
+		jobs = append(jobs, ct.JobPostRequest{
+			Command: "DOWNLOAD",
+			Arguments: &ct.CommandArguments{
+				ResourceID: id,
+			},
+		}, ct.JobPostRequest{
+			Command: "STAT",
+			Arguments: &ct.CommandArguments{
+				ResourceID: id,
+			},
+		})
+	}
+	jobs = append(jobs, ct.JobPostRequest{
+		Command: "DOWNLOAD",
+		Arguments: &ct.CommandArguments{
+			ResourceID: "batchmissing",
+		},
+	})
+	created, results := runBatch(jobs)
+	for idx, id := range ids {
+		download := created[2*idx]
+		if download.Status != http.StatusOK ||
+			download.TransferToken == "" {
+			t.Fatalf("Unexpected download result: %+v.\n", download)
+		}
+		status, data := transfer("GET", id, download.TransferToken, nil)
+		if status != http.StatusOK ||
+			string(data) != fileContent {
+			t.Fatalf("Unexpected download of %s: status %d.\n", id, status)
+		}
+		stat := results[created[2*idx+1].JobID]
+		if stat == nil ||
+			stat.Error != "" ||
+			stat.Size != len(fileContent) {
+			t.Fatalf("Unexpected stat result: %+v.\n", stat)
+		}
+	}
+	if missing := created[len(created)-1]; missing.Status != http.StatusNotFound ||
+		missing.TransferToken != "" {
+		t.Fatalf("Unexpected missing download result: %+v.\n", missing)
+	}
+
+	// verified jobs are no more available
+	var statuses ct.BatchStatusResponse
+	post("/v1/storage/batch/status", &ct.BatchStatusRequest{
+		JobIDs: []string{created[1].JobID},
+	}, &statuses)
+	if len(statuses.Jobs) != 1 ||
+		statuses.Jobs[0].Status != http.StatusGone {
+		t.Fatalf("Unexpected statuses: %+v.\n", statuses)
+	}
+
+	// deletes
+	jobs = nil
+	for _, id := range ids {
+		jobs = append(jobs, ct.JobPostRequest{
+			Command: "DELETE",
+			Arguments: &ct.CommandArguments{
+				ResourceID: id,
+			},
+		})
+	}
+	created, results = runBatch(jobs)
+	for idx, id := range ids {
+		if results[created[idx].JobID] == nil {
+			t.Fatalf("Unexpected delete result: %+v.\n", created[idx])
+		}
+		if _, err := db.GetFileLog(id); err == nil {
+			t.Fatalf("File log %s should be removed.\n", id)
+		}
+	}
+
+	// wrong batches
+	if status := post("/v1/storage/batch", &ct.BatchJobRequest{}, nil); status != http.StatusBadRequest {
+		t.Fatalf("Having status %d expecting %d.\n", status, http.StatusBadRequest)
+	}
+	if status := post("/v1/storage/batch/status", &ct.BatchStatusRequest{
+		JobIDs: make([]string, ct.MaxBatchJobs+1),
+	}, nil); status != http.StatusBadRequest {
+		t.Fatalf("Having status %d expecting %d.\n", status, http.StatusBadRequest)
+	}
+	// oversized bodies are refused
+	if status := post("/v1/storage/batch", &ct.BatchJobRequest{
+		Jobs: []ct.JobPostRequest{
+			{
+				Command: "UPLOAD",
+				Arguments: &ct.CommandArguments{
+					ResourceID: "batchoversized",
+					Data:       make([]byte, maxBatchBodySize),
+				},
+			},
+		},
+	}, nil); status != http.StatusBadRequest {
+		t.Fatalf("Having status %d expecting %d.\n", status, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,414 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Internal libs
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+// Third party
+import (
+	"github.com/gorilla/mux"
+	"gopkg.in/mgo.v2"
+)
+
+// chunkUploadArgs contains upload properties passed, in a
+// streamed chunk upload request, as http headers.
+type chunkUploadArgs struct {
+	checksum     []byte
+	timeToLive   time.Duration
+	permission   ct.Permission
+	sharingUsers []string
+}
+
+// parseChunkUploadHeaders extracts upload arguments from the
+// request headers: the checksum is mandatory while all other
+// values are optional.
+func parseChunkUploadHeaders(r *http.Request) (*chunkUploadArgs, error) {
+	var err error
+	args := &chunkUploadArgs{}
+	hexChecksum := r.Header.Get(ct.CheckSumKey)
+	if hexChecksum == "" {
+		return nil, fmt.Errorf("checksum header is required")
+	}
+	args.checksum, err = hex.DecodeString(hexChecksum)
+	if err != nil {
+		return nil, fmt.Errorf("checksum header is malformed (%s)", err.Error())
+	}
+	if len(args.checksum) != sha256.Size {
+		return nil, fmt.Errorf("unexpected checksum size, having %d expecting %d", len(args.checksum), sha256.Size)
+	}
+	if ttl := r.Header.Get(ct.TimeToLiveKey); ttl != "" {
+		args.timeToLive, err = time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("time to live header is malformed (%s)", err.Error())
+		}
+	}
+	if permission := r.Header.Get(ct.PermissionKey); permission != "" {
+		value, err := strconv.Atoi(permission)
+		if err != nil {
+			return nil, fmt.Errorf("permission header is malformed (%s)", err.Error())
+		}
+		args.permission = ct.Permission(value)
+	}
+	if sharing := r.Header.Get(ct.SharingUsersKey); sharing != "" {
+		args.sharingUsers = strings.Split(sharing, ",")
+	}
+	return args, nil
+}
+
+// putChunk creates a new storage resource streaming the raw
+// request body (application/octet-stream) to the storage backend. The
+// SHA256 checksum passed in the headers is verified against the
+// received data: in case of mismatch the resource is removed. The
+// content length is required to reserve, in the user's quota, the
+// space of the resource before receiving it.
+// Differently from the job based API the operation is synchronous.
+// The request can be authorised with a transfer token obtained with
+// a batch instead of the session token.
+func putChunk(w http.ResponseWriter, r *http.Request) {
+	// get id from url
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok || id == "" {
+		riseError(http.StatusBadRequest,
+			"unable to proceed with nil id", w,
+			r.RemoteAddr)
+		return
+	}
+
+	// authorise and get user's info from the session or the
+	// transfer token
+	userInfo, err := authoriseChunkTransfer(r, id, uploadScope)
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+
+	args, err := parseChunkUploadHeaders(r)
+	if err != nil {
+		riseError(http.StatusBadRequest,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	if r.ContentLength < 0 {
+		riseError(http.StatusLengthRequired,
+			"content length is required", w,
+			r.RemoteAddr)
+		return
+	}
+
+	// get time stamp
+	now := time.Now()
+	// retain db
+	dbSession := db.Copy()
+	defer dbSession.Close()
+	// insert file log in the database
+	fl := &FileLog{
+		Id:         id,
+		Size:       int(r.ContentLength), // provisional, updated once completed.
+		Bucket:     arguments.s3Bucket,
+		Creation:   now,
+		TimeToLive: args.timeToLive,
+		CheckSum: ct.CheckSum{
+			Hash: args.checksum,
+			Type: "SHA256",
+		},
+		Ownership: Owner{
+			Username:  userInfo.Username,
+			OriginIp:  r.RemoteAddr,
+			UserAgent: r.UserAgent(),
+		},
+		Acl: Acl{
+			Permission:   args.permission,
+			SharingUsers: args.sharingUsers,
+		},
+	}
+	if fl.TimeToLive != 0 {
+		fl.Expiration = fl.Creation.Add(fl.TimeToLive)
+	}
+	key, err := newDataKey(fl)
+	if err != nil {
+		riseError(http.StatusInternalServerError,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	// the unique id index refuses concurrent uploads of the same
+	// resource
+	err = dbSession.SetFileLog(fl)
+	if mgo.IsDup(err) {
+		riseError(http.StatusConflict,
+			fmt.Sprintf("resource %s already exists", id), w,
+			r.RemoteAddr)
+		return
+	}
+	if err != nil {
+		riseError(http.StatusInternalServerError,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	// verify user's quota, the file log reserves the declared
+	// size so data exceeding it are discarded
+	available, err := checkQuota(dbSession, userInfo, fl)
+	if err != nil {
+		dbSession.RemoveFileLog(fl.Id)
+		riseError(http.StatusForbidden,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+
+	// stream data to the backend computing the checksum
+	var expireTime *time.Time
+	if fl.TimeToLive != 0 {
+		expireTime = &fl.Expiration
+	}
+	counter := &byteCounter{}
+	var source io.Reader = r.Body
+	if available >= 0 {
+		source = io.LimitReader(r.Body, available+1)
+	}
+	digest := newDigestReader(io.TeeReader(source, counter), args.checksum)
+	var body io.Reader = digest
+	if key != nil {
+		body, err = newEncryptingReader(key, digest)
+		if err != nil {
+			dbSession.RemoveFileLog(fl.Id)
+			riseError(http.StatusInternalServerError,
+				fmt.Sprintf("unable to encrypt resource: %s", err.Error()), w,
+				r.RemoteAddr)
+			return
+		}
+	}
+	err = backend.UploadStream(fl.Bucket, fl.Id, body, expireTime)
+	if err != nil {
+		dbSession.RemoveFileLog(fl.Id)
+		switch {
+		case available >= 0 &&
+			int64(counter.count) > available:
+			recordAuditEvent(dbSession, fl, userInfo.Username, ct.AuditUpload, ct.AuditFailure, r.RemoteAddr)
+			riseError(http.StatusForbidden,
+				fmt.Sprintf("storage quota exceeded: only %d bytes available, resource has not been stored", available), w,
+				r.RemoteAddr)
+		case digest.mismatch:
+			recordAuditEvent(dbSession, fl, userInfo.Username, ct.AuditUpload, ct.AuditFailure, r.RemoteAddr)
+			riseError(http.StatusBadRequest,
+				"checksum mismatch, resource has not been stored", w,
+				r.RemoteAddr)
+		default:
+			riseError(http.StatusInternalServerError,
+				fmt.Sprintf("unable to store resource: %s", err.Error()), w,
+				r.RemoteAddr)
+		}
+		return
+	}
+
+	// verify quota
+	if available >= 0 &&
+		int64(counter.count) > available {
+		discardChunk(fl, &now)
+		recordAuditEvent(dbSession, fl, userInfo.Username, ct.AuditUpload, ct.AuditFailure, r.RemoteAddr)
+		riseError(http.StatusForbidden,
+			fmt.Sprintf("storage quota exceeded: only %d bytes available, resource has been discarded", available), w,
+			r.RemoteAddr)
+		return
+	}
+	// re-verify data stored in the backend
+	err = verifyStoredChunk(fl)
+	if err != nil {
+		discardChunk(fl, &now)
+		recordAuditEvent(dbSession, fl, userInfo.Username, ct.AuditUpload, ct.AuditFailure, r.RemoteAddr)
+		riseError(http.StatusInternalServerError,
+			fmt.Sprintf("unable to verify stored resource: %s, resource has been discarded", err.Error()), w,
+			r.RemoteAddr)
+		return
+	}
+
+	// complete file log
+	fl.Size = counter.count
+	fl.Complete = true
+	err = dbSession.UpdateFileLog(fl)
+	if err != nil {
+		riseError(http.StatusInternalServerError,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	recordAuditEvent(dbSession, fl, userInfo.Username, ct.AuditUpload, ct.AuditSuccess, r.RemoteAddr)
+
+	// return upload response message
+	w.Header().Set(ct.CheckSumKey, hex.EncodeToString(args.checksum))
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(
+		&ct.StandardResponse{
+			Status: ct.AckResponse,
+		})
+	if err != nil {
+		panic(err)
+	}
+	if arguments.verbose {
+		log.VerboseLog("Chunk %s (%d bytes) correctly stored.\n", fl.Id, fl.Size)
+	}
+}
+
+// discardChunk removes a not valid uploaded resource using the
+// async delete flow, that'll also remove the file log.
+func discardChunk(fl *FileLog, now *time.Time) {
+	jobId := generateTranscationId(fl.Id, fl.Ownership.Username, now)
+	err := jobs.SetAsyncTx(&AsyncTx{
+		Id:        jobId,
+		Complete:  false,
+		TimeStamp: *now,
+		Ownership: fl.Ownership,
+	})
+	if err != nil {
+		log.ErrorLog("Unable to create async tx for discarded resource %s: %s.\n", fl.Id, err.Error())
+	}
+	backend.Delete(fl.Bucket, fl.Id, jobId)
+}
+
+// getChunk streams a stored resource, as application/octet-stream,
+// directly from the storage backend to the client. The SHA256 checksum
+// recorded at upload time is returned in the headers. As for uploads
+// a transfer token can be used instead of the session token.
+func getChunk(w http.ResponseWriter, r *http.Request) {
+	// get id from url
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok || id == "" {
+		riseError(http.StatusBadRequest,
+			"unable to proceed with nil id", w,
+			r.RemoteAddr)
+		return
+	}
+
+	// authorise and get user's info from the session or the
+	// transfer token
+	userInfo, err := authoriseChunkTransfer(r, id, downloadScope)
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+
+	// retain db
+	dbSession := db.Copy()
+	defer dbSession.Close()
+	// get resources info
+	fileLog, err := dbSession.GetFileLog(id)
+	if err != nil ||
+		fileLog.Complete == false {
+		riseError(http.StatusNotFound,
+			fmt.Sprintf("requested file not found"), w,
+			r.RemoteAddr)
+		return
+	}
+
+	// expired files are no more available even if not
+	// yet removed by the reaper
+	if fileLogExpired(fileLog, time.Now()) {
+		riseError(http.StatusGone,
+			fmt.Sprintf("requested file is expired"), w,
+			r.RemoteAddr)
+		return
+	}
+
+	// check permission
+	granted := checkAclPermission(userInfo, fileLog)
+	if !granted {
+		recordAuditEvent(dbSession, fileLog, userInfo.Username, ct.AuditDownload, ct.AuditDenied, r.RemoteAddr)
+		riseError(http.StatusForbidden,
+			fmt.Sprintf("you are not authorised to access this resource"), w,
+			r.RemoteAddr)
+		return
+	}
+
+	err = streamChunk(w, r, fileLog)
+	recordAuditEvent(dbSession, fileLog, userInfo.Username, ct.AuditDownload, asyncOutcome(err), r.RemoteAddr)
+}
+
+// streamChunk streams the resource described by the argument file
+// log from the storage backend to the client, it's shared between
+// authenticated and link based downloads. The returned error, if
+// any, has been already managed and is only used for auditing.
+func streamChunk(w http.ResponseWriter, r *http.Request, fileLog *FileLog) error {
+	key, err := dataKey(fileLog)
+	if err != nil {
+		riseError(http.StatusInternalServerError,
+			err.Error(), w,
+			r.RemoteAddr)
+		return err
+	}
+	stored, size, err := backend.DownloadStream(fileLog.Bucket, fileLog.Id)
+	if err != nil {
+		riseError(http.StatusInternalServerError,
+			fmt.Sprintf("unable to retrieve resource: %s", err.Error()), w,
+			r.RemoteAddr)
+		return err
+	}
+	defer stored.Close()
+	var body io.Reader = stored
+	if key != nil {
+		body, err = newDecryptingReader(key, stored)
+		if err != nil {
+			riseError(http.StatusInternalServerError,
+				fmt.Sprintf("unable to decrypt resource: %s", err.Error()), w,
+				r.RemoteAddr)
+			return err
+		}
+		// stored size includes encryption overhead and padding
+		size = int64(fileLog.Size)
+	}
+
+	// stream data
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set(ct.CheckSumKey, hex.EncodeToString(fileLog.CheckSum.Hash))
+	if size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	written, err := io.Copy(w, body)
+	if err != nil {
+		log.ErrorLog("Unable to stream chunk %s to %s: %s.\n", fileLog.Id, r.RemoteAddr, err.Error())
+		return err
+	}
+	if arguments.verbose {
+		log.VerboseLog("Chunk %s (%d bytes) correctly streamed.\n", fileLog.Id, written)
+	}
+	return nil
+}
+
+// byteCounter is an io.Writer that only counts written bytes.
+type byteCounter struct {
+	count int
+}
+
+// Write counts the passed bytes.
+func (b *byteCounter) Write(p []byte) (int, error) {
+	b.count += len(p)
+	return len(p), nil
+}
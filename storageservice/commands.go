@@ -8,8 +8,8 @@ package main
 
 // Golang std libs
 import (
+	"bytes"
 	"crypto/sha256"
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"time"
@@ -21,16 +21,41 @@ import (
 	ct "github.com/nexocrew/3nigm4/lib/commons"
 )
 
+// jobCommand implements an async job command: it returns the id of
+// the created job or the http status code and the error describing
+// the failure.
+type jobCommand func(r *http.Request, args *ct.CommandArguments, userInfo *auth.UserInfoResponseArg) (string, int, error)
+
+// jobCommands maps the available commands to their implementation.
+var jobCommands = map[string]jobCommand{
+	"UPLOAD":   createStorageResource,
+	"DOWNLOAD": retrieveStorageResource,
+	"DELETE":   deleteStorageResource,
+	"STAT":     statStorageResource,
+}
+
+// jobScopes maps the available commands to the scope required to
+// api keys to execute them.
+var jobScopes = map[string]string{
+	"UPLOAD":   uploadScope,
+	"DOWNLOAD": downloadScope,
+	"DELETE":   deleteScope,
+	"STAT":     readScope,
+}
+
 // createStorageResource upload a data chunk to the S3 backend service
 // after authorising the user. It operates in async mode to perform the
 // actual upload using a working queue to integrate S3 backend.
-func createStorageResource(w http.ResponseWriter, r *http.Request, args *ct.JobPostRequest, userInfo *auth.UserInfoResponseArg) {
-	if args.Arguments.Data == nil ||
-		len(args.Arguments.Data) == 0 {
-		riseError(http.StatusBadRequest,
-			"data in request body is nil", w,
-			r.RemoteAddr)
-		return
+func createStorageResource(r *http.Request, args *ct.CommandArguments, userInfo *auth.UserInfoResponseArg) (string, int, error) {
+	if args.Data == nil ||
+		len(args.Data) == 0 {
+		return "", http.StatusBadRequest, fmt.Errorf("data in request body is nil")
+	}
+	// verify data against the client digest, if provided
+	checksum := sha256.Sum256(args.Data)
+	if len(args.CheckSum) != 0 &&
+		!bytes.Equal(checksum[:], args.CheckSum) {
+		return "", http.StatusBadRequest, fmt.Errorf("checksum mismatch, resource has not been stored")
 	}
 
 	// get time stamp
@@ -39,13 +64,12 @@ func createStorageResource(w http.ResponseWriter, r *http.Request, args *ct.JobP
 	dbSession := db.Copy()
 	defer dbSession.Close()
 	// insert file log in the database
-	checksum := sha256.Sum256(args.Arguments.Data)
 	fl := &FileLog{
-		Id:         args.Arguments.ResourceID,
-		Size:       len(args.Arguments.Data),
+		Id:         args.ResourceID,
+		Size:       len(args.Data),
 		Bucket:     arguments.s3Bucket,
 		Creation:   now,
-		TimeToLive: args.Arguments.TimeToLive,
+		TimeToLive: args.TimeToLive,
 		CheckSum: ct.CheckSum{
 			Hash: checksum[:],
 			Type: "SHA256",
@@ -56,55 +80,58 @@ func createStorageResource(w http.ResponseWriter, r *http.Request, args *ct.JobP
 			UserAgent: r.UserAgent(),
 		},
 		Acl: Acl{
-			Permission:   args.Arguments.Permission,
-			SharingUsers: args.Arguments.SharingUsers,
+			Permission:   args.Permission,
+			SharingUsers: args.SharingUsers,
 		},
 	}
-	err := dbSession.SetFileLog(fl)
+	if fl.TimeToLive != 0 {
+		fl.Expiration = fl.Creation.Add(fl.TimeToLive)
+	}
+	// encrypt data, if required
+	key, err := newDataKey(fl)
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	data, err := encryptData(key, args.Data)
 	if err != nil {
-		riseError(http.StatusInternalServerError,
-			err.Error(), w,
-			r.RemoteAddr)
-		return
+		return "", http.StatusInternalServerError, fmt.Errorf("unable to encrypt resource: %s", err.Error())
+	}
+	err = dbSession.SetFileLog(fl)
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	// verify user's quota
+	_, err = checkQuota(dbSession, userInfo, fl)
+	if err != nil {
+		dbSession.RemoveFileLog(fl.Id)
+		return "", http.StatusForbidden, err
 	}
 
 	// generate tx id
 	jobId := generateTranscationId(fl.Id, userInfo.Username, &now)
 
 	// add async tx record
-	err = dbSession.SetAsyncTx(&AsyncTx{
+	err = jobs.SetAsyncTx(&AsyncTx{
 		Id:        jobId,
 		Complete:  false,
 		TimeStamp: fl.Creation,
 		Ownership: fl.Ownership,
 	})
 	if err != nil {
-		riseError(http.StatusInternalServerError,
-			err.Error(), w,
-			r.RemoteAddr)
-		return
+		return "", http.StatusInternalServerError, err
 	}
 
 	// upload data to the S3 backend
 	var expireTime *time.Time
 	if fl.TimeToLive != 0 {
-		ttl := fl.Creation.Add(fl.TimeToLive)
-		expireTime = &ttl
+		expireTime = &fl.Expiration
 	}
-	s3backend.Upload(fl.Bucket, fl.Id, jobId, args.Arguments.Data, expireTime)
+	backend.Upload(fl.Bucket, fl.Id, jobId, data, expireTime)
 
-	// return upload response message
-	w.WriteHeader(http.StatusAccepted)
-	err = json.NewEncoder(w).Encode(
-		&ct.JobPostResponse{
-			JobID: jobId,
-		})
-	if err != nil {
-		panic(err)
-	}
 	if arguments.verbose {
 		log.VerboseLog("Upload request %s accepted, waiting for upload verification", jobId)
 	}
+	return jobId, http.StatusAccepted, nil
 }
 
 // checkAclPermission verify all possible acl scenarios and check if the
@@ -138,33 +165,34 @@ func checkAclPermission(userInfo *auth.UserInfoResponseArg, fileLog *FileLog) bo
 // it is exposed via a REST GET method and returns a txId usable with the verify
 // API call toretrieve the actual downloaded data (from S3 storage). The user
 // must be correctly authenticated to be able to access the requested resource.
-func retrieveStorageResource(w http.ResponseWriter, r *http.Request, args *ct.JobPostRequest, userInfo *auth.UserInfoResponseArg) {
+func retrieveStorageResource(r *http.Request, args *ct.CommandArguments, userInfo *auth.UserInfoResponseArg) (string, int, error) {
 	// retain db
 	dbSession := db.Copy()
 	defer dbSession.Close()
 	// get resources info
-	fileLog, err := dbSession.GetFileLog(args.Arguments.ResourceID)
+	fileLog, err := dbSession.GetFileLog(args.ResourceID)
 	if err != nil {
-		riseError(http.StatusNotFound,
-			fmt.Sprintf("requested file not found"), w,
-			r.RemoteAddr)
-		return
+		return "", http.StatusNotFound, fmt.Errorf("requested file not found")
+	}
+
+	// expired files are no more available even if not
+	// yet removed by the reaper
+	if fileLogExpired(fileLog, time.Now()) {
+		return "", http.StatusGone, fmt.Errorf("requested file is expired")
 	}
 
 	// check permission
 	granted := checkAclPermission(userInfo, fileLog)
 	if !granted {
-		riseError(http.StatusUnauthorized,
-			fmt.Sprintf("you are not authorised to access this resource"), w,
-			r.RemoteAddr)
-		return
+		recordAuditEvent(dbSession, fileLog, userInfo.Username, ct.AuditDownload, ct.AuditDenied, r.RemoteAddr)
+		return "", http.StatusForbidden, fmt.Errorf("you are not authorised to access this resource")
 	}
 
 	now := time.Now()
 	// generate tx id
-	jobId := generateTranscationId(args.Arguments.ResourceID, userInfo.Username, &now)
+	jobId := generateTranscationId(args.ResourceID, userInfo.Username, &now)
 	// add async tx record
-	err = dbSession.SetAsyncTx(&AsyncTx{
+	err = jobs.SetAsyncTx(&AsyncTx{
 		Id:        jobId,
 		Complete:  false,
 		TimeStamp: now,
@@ -175,42 +203,28 @@ func retrieveStorageResource(w http.ResponseWriter, r *http.Request, args *ct.Jo
 		},
 	})
 	if err != nil {
-		riseError(http.StatusInternalServerError,
-			err.Error(), w,
-			r.RemoteAddr)
-		return
+		return "", http.StatusInternalServerError, err
 	}
 
 	// require S3 download
-	s3backend.Download(arguments.s3Bucket, args.Arguments.ResourceID, jobId)
+	backend.Download(arguments.s3Bucket, args.ResourceID, jobId)
 
-	// return download response message
-	w.WriteHeader(http.StatusAccepted)
-	err = json.NewEncoder(w).Encode(
-		&ct.JobPostResponse{
-			JobID: jobId,
-		})
-	if err != nil {
-		panic(err)
-	}
 	if arguments.verbose {
 		log.VerboseLog("Download request %s accepted, waiting for download verification", jobId)
 	}
+	return jobId, http.StatusAccepted, nil
 }
 
 // deleteStorageResource remove a file from the S3 storage: only the original file
 // owner (who uploaded it) can remove a file from there.
-func deleteStorageResource(w http.ResponseWriter, r *http.Request, args *ct.JobPostRequest, userInfo *auth.UserInfoResponseArg) {
+func deleteStorageResource(r *http.Request, args *ct.CommandArguments, userInfo *auth.UserInfoResponseArg) (string, int, error) {
 	// retain db
 	dbSession := db.Copy()
 	defer dbSession.Close()
 	// get resources info
-	fileLog, err := dbSession.GetFileLog(args.Arguments.ResourceID)
+	fileLog, err := dbSession.GetFileLog(args.ResourceID)
 	if err != nil {
-		riseError(http.StatusNotFound,
-			fmt.Sprintf("requested file not found"), w,
-			r.RemoteAddr)
-		return
+		return "", http.StatusNotFound, fmt.Errorf("requested file not found")
 	}
 
 	// check permission
@@ -221,17 +235,15 @@ func deleteStorageResource(w http.ResponseWriter, r *http.Request, args *ct.JobP
 		granted = true
 	}
 	if !granted {
-		riseError(http.StatusUnauthorized,
-			fmt.Sprintf("you are not authorised to delete this resource"), w,
-			r.RemoteAddr)
-		return
+		recordAuditEvent(dbSession, fileLog, userInfo.Username, ct.AuditDelete, ct.AuditDenied, r.RemoteAddr)
+		return "", http.StatusForbidden, fmt.Errorf("you are not authorised to delete this resource")
 	}
 
 	now := time.Now()
 	// generate tx id
-	jobId := generateTranscationId(args.Arguments.ResourceID, userInfo.Username, &now)
+	jobId := generateTranscationId(args.ResourceID, userInfo.Username, &now)
 	// add async tx record
-	err = dbSession.SetAsyncTx(&AsyncTx{
+	err = jobs.SetAsyncTx(&AsyncTx{
 		Id:        jobId,
 		Complete:  false,
 		TimeStamp: now,
@@ -242,25 +254,60 @@ func deleteStorageResource(w http.ResponseWriter, r *http.Request, args *ct.JobP
 		},
 	})
 	if err != nil {
-		riseError(http.StatusInternalServerError,
-			err.Error(), w,
-			r.RemoteAddr)
-		return
+		return "", http.StatusInternalServerError, err
 	}
 
 	// require S3 download
-	s3backend.Delete(arguments.s3Bucket, args.Arguments.ResourceID, jobId)
+	backend.Delete(arguments.s3Bucket, args.ResourceID, jobId)
+
+	if arguments.verbose {
+		log.VerboseLog("Delete request %s accepted, waiting for delete verification", jobId)
+	}
+	return jobId, http.StatusAccepted, nil
+}
 
-	// return download response message
-	w.WriteHeader(http.StatusAccepted)
-	err = json.NewEncoder(w).Encode(
-		&ct.JobPostResponse{
-			JobID: jobId,
-		})
+// statStorageResource verifies that a resource is available in the
+// storage backend: the verify API call returns its size. The user
+// must be authorised to access the requested resource.
+func statStorageResource(r *http.Request, args *ct.CommandArguments, userInfo *auth.UserInfoResponseArg) (string, int, error) {
+	// retain db
+	dbSession := db.Copy()
+	defer dbSession.Close()
+	// get resources info
+	fileLog, err := dbSession.GetFileLog(args.ResourceID)
+	if err != nil {
+		return "", http.StatusNotFound, fmt.Errorf("requested file not found")
+	}
+	if fileLogExpired(fileLog, time.Now()) {
+		return "", http.StatusGone, fmt.Errorf("requested file is expired")
+	}
+	if !checkAclPermission(userInfo, fileLog) {
+		return "", http.StatusForbidden, fmt.Errorf("you are not authorised to access this resource")
+	}
+
+	now := time.Now()
+	// generate tx id
+	jobId := generateTranscationId(args.ResourceID, userInfo.Username, &now)
+	// add async tx record
+	err = jobs.SetAsyncTx(&AsyncTx{
+		Id:        jobId,
+		Complete:  false,
+		TimeStamp: now,
+		Ownership: Owner{
+			Username:  userInfo.Username,
+			OriginIp:  r.RemoteAddr,
+			UserAgent: r.UserAgent(),
+		},
+	})
 	if err != nil {
-		panic(err)
+		return "", http.StatusInternalServerError, err
 	}
+
+	// require backend stat
+	backend.Stat(arguments.s3Bucket, args.ResourceID, jobId)
+
 	if arguments.verbose {
-		log.VerboseLog("Delete request %s accepted, waiting for delete verification", jobId)
+		log.VerboseLog("Stat request %s accepted, waiting for stat verification", jobId)
 	}
+	return jobId, http.StatusAccepted, nil
 }
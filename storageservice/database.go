@@ -29,16 +29,20 @@ import (
 const (
 	defaultDatabaseName           = "storageservice"
 	defaultFilesLogCollectionName = "fileslog"
-	defaultAsyncTxCollectionName  = "asynctx"
+	defaultDeletionCollectionName = "deletionlog"
+	defaultLinksCollectionName    = "downloadlinks"
+	defaultAuditCollectionName    = "auditlog"
 	envDatabaseName               = "NEXO_FILESLOG_DATABASE"
 	envFilesLogCollectionName     = "NEXO_FILESLOG_COLLECTION"
-	envAsyncTxCollectionName      = "NEXO_ASYNCTX_COLLECTION"
+	envDeletionCollectionName     = "NEXO_DELETIONLOG_COLLECTION"
+	envLinksCollectionName        = "NEXO_DOWNLOADLINKS_COLLECTION"
+	envAuditCollectionName        = "NEXO_AUDITLOG_COLLECTION"
 )
 
-// MaxAsyncTxExistance represent the maximum time
-// that an async job can remain pending in the database
-// before being automatically deleted.
-var MaxAsyncTxExistance = 1 * time.Hour
+// AuditEventExistance represent the time audit events
+// are retained before being automatically deleted, if
+// zero events are never removed.
+var AuditEventExistance = 90 * 24 * time.Hour
 
 // dbArgs is the exposed arguments
 // required by each database interface
@@ -56,16 +60,28 @@ type database interface {
 	// db client related functions
 	Copy() database // retain the db client in a multi-coroutine environment;
 	Close()         // release the client;
+	Ping() error    // verify the db is reachable;
 	// db create file log
 	SetFileLog(fl *FileLog) error             // add a new file log when a file is uploaded;
 	UpdateFileLog(fl *FileLog) error          // update an existing file log;
 	GetFileLog(file string) (*FileLog, error) // get infos to a previously uploaded file;
 	RemoveFileLog(file string) error          // remove a previously added file log;
-	// async tx
-	SetAsyncTx(at *AsyncTx) error           // add a new async tx record;
-	UpdateAsyncTx(at *AsyncTx) error        // update an existing async tx;
-	GetAsyncTx(id string) (*AsyncTx, error) // get an existing tx;
-	RemoveAsyncTx(id string) error          // remove an existing tx (typically should be done automatically with a ttl setup).
+	// expired files
+	GetExpiredFileLogs(now time.Time, limit int) ([]FileLog, error) // get file logs expired before now;
+	SetDeletionLog(dl *DeletionLog) error                           // record a file removed by the reaper;
+	// listing
+	GetFileLogsByOwner(username, after string, limit int) ([]FileLog, error) // get, sorted by id, file logs owned by a user with id greater than after;
+	// sharing
+	GetSharedFileLogs(username string, limit int) ([]FileLog, error) // get file logs shared with a user;
+	// usage accounting
+	GetUsage(username string) (*Usage, error) // aggregate size and count of files owned by a user;
+	// download links
+	SetDownloadLink(dl *DownloadLink) error           // add a new download link;
+	GetDownloadLink(id string) (*DownloadLink, error) // get an existing download link;
+	UseDownloadLink(id, resource string) error        // atomically mark a resource as downloaded, fails if already done;
+	// audit log
+	SetAuditEvent(ae *AuditEvent) error                                                      // append a new audit event;
+	GetAuditEvents(owner, resource string, since time.Time, limit int) ([]AuditEvent, error) // get, most recent first, events about resources owned by a user;
 }
 
 // mongodb database, wrapping mgo session
@@ -73,9 +89,11 @@ type database interface {
 type mongodb struct {
 	session *mgo.Session
 	// target nodes
-	databaseName      string
-	filelogCollection string
-	asyncTxCollection string
+	databaseName       string
+	filelogCollection  string
+	deletionCollection string
+	linksCollection    string
+	auditCollection    string
 }
 
 // composeDbAddress compose a string starting from dbArgs slice.
@@ -114,11 +132,23 @@ func MgoSession(args *dbArgs) (*mongodb, error) {
 	} else {
 		db.filelogCollection = defaultFilesLogCollectionName
 	}
-	env = os.Getenv(envAsyncTxCollectionName)
+	env = os.Getenv(envDeletionCollectionName)
 	if env != "" {
-		db.asyncTxCollection = env
+		db.deletionCollection = env
 	} else {
-		db.asyncTxCollection = defaultAsyncTxCollectionName
+		db.deletionCollection = defaultDeletionCollectionName
+	}
+	env = os.Getenv(envLinksCollectionName)
+	if env != "" {
+		db.linksCollection = env
+	} else {
+		db.linksCollection = defaultLinksCollectionName
+	}
+	env = os.Getenv(envAuditCollectionName)
+	if env != "" {
+		db.auditCollection = env
+	} else {
+		db.auditCollection = defaultAuditCollectionName
 	}
 	// connect to db
 	return db, nil
@@ -127,10 +157,12 @@ func MgoSession(args *dbArgs) (*mongodb, error) {
 // Copy the internal session to permitt multi corutine usage.
 func (d *mongodb) Copy() database {
 	return &mongodb{
-		session:           d.session.Copy(),
-		databaseName:      d.databaseName,
-		filelogCollection: d.filelogCollection,
-		asyncTxCollection: d.asyncTxCollection,
+		session:            d.session.Copy(),
+		databaseName:       d.databaseName,
+		filelogCollection:  d.filelogCollection,
+		deletionCollection: d.deletionCollection,
+		linksCollection:    d.linksCollection,
+		auditCollection:    d.auditCollection,
 	}
 }
 
@@ -139,6 +171,11 @@ func (d *mongodb) Close() {
 	d.session.Close()
 }
 
+// Ping verifies that the db is reachable.
+func (d *mongodb) Ping() error {
+	return d.session.Ping()
+}
+
 // GetFileLog get file informations from the db.
 func (d *mongodb) GetFileLog(filename string) (*FileLog, error) {
 	// build query
@@ -196,66 +233,176 @@ func (d *mongodb) RemoveFileLog(filename string) error {
 	return nil
 }
 
-// GetAsyncTx returns an async tx document from the mongodb
-// instance.
-func (d *mongodb) GetAsyncTx(id string) (*AsyncTx, error) {
+// GetExpiredFileLogs returns, at max, limit file logs whose
+// expiration time is before now.
+func (d *mongodb) GetExpiredFileLogs(now time.Time, limit int) ([]FileLog, error) {
 	// build query
 	selector := bson.M{
-		"id": bson.M{"$eq": id},
+		"expiration": bson.M{"$lte": now},
 	}
 	// perform db query
-	var tx AsyncTx
-	err := d.session.DB(d.databaseName).C(d.asyncTxCollection).Find(selector).One(&tx)
+	var filelogs []FileLog
+	err := d.session.DB(d.databaseName).C(d.filelogCollection).Find(selector).Limit(limit).All(&filelogs)
 	if err != nil {
 		return nil, err
 	}
-	return &tx, nil
+	return filelogs, nil
 }
 
-// SetAsyncTx add a new async tx document to the mongodb
-// instance.
-func (d *mongodb) SetAsyncTx(at *AsyncTx) error {
-	err := d.session.DB(d.databaseName).C(d.asyncTxCollection).Insert(at)
+// GetFileLogsByOwner returns, at max, limit file logs owned by the
+// argument user sorted by id: only ids greater than after are
+// returned to permit cursor based paging.
+func (d *mongodb) GetFileLogsByOwner(username, after string, limit int) ([]FileLog, error) {
+	// build query
+	selector := bson.M{
+		"ownership.username": bson.M{"$eq": username},
+	}
+	if after != "" {
+		selector["id"] = bson.M{"$gt": after}
+	}
+	// perform db query
+	var filelogs []FileLog
+	err := d.session.DB(d.databaseName).C(d.filelogCollection).Find(selector).Sort("id").Limit(limit).All(&filelogs)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return filelogs, nil
 }
 
-// UpdateAsyncTx update an existing tx with the argument passed
-// doc.
-func (d *mongodb) UpdateAsyncTx(at *AsyncTx) error {
+// GetSharedFileLogs returns, at max, limit completed file logs
+// shared with the argument user.
+func (d *mongodb) GetSharedFileLogs(username string, limit int) ([]FileLog, error) {
+	// build query
 	selector := bson.M{
-		"id": at.Id,
+		"acl.permission": bson.M{"$eq": Shared},
+		"acl.sharing":    bson.M{"$eq": username},
+		"complete":       bson.M{"$eq": true},
 	}
-	update := bson.M{
-		"$set": at,
+	// perform db query
+	var filelogs []FileLog
+	err := d.session.DB(d.databaseName).C(d.filelogCollection).Find(selector).Sort("creation_time").Limit(limit).All(&filelogs)
+	if err != nil {
+		return nil, err
+	}
+	return filelogs, nil
+}
+
+// GetUsage aggregates size and number of the file logs owned
+// by the argument user.
+func (d *mongodb) GetUsage(username string) (*Usage, error) {
+	// build pipeline
+	pipeline := []bson.M{
+		bson.M{"$match": bson.M{"ownership.username": username}},
+		bson.M{"$group": bson.M{
+			"_id":     nil,
+			"bytes":   bson.M{"$sum": "$size"},
+			"objects": bson.M{"$sum": 1},
+		}},
+	}
+	// perform db aggregation
+	var usage Usage
+	err := d.session.DB(d.databaseName).C(d.filelogCollection).Pipe(pipeline).One(&usage)
+	if err == mgo.ErrNotFound {
+		return &Usage{}, nil
 	}
-	err := d.session.DB(d.databaseName).C(d.asyncTxCollection).Update(selector, update)
+	if err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// SetDeletionLog add a new deletion record to the database.
+func (d *mongodb) SetDeletionLog(dl *DeletionLog) error {
+	err := d.session.DB(d.databaseName).C(d.deletionCollection).Insert(dl)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// RemoveAsyncTx removes an existing async tx from the mongodb
-// instance.
-func (d *mongodb) RemoveAsyncTx(id string) error {
+// SetDownloadLink add a new download link to the database.
+func (d *mongodb) SetDownloadLink(dl *DownloadLink) error {
+	err := d.session.DB(d.databaseName).C(d.linksCollection).Insert(dl)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetDownloadLink returns a download link document from the
+// mongodb instance.
+func (d *mongodb) GetDownloadLink(id string) (*DownloadLink, error) {
 	// build query
 	selector := bson.M{
 		"id": bson.M{"$eq": id},
 	}
-	// perform db remove
-	err := d.session.DB(d.databaseName).C(d.asyncTxCollection).Remove(selector)
+	// perform db query
+	var dl DownloadLink
+	err := d.session.DB(d.databaseName).C(d.linksCollection).Find(selector).One(&dl)
+	if err != nil {
+		return nil, err
+	}
+	return &dl, nil
+}
+
+// UseDownloadLink adds the resource to the used ones of a download
+// link only if not already present: the update is atomic so a single
+// use link can not be consumed twice by concurrent requests.
+func (d *mongodb) UseDownloadLink(id, resource string) error {
+	selector := bson.M{
+		"id":   bson.M{"$eq": id},
+		"used": bson.M{"$ne": resource},
+	}
+	update := bson.M{
+		"$push": bson.M{"used": resource},
+	}
+	err := d.session.DB(d.databaseName).C(d.linksCollection).Update(selector, update)
+	if err == mgo.ErrNotFound {
+		return fmt.Errorf("resource %s already downloaded with link %s", resource, id)
+	}
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// SetAuditEvent appends a new audit event to the database.
+func (d *mongodb) SetAuditEvent(ae *AuditEvent) error {
+	err := d.session.DB(d.databaseName).C(d.auditCollection).Insert(ae)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetAuditEvents returns, at max, limit audit events about
+// resources owned by the argument user, most recent first. If
+// resource is not empty only events related to it are returned
+// while since, if not zero, excludes older events.
+func (d *mongodb) GetAuditEvents(owner, resource string, since time.Time, limit int) ([]AuditEvent, error) {
+	// build query
+	selector := bson.M{
+		"owner": bson.M{"$eq": owner},
+	}
+	if resource != "" {
+		selector["resource"] = bson.M{"$eq": resource}
+	}
+	if !since.IsZero() {
+		selector["time"] = bson.M{"$gte": since}
+	}
+	// perform db query
+	var events []AuditEvent
+	err := d.session.DB(d.databaseName).C(d.auditCollection).Find(selector).Sort("-time").Limit(limit).All(&events)
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
 // ensureMongodbIndexes assign mongodb indexes to the right
 // collections, this should be done only the first time the
-// collection is created.
+// collection is created. The audit events TTL index is
+// updated at each call to reflect the configured retention.
 func (d *mongodb) EnsureMongodbIndexes() error {
 	// file log
 	fileIndex := mgo.Index{
@@ -268,30 +415,120 @@ func (d *mongodb) EnsureMongodbIndexes() error {
 	if err != nil {
 		return err
 	}
-	// async tx
-	idIndex := mgo.Index{
+	// used by the expired files reaper
+	expirationIndex := mgo.Index{
+		Key:        []string{"expiration"},
+		Unique:     false,
+		Background: true,
+		Sparse:     true,
+	}
+	err = d.session.DB(d.databaseName).C(d.filelogCollection).EnsureIndex(expirationIndex)
+	if err != nil {
+		return err
+	}
+	// used by the shared resources listing
+	sharingIndex := mgo.Index{
+		Key:        []string{"acl.sharing"},
+		Unique:     false,
+		Background: true,
+		Sparse:     true,
+	}
+	err = d.session.DB(d.databaseName).C(d.filelogCollection).EnsureIndex(sharingIndex)
+	if err != nil {
+		return err
+	}
+	// used by usage accounting and resources listing
+	ownerIndex := mgo.Index{
+		Key:        []string{"ownership.username", "id"},
+		Unique:     false,
+		Background: true,
+		Sparse:     false,
+	}
+	err = d.session.DB(d.databaseName).C(d.filelogCollection).EnsureIndex(ownerIndex)
+	if err != nil {
+		return err
+	}
+	// download links
+	linkIndex := mgo.Index{
 		Key:        []string{"id"},
 		Unique:     true,
 		Background: true,
 		Sparse:     false,
 	}
-	// the following index is used to
-	// clean out every async tx after 1 hours
-	// from the creation time.
-	ttlIndex := mgo.Index{
-		Key:         []string{"ts"},
+	err = d.session.DB(d.databaseName).C(d.linksCollection).EnsureIndex(linkIndex)
+	if err != nil {
+		return err
+	}
+	// expired links are automatically removed
+	linkTtlIndex := mgo.Index{
+		Key:         []string{"expiration"},
 		Unique:      false,
-		DropDups:    false,
 		Background:  true,
-		ExpireAfter: MaxAsyncTxExistance, // clean async tx at max every 1 hours (time.Duration type).
+		ExpireAfter: time.Second,
 	}
-	err = d.session.DB(d.databaseName).C(d.asyncTxCollection).EnsureIndex(idIndex)
+	err = d.session.DB(d.databaseName).C(d.linksCollection).EnsureIndex(linkTtlIndex)
 	if err != nil {
 		return err
 	}
-	err = d.session.DB(d.databaseName).C(d.asyncTxCollection).EnsureIndex(ttlIndex)
+	// audit log
+	auditIndex := mgo.Index{
+		Key:        []string{"owner", "resource", "-time"},
+		Unique:     false,
+		Background: true,
+		Sparse:     false,
+	}
+	err = d.session.DB(d.databaseName).C(d.auditCollection).EnsureIndex(auditIndex)
 	if err != nil {
 		return err
 	}
+	// old audit events are automatically removed
+	err = ensureTtlIndex(d.session.DB(d.databaseName).C(d.auditCollection), "time", AuditEventExistance)
+	if err != nil {
+		return fmt.Errorf("unable to set audit events retention: %s", err.Error())
+	}
 	return nil
 }
+
+// ensureTtlIndex assigns to the collection a TTL index, on the
+// argument key, removing documents older than expireAfter. Differently
+// from EnsureIndex an already existing index is updated, using collMod,
+// if its expiration differs and it's dropped if expireAfter is zero.
+func ensureTtlIndex(c *mgo.Collection, key string, expireAfter time.Duration) error {
+	indexes, err := c.Indexes()
+	if err != nil {
+		return err
+	}
+	var existing *mgo.Index
+	for idx := range indexes {
+		if len(indexes[idx].Key) == 1 &&
+			indexes[idx].Key[0] == key &&
+			indexes[idx].ExpireAfter != 0 {
+			existing = &indexes[idx]
+			break
+		}
+	}
+
+	switch {
+	case existing == nil && expireAfter == 0:
+		return nil
+	case existing == nil:
+		return c.EnsureIndex(mgo.Index{
+			Key:         []string{key},
+			Unique:      false,
+			Background:  true,
+			ExpireAfter: expireAfter,
+		})
+	case expireAfter == 0:
+		return c.DropIndexName(existing.Name)
+	case existing.ExpireAfter == expireAfter:
+		return nil
+	}
+	var result bson.M
+	return c.Database.Run(bson.D{
+		{Name: "collMod", Value: c.Name},
+		{Name: "index", Value: bson.M{
+			"keyPattern":         bson.M{key: 1},
+			"expireAfterSeconds": int(expireAfter / time.Second),
+		}},
+	}, &result)
+}
@@ -4,9 +4,9 @@
 // v1.0 16/06/2016
 //
 // This mock database is used for tests purposes, should
-// never be used in production environment. It's not
-// concurrency safe and do not implement any performance
-// optimisation logic.
+// never be used in production environment. Accesses are
+// serialised by a mutex and it do not implement any
+// performance optimisation logic.
 //
 
 package main
@@ -14,16 +14,27 @@ package main
 // Golang std libs
 import (
 	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Third party
+import (
+	"gopkg.in/mgo.v2"
 )
 
 type mockdb struct {
+	mtx       sync.Mutex
 	addresses string
 	user      string
 	password  string
 	authDb    string
 	// in memory storage
 	fileLogStorage map[string]*FileLog
-	asyncTxStorage map[string]*AsyncTx
+	deletions      []DeletionLog
+	linksStorage   map[string]*DownloadLink
+	auditEvents    []AuditEvent
 }
 
 func newMockDb(args *dbArgs) *mockdb {
@@ -33,7 +44,7 @@ func newMockDb(args *dbArgs) *mockdb {
 		password:       args.password,
 		authDb:         args.authDb,
 		fileLogStorage: make(map[string]*FileLog),
-		asyncTxStorage: make(map[string]*AsyncTx),
+		linksStorage:   make(map[string]*DownloadLink),
 	}
 }
 
@@ -44,7 +55,13 @@ func (d *mockdb) Copy() database {
 func (d *mockdb) Close() {
 }
 
+func (d *mockdb) Ping() error {
+	return nil
+}
+
 func (d *mockdb) GetFileLog(filename string) (*FileLog, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
 	fl, ok := d.fileLogStorage[filename]
 	if !ok {
 		return nil, fmt.Errorf("unable to find a log for the required %s file", filename)
@@ -53,15 +70,23 @@ func (d *mockdb) GetFileLog(filename string) (*FileLog, error) {
 }
 
 func (d *mockdb) SetFileLog(fl *FileLog) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
 	_, ok := d.fileLogStorage[fl.Id]
 	if ok {
-		return fmt.Errorf("file %s already exist in the db", fl.Id)
+		// same error returned by the unique index
+		return &mgo.LastError{
+			Code: 11000,
+			Err:  fmt.Sprintf("file %s already exist in the db", fl.Id),
+		}
 	}
 	d.fileLogStorage[fl.Id] = fl
 	return nil
 }
 
 func (d *mockdb) UpdateFileLog(fl *FileLog) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
 	_, ok := d.fileLogStorage[fl.Id]
 	if !ok {
 		return fmt.Errorf("file %s do not exist in the db", fl.Id)
@@ -71,37 +96,147 @@ func (d *mockdb) UpdateFileLog(fl *FileLog) error {
 }
 
 func (d *mockdb) RemoveFileLog(filename string) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
 	delete(d.fileLogStorage, filename)
 	return nil
 }
 
-func (d *mockdb) GetAsyncTx(id string) (*AsyncTx, error) {
-	at, ok := d.asyncTxStorage[id]
-	if !ok {
-		return nil, fmt.Errorf("unable to find an async tx for the required %s id", id)
+func (d *mockdb) GetExpiredFileLogs(now time.Time, limit int) ([]FileLog, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	var expired []FileLog
+	for _, fl := range d.fileLogStorage {
+		if len(expired) == limit {
+			break
+		}
+		if !fl.Expiration.IsZero() &&
+			!fl.Expiration.After(now) {
+			expired = append(expired, *fl)
+		}
+	}
+	return expired, nil
+}
+
+func (d *mockdb) GetFileLogsByOwner(username, after string, limit int) ([]FileLog, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	var ids []string
+	for id, fl := range d.fileLogStorage {
+		if fl.Ownership.Username == username &&
+			id > after {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	filelogs := make([]FileLog, 0, len(ids))
+	for _, id := range ids {
+		filelogs = append(filelogs, *d.fileLogStorage[id])
+	}
+	return filelogs, nil
+}
+
+func (d *mockdb) GetSharedFileLogs(username string, limit int) ([]FileLog, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	var filelogs []FileLog
+	for _, fl := range d.fileLogStorage {
+		if fl.Acl.Permission != Shared ||
+			fl.Complete != true {
+			continue
+		}
+		for _, user := range fl.Acl.SharingUsers {
+			if user == username {
+				filelogs = append(filelogs, *fl)
+				break
+			}
+		}
+		if len(filelogs) == limit {
+			break
+		}
 	}
-	return at, nil
+	return filelogs, nil
 }
 
-func (d *mockdb) SetAsyncTx(at *AsyncTx) error {
-	_, ok := d.asyncTxStorage[at.Id]
+func (d *mockdb) GetUsage(username string) (*Usage, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	usage := &Usage{}
+	for _, fl := range d.fileLogStorage {
+		if fl.Ownership.Username == username {
+			usage.Bytes += int64(fl.Size)
+			usage.Objects++
+		}
+	}
+	return usage, nil
+}
+
+func (d *mockdb) SetDeletionLog(dl *DeletionLog) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.deletions = append(d.deletions, *dl)
+	return nil
+}
+
+func (d *mockdb) SetDownloadLink(dl *DownloadLink) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	_, ok := d.linksStorage[dl.Id]
 	if ok {
-		return fmt.Errorf("tx %s already exist in the db", at.Id)
+		return fmt.Errorf("link %s already exist in the db", dl.Id)
 	}
-	d.asyncTxStorage[at.Id] = at
+	d.linksStorage[dl.Id] = dl
 	return nil
 }
 
-func (d *mockdb) UpdateAsyncTx(at *AsyncTx) error {
-	_, ok := d.asyncTxStorage[at.Id]
+func (d *mockdb) GetDownloadLink(id string) (*DownloadLink, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	dl, ok := d.linksStorage[id]
+	if !ok {
+		return nil, fmt.Errorf("unable to find the required %s link", id)
+	}
+	return dl, nil
+}
+
+func (d *mockdb) UseDownloadLink(id, resource string) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	dl, ok := d.linksStorage[id]
 	if !ok {
-		return fmt.Errorf("tx %s do not exist in the db", at.Id)
+		return fmt.Errorf("unable to find the required %s link", id)
+	}
+	for _, used := range dl.Used {
+		if used == resource {
+			return fmt.Errorf("resource %s already downloaded with link %s", resource, id)
+		}
 	}
-	d.asyncTxStorage[at.Id] = at
+	dl.Used = append(dl.Used, resource)
 	return nil
 }
 
-func (d *mockdb) RemoveAsyncTx(id string) error {
-	delete(d.asyncTxStorage, id)
+func (d *mockdb) SetAuditEvent(ae *AuditEvent) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.auditEvents = append(d.auditEvents, *ae)
 	return nil
 }
+
+func (d *mockdb) GetAuditEvents(owner, resource string, since time.Time, limit int) ([]AuditEvent, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	var events []AuditEvent
+	for idx := len(d.auditEvents) - 1; idx >= 0 && len(events) < limit; idx-- {
+		ae := d.auditEvents[idx]
+		if ae.Owner != owner ||
+			(resource != "" && ae.Resource != resource) ||
+			ae.Time.Before(since) {
+			continue
+		}
+		events = append(events, ae)
+	}
+	return events, nil
+}
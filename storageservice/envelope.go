@@ -0,0 +1,353 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+// Server side envelope encryption: each stored object is encrypted
+// with a random data key that is, in turn, wrapped by a versioned
+// master key and saved in the file log. Objects are encrypted, with
+// AES-256-GCM, in fixed size segments and padded to a multiple of
+// the segment size so that a leaked bucket reveals neither contents
+// nor precise sizes. Rotating master keys only requires adding a new
+// version: retired ones must be kept while referenced by file logs.
+//
+
+package main
+
+// Golang std libs
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// envelopeKeySize is the size of master and data keys
+	// (AES-256).
+	envelopeKeySize = 32
+	// envelopeSegmentSize is the size of the plaintext segments
+	// encrypted independently, objects are padded to a multiple
+	// of it.
+	envelopeSegmentSize = 64 * 1024
+	// envelopePrefixSize is the size of the random nonce prefix
+	// stored in front of encrypted objects.
+	envelopePrefixSize = 7
+	// envelopePadding marks the beginning of the padding in the
+	// last segment.
+	envelopePadding = 0x80
+	// envMasterKeys is the environment variable defining the
+	// master keys if no master keys file is configured.
+	envMasterKeys = "NEXO_STORAGE_MASTER_KEYS"
+)
+
+// Master keys used to wrap data keys, if nil server side
+// encryption is disabled.
+var masterKeys map[uint32][]byte
+
+// currentKeyVersion is the version of the master key used to
+// wrap data keys of new resources.
+var currentKeyVersion uint32
+
+// masterKeysStartup loads the master keys from the configured file
+// or, if not defined, from the NEXO_STORAGE_MASTER_KEYS environment
+// variable: keys are not accepted as command line arguments not to
+// expose them in the processes list and in the shell history.
+func masterKeysStartup(a *args) (map[uint32][]byte, uint32, error) {
+	list := os.Getenv(envMasterKeys)
+	if a.masterKeysFile != "" {
+		data, err := ioutil.ReadFile(a.masterKeysFile)
+		if err != nil {
+			return nil, 0, fmt.Errorf("unable to read master keys file: %s", err.Error())
+		}
+		list = string(data)
+	}
+	return parseMasterKeys(list)
+}
+
+// parseMasterKeys parses master keys passed as a comma, or white
+// space, separated list of <version>:<hex encoded key> items: the
+// highest version is used for new resources. Version zero is
+// reserved to not encrypted resources.
+func parseMasterKeys(list string) (map[uint32][]byte, uint32, error) {
+	items := strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	if len(items) == 0 {
+		return nil, 0, nil
+	}
+	keys := make(map[uint32][]byte)
+	var current uint32
+	for _, item := range items {
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 {
+			return nil, 0, fmt.Errorf("master key should be in the <version>:<key> form")
+		}
+		version, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil ||
+			version == 0 {
+			return nil, 0, fmt.Errorf("master key version should be a positive number")
+		}
+		key, err := hex.DecodeString(parts[1])
+		if err != nil {
+			return nil, 0, fmt.Errorf("master key %d is malformed (%s)", version, err.Error())
+		}
+		if len(key) != envelopeKeySize {
+			return nil, 0, fmt.Errorf("master key %d should be %d bytes long", version, envelopeKeySize)
+		}
+		if _, ok := keys[uint32(version)]; ok {
+			return nil, 0, fmt.Errorf("master key %d is duplicated", version)
+		}
+		keys[uint32(version)] = key
+		if uint32(version) > current {
+			current = uint32(version)
+		}
+	}
+	return keys, current, nil
+}
+
+// newGcm creates an AES-GCM cipher using the argument key.
+func newGcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// keyContext returns the additional data binding wrapped data keys
+// to their resource.
+func keyContext(fl *FileLog) []byte {
+	return []byte(fl.Bucket + "/" + fl.Id)
+}
+
+// newDataKey generates a new data key for the argument file log,
+// saving it wrapped by the current master key. If server side
+// encryption is disabled a nil key is returned.
+func newDataKey(fl *FileLog) ([]byte, error) {
+	if masterKeys == nil {
+		return nil, nil
+	}
+	key := make([]byte, envelopeKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("unable to generate data key: %s", err.Error())
+	}
+	aead, err := newGcm(masterKeys[currentKeyVersion])
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("unable to generate nonce: %s", err.Error())
+	}
+	fl.KeyVersion = currentKeyVersion
+	fl.DataKey = aead.Seal(nonce, nonce, key, keyContext(fl))
+	return key, nil
+}
+
+// dataKey unwraps the data key of the argument file log, a nil key
+// is returned for not encrypted resources.
+func dataKey(fl *FileLog) ([]byte, error) {
+	if fl.KeyVersion == 0 {
+		return nil, nil
+	}
+	master, ok := masterKeys[fl.KeyVersion]
+	if !ok {
+		return nil, fmt.Errorf("master key %d is not available", fl.KeyVersion)
+	}
+	aead, err := newGcm(master)
+	if err != nil {
+		return nil, err
+	}
+	if len(fl.DataKey) < aead.NonceSize() {
+		return nil, fmt.Errorf("wrapped data key is malformed")
+	}
+	key, err := aead.Open(nil,
+		fl.DataKey[:aead.NonceSize()],
+		fl.DataKey[aead.NonceSize():],
+		keyContext(fl))
+	if err != nil {
+		return nil, fmt.Errorf("unable to unwrap data key: %s", err.Error())
+	}
+	return key, nil
+}
+
+// segmentNonce composes the nonce of a segment: the random prefix
+// is followed by the segment counter and by the last segment flag,
+// preventing segments reordering and truncation.
+func segmentNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, envelopePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[envelopePrefixSize:], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// encryptingReader encrypts, segment by segment, the data read
+// from the source.
+type encryptingReader struct {
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	source  io.Reader
+	segment []byte
+	pending []byte
+	done    bool
+}
+
+// newEncryptingReader returns a reader producing the encrypted
+// version of the source data.
+func newEncryptingReader(key []byte, source io.Reader) (io.Reader, error) {
+	aead, err := newGcm(key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, envelopePrefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, fmt.Errorf("unable to generate nonce: %s", err.Error())
+	}
+	return &encryptingReader{
+		aead:    aead,
+		prefix:  prefix,
+		source:  source,
+		segment: make([]byte, envelopeSegmentSize),
+		pending: prefix,
+	}, nil
+}
+
+// Read implements the io.Reader interface.
+func (e *encryptingReader) Read(p []byte) (int, error) {
+	for len(e.pending) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(e.source, e.segment)
+		last := false
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			// pad the last segment
+			last = true
+			e.segment[n] = envelopePadding
+			for idx := n + 1; idx < len(e.segment); idx++ {
+				e.segment[idx] = 0
+			}
+		default:
+			return 0, err
+		}
+		if e.counter == ^uint32(0) {
+			return 0, fmt.Errorf("resource is too big to be encrypted")
+		}
+		e.pending = e.aead.Seal(nil, segmentNonce(e.prefix, e.counter, last), e.segment, nil)
+		e.counter++
+		e.done = last
+	}
+	n := copy(p, e.pending)
+	e.pending = e.pending[n:]
+	return n, nil
+}
+
+// decryptingReader decrypts, segment by segment, the data read
+// from the source verifying its integrity.
+type decryptingReader struct {
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	source  *bufio.Reader
+	segment []byte
+	pending []byte
+	done    bool
+}
+
+// newDecryptingReader returns a reader producing the decrypted
+// version of the source data. Integrity errors are returned while
+// reading.
+func newDecryptingReader(key []byte, source io.Reader) (io.Reader, error) {
+	aead, err := newGcm(key)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptingReader{
+		aead:    aead,
+		source:  bufio.NewReader(source),
+		segment: make([]byte, envelopeSegmentSize+aead.Overhead()),
+	}, nil
+}
+
+// Read implements the io.Reader interface.
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.pending) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if d.prefix == nil {
+			d.prefix = make([]byte, envelopePrefixSize)
+			if _, err := io.ReadFull(d.source, d.prefix); err != nil {
+				return 0, fmt.Errorf("encrypted resource is truncated")
+			}
+		}
+		if _, err := io.ReadFull(d.source, d.segment); err != nil {
+			return 0, fmt.Errorf("encrypted resource is truncated")
+		}
+		// the last segment is not followed by other data
+		_, err := d.source.Peek(1)
+		last := err == io.EOF
+		plaintext, err := d.aead.Open(nil, segmentNonce(d.prefix, d.counter, last), d.segment, nil)
+		if err != nil {
+			return 0, fmt.Errorf("unable to decrypt resource: %s", err.Error())
+		}
+		d.counter++
+		if last {
+			// remove padding
+			idx := bytes.LastIndexByte(plaintext, envelopePadding)
+			if idx < 0 ||
+				len(bytes.Trim(plaintext[idx+1:], "\x00")) != 0 {
+				return 0, fmt.Errorf("encrypted resource padding is malformed")
+			}
+			plaintext = plaintext[:idx]
+			d.done = true
+		}
+		d.pending = plaintext
+	}
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
+
+// encryptData encrypts a data blob with the argument data key, if
+// the key is nil data are returned untouched.
+func encryptData(key, data []byte) ([]byte, error) {
+	if key == nil {
+		return data, nil
+	}
+	reader, err := newEncryptingReader(key, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
+
+// decryptData decrypts a data blob with the argument data key, if
+// the key is nil data are returned untouched.
+func decryptData(key, data []byte) ([]byte, error) {
+	if key == nil {
+		return data, nil
+	}
+	reader, err := newDecryptingReader(key, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
@@ -0,0 +1,409 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Internal dependencies.
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+var (
+	testMasterKeyV1 = strings.Repeat("01", envelopeKeySize)
+	testMasterKeyV2 = strings.Repeat("02", envelopeKeySize)
+)
+
+func encryptedSize(size int) int {
+	segments := size/envelopeSegmentSize + 1
+	return envelopePrefixSize + segments*(envelopeSegmentSize+16)
+}
+
+func TestParseMasterKeys(t *testing.T) {
+	keys, current, err := parseMasterKeys("")
+	if err != nil ||
+		keys != nil ||
+		current != 0 {
+		t.Fatalf("Empty master keys should disable encryption.\n")
+	}
+	keys, current, err = parseMasterKeys("2:" + testMasterKeyV2 + ", 1:" + testMasterKeyV1)
+	if err != nil {
+		t.Fatalf("Unable to parse master keys: %s.\n", err.Error())
+	}
+	if len(keys) != 2 ||
+		current != 2 {
+		t.Fatalf("Unexpected master keys %d, current version %d.\n", len(keys), current)
+	}
+
+	for _, wrong := range []string{
+		testMasterKeyV1,
+		"0:" + testMasterKeyV1,
+		"a:" + testMasterKeyV1,
+		"1:zz",
+		"1:0102",
+		"1:" + testMasterKeyV1 + ",1:" + testMasterKeyV2,
+	} {
+		_, _, err = parseMasterKeys(wrong)
+		if err == nil {
+			t.Fatalf("Master keys %q should be refused.\n", wrong)
+		}
+	}
+}
+
+func TestMasterKeysStartup(t *testing.T) {
+	defer os.Unsetenv(envMasterKeys)
+	os.Setenv(envMasterKeys, "1:"+testMasterKeyV1)
+	keys, current, err := masterKeysStartup(&args{})
+	if err != nil ||
+		len(keys) != 1 ||
+		current != 1 {
+		t.Fatalf("Unable to load master keys from environment (%v).\n", err)
+	}
+
+	// the file has precedence on the environment
+	file, err := ioutil.TempFile("", "masterkeys")
+	if err != nil {
+		t.Fatalf("Unable to create master keys file: %s.\n", err.Error())
+	}
+	defer os.Remove(file.Name())
+	fmt.Fprintf(file, "1:%s\n2:%s\n", testMasterKeyV1, testMasterKeyV2)
+	file.Close()
+	keys, current, err = masterKeysStartup(&args{
+		masterKeysFile: file.Name(),
+	})
+	if err != nil ||
+		len(keys) != 2 ||
+		current != 2 {
+		t.Fatalf("Unable to load master keys from file (%v).\n", err)
+	}
+	_, _, err = masterKeysStartup(&args{
+		masterKeysFile: file.Name() + ".missing",
+	})
+	if err == nil {
+		t.Fatalf("Missing master keys file should be refused.\n")
+	}
+}
+
+func TestEnvelopeEncryption(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, envelopeKeySize)
+	for _, size := range []int{
+		0,
+		1,
+		envelopeSegmentSize - 1,
+		envelopeSegmentSize,
+		envelopeSegmentSize + 1,
+		3*envelopeSegmentSize + 17,
+	} {
+		data := bytes.Repeat([]byte{0xaa}, size)
+		encrypted, err := encryptData(key, data)
+		if err != nil {
+			t.Fatalf("Unable to encrypt %d bytes: %s.\n", size, err.Error())
+		}
+		if len(encrypted) != encryptedSize(size) {
+			t.Fatalf("Having %d encrypted bytes expecting %d.\n", len(encrypted), encryptedSize(size))
+		}
+		decrypted, err := decryptData(key, encrypted)
+		if err != nil {
+			t.Fatalf("Unable to decrypt %d bytes: %s.\n", size, err.Error())
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Fatalf("Decrypted data differs from original %d bytes.\n", size)
+		}
+	}
+
+	data := bytes.Repeat([]byte{0xaa}, 2*envelopeSegmentSize)
+	encrypted, err := encryptData(key, data)
+	if err != nil {
+		t.Fatalf("Unable to encrypt data: %s.\n", err.Error())
+	}
+	// tampered data
+	tampered := append([]byte{}, encrypted...)
+	tampered[envelopePrefixSize+10] ^= 0x01
+	if _, err := decryptData(key, tampered); err == nil {
+		t.Fatalf("Tampered data should not be decrypted.\n")
+	}
+	// truncated data, on segments boundary
+	truncated := encrypted[:envelopePrefixSize+envelopeSegmentSize+16]
+	if _, err := decryptData(key, truncated); err == nil {
+		t.Fatalf("Truncated data should not be decrypted.\n")
+	}
+	// wrong key
+	if _, err := decryptData(bytes.Repeat([]byte{0x24}, envelopeKeySize), encrypted); err == nil {
+		t.Fatalf("Data should not be decrypted with a wrong key.\n")
+	}
+	// disabled encryption
+	plain, err := encryptData(nil, data)
+	if err != nil ||
+		!bytes.Equal(plain, data) {
+		t.Fatalf("Data should not be encrypted without key.\n")
+	}
+}
+
+func TestDataKeyRotation(t *testing.T) {
+	defaultKeys, defaultVersion := masterKeys, currentKeyVersion
+	defer func() {
+		masterKeys, currentKeyVersion = defaultKeys, defaultVersion
+	}()
+
+	var err error
+	masterKeys, currentKeyVersion, err = parseMasterKeys("1:" + testMasterKeyV1)
+	if err != nil {
+		t.Fatalf("Unable to parse master keys: %s.\n", err.Error())
+	}
+	fl := &FileLog{
+		Id:     "rotated",
+		Bucket: "bucket",
+	}
+	key, err := newDataKey(fl)
+	if err != nil {
+		t.Fatalf("Unable to generate data key: %s.\n", err.Error())
+	}
+	if fl.KeyVersion != 1 ||
+		len(fl.DataKey) == 0 ||
+		bytes.Contains(fl.DataKey, key) {
+		t.Fatalf("Unexpected wrapped data key %+v.\n", fl)
+	}
+
+	// old resources are readable after rotation
+	masterKeys, currentKeyVersion, _ = parseMasterKeys("1:" + testMasterKeyV1 + ",2:" + testMasterKeyV2)
+	unwrapped, err := dataKey(fl)
+	if err != nil {
+		t.Fatalf("Unable to unwrap data key: %s.\n", err.Error())
+	}
+	if !bytes.Equal(unwrapped, key) {
+		t.Fatalf("Unwrapped data key differs from original.\n")
+	}
+	newFl := &FileLog{
+		Id:     "new",
+		Bucket: "bucket",
+	}
+	if _, err := newDataKey(newFl); err != nil ||
+		newFl.KeyVersion != 2 {
+		t.Fatalf("New resources should use the current master key.\n")
+	}
+
+	// wrapped keys are bound to their resource
+	moved := *fl
+	moved.Id = "moved"
+	if _, err := dataKey(&moved); err == nil {
+		t.Fatalf("Data key should not be unwrapped for a different resource.\n")
+	}
+	// retired master keys are required
+	masterKeys, currentKeyVersion, _ = parseMasterKeys("2:" + testMasterKeyV2)
+	if _, err := dataKey(fl); err == nil {
+		t.Fatalf("Data key should not be unwrapped without its master key.\n")
+	}
+	// not encrypted resources
+	if key, err := dataKey(&FileLog{}); err != nil ||
+		key != nil {
+		t.Fatalf("Legacy resources should not have a data key.\n")
+	}
+}
+
+func TestStorageEnvelopeEncryption(t *testing.T) {
+	defaultKeys, defaultVersion := masterKeys, currentKeyVersion
+	defer func() {
+		masterKeys, currentKeyVersion = defaultKeys, defaultVersion
+	}()
+	var err error
+	masterKeys, currentKeyVersion, err = parseMasterKeys("1:" + testMasterKeyV1)
+	if err != nil {
+		t.Fatalf("Unable to parse master keys: %s.\n", err.Error())
+	}
+
+	// login
+	loginBody := ct.LoginRequest{
+		Username: mockUserInfo.Username,
+		Password: mockUserPassword,
+	}
+	body, err := json.Marshal(&loginBody)
+	if err != nil {
+		t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+	}
+	client := &http.Client{}
+	resp, err := client.Post(
+		fmt.Sprintf("http://%s:%d/v1/authsession", mockServiceAddress, mockServicePort),
+		"application/json",
+		bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Unable to perform login request on server: %s.\n", err.Error())
+	}
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	var session ct.LoginResponse
+	err = json.Unmarshal(respBody, &session)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+
+	postJob := func(request *ct.JobPostRequest) []byte {
+		body, err := json.Marshal(request)
+		if err != nil {
+			t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+		}
+		req, err := http.NewRequest(
+			"POST",
+			fmt.Sprintf("http://%s:%d/v1/storage/job", mockServiceAddress, mockServicePort),
+			bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatalf("Unable to prepare the storage/job request: %s.\n", err.Error())
+		}
+		req.Header.Set(ct.SecurityTokenKey, session.Token)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Unable to perform job request on server: %s.\n", err.Error())
+		}
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("Having status %d expecting %d.\n", resp.StatusCode, http.StatusAccepted)
+		}
+		var response ct.JobPostResponse
+		err = json.Unmarshal(respBody, &response)
+		if err != nil {
+			t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+		}
+		return verifyJobCompletion(t, response.JobID, session.Token, 30*time.Second)
+	}
+	storedData := func(id string) []byte {
+		stored, _, err := backend.DownloadStream(arguments.s3Bucket, id)
+		if err != nil {
+			t.Fatalf("Unable to read stored resource: %s.\n", err.Error())
+		}
+		defer stored.Close()
+		data, _ := ioutil.ReadAll(stored)
+		return data
+	}
+	verifyDownload := func(id string) {
+		var completed ct.JobGetRequest
+		err := json.Unmarshal(postJob(&ct.JobPostRequest{
+			Command: "DOWNLOAD",
+			Arguments: &ct.CommandArguments{
+				ResourceID: id,
+			},
+		}), &completed)
+		if err != nil {
+			t.Fatalf("Unable to unmarshal data: %s.\n", err.Error())
+		}
+		if completed.Error != "" ||
+			string(completed.Data) != fileContent {
+			t.Fatalf("Unexpected downloaded resource %s: %s.\n", id, completed.Error)
+		}
+	}
+
+	// streamed chunk
+	checksum := sha256.Sum256([]byte(fileContent))
+	chunkURL := fmt.Sprintf("http://%s:%d/v1/storage/chunk/%s", mockServiceAddress, mockServicePort, "envelopechunk")
+	req, err := http.NewRequest(
+		"PUT",
+		chunkURL,
+		bytes.NewBufferString(fileContent))
+	if err != nil {
+		t.Fatalf("Unable to prepare the chunk request: %s.\n", err.Error())
+	}
+	req.Header.Set(ct.SecurityTokenKey, session.Token)
+	req.Header.Set(ct.CheckSumKey, hex.EncodeToString(checksum[:]))
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform chunk request on server: %s.\n", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Having status %d expecting %d.\n", resp.StatusCode, http.StatusCreated)
+	}
+	fl, err := db.GetFileLog("envelopechunk")
+	if err != nil {
+		t.Fatalf("Unable to find file log: %s.\n", err.Error())
+	}
+	if fl.KeyVersion != 1 ||
+		fl.Size != len(fileContent) {
+		t.Fatalf("Unexpected file log: %+v.\n", fl)
+	}
+	stored := storedData("envelopechunk")
+	if len(stored) != encryptedSize(len(fileContent)) ||
+		bytes.Contains(stored, []byte("Test this content")) {
+		t.Fatalf("Stored resource is not encrypted (%d bytes).\n", len(stored))
+	}
+
+	// resources remain readable after a master key rotation
+	masterKeys, currentKeyVersion, _ = parseMasterKeys("1:" + testMasterKeyV1 + ",2:" + testMasterKeyV2)
+	getChunk := func() (int, []byte) {
+		req, err := http.NewRequest("GET", chunkURL, nil)
+		if err != nil {
+			t.Fatalf("Unable to prepare the chunk request: %s.\n", err.Error())
+		}
+		req.Header.Set(ct.SecurityTokenKey, session.Token)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Unable to perform chunk request on server: %s.\n", err.Error())
+		}
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusOK &&
+			resp.ContentLength != int64(len(fileContent)) {
+			t.Fatalf("Having content length %d expecting %d.\n", resp.ContentLength, len(fileContent))
+		}
+		return resp.StatusCode, data
+	}
+	status, data := getChunk()
+	if status != http.StatusOK ||
+		string(data) != fileContent {
+		t.Fatalf("Unexpected downloaded chunk, status %d.\n", status)
+	}
+	verifyDownload("envelopechunk")
+
+	// job uploaded resource
+	postJob(&ct.JobPostRequest{
+		Command: "UPLOAD",
+		Arguments: &ct.CommandArguments{
+			ResourceID: "envelopejob",
+			Data:       []byte(fileContent),
+			CheckSum:   fileContentCheckSum[:],
+			Permission: Private,
+		},
+	})
+	fl, err = db.GetFileLog("envelopejob")
+	if err != nil {
+		t.Fatalf("Unable to find file log: %s.\n", err.Error())
+	}
+	if fl.KeyVersion != 2 {
+		t.Fatalf("Having key version %d expecting 2.\n", fl.KeyVersion)
+	}
+	if stored := storedData("envelopejob"); len(stored) != encryptedSize(len(fileContent)) {
+		t.Fatalf("Stored resource is not encrypted (%d bytes).\n", len(stored))
+	}
+	verifyDownload("envelopejob")
+
+	// retired master keys are required to read old resources
+	masterKeys, currentKeyVersion, _ = parseMasterKeys("2:" + testMasterKeyV2)
+	status, _ = getChunk()
+	if status != http.StatusInternalServerError {
+		t.Fatalf("Having status %d expecting %d.\n", status, http.StatusInternalServerError)
+	}
+
+	// cleanup
+	for _, id := range []string{"envelopechunk", "envelopejob"} {
+		postJob(&ct.JobPostRequest{
+			Command: "DELETE",
+			Arguments: &ct.CommandArguments{
+				ResourceID: id,
+			},
+		})
+	}
+}
@@ -12,7 +12,9 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -20,6 +22,7 @@ import (
 import (
 	"github.com/nexocrew/3nigm4/lib/auth"
 	ct "github.com/nexocrew/3nigm4/lib/commons"
+	"github.com/nexocrew/3nigm4/lib/ratelimit"
 )
 
 // Third party
@@ -44,10 +47,66 @@ func riseError(status int, msg string, w http.ResponseWriter, ipa string) {
 	}
 }
 
-// authoriseGettingUserInfos authorises the provided token
-// and return user associated data. If returns a nil value
-// it means something went wrong.
-func authoriseGettingUserInfos(authToken string) (*auth.UserInfoResponseArg, error) {
+// riseLimitError rises a too many requests error advising the
+// client, if possible, about when to retry.
+func riseLimitError(err error, w http.ResponseWriter, ipa string) {
+	if limitErr, ok := err.(*ratelimit.LimitError); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limitErr.RetryAfter.Seconds()))))
+	}
+	riseError(http.StatusTooManyRequests,
+		err.Error(), w,
+		ipa)
+}
+
+// Scopes required to api keys, session tokens are authorised to
+// perform any operation.
+const (
+	uploadScope   = "storage:upload"
+	downloadScope = "storage:download"
+	deleteScope   = "storage:delete"
+	readScope     = "storage:read"
+	shareScope    = "storage:share"
+)
+
+// serviceAuthoriser verifies users permission levels on the
+// storage service.
+var serviceAuthoriser = auth.NewServiceAuthoriser(storageServiceName)
+
+// authenticateRequest authenticates requests for the service
+// middleware: api keys scopes are verified by each handler. Chunk
+// routes accept transfer tokens in place of session tokens.
+func authenticateRequest(r *http.Request) (*auth.UserInfoResponseArg, error) {
+	if route := mux.CurrentRoute(r); route != nil &&
+		chunkRoutes[route.GetName()] &&
+		r.Header.Get(ct.TransferTokenKey) != "" {
+		return authoriseChunkTransfer(r, mux.Vars(r)["id"], "")
+	}
+	authResponse, err := requestUserInfos(r)
+	if err != nil {
+		return nil, err
+	}
+	err = authResponse.Authorise("", r.RemoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	return authResponse, nil
+}
+
+// refuseRequest writes the response of requests refused by the
+// service middleware.
+func refuseRequest(status int, msg string, w http.ResponseWriter, r *http.Request) {
+	riseError(status, msg, w, r.RemoteAddr)
+}
+
+// requestUserInfos returns the user's infos associated to the
+// token, a session token or an api key, provided in the request
+// headers. User's infos already authorised by the service
+// middleware are reused.
+func requestUserInfos(r *http.Request) (*auth.UserInfoResponseArg, error) {
+	if authResponse := auth.RequestUserInfo(r); authResponse != nil {
+		return authResponse, nil
+	}
+	authToken := r.Header.Get(ct.SecurityTokenKey)
 	if authToken == "" {
 		return nil, fmt.Errorf("authorisation token is nil")
 	}
@@ -56,7 +115,25 @@ func authoriseGettingUserInfos(authToken string) (*auth.UserInfoResponseArg, err
 	if err != nil {
 		return nil, fmt.Errorf("authorisation token is malformed (%s)", err.Error())
 	}
-	authResponse, err := authClient.AuthoriseAndGetInfo(token)
+	return authClient.AuthoriseAndGetInfo(token)
+}
+
+// authoriseGettingUserInfos authorises the token, a session token
+// or an api key, provided in the request headers and return user
+// associated data. Api keys are verified to allow the required
+// scope from the request remote address and users to be granted
+// a level on the service. If returns a nil value it means
+// something went wrong.
+func authoriseGettingUserInfos(r *http.Request, scope string) (*auth.UserInfoResponseArg, error) {
+	authResponse, err := requestUserInfos(r)
+	if err != nil {
+		return nil, err
+	}
+	err = authResponse.Authorise(scope, r.RemoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	err = serviceAuthoriser.Authorise(authResponse, auth.LevelUser)
 	if err != nil {
 		return nil, err
 	}
@@ -87,14 +164,36 @@ func login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// check for brute-force attempts
+	userKey := ratelimit.UserKey(requestBody.Username)
+	addressKey := ratelimit.AddressKey(r.RemoteAddr)
+	reservation, err := loginLimiter.Reserve(userKey, addressKey)
+	if err != nil {
+		riseLimitError(err, w, r.RemoteAddr)
+		return
+	}
+	defer reservation.Cancel()
+
 	// perform login on auth service
-	token, err := authClient.Login(requestBody.Username, requestBody.Password)
+	token, err := authClient.Login(requestBody.Username, requestBody.Password, requestBody.OTP, r.RemoteAddr, r.UserAgent())
+	if auth.IsOtpRequired(err) {
+		// not a failure: the client should retry with the code
+		riseError(http.StatusUnauthorized,
+			ct.OtpRequiredError, w,
+			r.RemoteAddr)
+		return
+	}
 	if err != nil {
+		reservation.Failure()
 		riseError(http.StatusUnauthorized,
 			"unable to login with provided credentials", w,
 			r.RemoteAddr)
 		return
 	}
+	// only user's failures are forgotten: a valid login should
+	// not reset failures produced, from the same address, on
+	// other accounts.
+	reservation.Success(userKey)
 	// return the session token
 	w.WriteHeader(http.StatusOK)
 	err = json.NewEncoder(w).Encode(
@@ -142,13 +241,167 @@ func logout(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// refresh renews, redirecting to auth service, a still valid
+// user's session returning a new session token. It's used by
+// clients to avoid session expiration during long operations.
+func refresh(w http.ResponseWriter, r *http.Request) {
+	authToken := r.Header.Get(ct.SecurityTokenKey)
+	if authToken == "" {
+		riseError(http.StatusBadRequest,
+			"auth token is nil", w,
+			r.RemoteAddr)
+		return
+	}
+
+	rawToken, err := hex.DecodeString(authToken)
+	if err != nil {
+		riseError(http.StatusBadRequest,
+			"auth token is malformed", w,
+			r.RemoteAddr)
+		return
+	}
+	token, err := authClient.Refresh(rawToken)
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			"unable to refresh session with provided credentials", w,
+			r.RemoteAddr)
+		return
+	}
+	// return the new session token
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(
+		&ct.LoginResponse{
+			Token: hex.EncodeToString(token),
+		})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// enrolTotp generates, redirecting to auth service, a new two
+// factor authentication secret for the session user. The secret
+// must be configured in an authenticator app and confirmed with
+// confirmTotp.
+func enrolTotp(w http.ResponseWriter, r *http.Request) {
+	rawToken, err := hex.DecodeString(r.Header.Get(ct.SecurityTokenKey))
+	if err != nil ||
+		len(rawToken) == 0 {
+		riseError(http.StatusBadRequest,
+			"auth token is nil or malformed", w,
+			r.RemoteAddr)
+		return
+	}
+	enrolment, err := authClient.TotpEnrol(rawToken)
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			fmt.Sprintf("unable to enrol two factor authentication: %s", err.Error()), w,
+			r.RemoteAddr)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(
+		&ct.TotpEnrolResponse{
+			Secret: enrolment.Secret,
+			URL:    enrolment.URL,
+		})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// totpCodeRequest parses the body of two factor authentication
+// management requests.
+func totpCodeRequest(r *http.Request) (*ct.TotpCodeRequest, error) {
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(r.Body)
+	var request ct.TotpCodeRequest
+	err := json.Unmarshal(buf.Bytes(), &request)
+	if err != nil {
+		return nil, err
+	}
+	if request.Code == "" {
+		return nil, fmt.Errorf("code in request body is nil")
+	}
+	return &request, nil
+}
+
+// confirmTotp enables, redirecting to auth service, two factor
+// authentication returning the single use recovery codes.
+func confirmTotp(w http.ResponseWriter, r *http.Request) {
+	rawToken, err := hex.DecodeString(r.Header.Get(ct.SecurityTokenKey))
+	if err != nil ||
+		len(rawToken) == 0 {
+		riseError(http.StatusBadRequest,
+			"auth token is nil or malformed", w,
+			r.RemoteAddr)
+		return
+	}
+	request, err := totpCodeRequest(r)
+	if err != nil {
+		riseError(http.StatusBadRequest,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	codes, err := authClient.TotpConfirm(rawToken, request.Code)
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			fmt.Sprintf("unable to confirm two factor authentication: %s", err.Error()), w,
+			r.RemoteAddr)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(
+		&ct.TotpConfirmResponse{
+			RecoveryCodes: codes,
+		})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// disableTotp disables, redirecting to auth service, two factor
+// authentication: a TOTP or recovery code is required.
+func disableTotp(w http.ResponseWriter, r *http.Request) {
+	rawToken, err := hex.DecodeString(r.Header.Get(ct.SecurityTokenKey))
+	if err != nil ||
+		len(rawToken) == 0 {
+		riseError(http.StatusBadRequest,
+			"auth token is nil or malformed", w,
+			r.RemoteAddr)
+		return
+	}
+	request, err := totpCodeRequest(r)
+	if err != nil {
+		riseError(http.StatusBadRequest,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	err = authClient.TotpDisable(rawToken, request.Code)
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			fmt.Sprintf("unable to disable two factor authentication: %s", err.Error()), w,
+			r.RemoteAddr)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(
+		ct.StandardResponse{
+			Status: ct.AckResponse,
+		})
+	if err != nil {
+		panic(err)
+	}
+}
+
 // postJob creates a new async job request passing in the body
 // the requested command to be executed with all required
 // arguments.
 func postJob(w http.ResponseWriter, r *http.Request) {
 	// authorise and get user's info
 	// extract token from headers
-	userInfo, err := authoriseGettingUserInfos(r.Header.Get(ct.SecurityTokenKey))
+	userInfo, err := authoriseGettingUserInfos(r, "")
 	if err != nil {
 		riseError(http.StatusUnauthorized,
 			err.Error(), w,
@@ -181,19 +434,37 @@ func postJob(w http.ResponseWriter, r *http.Request) {
 	// passed resource id.
 
 	// select the right command implementation
-	switch job.Command {
-	case "UPLOAD":
-		createStorageResource(w, r, &job, userInfo)
-	case "DOWNLOAD":
-		retrieveStorageResource(w, r, &job, userInfo)
-	case "DELETE":
-		deleteStorageResource(w, r, &job, userInfo)
-	default:
+	command, ok := jobCommands[job.Command]
+	if !ok {
 		riseError(http.StatusBadRequest,
 			"unknown command", w,
 			r.RemoteAddr)
 		return
 	}
+	err = userInfo.Authorise(jobScopes[job.Command], r.RemoteAddr)
+	if err != nil {
+		riseError(http.StatusForbidden,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	jobId, status, err := command(r, job.Arguments, userInfo)
+	if err != nil {
+		riseError(status,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+
+	// return job response message
+	w.WriteHeader(http.StatusAccepted)
+	err = json.NewEncoder(w).Encode(
+		&ct.JobPostResponse{
+			JobID: jobId,
+		})
+	if err != nil {
+		panic(err)
+	}
 }
 
 // getJob responds to a request of info regarding a
@@ -215,7 +486,7 @@ func getJob(w http.ResponseWriter, r *http.Request) {
 
 	// authorise and get user's info
 	// extract token from headers
-	userInfo, err := authoriseGettingUserInfos(r.Header.Get(ct.SecurityTokenKey))
+	userInfo, err := authoriseGettingUserInfos(r, "")
 	if err != nil {
 		riseError(http.StatusUnauthorized,
 			err.Error(), w,
@@ -223,58 +494,69 @@ func getJob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// retain db
-	dbSession := db.Copy()
-	defer dbSession.Close()
-	// get tx status
-	at, err := dbSession.GetAsyncTx(id)
+	result, status, err := jobStatus(id, userInfo)
 	if err != nil {
-		riseError(http.StatusGone,
-			fmt.Sprintf("unable to find %s job, verification must be done at max %d min from request creation", id, MaxAsyncTxExistance/time.Minute),
-			w, r.RemoteAddr)
-		return
-	}
-
-	// check ownership
-	if at.Ownership.Username != userInfo.Username {
-		riseError(http.StatusUnauthorized,
-			fmt.Sprintf("user is not authorised to verify %s request id", id), w,
+		riseError(status,
+			err.Error(), w,
 			r.RemoteAddr)
 		return
 	}
-
 	// in case the processing is not yet completed
-	if at.Complete == false {
+	if status == http.StatusAccepted {
 		w.WriteHeader(http.StatusAccepted)
 		return
 	}
 
-	var errstr string
-	if at.Error != nil {
-		errstr = at.Error.Error()
-	}
 	// return verify message
 	w.WriteHeader(http.StatusOK)
-	err = json.NewEncoder(w).Encode(
-		&ct.JobGetRequest{
-			Complete: at.Complete,
-			Error:    errstr,
-			Data:     at.Data,
-			CheckSum: at.CheckSum,
-		})
+	err = json.NewEncoder(w).Encode(result)
 	if err != nil {
 		panic(err)
 	}
 	if arguments.verbose {
 		log.VerboseLog("Verify request %s correcly replyied.\n", id)
 	}
+}
+
+// jobStatus returns the result of a job owned by the argument user
+// with an http.StatusOK status code, not completed jobs produce an
+// http.StatusAccepted status code and no result. Async tx records
+// of completed jobs are removed once returned.
+func jobStatus(id string, userInfo *auth.UserInfoResponseArg) (*ct.JobGetRequest, int, error) {
+	// get tx status
+	at, err := jobs.GetAsyncTx(id)
+	if err != nil {
+		return nil, http.StatusGone, fmt.Errorf("unable to find %s job, verification must be done at max %d min from request creation", id, MaxAsyncTxExistance/time.Minute)
+	}
+
+	// check ownership
+	if at.Ownership.Username != userInfo.Username {
+		return nil, http.StatusUnauthorized, fmt.Errorf("user is not authorised to verify %s request id", id)
+	}
+
+	// in case the processing is not yet completed
+	if at.Complete == false {
+		return nil, http.StatusAccepted, nil
+	}
+
+	var errstr string
+	if at.Error != nil {
+		errstr = at.Error.Error()
+	}
 
-	// remove obsolete job db document
-	err = dbSession.RemoveAsyncTx(id)
+	// remove obsolete job record
+	err = jobs.RemoveAsyncTx(id)
 	if arguments.verbose &&
 		err != nil {
-		log.WarningLog("Unable to remove async tx from database: %s.\n", err.Error())
+		log.WarningLog("Unable to remove async tx from job store: %s.\n", err.Error())
 	}
+	return &ct.JobGetRequest{
+		Complete: at.Complete,
+		Error:    errstr,
+		Data:     at.Data,
+		CheckSum: at.CheckSum,
+		Size:     at.Size,
+	}, http.StatusOK, nil
 }
 
 // Ping function to verify if the service is on
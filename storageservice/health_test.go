@@ -0,0 +1,77 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+// Internal dependencies.
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+func getHealth(path string, t *testing.T) (int, *ct.HealthResponse) {
+	resp, err := http.Get(fmt.Sprintf("http://%s:%d%s", mockServiceAddress, mockServicePort, path))
+	if err != nil {
+		t.Fatalf("Unable to perform request on server: %s.\n", err.Error())
+	}
+	defer resp.Body.Close()
+	var response ct.HealthResponse
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+	return resp.StatusCode, &response
+}
+
+func TestHealth(t *testing.T) {
+	status, response := getHealth("/v1/health/live", t)
+	if status != http.StatusOK ||
+		response.Status != ct.AckResponse {
+		t.Fatalf("Unexpected liveness %d %+v.\n", status, response)
+	}
+	status, response = getHealth("/v1/health/ready", t)
+	if status != http.StatusOK ||
+		response.Status != ct.AckResponse ||
+		len(response.Checks) != 0 {
+		t.Fatalf("Unexpected readiness %d %+v.\n", status, response)
+	}
+
+	// shutting down services are not ready
+	atomic.StoreInt32(&shuttingDown, 1)
+	defer atomic.StoreInt32(&shuttingDown, 0)
+	status, response = getHealth("/v1/health/ready", t)
+	if status != http.StatusServiceUnavailable ||
+		response.Status != ct.NakResponse ||
+		response.Checks["service"] == "" {
+		t.Fatalf("Unexpected readiness while shutting down %d %+v.\n", status, response)
+	}
+	status, _ = getHealth("/v1/health/live", t)
+	if status != http.StatusOK {
+		t.Fatalf("Unexpected liveness while shutting down %d.\n", status)
+	}
+}
+
+func TestRunHealthChecks(t *testing.T) {
+	failures := runHealthChecks(map[string]func() error{
+		"ok": func() error {
+			return nil
+		},
+		"failing": func() error {
+			return fmt.Errorf("unreachable")
+		},
+	})
+	if len(failures) != 1 ||
+		failures["failing"] != "unreachable" {
+		t.Fatalf("Unexpected failures %v.\n", failures)
+	}
+}
@@ -0,0 +1,79 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// digestReader computes the SHA256 digest of the data read from
+// the source: at the end of data, if the digest differs from the
+// expected one, an error is returned in place of io.EOF so that
+// the backend write is aborted and nothing gets stored.
+type digestReader struct {
+	source   io.Reader
+	hash     hash.Hash
+	expected []byte
+	mismatch bool // set if data do not match the expected digest.
+}
+
+// newDigestReader returns a reader verifying the source data
+// against the expected SHA256 digest.
+func newDigestReader(source io.Reader, expected []byte) *digestReader {
+	return &digestReader{
+		source:   source,
+		hash:     sha256.New(),
+		expected: expected,
+	}
+}
+
+// Read implements the io.Reader interface.
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.source.Read(p)
+	d.hash.Write(p[:n])
+	if err == io.EOF &&
+		!bytes.Equal(d.hash.Sum(nil), d.expected) {
+		d.mismatch = true
+		return n, fmt.Errorf("checksum mismatch")
+	}
+	return n, err
+}
+
+// verifyStoredChunk reads back a stored resource verifying, after
+// decrypting it if required, that it matches the file log checksum.
+func verifyStoredChunk(fl *FileLog) error {
+	key, err := dataKey(fl)
+	if err != nil {
+		return err
+	}
+	stored, _, err := backend.DownloadStream(fl.Bucket, fl.Id)
+	if err != nil {
+		return err
+	}
+	defer stored.Close()
+	var body io.Reader = stored
+	if key != nil {
+		body, err = newDecryptingReader(key, stored)
+		if err != nil {
+			return err
+		}
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, body)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hash.Sum(nil), fl.CheckSum.Hash) {
+		return fmt.Errorf("stored resource %s does not match its checksum", fl.Id)
+	}
+	return nil
+}
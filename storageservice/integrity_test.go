@@ -0,0 +1,195 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Internal dependencies.
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+func TestDigestReader(t *testing.T) {
+	reader := newDigestReader(strings.NewReader(fileContent), fileContentCheckSum[:])
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Unable to read matching data: %s.\n", err.Error())
+	}
+	if string(data) != fileContent ||
+		reader.mismatch {
+		t.Fatalf("Unexpected data read.\n")
+	}
+
+	reader = newDigestReader(strings.NewReader("corrupted content"), fileContentCheckSum[:])
+	_, err = io.Copy(ioutil.Discard, reader)
+	if err == nil ||
+		!reader.mismatch {
+		t.Fatalf("Mismatching data should produce an error.\n")
+	}
+}
+
+func TestVerifyStoredChunk(t *testing.T) {
+	fl := &FileLog{
+		Id:     "verifiedchunk",
+		Bucket: arguments.s3Bucket,
+		CheckSum: ct.CheckSum{
+			Hash: fileContentCheckSum[:],
+			Type: "SHA256",
+		},
+	}
+	err := backend.UploadStream(fl.Bucket, fl.Id, strings.NewReader(fileContent), nil)
+	if err != nil {
+		t.Fatalf("Unable to store resource: %s.\n", err.Error())
+	}
+	err = verifyStoredChunk(fl)
+	if err != nil {
+		t.Fatalf("Unable to verify stored resource: %s.\n", err.Error())
+	}
+
+	// corrupted backend data
+	err = backend.UploadStream(fl.Bucket, fl.Id, strings.NewReader("corrupted content"), nil)
+	if err != nil {
+		t.Fatalf("Unable to store resource: %s.\n", err.Error())
+	}
+	err = verifyStoredChunk(fl)
+	if err == nil {
+		t.Fatalf("Corrupted resource should not be verified.\n")
+	}
+
+	// missing backend data
+	fl.Id = "missingchunk"
+	err = verifyStoredChunk(fl)
+	if err == nil {
+		t.Fatalf("Missing resource should not be verified.\n")
+	}
+}
+
+func TestStorageUploadJobChecksum(t *testing.T) {
+	// login
+	loginBody := ct.LoginRequest{
+		Username: mockUserInfo.Username,
+		Password: mockUserPassword,
+	}
+	body, err := json.Marshal(&loginBody)
+	if err != nil {
+		t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+	}
+	client := &http.Client{}
+	resp, err := client.Post(
+		fmt.Sprintf("http://%s:%d/v1/authsession", mockServiceAddress, mockServicePort),
+		"application/json",
+		bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Unable to perform login request on server: %s.\n", err.Error())
+	}
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	var session ct.LoginResponse
+	err = json.Unmarshal(respBody, &session)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+
+	postUpload := func(id string, checksum []byte) *http.Response {
+		body, err := json.Marshal(&ct.JobPostRequest{
+			Command: "UPLOAD",
+			Arguments: &ct.CommandArguments{
+				ResourceID: id,
+				Data:       []byte(fileContent),
+				CheckSum:   checksum,
+				Permission: Private,
+			},
+		})
+		if err != nil {
+			t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+		}
+		req, err := http.NewRequest(
+			"POST",
+			fmt.Sprintf("http://%s:%d/v1/storage/job", mockServiceAddress, mockServicePort),
+			bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatalf("Unable to prepare the storage/job request: %s.\n", err.Error())
+		}
+		req.Header.Set(ct.SecurityTokenKey, session.Token)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Unable to perform job request on server: %s.\n", err.Error())
+		}
+		return resp
+	}
+
+	// wrong digests are refused before storing data
+	resp = postUpload("checksumjob", []byte("wrong"))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Having status %d expecting %d.\n", resp.StatusCode, http.StatusBadRequest)
+	}
+	if _, err := db.GetFileLog("checksumjob"); err == nil {
+		t.Fatalf("File log should not be created.\n")
+	}
+
+	// valid uploads, clients not providing the digest are
+	// still accepted
+	for _, tc := range []struct {
+		id       string
+		checksum []byte
+	}{
+		{"checksumjob", fileContentCheckSum[:]},
+		{"checksumlegacyjob", nil},
+	} {
+		resp = postUpload(tc.id, tc.checksum)
+		respBody, _ = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("Having status %d expecting %d.\n", resp.StatusCode, http.StatusAccepted)
+		}
+		var response ct.JobPostResponse
+		err = json.Unmarshal(respBody, &response)
+		if err != nil {
+			t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+		}
+		var completed ct.JobGetRequest
+		err = json.Unmarshal(verifyJobCompletion(t, response.JobID, session.Token, 30*time.Second), &completed)
+		if err != nil {
+			t.Fatalf("Unable to unmarshal data: %s.\n", err.Error())
+		}
+		if completed.Error != "" {
+			t.Fatalf("Unexpected error: %s.\n", completed.Error)
+		}
+		fl, err := db.GetFileLog(tc.id)
+		if err != nil {
+			t.Fatalf("Unable to find file log: %s.\n", err.Error())
+		}
+		if fl.Complete != true ||
+			!bytes.Equal(fl.CheckSum.Hash, fileContentCheckSum[:]) {
+			t.Fatalf("Unexpected file log: %+v.\n", fl)
+		}
+
+		// cleanup
+		now := time.Now()
+		discardChunk(fl, &now)
+		for idx := 0; ; idx++ {
+			if _, err := db.GetFileLog(tc.id); err != nil {
+				break
+			}
+			if idx == 100 {
+				t.Fatalf("Resource has not been removed.\n")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
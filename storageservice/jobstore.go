@@ -0,0 +1,217 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+// Async jobs state is ephemeral: records are written at job
+// creation, updated when the backend operation completes and
+// removed when verified by the client (or after, at max,
+// MaxAsyncTxExistance). It's kept out of the primary database
+// using a dedicated job store.
+//
+
+package main
+
+// Golang std libs
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Available job store drivers.
+const (
+	memoryJobStore = "memory" // in process map, for single node deployments;
+	redisJobStore  = "redis"  // redis server, shared by several nodes.
+)
+
+// jobStore an interface defining the storage of async jobs state,
+// implementations must be usable by different goroutines
+// simultaneously and discard records older than
+// MaxAsyncTxExistance.
+type jobStore interface {
+	Ping() error                            // verify the store is reachable;
+	Close()                                 // release the store;
+	SetAsyncTx(at *AsyncTx) error           // add a new async tx record;
+	UpdateAsyncTx(at *AsyncTx) error        // update an existing async tx;
+	GetAsyncTx(id string) (*AsyncTx, error) // get an existing tx;
+	RemoveAsyncTx(id string) error          // remove an existing tx.
+}
+
+// MaxAsyncTxExistance represent the maximum time
+// that an async job can remain pending in the job
+// store before being automatically deleted.
+var MaxAsyncTxExistance = 1 * time.Hour
+
+// Global job store, usable by different goroutines simultaneously.
+var jobs jobStore
+
+// jobStoreStartup initialise the global job store using the driver
+// selected by the jobstore flag.
+func jobStoreStartup(a *args) (jobStore, error) {
+	switch a.jobStore {
+	case memoryJobStore:
+		log.MessageLog("Initialised in memory job store.\n")
+		return newMemoryJobs(time.Minute), nil
+	case redisJobStore:
+		store, err := newRedisJobs(a.redisAddress, a.redisPassword, a.redisDb)
+		if err != nil {
+			return nil, err
+		}
+		log.MessageLog("Initialised redis job store %s.\n", a.redisAddress)
+		return store, nil
+	}
+	return nil, fmt.Errorf("unknown job store %s", a.jobStore)
+}
+
+// jobExpiration returns the time after which an async tx is
+// discarded.
+func jobExpiration(at *AsyncTx) time.Time {
+	return at.TimeStamp.Add(MaxAsyncTxExistance)
+}
+
+// storedJob is the serialised form of an async tx, used by stores
+// not keeping records in process: the error is saved as a string.
+type storedJob struct {
+	AsyncTx
+	Error string `json:"error,omitempty"`
+}
+
+// marshalJob serialises an async tx.
+func marshalJob(at *AsyncTx) ([]byte, error) {
+	sj := storedJob{
+		AsyncTx: *at,
+	}
+	sj.AsyncTx.Error = nil
+	if at.Error != nil {
+		sj.Error = at.Error.Error()
+	}
+	return json.Marshal(&sj)
+}
+
+// unmarshalJob restores an async tx serialised by marshalJob.
+func unmarshalJob(data []byte) (*AsyncTx, error) {
+	var sj storedJob
+	err := json.Unmarshal(data, &sj)
+	if err != nil {
+		return nil, err
+	}
+	at := sj.AsyncTx
+	if sj.Error != "" {
+		at.Error = errors.New(sj.Error)
+	}
+	return &at, nil
+}
+
+// memoryJobs job store keeping async txs in a map, expired records
+// are periodically removed by a dedicated goroutine.
+type memoryJobs struct {
+	mtx     sync.Mutex
+	records map[string]AsyncTx
+	quit    chan struct{}
+	once    sync.Once
+}
+
+// newMemoryJobs creates an in memory job store removing expired
+// records every purge interval.
+func newMemoryJobs(purge time.Duration) *memoryJobs {
+	m := &memoryJobs{
+		records: make(map[string]AsyncTx),
+		quit:    make(chan struct{}),
+	}
+	go func() {
+		ticker := time.NewTicker(purge)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				m.purge(now)
+			case <-m.quit:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// purge removes the records expired before now.
+func (m *memoryJobs) purge(now time.Time) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	for id, at := range m.records {
+		if !jobExpiration(&at).After(now) {
+			delete(m.records, id)
+		}
+	}
+}
+
+// Ping the in memory store is always reachable.
+func (m *memoryJobs) Ping() error {
+	return nil
+}
+
+// Close stops the purging goroutine.
+func (m *memoryJobs) Close() {
+	m.once.Do(func() {
+		close(m.quit)
+	})
+}
+
+// lookup returns a not expired record, must be called holding the
+// lock.
+func (m *memoryJobs) lookup(id string) (AsyncTx, bool) {
+	at, ok := m.records[id]
+	if !ok {
+		return at, false
+	}
+	if !jobExpiration(&at).After(time.Now()) {
+		delete(m.records, id)
+		return at, false
+	}
+	return at, true
+}
+
+// SetAsyncTx add a new async tx record, a copy of the argument is
+// stored.
+func (m *memoryJobs) SetAsyncTx(at *AsyncTx) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if _, ok := m.lookup(at.Id); ok {
+		return fmt.Errorf("tx %s already exist in the job store", at.Id)
+	}
+	m.records[at.Id] = *at
+	return nil
+}
+
+// UpdateAsyncTx update an existing tx with the argument passed
+// record.
+func (m *memoryJobs) UpdateAsyncTx(at *AsyncTx) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if _, ok := m.lookup(at.Id); !ok {
+		return fmt.Errorf("tx %s do not exist in the job store", at.Id)
+	}
+	m.records[at.Id] = *at
+	return nil
+}
+
+// GetAsyncTx returns a copy of an existing async tx.
+func (m *memoryJobs) GetAsyncTx(id string) (*AsyncTx, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	at, ok := m.lookup(id)
+	if !ok {
+		return nil, fmt.Errorf("unable to find an async tx for the required %s id", id)
+	}
+	return &at, nil
+}
+
+// RemoveAsyncTx removes an existing async tx.
+func (m *memoryJobs) RemoveAsyncTx(id string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	delete(m.records, id)
+	return nil
+}
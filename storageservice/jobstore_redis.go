@@ -0,0 +1,119 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+// Redis job store implementation, shared by several service
+// nodes: records expiration is delegated to redis keys TTL.
+//
+
+package main
+
+// Golang std libs
+import (
+	"fmt"
+	"time"
+)
+
+// Third party libs
+import (
+	"gopkg.in/redis.v4"
+)
+
+// redisJobKeyPrefix namespaces async tx keys in the redis db.
+const redisJobKeyPrefix = "3nigm4:storageservice:asynctx:"
+
+// redisJobs job store keeping async txs in a redis db, records
+// are discarded using native keys expiration.
+type redisJobs struct {
+	client *redis.Client
+}
+
+// newRedisJobs connects to the redis server at address, verifying
+// it's reachable.
+func newRedisJobs(address, password string, db int) (jobStore, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     address,
+		Password: password,
+		DB:       db,
+	})
+	err := client.Ping().Err()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("unable to connect to redis %s: %s", address, err.Error())
+	}
+	return &redisJobs{
+		client: client,
+	}, nil
+}
+
+// redisJobKey returns the key of an async tx.
+func redisJobKey(id string) string {
+	return redisJobKeyPrefix + id
+}
+
+// Ping verifies the redis server is reachable.
+func (r *redisJobs) Ping() error {
+	return r.client.Ping().Err()
+}
+
+// Close releases the redis client.
+func (r *redisJobs) Close() {
+	r.client.Close()
+}
+
+// store saves an async tx, with an expiration matching its
+// creation time, using the argument set command.
+func (r *redisJobs) store(at *AsyncTx, set func(string, interface{}, time.Duration) *redis.BoolCmd) (bool, error) {
+	ttl := jobExpiration(at).Sub(time.Now())
+	if ttl <= 0 {
+		return false, fmt.Errorf("tx %s is expired", at.Id)
+	}
+	data, err := marshalJob(at)
+	if err != nil {
+		return false, err
+	}
+	return set(redisJobKey(at.Id), data, ttl).Result()
+}
+
+// SetAsyncTx add a new async tx record.
+func (r *redisJobs) SetAsyncTx(at *AsyncTx) error {
+	stored, err := r.store(at, r.client.SetNX)
+	if err != nil {
+		return err
+	}
+	if !stored {
+		return fmt.Errorf("tx %s already exist in the job store", at.Id)
+	}
+	return nil
+}
+
+// UpdateAsyncTx update an existing tx with the argument passed
+// record.
+func (r *redisJobs) UpdateAsyncTx(at *AsyncTx) error {
+	stored, err := r.store(at, r.client.SetXX)
+	if err != nil {
+		return err
+	}
+	if !stored {
+		return fmt.Errorf("tx %s do not exist in the job store", at.Id)
+	}
+	return nil
+}
+
+// GetAsyncTx returns an existing async tx.
+func (r *redisJobs) GetAsyncTx(id string) (*AsyncTx, error) {
+	data, err := r.client.Get(redisJobKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("unable to find an async tx for the required %s id", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalJob(data)
+}
+
+// RemoveAsyncTx removes an existing async tx.
+func (r *redisJobs) RemoveAsyncTx(id string) error {
+	return r.client.Del(redisJobKey(id)).Err()
+}
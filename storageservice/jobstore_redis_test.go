@@ -0,0 +1,221 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockRedis is a minimal redis server, speaking the RESP protocol,
+// implementing the commands used by the redis job store: keys
+// expiration is lazily enforced as in redis.
+type mockRedis struct {
+	listener    net.Listener
+	mtx         sync.Mutex
+	values      map[string]string
+	expirations map[string]time.Time
+}
+
+func newMockRedis(t *testing.T) *mockRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to start mock redis: %s.\n", err.Error())
+	}
+	m := &mockRedis{
+		listener:    listener,
+		values:      make(map[string]string),
+		expirations: make(map[string]time.Time),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go m.serve(conn)
+		}
+	}()
+	return m
+}
+
+func (m *mockRedis) Address() string {
+	return m.listener.Addr().String()
+}
+
+func (m *mockRedis) Close() {
+	m.listener.Close()
+}
+
+// readCommand reads a command sent as an array of bulk strings.
+func readCommand(rd *bufio.Reader) ([]string, error) {
+	readLine := func(prefix byte) (int, error) {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return 0, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) < 1 || line[0] != prefix {
+			return 0, fmt.Errorf("unexpected line %q", line)
+		}
+		return strconv.Atoi(line[1:])
+	}
+	count, err := readLine('*')
+	if err != nil {
+		return nil, err
+	}
+	command := make([]string, count)
+	for idx := range command {
+		size, err := readLine('$')
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err = io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		command[idx] = string(buf[:size])
+	}
+	return command, nil
+}
+
+func (m *mockRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	for {
+		command, err := readCommand(rd)
+		if err != nil {
+			return
+		}
+		if _, err = io.WriteString(conn, m.execute(command)); err != nil {
+			return
+		}
+	}
+}
+
+// lookup returns a not expired value, must be called holding the
+// lock.
+func (m *mockRedis) lookup(key string) (string, bool) {
+	if expiration, ok := m.expirations[key]; ok &&
+		!time.Now().Before(expiration) {
+		delete(m.values, key)
+		delete(m.expirations, key)
+	}
+	value, ok := m.values[key]
+	return value, ok
+}
+
+// execute runs a command returning the RESP encoded reply.
+func (m *mockRedis) execute(command []string) string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	switch strings.ToLower(command[0]) {
+	case "ping":
+		return "+PONG\r\n"
+	case "get":
+		value, ok := m.lookup(command[1])
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+	case "del":
+		removed := 0
+		for _, key := range command[1:] {
+			if _, ok := m.lookup(key); ok {
+				delete(m.values, key)
+				delete(m.expirations, key)
+				removed++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", removed)
+	case "set":
+		key, value := command[1], command[2]
+		var ttl time.Duration
+		var nx, xx bool
+		for idx := 3; idx < len(command); idx++ {
+			switch strings.ToLower(command[idx]) {
+			case "px", "ex":
+				amount, err := strconv.Atoi(command[idx+1])
+				if err != nil {
+					return "-ERR value is not an integer\r\n"
+				}
+				ttl = time.Duration(amount) * time.Millisecond
+				if strings.ToLower(command[idx]) == "ex" {
+					ttl = time.Duration(amount) * time.Second
+				}
+				idx++
+			case "nx":
+				nx = true
+			case "xx":
+				xx = true
+			}
+		}
+		_, exists := m.lookup(key)
+		if (nx && exists) ||
+			(xx && !exists) {
+			return "$-1\r\n"
+		}
+		m.values[key] = value
+		delete(m.expirations, key)
+		if ttl != 0 {
+			m.expirations[key] = time.Now().Add(ttl)
+		}
+		return "+OK\r\n"
+	}
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", command[0])
+}
+
+func TestRedisJobs(t *testing.T) {
+	server := newMockRedis(t)
+	defer server.Close()
+
+	store, err := newRedisJobs(server.Address(), "", 0)
+	if err != nil {
+		t.Fatalf("Unable to connect to redis: %s.\n", err.Error())
+	}
+	defer store.Close()
+	if err = store.Ping(); err != nil {
+		t.Fatalf("Unexpected ping error: %s.\n", err.Error())
+	}
+	testJobStore(t, store)
+	testJobStoreExpiration(t, store)
+
+	// records are namespaced and expire with their tx
+	now := time.Now()
+	err = store.SetAsyncTx(&AsyncTx{
+		Id:        "redisjob",
+		TimeStamp: now,
+	})
+	if err != nil {
+		t.Fatalf("Unable to set async tx: %s.\n", err.Error())
+	}
+	server.mtx.Lock()
+	expiration, ok := server.expirations[redisJobKey("redisjob")]
+	server.mtx.Unlock()
+	if !ok ||
+		expiration.Sub(now.Add(MaxAsyncTxExistance)) > time.Second {
+		t.Fatalf("Unexpected redis key expiration %v, expecting %v.\n", expiration, now.Add(MaxAsyncTxExistance))
+	}
+}
+
+func TestRedisJobsUnreachable(t *testing.T) {
+	server := newMockRedis(t)
+	address := server.Address()
+	server.Close()
+
+	_, err := newRedisJobs(address, "", 0)
+	if err == nil {
+		t.Fatalf("Unreachable redis should not be accepted.\n")
+	}
+}
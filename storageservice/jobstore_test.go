@@ -0,0 +1,247 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Internal dependencies.
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+// testJobStore verifies the basic operations of a job store.
+func testJobStore(t *testing.T, store jobStore) {
+	now := time.Now()
+	at := &AsyncTx{
+		Id:        "memoryjob",
+		TimeStamp: now,
+		Ownership: Owner{
+			Username: "userA",
+		},
+	}
+	err := store.SetAsyncTx(at)
+	if err != nil {
+		t.Fatalf("Unable to set async tx: %s.\n", err.Error())
+	}
+	if err = store.SetAsyncTx(at); err == nil {
+		t.Fatalf("Duplicated async tx should not be accepted.\n")
+	}
+	if err = store.UpdateAsyncTx(&AsyncTx{Id: "missingjob", TimeStamp: now}); err == nil {
+		t.Fatalf("Missing async tx should not be updated.\n")
+	}
+
+	// returned records are copies
+	retrieved, err := store.GetAsyncTx(at.Id)
+	if err != nil {
+		t.Fatalf("Unable to get async tx: %s.\n", err.Error())
+	}
+	retrieved.Complete = true
+	retrieved.Data = []byte(fileContent)
+	unchanged, _ := store.GetAsyncTx(at.Id)
+	if unchanged.Complete {
+		t.Fatalf("Stored async tx should change only when updated.\n")
+	}
+	err = store.UpdateAsyncTx(retrieved)
+	if err != nil {
+		t.Fatalf("Unable to update async tx: %s.\n", err.Error())
+	}
+	updated, _ := store.GetAsyncTx(at.Id)
+	if !updated.Complete ||
+		string(updated.Data) != fileContent ||
+		updated.Ownership.Username != "userA" {
+		t.Fatalf("Unexpected async tx: %+v.\n", updated)
+	}
+
+	err = store.RemoveAsyncTx(at.Id)
+	if err != nil {
+		t.Fatalf("Unable to remove async tx: %s.\n", err.Error())
+	}
+	if _, err = store.GetAsyncTx(at.Id); err == nil {
+		t.Fatalf("Removed async tx should not be available.\n")
+	}
+}
+
+// testJobStoreExpiration verifies that a job store discards records
+// older than MaxAsyncTxExistance.
+func testJobStoreExpiration(t *testing.T, store jobStore) {
+	// expired records are never returned
+	store.SetAsyncTx(&AsyncTx{
+		Id:        "expiredjob",
+		TimeStamp: time.Now().Add(-MaxAsyncTxExistance),
+	})
+	if _, err := store.GetAsyncTx("expiredjob"); err == nil {
+		t.Fatalf("Expired async tx should not be available.\n")
+	}
+
+	// records expire after MaxAsyncTxExistance from their creation
+	at := &AsyncTx{
+		Id:        "expiringjob",
+		TimeStamp: time.Now().Add(-MaxAsyncTxExistance + 200*time.Millisecond),
+	}
+	err := store.SetAsyncTx(at)
+	if err != nil {
+		t.Fatalf("Unable to set async tx: %s.\n", err.Error())
+	}
+	if _, err = store.GetAsyncTx(at.Id); err != nil {
+		t.Fatalf("Not expired async tx should be available: %s.\n", err.Error())
+	}
+	// updates do not extend the expiration
+	at.Complete = true
+	err = store.UpdateAsyncTx(at)
+	if err != nil {
+		t.Fatalf("Unable to update async tx: %s.\n", err.Error())
+	}
+	time.Sleep(300 * time.Millisecond)
+	if _, err = store.GetAsyncTx(at.Id); err == nil {
+		t.Fatalf("Expired async tx should not be available.\n")
+	}
+	if err = store.UpdateAsyncTx(at); err == nil {
+		t.Fatalf("Expired async tx should not be updated.\n")
+	}
+
+	// expired records can be replaced
+	err = store.SetAsyncTx(&AsyncTx{
+		Id:        at.Id,
+		TimeStamp: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("Unable to replace expired async tx: %s.\n", err.Error())
+	}
+	replaced, err := store.GetAsyncTx(at.Id)
+	if err != nil {
+		t.Fatalf("Unable to get async tx: %s.\n", err.Error())
+	}
+	if replaced.Complete {
+		t.Fatalf("Unexpected replaced async tx: %+v.\n", replaced)
+	}
+	store.RemoveAsyncTx(at.Id)
+}
+
+func TestMemoryJobs(t *testing.T) {
+	store := newMemoryJobs(time.Hour)
+	defer store.Close()
+	testJobStore(t, store)
+	testJobStoreExpiration(t, store)
+}
+
+func TestMemoryJobsExpiration(t *testing.T) {
+	store := newMemoryJobs(10 * time.Millisecond)
+	defer store.Close()
+
+	old := time.Now().Add(-MaxAsyncTxExistance)
+	err := store.SetAsyncTx(&AsyncTx{
+		Id:        "expiredjob",
+		TimeStamp: old,
+	})
+	if err != nil {
+		t.Fatalf("Unable to set async tx: %s.\n", err.Error())
+	}
+	if _, err = store.GetAsyncTx("expiredjob"); err == nil {
+		t.Fatalf("Expired async tx should not be available.\n")
+	}
+	// expired records can be replaced
+	err = store.SetAsyncTx(&AsyncTx{
+		Id:        "expiredjob",
+		TimeStamp: old.Add(50 * time.Millisecond),
+	})
+	if err != nil {
+		t.Fatalf("Unable to replace expired async tx: %s.\n", err.Error())
+	}
+	// and are removed by the purging goroutine
+	for idx := 0; ; idx++ {
+		store.mtx.Lock()
+		count := len(store.records)
+		store.mtx.Unlock()
+		if count == 0 {
+			break
+		}
+		if idx == 100 {
+			t.Fatalf("Expired async tx has not been purged.\n")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMarshalJob(t *testing.T) {
+	at := &AsyncTx{
+		Id:       "marshaledjob",
+		Complete: true,
+		Error:    fmt.Errorf("backend failure"),
+		Data:     []byte(fileContent),
+		CheckSum: ct.CheckSum{
+			Hash: fileContentCheckSum[:],
+			Type: "SHA256",
+		},
+		Size: len(fileContent),
+		Ownership: Owner{
+			Username: "userA",
+			OriginIp: "127.0.0.1",
+		},
+		TimeStamp: time.Now().UTC().Truncate(time.Second),
+	}
+	data, err := marshalJob(at)
+	if err != nil {
+		t.Fatalf("Unable to marshal async tx: %s.\n", err.Error())
+	}
+	restored, err := unmarshalJob(data)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal async tx: %s.\n", err.Error())
+	}
+	if restored.Error == nil ||
+		restored.Error.Error() != at.Error.Error() {
+		t.Fatalf("Unexpected error: %v.\n", restored.Error)
+	}
+	restored.Error = at.Error
+	if fmt.Sprintf("%+v", *restored) != fmt.Sprintf("%+v", *at) {
+		t.Fatalf("Unexpected async tx: %+v.\n", restored)
+	}
+
+	// records without errors
+	at.Error = nil
+	data, _ = marshalJob(at)
+	restored, _ = unmarshalJob(data)
+	if restored.Error != nil {
+		t.Fatalf("Unexpected error: %s.\n", restored.Error.Error())
+	}
+}
+
+func TestJobStoreStartup(t *testing.T) {
+	_, err := jobStoreStartup(&args{
+		jobStore: "unknown",
+	})
+	if err == nil {
+		t.Fatalf("Unknown job store should not be initialised.\n")
+	}
+	store, err := jobStoreStartup(&args{
+		jobStore: memoryJobStore,
+	})
+	if err != nil {
+		t.Fatalf("Unable to initialise memory job store: %s.\n", err.Error())
+	}
+	defer store.Close()
+	if err = store.Ping(); err != nil {
+		t.Fatalf("Unexpected ping error: %s.\n", err.Error())
+	}
+
+	server := newMockRedis(t)
+	defer server.Close()
+	store, err = jobStoreStartup(&args{
+		jobStore:     redisJobStore,
+		redisAddress: server.Address(),
+	})
+	if err != nil {
+		t.Fatalf("Unable to initialise redis job store: %s.\n", err.Error())
+	}
+	defer store.Close()
+	if err = store.Ping(); err != nil {
+		t.Fatalf("Unexpected ping error: %s.\n", err.Error())
+	}
+}
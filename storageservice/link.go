@@ -0,0 +1,365 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Internal libs
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+// Third party
+import (
+	"github.com/gorilla/mux"
+)
+
+const (
+	// maxLinkResources is the maximum number of resources that
+	// can be reached with a single download link.
+	maxLinkResources = 1000
+	// maxLinkTimeToLive is the maximum validity of a download
+	// link.
+	maxLinkTimeToLive = 30 * 24 * time.Hour
+	// linkSecretSize is the size of the randomly generated link
+	// signing secret.
+	linkSecretSize = 32
+)
+
+// Secret used to sign download link tokens, initialised at
+// startup by linkSecretStartup.
+var linkSecret []byte
+
+// linkSecretStartup returns the download links signing secret:
+// the hex encoded one passed as argument or, if not available, a
+// randomly generated one (in this case links will not survive a
+// service restart and are not shared between service instances).
+func linkSecretStartup(a *args) ([]byte, error) {
+	if a.linkSecret != "" {
+		secret, err := hex.DecodeString(a.linkSecret)
+		if err != nil {
+			return nil, fmt.Errorf("link secret is malformed (%s)", err.Error())
+		}
+		if len(secret) < linkSecretSize {
+			return nil, fmt.Errorf("link secret is too short, at least %d bytes are required", linkSecretSize)
+		}
+		return secret, nil
+	}
+	secret, err := ct.RandomBytesForLen(linkSecretSize)
+	if err != nil {
+		return nil, err
+	}
+	log.WarningLog("No link secret defined, using a random one: download links will not survive restarts.\n")
+	return secret, nil
+}
+
+// linkClaims are the properties signed in a download link token.
+type linkClaims struct {
+	Id         string `json:"id"`  // the download link id;
+	Expiration int64  `json:"exp"` // expiration time as unix time stamp.
+}
+
+// linkSignature computes the HMAC-SHA256 signature of the argument
+// encoded claims.
+func linkSignature(payload string) []byte {
+	mac := hmac.New(sha256.New, linkSecret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// signToken creates a token composed by the base64 encoded claims
+// and their signature separated by a dot. The domain, if not empty,
+// is signed together with the claims so that tokens issued for a
+// purpose are never accepted for another one.
+func signToken(domain string, claims interface{}) (string, error) {
+	raw, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(raw)
+	signature := base64.RawURLEncoding.EncodeToString(linkSignature(domain + payload))
+	return payload + "." + signature, nil
+}
+
+// verifyToken verifies the signature of a token, created with
+// signToken for the same domain, decoding the signed claims.
+func verifyToken(domain, token string, claims interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return fmt.Errorf("token is malformed")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("token signature is malformed (%s)", err.Error())
+	}
+	if !hmac.Equal(signature, linkSignature(domain+parts[0])) {
+		return fmt.Errorf("token signature is not valid")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return fmt.Errorf("token payload is malformed (%s)", err.Error())
+	}
+	err = json.Unmarshal(raw, claims)
+	if err != nil {
+		return fmt.Errorf("token payload is malformed (%s)", err.Error())
+	}
+	return nil
+}
+
+// signLinkToken creates a download token signing the argument
+// claims.
+func signLinkToken(claims *linkClaims) (string, error) {
+	return signToken("", claims)
+}
+
+// verifyLinkToken verifies the signature and the expiration of a
+// download token returning the signed claims.
+func verifyLinkToken(token string, now time.Time) (*linkClaims, error) {
+	var claims linkClaims
+	err := verifyToken("", token, &claims)
+	if err != nil {
+		return nil, fmt.Errorf("link %s", err.Error())
+	}
+	if !time.Unix(claims.Expiration, 0).After(now) {
+		return nil, fmt.Errorf("link token is expired")
+	}
+	return &claims, nil
+}
+
+// createLink creates a download link for a list of Public resources
+// owned by the caller returning the signed token. The token can be
+// used, until expired, to download resources without a session.
+// Resources switched to a different permission after the link
+// creation are no more reachable.
+func createLink(w http.ResponseWriter, r *http.Request) {
+	// authorise and get user's info
+	// extract token from headers
+	userInfo, err := authoriseGettingUserInfos(r, shareScope)
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+
+	// get message BODY
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(r.Body)
+	// parse json body
+	var requestBody ct.LinkRequest
+	err = json.Unmarshal(buf.Bytes(), &requestBody)
+	if err != nil {
+		riseError(http.StatusBadRequest,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	if len(requestBody.ResourceIDs) == 0 {
+		riseError(http.StatusBadRequest,
+			"resource ids in request body are nil", w,
+			r.RemoteAddr)
+		return
+	}
+	if len(requestBody.ResourceIDs) > maxLinkResources {
+		riseError(http.StatusBadRequest,
+			fmt.Sprintf("too many resources, at most %d can be linked at once", maxLinkResources), w,
+			r.RemoteAddr)
+		return
+	}
+	if requestBody.TimeToLive <= 0 ||
+		requestBody.TimeToLive > maxLinkTimeToLive {
+		riseError(http.StatusBadRequest,
+			fmt.Sprintf("time to live should be a positive duration lower than %s", maxLinkTimeToLive.String()), w,
+			r.RemoteAddr)
+		return
+	}
+
+	// retain db
+	dbSession := db.Copy()
+	defer dbSession.Close()
+
+	now := time.Now()
+	// verify resources: only owned Public resources can be linked
+	for _, id := range requestBody.ResourceIDs {
+		fileLog, err := dbSession.GetFileLog(id)
+		if err != nil ||
+			fileLog.Complete == false ||
+			fileLogExpired(fileLog, now) {
+			riseError(http.StatusNotFound,
+				fmt.Sprintf("requested file %s not found", id), w,
+				r.RemoteAddr)
+			return
+		}
+		if fileLog.Ownership.Username != userInfo.Username {
+			riseError(http.StatusUnauthorized,
+				fmt.Sprintf("you are not authorised to link resource %s", id), w,
+				r.RemoteAddr)
+			return
+		}
+		if fileLog.Acl.Permission != Public {
+			riseError(http.StatusBadRequest,
+				fmt.Sprintf("resource %s is not public", id), w,
+				r.RemoteAddr)
+			return
+		}
+	}
+
+	// create link
+	rawId, err := ct.RandomBytesForLen(32)
+	if err != nil {
+		riseError(http.StatusInternalServerError,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	link := &DownloadLink{
+		Id:         hex.EncodeToString(rawId),
+		Owner:      userInfo.Username,
+		Resources:  requestBody.ResourceIDs,
+		Creation:   now,
+		Expiration: now.Add(requestBody.TimeToLive),
+		SingleUse:  requestBody.SingleUse,
+	}
+	token, err := signLinkToken(&linkClaims{
+		Id:         link.Id,
+		Expiration: link.Expiration.Unix(),
+	})
+	if err != nil {
+		riseError(http.StatusInternalServerError,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	err = dbSession.SetDownloadLink(link)
+	if err != nil {
+		riseError(http.StatusInternalServerError,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+
+	// return link response message
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(
+		&ct.LinkResponse{
+			Token:      token,
+			Expiration: time.Unix(link.Expiration.Unix(), 0),
+		})
+	if err != nil {
+		panic(err)
+	}
+	if arguments.verbose {
+		log.VerboseLog("Download link %s created by user %s for %d resources.\n",
+			link.Id,
+			userInfo.Username,
+			len(link.Resources))
+	}
+}
+
+// getLinkChunk streams a resource, without requiring a session
+// token, to anyone presenting a valid download link token.
+func getLinkChunk(w http.ResponseWriter, r *http.Request) {
+	// get token and id from url
+	vars := mux.Vars(r)
+	token, ok := vars["token"]
+	if !ok || token == "" {
+		riseError(http.StatusBadRequest,
+			"unable to proceed with nil token", w,
+			r.RemoteAddr)
+		return
+	}
+	id, ok := vars["id"]
+	if !ok || id == "" {
+		riseError(http.StatusBadRequest,
+			"unable to proceed with nil id", w,
+			r.RemoteAddr)
+		return
+	}
+
+	now := time.Now()
+	claims, err := verifyLinkToken(token, now)
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+
+	// retain db
+	dbSession := db.Copy()
+	defer dbSession.Close()
+	link, err := dbSession.GetDownloadLink(claims.Id)
+	if err != nil ||
+		!link.Expiration.After(now) {
+		riseError(http.StatusUnauthorized,
+			"link is not valid", w,
+			r.RemoteAddr)
+		return
+	}
+	var linked bool
+	for _, resource := range link.Resources {
+		if resource == id {
+			linked = true
+			break
+		}
+	}
+	if !linked {
+		riseError(http.StatusUnauthorized,
+			"you are not authorised to access this resource", w,
+			r.RemoteAddr)
+		return
+	}
+
+	// get resources info
+	fileLog, err := dbSession.GetFileLog(id)
+	if err != nil ||
+		fileLog.Complete == false ||
+		fileLog.Ownership.Username != link.Owner {
+		riseError(http.StatusNotFound,
+			fmt.Sprintf("requested file not found"), w,
+			r.RemoteAddr)
+		return
+	}
+	if fileLogExpired(fileLog, now) {
+		riseError(http.StatusGone,
+			fmt.Sprintf("requested file is expired"), w,
+			r.RemoteAddr)
+		return
+	}
+	// resources no more public are not reachable
+	if fileLog.Acl.Permission != Public {
+		recordAuditEvent(dbSession, fileLog, "", ct.AuditDownload, ct.AuditDenied, r.RemoteAddr)
+		riseError(http.StatusUnauthorized,
+			"you are not authorised to access this resource", w,
+			r.RemoteAddr)
+		return
+	}
+	if link.SingleUse {
+		err = dbSession.UseDownloadLink(link.Id, id)
+		if err != nil {
+			riseError(http.StatusGone,
+				"resource already downloaded with this link", w,
+				r.RemoteAddr)
+			return
+		}
+	}
+
+	err = streamChunk(w, r, fileLog)
+	recordAuditEvent(dbSession, fileLog, "", ct.AuditDownload, asyncOutcome(err), r.RemoteAddr)
+}
@@ -0,0 +1,248 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Internal dependencies.
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+func TestLinkToken(t *testing.T) {
+	now := time.Now()
+	token, err := signLinkToken(&linkClaims{
+		Id:         "linkid",
+		Expiration: now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatalf("Unable to sign token: %s.\n", err.Error())
+	}
+	claims, err := verifyLinkToken(token, now)
+	if err != nil {
+		t.Fatalf("Unable to verify token: %s.\n", err.Error())
+	}
+	if claims.Id != "linkid" {
+		t.Fatalf("Unexpected claims id %s.\n", claims.Id)
+	}
+	// expired
+	_, err = verifyLinkToken(token, now.Add(2*time.Hour))
+	if err == nil {
+		t.Fatalf("Expired token should be rejected.\n")
+	}
+	// tampered
+	forged, err := signLinkToken(&linkClaims{
+		Id:         "anotherid",
+		Expiration: now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatalf("Unable to sign token: %s.\n", err.Error())
+	}
+	var invalidTokens = []string{
+		"",
+		"malformed",
+		forged[:len(forged)-2] + token[len(token)-2:],
+		forged[:bytes.IndexByte([]byte(forged), '.')] + token[bytes.IndexByte([]byte(token), '.'):],
+	}
+	for idx, invalid := range invalidTokens {
+		_, err = verifyLinkToken(invalid, now)
+		if err == nil {
+			t.Fatalf("Test case %d: invalid token should be rejected.\n", idx)
+		}
+	}
+}
+
+func TestDownloadLinkFlow(t *testing.T) {
+	now := time.Now()
+	files := []*FileLog{
+		{
+			Id:       "linkpublic",
+			Bucket:   arguments.s3Bucket,
+			Creation: now,
+			Complete: true,
+			Ownership: Owner{
+				Username: mockUserInfo.Username,
+			},
+			Acl: Acl{
+				Permission: Public,
+			},
+		},
+		{
+			Id:       "linkprivate",
+			Bucket:   arguments.s3Bucket,
+			Creation: now,
+			Complete: true,
+			Ownership: Owner{
+				Username: mockUserInfo.Username,
+			},
+		},
+	}
+	for _, fl := range files {
+		err := backend.UploadStream(fl.Bucket, fl.Id, bytes.NewBufferString(fileContent), nil)
+		if err != nil {
+			t.Fatalf("Unable to upload file: %s.\n", err.Error())
+		}
+		err = db.SetFileLog(fl)
+		if err != nil {
+			t.Fatalf("Unable to set file log: %s.\n", err.Error())
+		}
+		defer db.RemoveFileLog(fl.Id)
+	}
+
+	// login
+	loginBody := ct.LoginRequest{
+		Username: mockUserInfo.Username,
+		Password: mockUserPassword,
+	}
+	body, err := json.Marshal(&loginBody)
+	if err != nil {
+		t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+	}
+	client := &http.Client{}
+	req, err := http.NewRequest(
+		"POST",
+		fmt.Sprintf("http://%s:%d/v1/authsession", mockServiceAddress, mockServicePort),
+		bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Unable to prepare the login request: %s.\n", err.Error())
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform login request on server: %s.\n", err.Error())
+	}
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	var session ct.LoginResponse
+	err = json.Unmarshal(respBody, &session)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+
+	createLink := func(request *ct.LinkRequest) (int, *ct.LinkResponse) {
+		body, err := json.Marshal(request)
+		if err != nil {
+			t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+		}
+		req, err := http.NewRequest(
+			"POST",
+			fmt.Sprintf("http://%s:%d/v1/storage/link", mockServiceAddress, mockServicePort),
+			bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatalf("Unable to prepare the link request: %s.\n", err.Error())
+		}
+		req.Header.Set(ct.SecurityTokenKey, session.Token)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Unable to perform link request on server: %s.\n", err.Error())
+		}
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			return resp.StatusCode, nil
+		}
+		var link ct.LinkResponse
+		err = json.Unmarshal(respBody, &link)
+		if err != nil {
+			t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+		}
+		return resp.StatusCode, &link
+	}
+	// anonymous download
+	getLink := func(token, id string) (int, []byte) {
+		req, err := http.NewRequest(
+			"GET",
+			fmt.Sprintf("http://%s:%d/v1/storage/link/%s/%s", mockServiceAddress, mockServicePort, token, id),
+			nil)
+		if err != nil {
+			t.Fatalf("Unable to prepare the link download request: %s.\n", err.Error())
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Unable to perform link download request on server: %s.\n", err.Error())
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode, data
+	}
+
+	// invalid link requests
+	var invalidRequests = []struct {
+		request ct.LinkRequest
+		status  int
+	}{
+		{ct.LinkRequest{TimeToLive: time.Hour}, http.StatusBadRequest},
+		{ct.LinkRequest{ResourceIDs: []string{"linkpublic"}}, http.StatusBadRequest},
+		{ct.LinkRequest{ResourceIDs: []string{"linkpublic"}, TimeToLive: 2 * maxLinkTimeToLive}, http.StatusBadRequest},
+		{ct.LinkRequest{ResourceIDs: []string{"linkpublic", "linkprivate"}, TimeToLive: time.Hour}, http.StatusBadRequest},
+		{ct.LinkRequest{ResourceIDs: []string{"linkmissing"}, TimeToLive: time.Hour}, http.StatusNotFound},
+	}
+	for idx, tc := range invalidRequests {
+		status, _ := createLink(&tc.request)
+		if status != tc.status {
+			t.Fatalf("Test case %d: having status %d expecting %d.\n", idx, status, tc.status)
+		}
+	}
+
+	// single use link
+	status, link := createLink(&ct.LinkRequest{
+		ResourceIDs: []string{"linkpublic"},
+		TimeToLive:  time.Hour,
+		SingleUse:   true,
+	})
+	if status != http.StatusCreated {
+		t.Fatalf("Having status %d expecting %d.\n", status, http.StatusCreated)
+	}
+	if !link.Expiration.After(now) {
+		t.Fatalf("Unexpected link expiration %s.\n", link.Expiration.String())
+	}
+	if status, _ = getLink(link.Token, "linkprivate"); status != http.StatusUnauthorized {
+		t.Fatalf("Having status %d expecting %d.\n", status, http.StatusUnauthorized)
+	}
+	if status, _ = getLink(link.Token+"x", "linkpublic"); status != http.StatusUnauthorized {
+		t.Fatalf("Having status %d expecting %d.\n", status, http.StatusUnauthorized)
+	}
+	status, data := getLink(link.Token, "linkpublic")
+	if status != http.StatusOK {
+		t.Fatalf("Having status %d expecting %d.\n", status, http.StatusOK)
+	}
+	if string(data) != fileContent {
+		t.Fatalf("Downloaded data differs from uploaded one.\n")
+	}
+	if status, _ = getLink(link.Token, "linkpublic"); status != http.StatusGone {
+		t.Fatalf("Having status %d expecting %d.\n", status, http.StatusGone)
+	}
+
+	// reusable link revoked changing the permission
+	status, link = createLink(&ct.LinkRequest{
+		ResourceIDs: []string{"linkpublic"},
+		TimeToLive:  time.Hour,
+	})
+	if status != http.StatusCreated {
+		t.Fatalf("Having status %d expecting %d.\n", status, http.StatusCreated)
+	}
+	for idx := 0; idx < 2; idx++ {
+		if status, _ = getLink(link.Token, "linkpublic"); status != http.StatusOK {
+			t.Fatalf("Having status %d expecting %d.\n", status, http.StatusOK)
+		}
+	}
+	fl, err := db.GetFileLog("linkpublic")
+	if err != nil {
+		t.Fatalf("Unable to find file log: %s.\n", err.Error())
+	}
+	fl.Acl.Permission = Private
+	if status, _ = getLink(link.Token, "linkpublic"); status != http.StatusUnauthorized {
+		t.Fatalf("Having status %d expecting %d.\n", status, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,48 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	client := &http.Client{}
+	// produce at least a routed request
+	resp, err := client.Get(fmt.Sprintf("http://%s:%d/v1/ping", mockServiceAddress, mockServicePort))
+	if err != nil {
+		t.Fatalf("Unable to perform request on server: %s.\n", err.Error())
+	}
+	resp.Body.Close()
+
+	resp, err = client.Get(fmt.Sprintf("http://%s:%d/metrics", mockServiceAddress, mockServicePort))
+	if err != nil {
+		t.Fatalf("Unable to perform request on server: %s.\n", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unable to get metrics, returned %d but expected %d.\n", resp.StatusCode, http.StatusOK)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Unable to read response body: %s.\n", err.Error())
+	}
+	for _, expected := range []string{
+		"http_requests_total{route=\"/v1/ping\",method=\"GET\",code=\"200\"}",
+		"http_request_duration_seconds_bucket{route=\"/v1/ping\",method=\"GET\",le=\"+Inf\"}",
+		"workingqueue_workers{queue=\"backend\"}",
+		"workingqueue_queued_jobs{queue=\"backend\"}",
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Fatalf("Missing metric %s in:\n%s", expected, string(body))
+		}
+	}
+}
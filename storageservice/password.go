@@ -0,0 +1,160 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Internal libs
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+	"github.com/nexocrew/3nigm4/lib/ratelimit"
+)
+
+// ackResponse returns a standard positive response.
+func ackResponse(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(
+		ct.StandardResponse{
+			Status: ct.AckResponse,
+		})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// changePassword replaces, redirecting to auth service, the
+// password of the session user: the current password is required
+// and the other user sessions are invalidated.
+func changePassword(w http.ResponseWriter, r *http.Request) {
+	rawToken, err := sessionToken(r)
+	if err != nil {
+		riseError(http.StatusBadRequest,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	// parse json body
+	var request ct.PasswordChangeRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		riseError(http.StatusBadRequest,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	if request.Password == "" ||
+		request.NewPassword == "" {
+		riseError(http.StatusBadRequest,
+			"password or new password in request body are nil", w,
+			r.RemoteAddr)
+		return
+	}
+
+	// check for brute-force attempts from the address
+	addressKey := ratelimit.AddressKey(r.RemoteAddr)
+	reservation, err := loginLimiter.Reserve(addressKey)
+	if err != nil {
+		riseLimitError(err, w, r.RemoteAddr)
+		return
+	}
+	defer reservation.Cancel()
+	err = authClient.ChangePassword(rawToken, request.Password, request.NewPassword)
+	if err != nil {
+		reservation.Failure()
+		riseError(http.StatusUnauthorized,
+			fmt.Sprintf("unable to change password: %s", err.Error()), w,
+			r.RemoteAddr)
+		return
+	}
+	ackResponse(w)
+}
+
+// resetPassword issues, redirecting to auth service, a password
+// reset token delivered by email to the required user. Reserved
+// to super-admins.
+func resetPassword(w http.ResponseWriter, r *http.Request) {
+	rawToken, err := sessionToken(r)
+	if err != nil {
+		riseError(http.StatusBadRequest,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	// parse json body
+	var request ct.PasswordResetRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		riseError(http.StatusBadRequest,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	if request.Username == "" {
+		riseError(http.StatusBadRequest,
+			"username in request body is nil", w,
+			r.RemoteAddr)
+		return
+	}
+	err = authClient.ResetPassword(rawToken, request.Username)
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			fmt.Sprintf("unable to reset password: %s", err.Error()), w,
+			r.RemoteAddr)
+		return
+	}
+	if arguments.verbose {
+		log.VerboseLog("Password reset issued for user %s.\n", request.Username)
+	}
+	ackResponse(w)
+}
+
+// completePasswordReset sets, redirecting to auth service, a new
+// password using a reset token: no session is required.
+func completePasswordReset(w http.ResponseWriter, r *http.Request) {
+	// parse json body
+	var request ct.PasswordResetCompleteRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		riseError(http.StatusBadRequest,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	if request.Username == "" ||
+		request.ResetToken == "" ||
+		request.NewPassword == "" {
+		riseError(http.StatusBadRequest,
+			"username, reset token or new password in request body are nil", w,
+			r.RemoteAddr)
+		return
+	}
+
+	// check for brute-force attempts
+	userKey := ratelimit.UserKey(request.Username)
+	addressKey := ratelimit.AddressKey(r.RemoteAddr)
+	reservation, err := loginLimiter.Reserve(userKey, addressKey)
+	if err != nil {
+		riseLimitError(err, w, r.RemoteAddr)
+		return
+	}
+	defer reservation.Cancel()
+	err = authClient.CompletePasswordReset(request.Username, request.ResetToken, request.NewPassword)
+	if err != nil {
+		reservation.Failure()
+		riseError(http.StatusUnauthorized,
+			fmt.Sprintf("unable to reset password: %s", err.Error()), w,
+			r.RemoteAddr)
+		return
+	}
+	reservation.Success(userKey)
+	ackResponse(w)
+}
@@ -0,0 +1,119 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Internal libs
+import (
+	"github.com/nexocrew/3nigm4/lib/auth"
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+const (
+	// storageServiceName is the key used to look up storage
+	// specific settings in the user's permissions.
+	storageServiceName = "storage"
+	// allServicesName is the key used in user's permissions
+	// for settings shared by all services.
+	allServicesName = auth.AllServices
+)
+
+// userQuota returns the quota enforced on the argument user: the
+// storage specific quota, defined in user's permissions, is used
+// if available, than the generalised one ("all" services) and, at
+// last, the default one passed as service flag.
+func userQuota(userInfo *auth.UserInfoResponseArg) auth.Quota {
+	if userInfo.Permissions != nil &&
+		userInfo.Permissions.Quotas != nil {
+		if quota, ok := userInfo.Permissions.Quotas[storageServiceName]; ok {
+			return quota
+		}
+		if quota, ok := userInfo.Permissions.Quotas[allServicesName]; ok {
+			return quota
+		}
+	}
+	return auth.Quota{
+		Bytes:   arguments.quotaBytes,
+		Objects: arguments.quotaObjects,
+	}
+}
+
+// checkQuota verifies that the new object, whose file log has been
+// already stored with its provisional size, do not exceed the user's
+// quota. Usage accounts for all the stored file logs, including the
+// ones of in progress uploads, so that concurrent uploads can not
+// exceed the quota together. It returns the bytes that can be stored
+// for the new object (a negative value if no byte limit is enforced);
+// in case of error the caller should remove the file log.
+func checkQuota(session database, userInfo *auth.UserInfoResponseArg, fl *FileLog) (int64, error) {
+	quota := userQuota(userInfo)
+	if quota.Bytes == 0 &&
+		quota.Objects == 0 {
+		return -1, nil
+	}
+	usage, err := session.GetUsage(userInfo.Username)
+	if err != nil {
+		return 0, fmt.Errorf("unable to compute storage usage: %s", err.Error())
+	}
+	if quota.Objects != 0 &&
+		usage.Objects > quota.Objects {
+		return 0, fmt.Errorf("storage quota exceeded: %d objects of %d already stored", usage.Objects-1, quota.Objects)
+	}
+	if quota.Bytes == 0 {
+		return -1, nil
+	}
+	size := int64(fl.Size)
+	if usage.Bytes > quota.Bytes {
+		return 0, fmt.Errorf("storage quota exceeded: %d bytes of %d already stored, %d more required", usage.Bytes-size, quota.Bytes, size)
+	}
+	return quota.Bytes - usage.Bytes + size, nil
+}
+
+// getUsage returns the storage usage of the calling user with the
+// enforced quotas.
+func getUsage(w http.ResponseWriter, r *http.Request) {
+	// authorise and get user's info
+	// extract token from headers
+	userInfo, err := authoriseGettingUserInfos(r, readScope)
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+
+	// retain db
+	dbSession := db.Copy()
+	defer dbSession.Close()
+	usage, err := dbSession.GetUsage(userInfo.Username)
+	if err != nil {
+		riseError(http.StatusInternalServerError,
+			fmt.Sprintf("unable to compute storage usage: %s", err.Error()), w,
+			r.RemoteAddr)
+		return
+	}
+	quota := userQuota(userInfo)
+
+	// return usage response message
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(
+		&ct.UsageResponse{
+			Bytes:        usage.Bytes,
+			Objects:      usage.Objects,
+			QuotaBytes:   quota.Bytes,
+			QuotaObjects: quota.Objects,
+		})
+	if err != nil {
+		panic(err)
+	}
+}
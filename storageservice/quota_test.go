@@ -0,0 +1,308 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Internal dependencies.
+import (
+	"github.com/nexocrew/3nigm4/lib/auth"
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+func TestUserQuota(t *testing.T) {
+	defaultBytes, defaultObjects := arguments.quotaBytes, arguments.quotaObjects
+	defer func() {
+		arguments.quotaBytes, arguments.quotaObjects = defaultBytes, defaultObjects
+	}()
+	arguments.quotaBytes, arguments.quotaObjects = 1000, 10
+
+	var testCases = []struct {
+		permissions *auth.Permissions
+		quota       auth.Quota
+	}{
+		{nil, auth.Quota{Bytes: 1000, Objects: 10}},
+		{&auth.Permissions{}, auth.Quota{Bytes: 1000, Objects: 10}},
+		{&auth.Permissions{Quotas: map[string]auth.Quota{
+			allServicesName: {Bytes: 50},
+		}}, auth.Quota{Bytes: 50}},
+		{&auth.Permissions{Quotas: map[string]auth.Quota{
+			allServicesName:    {Bytes: 50},
+			storageServiceName: {Objects: 5},
+		}}, auth.Quota{Objects: 5}},
+	}
+	for idx, tc := range testCases {
+		quota := userQuota(&auth.UserInfoResponseArg{
+			Username:    "quotauser",
+			Permissions: tc.permissions,
+		})
+		if quota != tc.quota {
+			t.Fatalf("Test case %d: having quota %v expecting %v.\n", idx, quota, tc.quota)
+		}
+	}
+}
+
+func TestStorageQuota(t *testing.T) {
+	defaultBytes, defaultObjects := arguments.quotaBytes, arguments.quotaObjects
+	defer func() {
+		arguments.quotaBytes, arguments.quotaObjects = defaultBytes, defaultObjects
+	}()
+
+	// login
+	loginBody := ct.LoginRequest{
+		Username: mockUserInfo.Username,
+		Password: mockUserPassword,
+	}
+	body, err := json.Marshal(&loginBody)
+	if err != nil {
+		t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+	}
+	client := &http.Client{}
+	req, err := http.NewRequest(
+		"POST",
+		fmt.Sprintf("http://%s:%d/v1/authsession", mockServiceAddress, mockServicePort),
+		bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Unable to prepare the login request: %s.\n", err.Error())
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform login request on server: %s.\n", err.Error())
+	}
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	var session ct.LoginResponse
+	err = json.Unmarshal(respBody, &session)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+
+	getUsage := func() ct.UsageResponse {
+		req, err := http.NewRequest(
+			"GET",
+			fmt.Sprintf("http://%s:%d/v1/storage/usage", mockServiceAddress, mockServicePort),
+			nil)
+		if err != nil {
+			t.Fatalf("Unable to prepare the usage request: %s.\n", err.Error())
+		}
+		req.Header.Set(ct.SecurityTokenKey, session.Token)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Unable to perform usage request on server: %s.\n", err.Error())
+		}
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Having status %d expecting %d.\n", resp.StatusCode, http.StatusOK)
+		}
+		var usage ct.UsageResponse
+		err = json.Unmarshal(respBody, &usage)
+		if err != nil {
+			t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+		}
+		return usage
+	}
+	putChunk := func(id string) int {
+		checksum := sha256.Sum256([]byte(fileContent))
+		req, err := http.NewRequest(
+			"PUT",
+			fmt.Sprintf("http://%s:%d/v1/storage/chunk/%s", mockServiceAddress, mockServicePort, id),
+			bytes.NewBufferString(fileContent))
+		if err != nil {
+			t.Fatalf("Unable to prepare the chunk request: %s.\n", err.Error())
+		}
+		req.Header.Set(ct.SecurityTokenKey, session.Token)
+		req.Header.Set(ct.CheckSumKey, hex.EncodeToString(checksum[:]))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Unable to perform chunk request on server: %s.\n", err.Error())
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// no quota
+	initial := getUsage()
+	if initial.QuotaBytes != 0 ||
+		initial.QuotaObjects != 0 {
+		t.Fatalf("Unexpected quotas: %v.\n", initial)
+	}
+
+	// upload within quota
+	arguments.quotaBytes = initial.Bytes + int64(len(fileContent))
+	arguments.quotaObjects = initial.Objects + 1
+	if status := putChunk("quotachunkA"); status != http.StatusCreated {
+		t.Fatalf("Having status %d expecting %d.\n", status, http.StatusCreated)
+	}
+	defer db.RemoveFileLog("quotachunkA")
+	usage := getUsage()
+	if usage.Bytes != initial.Bytes+int64(len(fileContent)) ||
+		usage.Objects != initial.Objects+1 ||
+		usage.QuotaBytes != arguments.quotaBytes ||
+		usage.QuotaObjects != arguments.quotaObjects {
+		t.Fatalf("Unexpected usage: %v.\n", usage)
+	}
+
+	// objects quota exceeded
+	if status := putChunk("quotachunkB"); status != http.StatusForbidden {
+		t.Fatalf("Having status %d expecting %d.\n", status, http.StatusForbidden)
+	}
+	// bytes quota exceeded
+	arguments.quotaObjects = 0
+	if status := putChunk("quotachunkB"); status != http.StatusForbidden {
+		t.Fatalf("Having status %d expecting %d.\n", status, http.StatusForbidden)
+	}
+	// job uploads are rejected as well
+	body, err = json.Marshal(&ct.JobPostRequest{
+		Command: "UPLOAD",
+		Arguments: &ct.CommandArguments{
+			ResourceID: "quotachunkB",
+			Data:       []byte(fileContent),
+			CheckSum:   fileContentCheckSum[:],
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+	}
+	req, err = http.NewRequest(
+		"POST",
+		fmt.Sprintf("http://%s:%d/v1/storage/job", mockServiceAddress, mockServicePort),
+		bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Unable to prepare the storage/job request: %s.\n", err.Error())
+	}
+	req.Header.Set(ct.SecurityTokenKey, session.Token)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform job request on server: %s.\n", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Having status %d expecting %d.\n", resp.StatusCode, http.StatusForbidden)
+	}
+	if _, err := db.GetFileLog("quotachunkB"); err == nil {
+		t.Fatalf("Rejected resource should not be logged.\n")
+	}
+
+	// uploads without content length are refused
+	req, err = http.NewRequest(
+		"PUT",
+		fmt.Sprintf("http://%s:%d/v1/storage/chunk/%s", mockServiceAddress, mockServicePort, "quotachunkB"),
+		ioutil.NopCloser(strings.NewReader(fileContent)))
+	if err != nil {
+		t.Fatalf("Unable to prepare the chunk request: %s.\n", err.Error())
+	}
+	checksum := sha256.Sum256([]byte(fileContent))
+	req.Header.Set(ct.SecurityTokenKey, session.Token)
+	req.Header.Set(ct.CheckSumKey, hex.EncodeToString(checksum[:]))
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform chunk request on server: %s.\n", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusLengthRequired {
+		t.Fatalf("Having status %d expecting %d.\n", resp.StatusCode, http.StatusLengthRequired)
+	}
+}
+
+func TestStorageQuotaConcurrentUploads(t *testing.T) {
+	defaultBytes, defaultObjects := arguments.quotaBytes, arguments.quotaObjects
+	defer func() {
+		arguments.quotaBytes, arguments.quotaObjects = defaultBytes, defaultObjects
+	}()
+
+	// login
+	loginBody := ct.LoginRequest{
+		Username: mockUserInfo.Username,
+		Password: mockUserPassword,
+	}
+	body, err := json.Marshal(&loginBody)
+	if err != nil {
+		t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+	}
+	client := &http.Client{}
+	resp, err := client.Post(
+		fmt.Sprintf("http://%s:%d/v1/authsession", mockServiceAddress, mockServicePort),
+		"application/json",
+		bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Unable to perform login request on server: %s.\n", err.Error())
+	}
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	var session ct.LoginResponse
+	err = json.Unmarshal(respBody, &session)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+
+	// only two of the concurrent uploads fit in the quota
+	initial, err := db.GetUsage(mockUserInfo.Username)
+	if err != nil {
+		t.Fatalf("Unable to get usage: %s.\n", err.Error())
+	}
+	arguments.quotaObjects = 0
+	arguments.quotaBytes = initial.Bytes + 2*int64(len(fileContent))
+
+	const uploads = 8
+	checksum := sha256.Sum256([]byte(fileContent))
+	statuses := make(chan int, uploads)
+	for idx := 0; idx < uploads; idx++ {
+		id := fmt.Sprintf("quotaconcurrent%d", idx)
+		defer db.RemoveFileLog(id)
+		go func(id string) {
+			req, err := http.NewRequest(
+				"PUT",
+				fmt.Sprintf("http://%s:%d/v1/storage/chunk/%s", mockServiceAddress, mockServicePort, id),
+				bytes.NewBufferString(fileContent))
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			req.Header.Set(ct.SecurityTokenKey, session.Token)
+			req.Header.Set(ct.CheckSumKey, hex.EncodeToString(checksum[:]))
+			resp, err := client.Do(req)
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}(id)
+	}
+	created := 0
+	for idx := 0; idx < uploads; idx++ {
+		switch status := <-statuses; status {
+		case http.StatusCreated:
+			created++
+		case http.StatusForbidden:
+		default:
+			t.Fatalf("Unexpected upload status %d.\n", status)
+		}
+	}
+	if created > 2 {
+		t.Fatalf("Concurrent uploads exceeded the quota: %d stored, expecting at most 2.\n", created)
+	}
+	usage, err := db.GetUsage(mockUserInfo.Username)
+	if err != nil {
+		t.Fatalf("Unable to get usage: %s.\n", err.Error())
+	}
+	if usage.Bytes > arguments.quotaBytes ||
+		usage.Objects != initial.Objects+int64(created) {
+		t.Fatalf("Unexpected usage %v, quota is %d bytes.\n", usage, arguments.quotaBytes)
+	}
+}
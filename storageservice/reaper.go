@@ -0,0 +1,145 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// Internal dependencies
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+const (
+	// reaperUsername is the owner of the async txs created by
+	// the expired files reaper.
+	reaperUsername = "3nigm4.reaper"
+	// reaperBatchSize is the maximum number of files removed
+	// in a single reaper iteration.
+	reaperBatchSize = 500
+)
+
+// fileLogExpired verifies if a file is expired: the time to live
+// is used if the expiration time is not available (files uploaded
+// by previous versions).
+func fileLogExpired(fl *FileLog, now time.Time) bool {
+	if !fl.Expiration.IsZero() {
+		return !fl.Expiration.After(now)
+	}
+	if fl.TimeToLive != 0 {
+		return !fl.Creation.Add(fl.TimeToLive).After(now)
+	}
+	return false
+}
+
+// reaperTxId returns the async tx id used to remove an expired
+// file: it's stable to avoid requiring the same deletion twice
+// while the previous one is still pending.
+func reaperTxId(id string) string {
+	checksum := sha256.Sum256([]byte(reaperUsername + "." + id))
+	return hex.EncodeToString(checksum[:])
+}
+
+// reapExpiredFiles requires to the backend the removal of the
+// files expired before now. The file logs are removed and the
+// deletion recorded when the async delete operation succeeds
+// (see updateDeleteRequestStatus), failed deletions are required
+// again by the next run. It returns the number of required
+// deletions.
+func reapExpiredFiles(now time.Time) (int, error) {
+	session := db.Copy()
+	defer session.Close()
+
+	expired, err := session.GetExpiredFileLogs(now, reaperBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	for _, fl := range expired {
+		txId := reaperTxId(fl.Id)
+		// skip pending deletions
+		if _, err := jobs.GetAsyncTx(txId); err == nil {
+			continue
+		}
+		err = jobs.SetAsyncTx(&AsyncTx{
+			Id:        txId,
+			Complete:  false,
+			TimeStamp: now,
+			Ownership: Owner{
+				Username: reaperUsername,
+			},
+		})
+		if err != nil {
+			log.ErrorLog("Unable to create reaper async tx for %s: %s, ignoring.\n", fl.Id, err.Error())
+			continue
+		}
+		backend.Delete(fl.Bucket, fl.Id, txId)
+		count++
+	}
+	return count, nil
+}
+
+// recordReapedFile records a deletion requested by the reaper and
+// removes the related async tx (nobody is going to verify it).
+// Failed deletions are only logged: the file log is retained and
+// the removed async tx lets the next reaper run retry them.
+func recordReapedFile(session database, at *AsyncTx, fl *FileLog, dr ct.OpResult) {
+	defer func() {
+		err := jobs.RemoveAsyncTx(at.Id)
+		if err != nil {
+			log.WarningLog("Unable to remove async tx from database: %s.\n", err.Error())
+		}
+	}()
+	if dr.Error != nil {
+		log.ErrorLog("Reaper unable to remove expired file %s: %s.\n", dr.ID, dr.Error.Error())
+		return
+	}
+	if arguments.verbose {
+		log.VerboseLog("Reaper removed expired file %s.\n", dr.ID)
+	}
+	dl := &DeletionLog{
+		Id:       dr.ID,
+		Deletion: time.Now(),
+	}
+	if fl != nil {
+		dl.Bucket = fl.Bucket
+		dl.Owner = fl.Ownership.Username
+		dl.Size = fl.Size
+		dl.Creation = fl.Creation
+		dl.Expiration = fl.Expiration
+	}
+	err := session.SetDeletionLog(dl)
+	if err != nil {
+		log.ErrorLog("Unable to record deletion of %s: %s, ignoring.\n", dr.ID, err.Error())
+	}
+}
+
+// runReaper periodically removes expired files until the quit
+// chan is closed.
+func runReaper(interval time.Duration, quit <-chan struct{}) {
+	schedule := time.NewTicker(interval)
+	defer schedule.Stop()
+	for {
+		select {
+		case <-schedule.C:
+			count, err := reapExpiredFiles(time.Now())
+			if err != nil {
+				log.ErrorLog("Unable to reap expired files: %s.\n", err.Error())
+				continue
+			}
+			if count != 0 {
+				log.MessageLog("Reaper required removal of %d expired files.\n", count)
+			}
+		case <-quit:
+			return
+		}
+	}
+}
@@ -0,0 +1,226 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Internal dependencies.
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+func TestFileLogExpired(t *testing.T) {
+	now := time.Now()
+	var testCases = []struct {
+		fl      FileLog
+		expired bool
+	}{
+		{FileLog{Creation: now}, false},
+		{FileLog{Creation: now, TimeToLive: time.Hour}, false},
+		{FileLog{Creation: now.Add(-2 * time.Hour), TimeToLive: time.Hour}, true},
+		{FileLog{Creation: now, Expiration: now.Add(time.Minute)}, false},
+		{FileLog{Creation: now, Expiration: now.Add(-time.Minute)}, true},
+	}
+	for idx, tc := range testCases {
+		if fileLogExpired(&tc.fl, now) != tc.expired {
+			t.Fatalf("Test case %d: expecting expired %v.\n", idx, tc.expired)
+		}
+	}
+}
+
+func TestReapExpiredFiles(t *testing.T) {
+	mock, ok := db.(*mockdb)
+	if !ok {
+		t.Fatalf("Unexpected database type, mockdb is required.\n")
+	}
+	now := time.Now()
+	files := []*FileLog{
+		{
+			Id:         "reaperexpired",
+			Bucket:     arguments.s3Bucket,
+			Creation:   now.Add(-2 * time.Hour),
+			TimeToLive: time.Hour,
+			Expiration: now.Add(-time.Hour),
+			Complete:   true,
+			Ownership: Owner{
+				Username: mockUserInfo.Username,
+			},
+			Acl: Acl{
+				Permission: Public,
+			},
+		},
+		{
+			Id:         "reapervalid",
+			Bucket:     arguments.s3Bucket,
+			Creation:   now,
+			TimeToLive: time.Hour,
+			Expiration: now.Add(time.Hour),
+			Complete:   true,
+			Ownership: Owner{
+				Username: mockUserInfo.Username,
+			},
+		},
+	}
+	for _, fl := range files {
+		err := backend.UploadStream(fl.Bucket, fl.Id, bytes.NewBufferString(fileContent), nil)
+		if err != nil {
+			t.Fatalf("Unable to upload file: %s.\n", err.Error())
+		}
+		err = db.SetFileLog(fl)
+		if err != nil {
+			t.Fatalf("Unable to set file log: %s.\n", err.Error())
+		}
+	}
+	defer db.RemoveFileLog("reapervalid")
+
+	// expired files are not served
+	loginBody := ct.LoginRequest{
+		Username: mockUserInfo.Username,
+		Password: mockUserPassword,
+	}
+	body, err := json.Marshal(&loginBody)
+	if err != nil {
+		t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+	}
+	client := &http.Client{}
+	req, err := http.NewRequest(
+		"POST",
+		fmt.Sprintf("http://%s:%d/v1/authsession", mockServiceAddress, mockServicePort),
+		bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Unable to prepare the login request: %s.\n", err.Error())
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform login request on server: %s.\n", err.Error())
+	}
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	var session ct.LoginResponse
+	err = json.Unmarshal(respBody, &session)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+	req, err = http.NewRequest(
+		"GET",
+		fmt.Sprintf("http://%s:%d/v1/storage/chunk/%s", mockServiceAddress, mockServicePort, "reaperexpired"),
+		nil)
+	if err != nil {
+		t.Fatalf("Unable to prepare the chunk request: %s.\n", err.Error())
+	}
+	req.Header.Set(ct.SecurityTokenKey, session.Token)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform chunk request on server: %s.\n", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGone {
+		t.Fatalf("Having status %d expecting %d.\n", resp.StatusCode, http.StatusGone)
+	}
+
+	// reap
+	deletions := len(mock.deletions)
+	count, err := reapExpiredFiles(now)
+	if err != nil {
+		t.Fatalf("Unable to reap expired files: %s.\n", err.Error())
+	}
+	if count != 1 {
+		t.Fatalf("Unexpected reaped files: having %d expecting 1.\n", count)
+	}
+	for idx := 0; ; idx++ {
+		if _, err := db.GetFileLog("reaperexpired"); err != nil {
+			break
+		}
+		if idx == 100 {
+			t.Fatalf("Expired file log has not been removed.\n")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// wait for the deletion record
+	for idx := 0; len(mock.deletions) == deletions; idx++ {
+		if idx == 100 {
+			t.Fatalf("Deletion has not been recorded.\n")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	dl := mock.deletions[len(mock.deletions)-1]
+	if dl.Id != "reaperexpired" ||
+		dl.Owner != mockUserInfo.Username {
+		t.Fatalf("Unexpected deletion record: %v.\n", dl)
+	}
+	if _, err := jobs.GetAsyncTx(reaperTxId("reaperexpired")); err == nil {
+		t.Fatalf("Reaper async tx should be removed.\n")
+	}
+	if _, _, err := backend.DownloadStream(arguments.s3Bucket, "reaperexpired"); err == nil {
+		t.Fatalf("Expired file should be removed from the backend.\n")
+	}
+	// valid files are preserved
+	if _, err := db.GetFileLog("reapervalid"); err != nil {
+		t.Fatalf("Valid file log should not be removed: %s.\n", err.Error())
+	}
+}
+
+func TestReapFailedDeletion(t *testing.T) {
+	mock, ok := db.(*mockdb)
+	if !ok {
+		t.Fatalf("Unexpected database type, mockdb is required.\n")
+	}
+	now := time.Now()
+	// not available in the backend: deletion fails
+	fl := &FileLog{
+		Id:         "reaperfailing",
+		Bucket:     arguments.s3Bucket,
+		Creation:   now.Add(-2 * time.Hour),
+		TimeToLive: time.Hour,
+		Expiration: now.Add(-time.Hour),
+		Complete:   true,
+		Ownership: Owner{
+			Username: mockUserInfo.Username,
+		},
+	}
+	err := db.SetFileLog(fl)
+	if err != nil {
+		t.Fatalf("Unable to set file log: %s.\n", err.Error())
+	}
+	defer db.RemoveFileLog(fl.Id)
+
+	deletions := len(mock.deletions)
+	for run := 0; run < 2; run++ {
+		count, err := reapExpiredFiles(now)
+		if err != nil {
+			t.Fatalf("Unable to reap expired files: %s.\n", err.Error())
+		}
+		if count != 1 {
+			t.Fatalf("Run %d: unexpected reaped files, having %d expecting 1.\n", run, count)
+		}
+		// wait for the deletion result
+		for idx := 0; ; idx++ {
+			if _, err := jobs.GetAsyncTx(reaperTxId(fl.Id)); err != nil {
+				break
+			}
+			if idx == 100 {
+				t.Fatalf("Reaper async tx should be removed.\n")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		// the file log is retained to retry the deletion
+		if _, err := db.GetFileLog(fl.Id); err != nil {
+			t.Fatalf("Run %d: file log should be retained: %s.\n", run, err.Error())
+		}
+		if len(mock.deletions) != deletions {
+			t.Fatalf("Run %d: failed deletions should not be recorded.\n", run)
+		}
+	}
+}
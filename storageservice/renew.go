@@ -0,0 +1,136 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Internal libs
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+// maxRenewResources is the maximum number of resources that can
+// be renewed with a single request.
+const maxRenewResources = 1000
+
+// renewFileLog extends the time to live of a file log: the new
+// expiration is computed starting from now and the time to live
+// is updated accordingly (it's always relative to the creation
+// time).
+func renewFileLog(fl *FileLog, now time.Time, ttl time.Duration) {
+	fl.Expiration = now.Add(ttl)
+	fl.TimeToLive = fl.Expiration.Sub(fl.Creation)
+}
+
+// renewResources extends the time to live of a list of resources
+// owned by the caller. Only resources created with a time to live
+// can be renewed (resources without it never expire) and expired
+// ones, even if not yet removed by the reaper, are not resumed.
+// The operation is synchronous and reports the outcome for each
+// resource.
+func renewResources(w http.ResponseWriter, r *http.Request) {
+	// authorise and get user's info
+	// extract token from headers
+	userInfo, err := authoriseGettingUserInfos(r, uploadScope)
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+
+	// get message BODY
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(r.Body)
+	// parse json body
+	var requestBody ct.RenewRequest
+	err = json.Unmarshal(buf.Bytes(), &requestBody)
+	if err != nil {
+		riseError(http.StatusBadRequest,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	if len(requestBody.ResourceIDs) == 0 {
+		riseError(http.StatusBadRequest,
+			"resource ids in request body are nil", w,
+			r.RemoteAddr)
+		return
+	}
+	if len(requestBody.ResourceIDs) > maxRenewResources {
+		riseError(http.StatusBadRequest,
+			fmt.Sprintf("too many resources, at most %d can be renewed at once", maxRenewResources), w,
+			r.RemoteAddr)
+		return
+	}
+	if requestBody.TimeToLive <= 0 {
+		riseError(http.StatusBadRequest,
+			"time to live should be a positive duration", w,
+			r.RemoteAddr)
+		return
+	}
+
+	// retain db
+	dbSession := db.Copy()
+	defer dbSession.Close()
+
+	now := time.Now()
+	response := ct.RenewResponse{
+		Expiration: now.Add(requestBody.TimeToLive),
+		Failed:     make(map[string]string),
+	}
+	for _, id := range requestBody.ResourceIDs {
+		fileLog, err := dbSession.GetFileLog(id)
+		if err != nil ||
+			fileLog.Complete == false {
+			response.Failed[id] = "requested file not found"
+			continue
+		}
+		// strict acl verification: only the file owner is able
+		// to renew it.
+		if fileLog.Ownership.Username != userInfo.Username {
+			response.Failed[id] = "you are not authorised to renew this resource"
+			continue
+		}
+		if fileLogExpired(fileLog, now) {
+			response.Failed[id] = "requested file is expired"
+			continue
+		}
+		if fileLog.TimeToLive == 0 &&
+			fileLog.Expiration.IsZero() {
+			response.Failed[id] = "requested file has no time to live"
+			continue
+		}
+		renewFileLog(fileLog, now, requestBody.TimeToLive)
+		err = dbSession.UpdateFileLog(fileLog)
+		if err != nil {
+			response.Failed[id] = fmt.Sprintf("unable to update file log: %s", err.Error())
+			continue
+		}
+		response.Renewed = append(response.Renewed, id)
+	}
+
+	// return renew response message
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		panic(err)
+	}
+	if arguments.verbose {
+		log.VerboseLog("Renewed %d resources for user %s (%d failures).\n",
+			len(response.Renewed),
+			userInfo.Username,
+			len(response.Failed))
+	}
+}
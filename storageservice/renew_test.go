@@ -0,0 +1,222 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Internal dependencies.
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+func TestRenewFileLog(t *testing.T) {
+	now := time.Now()
+	fl := &FileLog{
+		Creation:   now.Add(-time.Hour),
+		TimeToLive: 2 * time.Hour,
+		Expiration: now.Add(time.Hour),
+	}
+	renewFileLog(fl, now, 24*time.Hour)
+	if !fl.Expiration.Equal(now.Add(24 * time.Hour)) {
+		t.Fatalf("Unexpected expiration: %s.\n", fl.Expiration.String())
+	}
+	if fl.TimeToLive != 25*time.Hour {
+		t.Fatalf("Unexpected time to live: %s.\n", fl.TimeToLive.String())
+	}
+}
+
+func TestRenewResources(t *testing.T) {
+	now := time.Now()
+	files := []*FileLog{
+		{
+			Id:         "renewowned",
+			Bucket:     arguments.s3Bucket,
+			Creation:   now,
+			TimeToLive: time.Hour,
+			Expiration: now.Add(time.Hour),
+			Complete:   true,
+			Ownership: Owner{
+				Username: mockUserInfo.Username,
+			},
+		},
+		{
+			Id:       "renewnottl",
+			Bucket:   arguments.s3Bucket,
+			Creation: now,
+			Complete: true,
+			Ownership: Owner{
+				Username: mockUserInfo.Username,
+			},
+		},
+		{
+			Id:         "renewexpired",
+			Bucket:     arguments.s3Bucket,
+			Creation:   now.Add(-2 * time.Hour),
+			TimeToLive: time.Hour,
+			Expiration: now.Add(-time.Hour),
+			Complete:   true,
+			Ownership: Owner{
+				Username: mockUserInfo.Username,
+			},
+		},
+		{
+			Id:         "renewnotowned",
+			Bucket:     arguments.s3Bucket,
+			Creation:   now,
+			TimeToLive: time.Hour,
+			Expiration: now.Add(time.Hour),
+			Complete:   true,
+			Ownership: Owner{
+				Username: "anotheruser",
+			},
+			Acl: Acl{
+				Permission: Public,
+			},
+		},
+	}
+	for _, fl := range files {
+		err := db.SetFileLog(fl)
+		if err != nil {
+			t.Fatalf("Unable to set file log: %s.\n", err.Error())
+		}
+		defer db.RemoveFileLog(fl.Id)
+	}
+
+	// login
+	loginBody := ct.LoginRequest{
+		Username: mockUserInfo.Username,
+		Password: mockUserPassword,
+	}
+	body, err := json.Marshal(&loginBody)
+	if err != nil {
+		t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+	}
+	client := &http.Client{}
+	req, err := http.NewRequest(
+		"POST",
+		fmt.Sprintf("http://%s:%d/v1/authsession", mockServiceAddress, mockServicePort),
+		bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Unable to prepare the login request: %s.\n", err.Error())
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform login request on server: %s.\n", err.Error())
+	}
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	var session ct.LoginResponse
+	err = json.Unmarshal(respBody, &session)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+
+	renewURL := fmt.Sprintf("http://%s:%d/v1/storage/renew", mockServiceAddress, mockServicePort)
+	// invalid requests
+	var invalidRequests = []struct {
+		request ct.RenewRequest
+		token   string
+		status  int
+	}{
+		{ct.RenewRequest{ResourceIDs: []string{"renewowned"}, TimeToLive: time.Hour}, "", http.StatusUnauthorized},
+		{ct.RenewRequest{TimeToLive: time.Hour}, session.Token, http.StatusBadRequest},
+		{ct.RenewRequest{ResourceIDs: []string{"renewowned"}}, session.Token, http.StatusBadRequest},
+		{ct.RenewRequest{ResourceIDs: []string{"renewowned"}, TimeToLive: -time.Hour}, session.Token, http.StatusBadRequest},
+	}
+	for idx, tc := range invalidRequests {
+		body, err = json.Marshal(&tc.request)
+		if err != nil {
+			t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+		}
+		req, err = http.NewRequest("POST", renewURL, bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatalf("Unable to prepare the renew request: %s.\n", err.Error())
+		}
+		if tc.token != "" {
+			req.Header.Set(ct.SecurityTokenKey, tc.token)
+		}
+		resp, err = client.Do(req)
+		if err != nil {
+			t.Fatalf("Unable to perform renew request on server: %s.\n", err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Fatalf("Test case %d: having status %d expecting %d.\n", idx, resp.StatusCode, tc.status)
+		}
+	}
+
+	// renew
+	body, err = json.Marshal(&ct.RenewRequest{
+		ResourceIDs: []string{
+			"renewowned",
+			"renewnottl",
+			"renewexpired",
+			"renewnotowned",
+			"renewmissing",
+		},
+		TimeToLive: 24 * time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+	}
+	req, err = http.NewRequest("POST", renewURL, bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Unable to prepare the renew request: %s.\n", err.Error())
+	}
+	req.Header.Set(ct.SecurityTokenKey, session.Token)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform renew request on server: %s.\n", err.Error())
+	}
+	respBody, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Having status %d expecting %d.\n", resp.StatusCode, http.StatusOK)
+	}
+	var renewResponse ct.RenewResponse
+	err = json.Unmarshal(respBody, &renewResponse)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+	if len(renewResponse.Renewed) != 1 ||
+		renewResponse.Renewed[0] != "renewowned" {
+		t.Fatalf("Unexpected renewed resources: %v.\n", renewResponse.Renewed)
+	}
+	if len(renewResponse.Failed) != 4 {
+		t.Fatalf("Unexpected failed resources: %v.\n", renewResponse.Failed)
+	}
+	for _, id := range []string{"renewnottl", "renewexpired", "renewnotowned", "renewmissing"} {
+		if _, ok := renewResponse.Failed[id]; !ok {
+			t.Fatalf("Resource %s should not be renewed.\n", id)
+		}
+	}
+
+	// verify file logs
+	fl, err := db.GetFileLog("renewowned")
+	if err != nil {
+		t.Fatalf("Unable to find file log: %s.\n", err.Error())
+	}
+	if fl.Expiration.Before(now.Add(24*time.Hour)) ||
+		fileLogExpired(fl, now.Add(23*time.Hour)) {
+		t.Fatalf("File log has not been renewed: %s.\n", fl.Expiration.String())
+	}
+	fl, err = db.GetFileLog("renewnotowned")
+	if err != nil {
+		t.Fatalf("Unable to find file log: %s.\n", err.Error())
+	}
+	if fl.TimeToLive != time.Hour {
+		t.Fatalf("Not owned file log should not be renewed.\n")
+	}
+}
@@ -0,0 +1,91 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Internal libs
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+const (
+	// defaultResourcesPage is the number of resources returned by
+	// a listing request if no limit is specified.
+	defaultResourcesPage = 100
+	// maxResourcesPage is the maximum number of resources returned
+	// by a single listing request.
+	maxResourcesPage = 1000
+)
+
+// getResources pages through the resources owned by the caller,
+// sorted by id. Incomplete and expired resources are listed too, to
+// let clients find and remove orphaned chunks. The "after" query
+// parameter is the cursor returned, as next, by the previous page
+// while "limit" defines the page size.
+func getResources(w http.ResponseWriter, r *http.Request) {
+	// authorise and get user's info
+	// extract token from headers
+	userInfo, err := authoriseGettingUserInfos(r, readScope)
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+
+	// get query parameters
+	query := r.URL.Query()
+	limit := defaultResourcesPage
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil ||
+			limit <= 0 ||
+			limit > maxResourcesPage {
+			riseError(http.StatusBadRequest,
+				fmt.Sprintf("limit should be a positive number lower than %d", maxResourcesPage), w,
+				r.RemoteAddr)
+			return
+		}
+	}
+	after := query.Get("after")
+
+	// retain db
+	dbSession := db.Copy()
+	defer dbSession.Close()
+	fileLogs, err := dbSession.GetFileLogsByOwner(userInfo.Username, after, limit)
+	if err != nil {
+		riseError(http.StatusInternalServerError,
+			fmt.Sprintf("unable to retrieve resources: %s", err.Error()), w,
+			r.RemoteAddr)
+		return
+	}
+
+	response := ct.ResourcesResponse{
+		Resources: make([]ct.ResourceInfo, 0, len(fileLogs)),
+	}
+	for idx := range fileLogs {
+		response.Resources = append(response.Resources, resourceInfo(&fileLogs[idx]))
+	}
+	// a full page may be followed by other resources
+	if len(fileLogs) == limit {
+		response.Next = fileLogs[len(fileLogs)-1].Id
+	}
+
+	// return resources
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		panic(err)
+	}
+}
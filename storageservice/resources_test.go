@@ -0,0 +1,180 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Internal dependencies.
+import (
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+func TestListResources(t *testing.T) {
+	now := time.Now()
+	files := []*FileLog{
+		{
+			Id:       "resourcesc",
+			Bucket:   arguments.s3Bucket,
+			Creation: now,
+			Complete: true,
+			Ownership: Owner{
+				Username: mockUserInfo.Username,
+			},
+		},
+		{
+			Id:       "resourcesa",
+			Bucket:   arguments.s3Bucket,
+			Creation: now,
+			Complete: false,
+			Ownership: Owner{
+				Username: mockUserInfo.Username,
+			},
+		},
+		{
+			Id:         "resourcesb",
+			Bucket:     arguments.s3Bucket,
+			Creation:   now.Add(-2 * time.Hour),
+			TimeToLive: time.Hour,
+			Expiration: now.Add(-time.Hour),
+			Complete:   true,
+			Ownership: Owner{
+				Username: mockUserInfo.Username,
+			},
+		},
+		{
+			Id:       "resourcesnotowned",
+			Bucket:   arguments.s3Bucket,
+			Creation: now,
+			Complete: true,
+			Ownership: Owner{
+				Username: "anotheruser",
+			},
+		},
+	}
+	for _, fl := range files {
+		err := db.SetFileLog(fl)
+		if err != nil {
+			t.Fatalf("Unable to set file log: %s.\n", err.Error())
+		}
+		defer db.RemoveFileLog(fl.Id)
+	}
+
+	// login
+	loginBody := ct.LoginRequest{
+		Username: mockUserInfo.Username,
+		Password: mockUserPassword,
+	}
+	body, err := json.Marshal(&loginBody)
+	if err != nil {
+		t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+	}
+	client := &http.Client{}
+	req, err := http.NewRequest(
+		"POST",
+		fmt.Sprintf("http://%s:%d/v1/authsession", mockServiceAddress, mockServicePort),
+		bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Unable to prepare the login request: %s.\n", err.Error())
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform login request on server: %s.\n", err.Error())
+	}
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	var session ct.LoginResponse
+	err = json.Unmarshal(respBody, &session)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+
+	listResources := func(query string) (int, *ct.ResourcesResponse) {
+		req, err := http.NewRequest(
+			"GET",
+			fmt.Sprintf("http://%s:%d/v1/storage/resources?%s", mockServiceAddress, mockServicePort, query),
+			nil)
+		if err != nil {
+			t.Fatalf("Unable to prepare the resources request: %s.\n", err.Error())
+		}
+		req.Header.Set(ct.SecurityTokenKey, session.Token)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Unable to perform resources request on server: %s.\n", err.Error())
+		}
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return resp.StatusCode, nil
+		}
+		var resources ct.ResourcesResponse
+		err = json.Unmarshal(respBody, &resources)
+		if err != nil {
+			t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+		}
+		return resp.StatusCode, &resources
+	}
+
+	// invalid limits
+	var invalidQueries = []string{
+		"limit=0",
+		"limit=-1",
+		"limit=notanumber",
+		fmt.Sprintf("limit=%d", maxResourcesPage+1),
+	}
+	for idx, query := range invalidQueries {
+		status, _ := listResources(query)
+		if status != http.StatusBadRequest {
+			t.Fatalf("Test case %d: having status %d expecting %d.\n", idx, status, http.StatusBadRequest)
+		}
+	}
+
+	// page through resources
+	var ids []string
+	next := ""
+	for pages := 0; ; pages++ {
+		if pages > len(files) {
+			t.Fatalf("Too many pages returned.\n")
+		}
+		status, resources := listResources(fmt.Sprintf("limit=2&after=%s", next))
+		if status != http.StatusOK {
+			t.Fatalf("Having status %d expecting %d.\n", status, http.StatusOK)
+		}
+		for _, resource := range resources.Resources {
+			ids = append(ids, resource.ID)
+			if resource.ID == "resourcesb" &&
+				(resource.TimeToLive != time.Hour ||
+					resource.Expiration == nil) {
+				t.Fatalf("Unexpected resource infos: %v.\n", resource)
+			}
+			if resource.ID == "resourcesa" &&
+				resource.Complete {
+				t.Fatalf("Resource should be incomplete: %v.\n", resource)
+			}
+		}
+		if resources.Next == "" {
+			break
+		}
+		next = resources.Next
+	}
+	expected := []string{"resourcesa", "resourcesb", "resourcesc"}
+	if len(ids) != len(expected) {
+		t.Fatalf("Having resources %v expecting %v.\n", ids, expected)
+	}
+	for idx := range expected {
+		if ids[idx] != expected[idx] {
+			t.Fatalf("Having resources %v expecting %v.\n", ids, expected)
+		}
+	}
+}
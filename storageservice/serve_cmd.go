@@ -8,14 +8,25 @@ package main
 
 // Golang std libs
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 // Internal dependencies
 import (
+	"github.com/nexocrew/3nigm4/lib/auth"
+	"github.com/nexocrew/3nigm4/lib/metrics"
+	"github.com/nexocrew/3nigm4/lib/ratelimit"
 	s3c "github.com/nexocrew/3nigm4/lib/s3"
+	sb "github.com/nexocrew/3nigm4/lib/storagebackend"
 )
 
 // Third party libs
@@ -49,15 +60,44 @@ func init() {
 	// auth RPC service
 	ServeCmd.PersistentFlags().StringVarP(&arguments.authServiceAddress, "authaddr", "A", "", "the authorisation RPC service address")
 	ServeCmd.PersistentFlags().IntVarP(&arguments.authServicePort, "authport", "P", 7931, "the authorisation RPC service port")
+	ServeCmd.PersistentFlags().StringVarP(&arguments.authCA, "authca", "", "", "the PEM file of the CA used to verify the authorisation RPC service certificate")
+	ServeCmd.PersistentFlags().StringVarP(&arguments.authCertificate, "authcert", "", "", "the client certificate PEM file presented to the authorisation RPC service")
+	ServeCmd.PersistentFlags().StringVarP(&arguments.authPrivateKey, "authkey", "", "", "the client certificate private key PEM file")
+	ServeCmd.PersistentFlags().BoolVarP(&arguments.authInsecure, "authinsecure", "", false, "connect to the authorisation RPC service over plain TCP (passwords and tokens are sent in clear text)")
+	ServeCmd.PersistentFlags().IntVarP(&arguments.authPoolSize, "authpool", "", 4, "maximum number of connections to the authorisation RPC service")
+	ServeCmd.PersistentFlags().Uint32VarP(&arguments.authCacheSeconds, "authcache", "", 0, "time, in seconds, authorised users infos are cached avoiding authorisation RPC calls (0 disables the cache)")
+	// storage backend
+	ServeCmd.PersistentFlags().StringVarP(&arguments.backend, "backend", "", s3Backend, "storage backend driver (s3, filesystem or memory)")
+	ServeCmd.PersistentFlags().StringVarP(&arguments.fsRoot, "fsroot", "", "/var/lib/3nigm4", "filesystem backend root directory")
 	// s3 references
 	ServeCmd.PersistentFlags().StringVarP(&arguments.s3Endpoint, "s3endpoint", "", "s3.amazonaws.com", "s3 backend service endpoint")
 	ServeCmd.PersistentFlags().StringVarP(&arguments.s3Region, "s3region", "", "eu-central-1", "s3 backend service region")
 	ServeCmd.PersistentFlags().StringVarP(&arguments.s3Id, "s3id", "", "", "s3 backend service id")
 	ServeCmd.PersistentFlags().StringVarP(&arguments.s3Secret, "s3secret", "", "", "s3 backend service secret")
 	ServeCmd.PersistentFlags().StringVarP(&arguments.s3Token, "s3token", "", "", "s3 backend service token")
-	ServeCmd.PersistentFlags().IntVarP(&arguments.s3WorkingQueueSize, "s3wqsize", "", 24, "s3 working queue size")
-	ServeCmd.PersistentFlags().IntVarP(&arguments.s3QueueSize, "s3queuesize", "", 300, "s3 queue size")
-	ServeCmd.PersistentFlags().StringVarP(&arguments.s3Bucket, "s3bucket", "", "3nigm4", "s3 backend service target bucket")
+	ServeCmd.PersistentFlags().IntVarP(&arguments.s3WorkingQueueSize, "s3wqsize", "", 24, "backend working queue size")
+	ServeCmd.PersistentFlags().IntVarP(&arguments.s3QueueSize, "s3queuesize", "", 300, "backend queue size")
+	ServeCmd.PersistentFlags().StringVarP(&arguments.s3Bucket, "s3bucket", "", "3nigm4", "backend target bucket")
+	// expired files reaper
+	ServeCmd.PersistentFlags().Uint32VarP(&arguments.reaperScheduleMinutes, "reapertime", "", 10, "run at defined intervals the reaper removing expired files in minutes (0 disables it)")
+	// default users quotas
+	ServeCmd.PersistentFlags().Int64VarP(&arguments.quotaBytes, "quotabytes", "", 0, "default maximum bytes stored per user, overridden by user's permissions (0 means unlimited)")
+	ServeCmd.PersistentFlags().Int64VarP(&arguments.quotaObjects, "quotaobjects", "", 0, "default maximum objects stored per user, overridden by user's permissions (0 means unlimited)")
+	// download links
+	ServeCmd.PersistentFlags().StringVarP(&arguments.linkSecret, "linksecret", "", "", "hex encoded secret (at least 32 bytes) used to sign download links, if empty a random one is generated")
+	// audit log
+	ServeCmd.PersistentFlags().Uint32VarP(&arguments.auditRetentionDays, "auditretention", "", 90, "days audit events are retained before being automatically removed (0 retains them forever)")
+	// brute-force protection
+	arguments.limits.Register(ServeCmd.PersistentFlags(), "login")
+	// server side encryption
+	ServeCmd.PersistentFlags().StringVarP(&arguments.masterKeysFile, "masterkeysfile", "", "", "path of the file listing, comma or new line separated, the <version>:<hex encoded 32 bytes key> master keys used to encrypt stored resources, the highest version protects new ones (if not set the "+envMasterKeys+" environment variable is used, if empty resources are stored as received)")
+	// graceful shutdown
+	ServeCmd.PersistentFlags().Uint32VarP(&arguments.shutdownTimeoutSeconds, "shutdowntimeout", "", 30, "maximum time, in seconds, waited for in flight requests and backend operations on shutdown")
+	// async jobs state
+	ServeCmd.PersistentFlags().StringVarP(&arguments.jobStore, "jobstore", "", memoryJobStore, "async jobs state store (memory, for single node deployments, or redis)")
+	ServeCmd.PersistentFlags().StringVarP(&arguments.redisAddress, "redisaddr", "", "127.0.0.1:6379", "the redis job store address")
+	ServeCmd.PersistentFlags().StringVarP(&arguments.redisPassword, "redispwd", "", "", "the redis job store password")
+	ServeCmd.PersistentFlags().IntVarP(&arguments.redisDb, "redisdb", "", 0, "the redis job store db index")
 	// files parameters
 	ServeCmd.RunE = serve
 }
@@ -89,9 +129,11 @@ func mgoStartup(a *args) (database, error) {
 	log.MessageLog("Mongodb %s successfully connected.\n", a.dbAddresses)
 
 	// ensure indexes
+	AuditEventExistance = time.Duration(a.auditRetentionDays) * 24 * time.Hour
 	err = mgodb.EnsureMongodbIndexes()
 	if err != nil {
-		log.WarningLog("Ensuring indexes in Mongodb returned error %s.\n", err.Error())
+		mgodb.Close()
+		return nil, fmt.Errorf("failed to ensure db indexes cause %s", err.Error())
 	}
 	return mgodb, nil
 }
@@ -108,23 +150,77 @@ var authClientStartup func(*args) (AuthClient, error) = rpcClientStartup
 // rpcClientStartup creates an RPC client and returns it if no error
 // encountered. It manage a success log if all went right.
 func rpcClientStartup(a *args) (AuthClient, error) {
-	client, err := NewAuthRpc(arguments.authServiceAddress, arguments.authServicePort)
+	var config *tls.Config
+	if a.authInsecure {
+		log.WarningLog("Connecting to auth RPC service without SSL/TLS, this can produce security risks.\n")
+	} else {
+		var err error
+		config, err = auth.ClientTLSConfig(&auth.TLSArgs{
+			CA:          a.authCA,
+			Certificate: a.authCertificate,
+			PrivateKey:  a.authPrivateKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to configure TLS (use authinsecure flag to connect via plain TCP): %s", err.Error())
+		}
+	}
+	client, err := NewAuthRpc(
+		a.authServiceAddress,
+		a.authServicePort,
+		config,
+		a.authPoolSize,
+		time.Duration(a.authCacheSeconds)*time.Second)
 	if err != nil {
 		return nil, err
 	}
 
 	log.MessageLog("Initialised connection with auth RPC service: %s:%d.\n",
-		arguments.authServiceAddress,
-		arguments.authServicePort)
+		a.authServiceAddress,
+		a.authServicePort)
 
 	return client, nil
 }
 
-// S3 backend service managed with working
+// Available storage backend drivers.
+const (
+	s3Backend         = "s3"         // Amazon S3 (or compatible) object store;
+	filesystemBackend = "filesystem" // local directory, for self hosted deployments;
+	memoryBackend     = "memory"     // volatile in memory storage, for tests.
+)
+
+// Storage backend service managed with working
 // queue.
-var s3backend *s3c.Session
+var backend sb.Backend
 
-// s3backendStartup initialise the global s3 backend session.
+// backendStartup initialise the global storage backend using the
+// driver selected by the backend flag.
+func backendStartup(a *args) (sb.Backend, error) {
+	switch a.backend {
+	case s3Backend:
+		s3, err := s3backendStartup(a)
+		if err != nil {
+			return nil, err
+		}
+		return s3, nil
+	case filesystemBackend:
+		fs, err := sb.NewFilesystemSession(a.fsRoot, a.s3WorkingQueueSize, a.s3QueueSize)
+		if err != nil {
+			return nil, err
+		}
+		log.MessageLog("Initialised filesystem backend in directory %s.\n", a.fsRoot)
+		return fs, nil
+	case memoryBackend:
+		memory, err := sb.NewMemorySession(a.s3WorkingQueueSize, a.s3QueueSize)
+		if err != nil {
+			return nil, err
+		}
+		log.WarningLog("Initialised in memory backend, stored data will be lost on exit.\n")
+		return memory, nil
+	}
+	return nil, fmt.Errorf("unknown storage backend %s", a.backend)
+}
+
+// s3backendStartup initialise the s3 backend session.
 func s3backendStartup(a *args) (*s3c.Session, error) {
 	s3, err := s3c.NewSession(
 		a.s3Endpoint,
@@ -144,6 +240,107 @@ func s3backendStartup(a *args) (*s3c.Session, error) {
 	return s3, nil
 }
 
+// Global brute-force protection limiter for login attempts, failures
+// are counted per username and origin ip address.
+var loginLimiter *ratelimit.Limiter
+
+// publicRoutes are the names of the routes not requiring users to
+// be granted a level on the service: login, password reset
+// completion (authorised by the reset token), download links and
+// health checks.
+var publicRoutes = []string{
+	"login",
+	"passwordreset",
+	"linkchunk",
+	"ping",
+	"live",
+	"ready",
+	"metrics",
+}
+
+// chunkRoutes are the names of the routes accepting transfer tokens,
+// obtained with a batch, in place of session tokens.
+var chunkRoutes = map[string]bool{
+	"putchunk": true,
+	"getchunk": true,
+}
+
+// newRouter creates the service router: all the routes, but the
+// public ones, are reserved to users granted a level on the storage
+// service.
+func newRouter() (*mux.Router, error) {
+	route := mux.NewRouter()
+	// define auth routes
+	route.HandleFunc("/v1/authsession", login).Methods("POST").Name("login")
+	route.HandleFunc("/v1/authsession", logout).Methods("DELETE")
+	route.HandleFunc("/v1/authsession", refresh).Methods("PUT")
+	route.HandleFunc("/v1/authsession/totp", enrolTotp).Methods("POST")
+	route.HandleFunc("/v1/authsession/totp", confirmTotp).Methods("PUT")
+	route.HandleFunc("/v1/authsession/totp", disableTotp).Methods("DELETE")
+	route.HandleFunc("/v1/authsession/apikey", createApiKey).Methods("POST")
+	route.HandleFunc("/v1/authsession/apikey", listApiKeys).Methods("GET")
+	route.HandleFunc("/v1/authsession/apikey/{keyid:[a-f0-9]+}", revokeApiKey).Methods("DELETE")
+	route.HandleFunc("/v1/authsession/sessions", listSessions).Methods("GET")
+	route.HandleFunc("/v1/authsession/sessions", revokeSessions).Methods("DELETE")
+	route.HandleFunc("/v1/authsession/sessions/{sessionid:[a-f0-9]+}", revokeSessions).Methods("DELETE")
+	route.HandleFunc("/v1/authsession/password", changePassword).Methods("PUT")
+	route.HandleFunc("/v1/authsession/password/reset", resetPassword).Methods("POST")
+	route.HandleFunc("/v1/authsession/password/reset", completePasswordReset).Methods("PUT").Name("passwordreset")
+	// administrative routes: reserved to storage administrators,
+	// users management requires super-admins.
+	route.HandleFunc("/v1/admin/users", listUsers).Methods("GET")
+	route.HandleFunc("/v1/admin/users", createUser).Methods("POST")
+	route.HandleFunc("/v1/admin/users/{username}", getUser).Methods("GET")
+	route.HandleFunc("/v1/admin/users/{username}", updateUser).Methods("PUT")
+	route.HandleFunc("/v1/admin/users/{username}", removeUser).Methods("DELETE")
+	route.HandleFunc("/v1/admin/sessions", kickOutAllSessions).Methods("DELETE")
+	// define async storage routes: the REST resource is a job. Every type a
+	// job is created using a POST method the status of the request can be
+	// vefified using the FET method on the returned jobid.
+	route.HandleFunc("/v1/storage/job", postJob).Methods("POST")
+	route.HandleFunc("/v1/storage/job/{jobid:[A-Fa-f0-9]+}", getJob).Methods("GET")
+	// batches create, or verify, several jobs with a single request.
+	route.HandleFunc("/v1/storage/batch", postBatch).Methods("POST")
+	route.HandleFunc("/v1/storage/batch/status", postBatchStatus).Methods("POST")
+	// define streaming storage routes: chunks are directly transferred as
+	// raw bodies (application/octet-stream) without async jobs.
+	route.HandleFunc("/v1/storage/chunk/{id}", putChunk).Methods("PUT").Name("putchunk")
+	route.HandleFunc("/v1/storage/chunk/{id}", getChunk).Methods("GET").Name("getchunk")
+	// lease renewal route: extends the time to live of owned resources.
+	route.HandleFunc("/v1/storage/renew", renewResources).Methods("POST")
+	// acl routes: update owned resources permissions and list
+	// resources shared with the caller.
+	route.HandleFunc("/v1/storage/acl", updateAcl).Methods("PUT")
+	route.HandleFunc("/v1/storage/shared", getSharedResources).Methods("GET")
+	// download link routes: links are created by resources owners
+	// and can be used to download Public resources without a session.
+	route.HandleFunc("/v1/storage/link", createLink).Methods("POST")
+	route.HandleFunc("/v1/storage/link/{token}/{id}", getLinkChunk).Methods("GET").Name("linkchunk")
+	// listing route: pages through the resources owned by the caller.
+	route.HandleFunc("/v1/storage/resources", getResources).Methods("GET")
+	// audit route: lists events about resources owned by the caller.
+	route.HandleFunc("/v1/storage/audit", getAuditEvents).Methods("GET")
+	// usage accounting route: returns caller's usage and quotas.
+	route.HandleFunc("/v1/storage/usage", getUsage).Methods("GET")
+	// utility routes
+	route.HandleFunc("/v1/ping", getPing).Methods("GET").Name("ping")
+	// health routes: liveness and readiness, checking dependencies.
+	route.HandleFunc("/v1/health/live", getLive).Methods("GET").Name("live")
+	route.HandleFunc("/v1/health/ready", getReady).Methods("GET").Name("ready")
+	// metrics route: exposes service metrics in Prometheus format.
+	route.Handle("/metrics", metrics.Handler()).Methods("GET").Name("metrics")
+	// users not granted a level on the service are refused on all
+	// non public routes.
+	err := auth.ProtectRouter(
+		route,
+		serviceAuthoriser.Middleware(auth.LevelUser, authenticateRequest, refuseRequest),
+		publicRoutes...)
+	if err != nil {
+		return nil, err
+	}
+	return route, nil
+}
+
 // serve command expose REST APIs.
 func serve(cmd *cobra.Command, args []string) error {
 	printLogo()
@@ -156,58 +353,144 @@ func serve(cmd *cobra.Command, args []string) error {
 	}
 	defer db.Close()
 
+	// startup async jobs store
+	jobs, err = jobStoreStartup(&arguments)
+	if err != nil {
+		return fmt.Errorf("unable to initialise job store: %s", err.Error())
+	}
+	defer jobs.Close()
+
 	// startup RPC auth service
 	authClient, err = authClientStartup(&arguments)
 	if err != nil {
 		return fmt.Errorf("unable to start RPC client connection: %s", err.Error())
 	}
 	defer authClient.Close()
+	// startup login brute-force protection
+	loginLimiter = arguments.limits.NewLimiter(log)
 
-	// startup S3 backend
-	s3backend, err = s3backendStartup(&arguments)
+	// startup storage backend
+	backend, err = backendStartup(&arguments)
 	if err != nil {
-		return fmt.Errorf("unable to initialise s3 backend session: %s", err.Error())
+		return fmt.Errorf("unable to initialise storage backend: %s", err.Error())
+	}
+	defer backend.Close()
+	metrics.RegisterQueue("backend", backend)
+	// start wq chan for async processing: results processing is
+	// tracked to be awaited on shutdown.
+	quitResults := make(chan struct{})
+	asyncUpdates.Add(1)
+	go func() {
+		defer asyncUpdates.Done()
+		manageBackendChans(backend.Chans(), quitResults)
+	}()
+	// start expired files reaper
+	if arguments.reaperScheduleMinutes != 0 {
+		quitReaper := make(chan struct{})
+		defer close(quitReaper)
+		go runReaper(time.Duration(arguments.reaperScheduleMinutes)*time.Minute, quitReaper)
+	}
+
+	// download links secret
+	linkSecret, err = linkSecretStartup(&arguments)
+	if err != nil {
+		return fmt.Errorf("unable to initialise download links: %s", err.Error())
+	}
+	// server side encryption master keys
+	masterKeys, currentKeyVersion, err = masterKeysStartup(&arguments)
+	if err != nil {
+		return fmt.Errorf("unable to initialise master keys: %s", err.Error())
 	}
-	defer s3backend.Close()
-	// start wq chan for async processing
-	go manageS3chans(s3backend)
 
 	// create router
-	route := mux.NewRouter()
-	// define auth routes
-	route.HandleFunc("/v1/authsession", login).Methods("POST")
-	route.HandleFunc("/v1/authsession", logout).Methods("DELETE")
-	// define async storage routes: the REST resource is a job. Every type a
-	// job is created using a POST method the status of the request can be
-	// vefified using the FET method on the returned jobid.
-	route.HandleFunc("/v1/storage/job", postJob).Methods("POST")
-	route.HandleFunc("/v1/storage/job/{jobid:[A-Fa-f0-9]+}", getJob).Methods("GET")
-	// utility routes
-	route.HandleFunc("/v1/ping", getPing).Methods("GET")
-	// root routes
-	http.Handle("/", route)
+	route, err := newRouter()
+	if err != nil {
+		return err
+	}
+	// root routes: requests are counted and timed by route.
+	http.Handle("/", metrics.InstrumentRouter(route))
 
 	serviceAddress := fmt.Sprintf("%s:%d", arguments.address, arguments.port)
-	// init http or https connection
-	if arguments.SslCertificate != "" &&
-		arguments.SslPrivateKey != "" {
-		log.MessageLog("Starting listening with TLS on address %s.\n", serviceAddress)
+	server := &http.Server{
+		Addr: serviceAddress,
+	}
+	serverErrors := make(chan error, 1)
+	go func() {
+		serverErrors <- listenAndServe(server, &arguments)
+	}()
+
+	// wait for termination signals
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	select {
+	case err = <-serverErrors:
+		return err
+	case sig := <-signals:
+		log.MessageLog("Received %s signal, shutting down.\n", sig.String())
+	}
+	shutdown(server, quitResults, time.Duration(arguments.shutdownTimeoutSeconds)*time.Second)
+	return nil
+}
+
+// listenAndServe starts the http or https, if certificates are
+// configured, server returning when it's closed.
+func listenAndServe(server *http.Server, a *args) error {
+	if a.SslCertificate != "" &&
+		a.SslPrivateKey != "" {
+		log.MessageLog("Starting listening with TLS on address %s.\n", server.Addr)
 		// set up SSL/TLS
-		err = http.ListenAndServeTLS(
-			serviceAddress,
-			arguments.SslCertificate,
-			arguments.SslPrivateKey,
-			nil)
-		if err != nil {
-			return fmt.Errorf("https unable to listen and serve on address: %s cause error: %s", serviceAddress, err.Error())
-		}
-	} else {
-		log.WarningLog("Starting listening on address %s (no SSL/TLS this can produce security risks).\n", serviceAddress)
-		// plain https
-		err = http.ListenAndServe(serviceAddress, nil)
-		if err != nil {
-			return fmt.Errorf("http unable to listen and serve on address: %s cause error: %s", serviceAddress, err.Error())
+		err := server.ListenAndServeTLS(
+			a.SslCertificate,
+			a.SslPrivateKey)
+		if err != nil &&
+			err != http.ErrServerClosed {
+			return fmt.Errorf("https unable to listen and serve on address: %s cause error: %s", server.Addr, err.Error())
 		}
+		return nil
+	}
+	log.WarningLog("Starting listening on address %s (no SSL/TLS this can produce security risks).\n", server.Addr)
+	// plain https
+	err := server.ListenAndServe()
+	if err != nil &&
+		err != http.ErrServerClosed {
+		return fmt.Errorf("http unable to listen and serve on address: %s cause error: %s", server.Addr, err.Error())
 	}
 	return nil
 }
+
+// shutdown gracefully stops the service: new requests are refused
+// while in flight ones are completed, then enqueued backend
+// operations and the related async tx updates are awaited. The
+// argument timeout bounds the whole procedure, resources are then
+// released by serve deferred calls.
+func shutdown(server *http.Server, quitResults chan struct{}, timeout time.Duration) {
+	atomic.StoreInt32(&shuttingDown, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// stop accepting requests
+	err := server.Shutdown(ctx)
+	if err != nil {
+		log.ErrorLog("Unable to complete in flight requests: %s.\n", err.Error())
+	}
+	// drain backend working queue
+	deadline, _ := ctx.Deadline()
+	err = backend.Drain(deadline.Sub(time.Now()))
+	if err != nil {
+		log.ErrorLog("Unable to complete backend operations: %s.\n", err.Error())
+	}
+	// wait for async tx updates
+	close(quitResults)
+	done := make(chan struct{})
+	go func() {
+		asyncUpdates.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.MessageLog("Service gracefully stopped.\n")
+	case <-ctx.Done():
+		log.ErrorLog("Unable to complete async tx updates before timeout.\n")
+	}
+}
@@ -8,12 +8,15 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -21,12 +24,19 @@ import (
 
 // Internal dependencies.
 import (
+	"github.com/nexocrew/3nigm4/lib/auth"
 	ct "github.com/nexocrew/3nigm4/lib/commons"
 	"github.com/nexocrew/3nigm4/lib/itm"
 	"github.com/nexocrew/3nigm4/lib/logger"
+	"github.com/nexocrew/3nigm4/lib/ratelimit"
 	wq "github.com/nexocrew/3nigm4/lib/workingqueue"
 )
 
+// Third party
+import (
+	"github.com/gorilla/mux"
+)
+
 const (
 	fileContent = `Test this content for file usage,
 		should be used to test upload functions to the
@@ -55,9 +65,10 @@ const (
 )
 
 var (
-	mockServiceAddress = "127.0.0.1"
-	mockServicePort    = 17973
-	fileID             = ""
+	mockServiceAddress  = "127.0.0.1"
+	mockServicePort     = 17973
+	fileID              = ""
+	fileContentCheckSum = sha256.Sum256([]byte(fileContent))
 )
 
 func TestMain(m *testing.M) {
@@ -81,6 +92,7 @@ func TestMain(m *testing.M) {
 		dbAuth:             itm.S().DbAuth(),
 		address:            mockServiceAddress,
 		port:               mockServicePort,
+		backend:            memoryBackend,
 		s3Endpoint:         itm.S().S3Endpoint(),
 		s3Region:           itm.S().S3Region(),
 		s3Id:               itm.S().S3Id(),
@@ -89,6 +101,7 @@ func TestMain(m *testing.M) {
 		s3Bucket:           itm.S().S3Bucket(),
 		s3QueueSize:        200,
 		s3WorkingQueueSize: 12,
+		jobStore:           memoryJobStore,
 	}
 	databaseStartup = mockDbStartup
 	authClientStartup = mockAuthStartup
@@ -187,6 +200,58 @@ func TestPing(t *testing.T) {
 	}
 }
 
+func TestLoginThrottling(t *testing.T) {
+	// replace the service limiter with a stricter one
+	previous := loginLimiter
+	loginLimiter = ratelimit.NewLimiter(ratelimit.Config{
+		FreeAttempts: 1,
+		BaseDelay:    time.Hour,
+	}, nil)
+	defer func() {
+		loginLimiter = previous
+	}()
+
+	login := func(password string) *http.Response {
+		body, err := json.Marshal(&ct.LoginRequest{
+			Username: mockUserInfo.Username,
+			Password: password,
+		})
+		if err != nil {
+			t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+		}
+		resp, err := http.Post(
+			fmt.Sprintf("http://%s:%d/v1/authsession", mockServiceAddress, mockServicePort),
+			"application/json",
+			bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatalf("Unable to perform login request on server: %s.\n", err.Error())
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	var testCases = []struct {
+		password string
+		status   int
+	}{
+		{"wrongpassword", http.StatusUnauthorized},
+		{mockUserPassword, http.StatusOK},
+		// address failures are not reset by a valid login
+		{"wrongpassword", http.StatusUnauthorized},
+		{mockUserPassword, http.StatusTooManyRequests},
+	}
+	var resp *http.Response
+	for idx, tc := range testCases {
+		resp = login(tc.password)
+		if resp.StatusCode != tc.status {
+			t.Fatalf("Test case %d: having status %d expecting %d.\n", idx, resp.StatusCode, tc.status)
+		}
+	}
+	if resp.Header.Get("Retry-After") != "3600" {
+		t.Fatalf("Unexpected Retry-After header %s.\n", resp.Header.Get("Retry-After"))
+	}
+}
+
 func TestLoginAndLogout(t *testing.T) {
 	loginBody := ct.LoginRequest{
 		Username: mockUserInfo.Username,
@@ -261,6 +326,773 @@ func TestLoginAndLogout(t *testing.T) {
 	}
 }
 
+func TestLoginRefreshAndLogout(t *testing.T) {
+	loginBody := ct.LoginRequest{
+		Username: mockUserInfo.Username,
+		Password: mockUserPassword,
+	}
+	body, err := json.Marshal(&loginBody)
+	if err != nil {
+		t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+	}
+
+	client := &http.Client{}
+	req, err := http.NewRequest(
+		"POST",
+		fmt.Sprintf("http://%s:%d/v1/authsession", mockServiceAddress, mockServicePort),
+		bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Unable to prepare the login request: %s.\n", err.Error())
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform login request on server: %s.\n", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unable to access login service, returned %d but expected %d.\n", resp.StatusCode, http.StatusOK)
+	}
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	var session ct.LoginResponse
+	err = json.Unmarshal(respBody, &session)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+	resp.Body.Close()
+
+	// refresh the session
+	req, err = http.NewRequest(
+		"PUT",
+		fmt.Sprintf("http://%s:%d/v1/authsession", mockServiceAddress, mockServicePort),
+		nil)
+	if err != nil {
+		t.Fatalf("Unable to prepare the refresh request: %s.\n", err.Error())
+	}
+	req.Header.Set(ct.SecurityTokenKey, session.Token)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform refresh request on server: %s.\n", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unable to refresh session, returned %d but expected %d.\n", resp.StatusCode, http.StatusOK)
+	}
+	respBody, _ = ioutil.ReadAll(resp.Body)
+	var refreshed ct.LoginResponse
+	err = json.Unmarshal(respBody, &refreshed)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+	resp.Body.Close()
+	if refreshed.Token == "" ||
+		refreshed.Token == session.Token {
+		t.Fatalf("Invalid refreshed token: should be not nil and different from %s.\n", session.Token)
+	}
+
+	// the old token must be invalidated
+	req, err = http.NewRequest(
+		"PUT",
+		fmt.Sprintf("http://%s:%d/v1/authsession", mockServiceAddress, mockServicePort),
+		nil)
+	if err != nil {
+		t.Fatalf("Unable to prepare the refresh request: %s.\n", err.Error())
+	}
+	req.Header.Set(ct.SecurityTokenKey, session.Token)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform refresh request on server: %s.\n", err.Error())
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Refreshing an invalidated session should fail, returned %d but expected %d.\n", resp.StatusCode, http.StatusUnauthorized)
+	}
+	resp.Body.Close()
+
+	// logout with the refreshed token
+	req, err = http.NewRequest(
+		"DELETE",
+		fmt.Sprintf("http://%s:%d/v1/authsession", mockServiceAddress, mockServicePort),
+		nil)
+	if err != nil {
+		t.Fatalf("Unable to prepare the logout request: %s.\n", err.Error())
+	}
+	req.Header.Set(ct.SecurityTokenKey, refreshed.Token)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform logout request on server: %s.\n", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unable to perform logout, returned %d but expected %d.\n", resp.StatusCode, http.StatusOK)
+	}
+	resp.Body.Close()
+}
+
+// serviceRequest performs a request, JSON encoding body if not
+// nil, on the mock service returning the status code and the
+// response body.
+func serviceRequest(t *testing.T, method, path, token string, body interface{}) (int, []byte) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+		}
+		reader = bytes.NewBuffer(data)
+	}
+	req, err := http.NewRequest(
+		method,
+		fmt.Sprintf("http://%s:%d%s", mockServiceAddress, mockServicePort, path),
+		reader)
+	if err != nil {
+		t.Fatalf("Unable to prepare the request: %s.\n", err.Error())
+	}
+	if token != "" {
+		req.Header.Set(ct.SecurityTokenKey, token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform request on server: %s.\n", err.Error())
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, respBody
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	// wrong codes must not lock out following tests
+	previous := loginLimiter
+	loginLimiter = ratelimit.NewLimiter(ratelimit.Config{
+		FreeAttempts: 100,
+	}, nil)
+	defer func() {
+		loginLimiter = previous
+	}()
+
+	request := func(method, path, token string, body interface{}) (int, []byte) {
+		return serviceRequest(t, method, path, token, body)
+	}
+	login := func(otp string) (int, []byte) {
+		return request("POST", "/v1/authsession", "", &ct.LoginRequest{
+			Username: mockUserInfo.Username,
+			Password: mockUserPassword,
+			OTP:      otp,
+		})
+	}
+
+	status, respBody := login("")
+	if status != http.StatusOK {
+		t.Fatalf("Unable to login, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+	var session ct.LoginResponse
+	err := json.Unmarshal(respBody, &session)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+
+	// enrol and confirm
+	status, respBody = request("POST", "/v1/authsession/totp", session.Token, nil)
+	if status != http.StatusOK {
+		t.Fatalf("Unable to enrol, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+	var enrolment ct.TotpEnrolResponse
+	err = json.Unmarshal(respBody, &enrolment)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+	if enrolment.Secret == "" ||
+		enrolment.URL == "" {
+		t.Fatalf("Invalid enrolment: secret and URL should not be nil.\n")
+	}
+	status, _ = request("PUT", "/v1/authsession/totp", session.Token, &ct.TotpCodeRequest{})
+	if status != http.StatusBadRequest {
+		t.Fatalf("Missing code should be refused, returned %d but expected %d.\n", status, http.StatusBadRequest)
+	}
+	status, respBody = request("PUT", "/v1/authsession/totp", session.Token, &ct.TotpCodeRequest{
+		Code: mockTotpCode,
+	})
+	if status != http.StatusOK {
+		t.Fatalf("Unable to confirm, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+	var confirmation ct.TotpConfirmResponse
+	err = json.Unmarshal(respBody, &confirmation)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+	if len(confirmation.RecoveryCodes) == 0 {
+		t.Fatalf("Recovery codes should be returned.\n")
+	}
+
+	// login requires the code
+	status, respBody = login("")
+	if status != http.StatusUnauthorized {
+		t.Fatalf("Login without code should fail, returned %d but expected %d.\n", status, http.StatusUnauthorized)
+	}
+	var standard ct.StandardResponse
+	err = json.Unmarshal(respBody, &standard)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+	if standard.Error != ct.OtpRequiredError {
+		t.Fatalf("Unexpected error having %s expecting %s.\n", standard.Error, ct.OtpRequiredError)
+	}
+	status, _ = login("000000")
+	if status != http.StatusUnauthorized {
+		t.Fatalf("Login with wrong code should fail, returned %d but expected %d.\n", status, http.StatusUnauthorized)
+	}
+	status, _ = login(mockTotpCode)
+	if status != http.StatusOK {
+		t.Fatalf("Unable to login with code, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+
+	// disable
+	status, _ = request("DELETE", "/v1/authsession/totp", session.Token, &ct.TotpCodeRequest{
+		Code: mockTotpCode,
+	})
+	if status != http.StatusOK {
+		t.Fatalf("Unable to disable, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+	status, _ = login("")
+	if status != http.StatusOK {
+		t.Fatalf("Unable to login after disabling, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+}
+
+func TestApiKeys(t *testing.T) {
+	status, respBody := serviceRequest(t, "POST", "/v1/authsession", "", &ct.LoginRequest{
+		Username: mockUserInfo.Username,
+		Password: mockUserPassword,
+	})
+	if status != http.StatusOK {
+		t.Fatalf("Unable to login, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+	var session ct.LoginResponse
+	err := json.Unmarshal(respBody, &session)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+
+	// create
+	status, _ = serviceRequest(t, "POST", "/v1/authsession/apikey", session.Token, &ct.ApiKeyRequest{
+		Name: "backup",
+	})
+	if status != http.StatusBadRequest {
+		t.Fatalf("Keys without scopes should be refused, returned %d but expected %d.\n", status, http.StatusBadRequest)
+	}
+	status, respBody = serviceRequest(t, "POST", "/v1/authsession/apikey", session.Token, &ct.ApiKeyRequest{
+		Name:             "backup",
+		Scopes:           []string{"storage:read"},
+		AllowedAddresses: []string{"127.0.0.1"},
+	})
+	if status != http.StatusCreated {
+		t.Fatalf("Unable to create api key, returned %d but expected %d.\n", status, http.StatusCreated)
+	}
+	var created ct.ApiKeyResponse
+	err = json.Unmarshal(respBody, &created)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+	if created.Key == "" ||
+		created.Info.ID == "" {
+		t.Fatalf("Invalid api key: key and id should not be nil.\n")
+	}
+	// keys can not manage keys
+	status, _ = serviceRequest(t, "GET", "/v1/authsession/apikey", created.Key, nil)
+	if status != http.StatusBadRequest {
+		t.Fatalf("Api keys should not list keys, returned %d but expected %d.\n", status, http.StatusBadRequest)
+	}
+
+	// scopes
+	status, _ = serviceRequest(t, "GET", "/v1/storage/shared", created.Key, nil)
+	if status != http.StatusOK {
+		t.Fatalf("Key should be allowed to read, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+	status, _ = serviceRequest(t, "PUT", "/v1/storage/acl", created.Key, &ct.AclRequest{})
+	if status != http.StatusUnauthorized {
+		t.Fatalf("Key should not be allowed to share, returned %d but expected %d.\n", status, http.StatusUnauthorized)
+	}
+	status, _ = serviceRequest(t, "POST", "/v1/storage/job", created.Key, &ct.JobPostRequest{
+		Command: "DELETE",
+		Arguments: &ct.CommandArguments{
+			ResourceID: "0000",
+		},
+	})
+	if status != http.StatusForbidden {
+		t.Fatalf("Key should not be allowed to delete, returned %d but expected %d.\n", status, http.StatusForbidden)
+	}
+
+	// list
+	status, respBody = serviceRequest(t, "GET", "/v1/authsession/apikey", session.Token, nil)
+	if status != http.StatusOK {
+		t.Fatalf("Unable to list api keys, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+	var list ct.ApiKeysResponse
+	err = json.Unmarshal(respBody, &list)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+	if len(list.Keys) != 1 ||
+		list.Keys[0].ID != created.Info.ID {
+		t.Fatalf("Unexpected api keys list %v.\n", list.Keys)
+	}
+
+	// revoke
+	status, _ = serviceRequest(t, "DELETE", "/v1/authsession/apikey/"+created.Info.ID, session.Token, nil)
+	if status != http.StatusOK {
+		t.Fatalf("Unable to revoke api key, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+	status, _ = serviceRequest(t, "GET", "/v1/storage/shared", created.Key, nil)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("Revoked key should be refused, returned %d but expected %d.\n", status, http.StatusUnauthorized)
+	}
+}
+
+func TestPasswordManagement(t *testing.T) {
+	// wrong passwords must not lock out following tests
+	previous := loginLimiter
+	loginLimiter = ratelimit.NewLimiter(ratelimit.Config{
+		FreeAttempts: 100,
+	}, nil)
+	mock := authClient.(*authMock)
+	defer func() {
+		loginLimiter = previous
+		mock.credentials[mockUserInfo.Username] = mockUserPassword
+	}()
+
+	login := func(password string) (int, string) {
+		status, respBody := serviceRequest(t, "POST", "/v1/authsession", "", &ct.LoginRequest{
+			Username: mockUserInfo.Username,
+			Password: password,
+		})
+		var session ct.LoginResponse
+		json.Unmarshal(respBody, &session)
+		return status, session.Token
+	}
+	status, first := login(mockUserPassword)
+	if status != http.StatusOK {
+		t.Fatalf("Unable to login, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+	_, second := login(mockUserPassword)
+
+	// change
+	status, _ = serviceRequest(t, "PUT", "/v1/authsession/password", first, &ct.PasswordChangeRequest{
+		Password:    "wrong",
+		NewPassword: "newpassword",
+	})
+	if status != http.StatusUnauthorized {
+		t.Fatalf("Wrong password should be refused, returned %d but expected %d.\n", status, http.StatusUnauthorized)
+	}
+	status, _ = serviceRequest(t, "PUT", "/v1/authsession/password", first, &ct.PasswordChangeRequest{
+		Password:    mockUserPassword,
+		NewPassword: "newpassword",
+	})
+	if status != http.StatusOK {
+		t.Fatalf("Unable to change password, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+	status, _ = serviceRequest(t, "GET", "/v1/storage/shared", second, nil)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("Other sessions should be invalidated, returned %d but expected %d.\n", status, http.StatusUnauthorized)
+	}
+	if status, _ = login("newpassword"); status != http.StatusOK {
+		t.Fatalf("Unable to login with new password, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+
+	// reset
+	request := &ct.PasswordResetRequest{
+		Username: mockUserInfo.Username,
+	}
+	status, _ = serviceRequest(t, "POST", "/v1/authsession/password/reset", first, request)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("Common users should not reset passwords, returned %d but expected %d.\n", status, http.StatusUnauthorized)
+	}
+	adminToken, err := ct.RandomBytesForLen(32)
+	if err != nil {
+		t.Fatalf("Unable to generate token: %s.\n", err.Error())
+	}
+	mock.sessions[hex.EncodeToString(adminToken)] = &auth.UserInfoResponseArg{
+		Username: "admin",
+		Permissions: &auth.Permissions{
+			SuperAdmin: true,
+		},
+	}
+	status, _ = serviceRequest(t, "POST", "/v1/authsession/password/reset", hex.EncodeToString(adminToken), request)
+	if status != http.StatusOK {
+		t.Fatalf("Unable to reset password, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+	complete := &ct.PasswordResetCompleteRequest{
+		Username:    mockUserInfo.Username,
+		ResetToken:  "wrong",
+		NewPassword: "resetpassword",
+	}
+	status, _ = serviceRequest(t, "PUT", "/v1/authsession/password/reset", "", complete)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("Wrong reset token should be refused, returned %d but expected %d.\n", status, http.StatusUnauthorized)
+	}
+	complete.ResetToken = mockResetToken
+	status, _ = serviceRequest(t, "PUT", "/v1/authsession/password/reset", "", complete)
+	if status != http.StatusOK {
+		t.Fatalf("Unable to complete reset, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+	if status, _ = login("resetpassword"); status != http.StatusOK {
+		t.Fatalf("Unable to login with reset password, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+}
+
+func TestAdministration(t *testing.T) {
+	mock := authClient.(*authMock)
+	status, respBody := serviceRequest(t, "POST", "/v1/authsession", "", &ct.LoginRequest{
+		Username: mockUserInfo.Username,
+		Password: mockUserPassword,
+	})
+	if status != http.StatusOK {
+		t.Fatalf("Unable to login, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+	var session ct.LoginResponse
+	err := json.Unmarshal(respBody, &session)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+	status, _ = serviceRequest(t, "GET", "/v1/admin/users", session.Token, nil)
+	if status != http.StatusForbidden {
+		t.Fatalf("Common users should not administer, returned %d but expected %d.\n", status, http.StatusForbidden)
+	}
+	rawAdmin, err := ct.RandomBytesForLen(32)
+	if err != nil {
+		t.Fatalf("Unable to generate token: %s.\n", err.Error())
+	}
+	adminToken := hex.EncodeToString(rawAdmin)
+	mock.sessions[adminToken] = &auth.UserInfoResponseArg{
+		Username: "admin",
+		Permissions: &auth.Permissions{
+			SuperAdmin: true,
+		},
+	}
+
+	// add
+	user := &ct.AdminUser{
+		Username: "userB",
+		FullName: "User B",
+		Services: map[string]uint{
+			"storage": uint(auth.LevelAdmin),
+		},
+	}
+	status, _ = serviceRequest(t, "POST", "/v1/admin/users", adminToken, user)
+	if status != http.StatusBadRequest {
+		t.Fatalf("Users without password should be refused, returned %d but expected %d.\n", status, http.StatusBadRequest)
+	}
+	user.Password = "passwordB"
+	status, _ = serviceRequest(t, "POST", "/v1/admin/users", adminToken, user)
+	if status != http.StatusCreated {
+		t.Fatalf("Unable to add user, returned %d but expected %d.\n", status, http.StatusCreated)
+	}
+	status, _ = serviceRequest(t, "POST", "/v1/admin/users", adminToken, user)
+	if status != http.StatusConflict {
+		t.Fatalf("Existing users should not be added, returned %d but expected %d.\n", status, http.StatusConflict)
+	}
+
+	// update
+	user.Password = ""
+	user.Disabled = true
+	status, _ = serviceRequest(t, "PUT", "/v1/admin/users/userB", adminToken, user)
+	if status != http.StatusOK {
+		t.Fatalf("Unable to update user, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+	status, _ = serviceRequest(t, "PUT", "/v1/admin/users/unknown", adminToken, user)
+	if status != http.StatusNotFound {
+		t.Fatalf("Unknown users should not be updated, returned %d but expected %d.\n", status, http.StatusNotFound)
+	}
+	status, respBody = serviceRequest(t, "GET", "/v1/admin/users/userB", adminToken, nil)
+	if status != http.StatusOK {
+		t.Fatalf("Unable to get user, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+	var stored ct.AdminUser
+	err = json.Unmarshal(respBody, &stored)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+	if !stored.Disabled ||
+		stored.FullName != "User B" ||
+		stored.Services["storage"] != uint(auth.LevelAdmin) {
+		t.Fatalf("Unexpected user %v.\n", stored)
+	}
+
+	// list
+	status, respBody = serviceRequest(t, "GET", "/v1/admin/users", adminToken, nil)
+	if status != http.StatusOK {
+		t.Fatalf("Unable to list users, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+	var list ct.AdminUsersResponse
+	err = json.Unmarshal(respBody, &list)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+	if len(list.Users) != 2 {
+		t.Fatalf("Unexpected users list %v.\n", list.Users)
+	}
+
+	// remove
+	status, _ = serviceRequest(t, "DELETE", "/v1/admin/users/userB", adminToken, nil)
+	if status != http.StatusOK {
+		t.Fatalf("Unable to remove user, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+	status, _ = serviceRequest(t, "GET", "/v1/admin/users/userB", adminToken, nil)
+	if status != http.StatusNotFound {
+		t.Fatalf("Removed user should not be found, returned %d but expected %d.\n", status, http.StatusNotFound)
+	}
+
+	// kick out
+	status, _ = serviceRequest(t, "DELETE", "/v1/admin/sessions", adminToken, nil)
+	if status != http.StatusOK {
+		t.Fatalf("Unable to remove sessions, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+	status, _ = serviceRequest(t, "GET", "/v1/storage/shared", session.Token, nil)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("Sessions should be removed, returned %d but expected %d.\n", status, http.StatusUnauthorized)
+	}
+}
+
+func TestAdministrationPermissions(t *testing.T) {
+	mock := authClient.(*authMock)
+	session := func(username string, permissions *auth.Permissions) string {
+		raw, err := ct.RandomBytesForLen(32)
+		if err != nil {
+			t.Fatalf("Unable to generate token: %s.\n", err.Error())
+		}
+		token := hex.EncodeToString(raw)
+		mock.sessions[token] = &auth.UserInfoResponseArg{
+			Username:    username,
+			Permissions: permissions,
+		}
+		return token
+	}
+	userToken := session(mockUserInfo.Username, mockUserInfo.Permissions)
+	storageAdminToken := session("storageadmin", &auth.Permissions{
+		Services: map[string]auth.Level{
+			"storage": auth.LevelAdmin,
+		},
+	})
+	user := &ct.AdminUser{
+		Username: mockUserInfo.Username,
+		Password: "passwordX",
+		Services: map[string]uint{
+			"storage": uint(auth.LevelAdmin),
+		},
+	}
+	routes := []struct {
+		method string
+		path   string
+		body   interface{}
+	}{
+		{"GET", "/v1/admin/users", nil},
+		{"POST", "/v1/admin/users", user},
+		{"GET", "/v1/admin/users/" + mockUserInfo.Username, nil},
+		{"PUT", "/v1/admin/users/" + mockUserInfo.Username, user},
+		{"DELETE", "/v1/admin/users/" + mockUserInfo.Username, nil},
+		{"DELETE", "/v1/admin/sessions", nil},
+	}
+	for _, route := range routes {
+		// user level accounts are refused by the storage service
+		status, _ := serviceRequest(t, route.method, route.path, userToken, route.body)
+		if status != http.StatusForbidden {
+			t.Fatalf("%s %s: common users should not administer, returned %d but expected %d.\n", route.method, route.path, status, http.StatusForbidden)
+		}
+		// unauthorised tokens are refused
+		status, _ = serviceRequest(t, route.method, route.path, hex.EncodeToString([]byte("unknown")), route.body)
+		if status != http.StatusUnauthorized {
+			t.Fatalf("%s %s: unknown sessions should not administer, returned %d but expected %d.\n", route.method, route.path, status, http.StatusUnauthorized)
+		}
+	}
+	// storage administrators reach the auth service, that requires
+	// super-admins to manage users
+	status, _ := serviceRequest(t, "GET", "/v1/admin/users", storageAdminToken, nil)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("Users should be managed only by super-admins, returned %d but expected %d.\n", status, http.StatusUnauthorized)
+	}
+
+	// nothing changed
+	if _, ok := mock.sessions[userToken]; !ok {
+		t.Fatalf("Sessions should not be removed by common users.\n")
+	}
+	if password := mock.credentials[mockUserInfo.Username]; password != mockUserPassword {
+		t.Fatalf("Users should not be updated by common users.\n")
+	}
+	delete(mock.sessions, userToken)
+	delete(mock.sessions, storageAdminToken)
+}
+
+func TestUserSessions(t *testing.T) {
+	login := func() string {
+		status, respBody := serviceRequest(t, "POST", "/v1/authsession", "", &ct.LoginRequest{
+			Username: mockUserInfo.Username,
+			Password: mockUserPassword,
+		})
+		if status != http.StatusOK {
+			t.Fatalf("Unable to login, returned %d but expected %d.\n", status, http.StatusOK)
+		}
+		var session ct.LoginResponse
+		err := json.Unmarshal(respBody, &session)
+		if err != nil {
+			t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+		}
+		return session.Token
+	}
+	list := func(token string) []ct.SessionInfo {
+		status, respBody := serviceRequest(t, "GET", "/v1/authsession/sessions", token, nil)
+		if status != http.StatusOK {
+			t.Fatalf("Unable to list sessions, returned %d but expected %d.\n", status, http.StatusOK)
+		}
+		var sessions ct.SessionsResponse
+		err := json.Unmarshal(respBody, &sessions)
+		if err != nil {
+			t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+		}
+		return sessions.Sessions
+	}
+	current := login()
+	stolen := login()
+
+	var stolenId string
+	sessions := list(current)
+	for _, session := range sessions {
+		if session.Address == "" ||
+			session.UserAgent == "" {
+			t.Fatalf("Session client infos should be recorded: %v.\n", session)
+		}
+		if !session.Current &&
+			strings.HasPrefix(stolen, session.ID) {
+			stolenId = session.ID
+		}
+	}
+	if stolenId == "" {
+		t.Fatalf("Unable to find the session in %v.\n", sessions)
+	}
+
+	// revoke one
+	status, _ := serviceRequest(t, "DELETE", "/v1/authsession/sessions/0000000000000000", current, nil)
+	if status != http.StatusNotFound {
+		t.Fatalf("Unknown sessions should not be revoked, returned %d but expected %d.\n", status, http.StatusNotFound)
+	}
+	status, _ = serviceRequest(t, "DELETE", "/v1/authsession/sessions/"+stolenId, current, nil)
+	if status != http.StatusOK {
+		t.Fatalf("Unable to revoke session, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+	status, _ = serviceRequest(t, "GET", "/v1/authsession/sessions", stolen, nil)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("Revoked session should be refused, returned %d but expected %d.\n", status, http.StatusUnauthorized)
+	}
+
+	// revoke all the others
+	other := login()
+	status, respBody := serviceRequest(t, "DELETE", "/v1/authsession/sessions", current, nil)
+	if status != http.StatusOK {
+		t.Fatalf("Unable to revoke sessions, returned %d but expected %d.\n", status, http.StatusOK)
+	}
+	var revoked ct.SessionsRevokeResponse
+	err := json.Unmarshal(respBody, &revoked)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+	if revoked.Revoked < 1 {
+		t.Fatalf("Unexpected revoked sessions %d.\n", revoked.Revoked)
+	}
+	status, _ = serviceRequest(t, "GET", "/v1/authsession/sessions", other, nil)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("Revoked session should be refused, returned %d but expected %d.\n", status, http.StatusUnauthorized)
+	}
+	sessions = list(current)
+	if len(sessions) != 1 ||
+		!sessions[0].Current {
+		t.Fatalf("Only the requesting session should be preserved: %v.\n", sessions)
+	}
+}
+
+func TestServicePermissions(t *testing.T) {
+	rawToken, err := ct.RandomBytesForLen(32)
+	if err != nil {
+		t.Fatalf("Unable to generate token: %s.\n", err.Error())
+	}
+	token := hex.EncodeToString(rawToken)
+	mock := authClient.(*authMock)
+	defer delete(mock.sessions, token)
+	var testCases = []struct {
+		services map[string]auth.Level
+		status   int
+	}{
+		{nil, http.StatusForbidden},
+		{map[string]auth.Level{"ishtm": auth.LevelAdmin}, http.StatusForbidden},
+		{map[string]auth.Level{storageServiceName: auth.LevelUser}, http.StatusOK},
+		{map[string]auth.Level{auth.AllServices: auth.LevelUser}, http.StatusOK},
+	}
+	for idx, tc := range testCases {
+		mock.sessions[token] = &auth.UserInfoResponseArg{
+			Username: "userB",
+			Permissions: &auth.Permissions{
+				Services: tc.services,
+			},
+		}
+		status, _ := serviceRequest(t, "GET", "/v1/storage/usage", token, nil)
+		if status != tc.status {
+			t.Fatalf("Test case %d: having status %d expecting %d.\n", idx, status, tc.status)
+		}
+	}
+
+	// ishtm only accounts are refused on all the routes, but the
+	// public ones
+	mock.sessions[token] = &auth.UserInfoResponseArg{
+		Username: "userB",
+		Permissions: &auth.Permissions{
+			Services: map[string]auth.Level{
+				"ishtm": auth.LevelAdmin,
+			},
+		},
+	}
+	router, err := newRouter()
+	if err != nil {
+		t.Fatalf("Unable to create router: %s.\n", err.Error())
+	}
+	public := make(map[string]bool)
+	for _, name := range publicRoutes {
+		public[name] = true
+	}
+	variables := regexp.MustCompile(`{[^}]+}`)
+	type protectedRoute struct {
+		method string
+		path   string
+	}
+	var routes []protectedRoute
+	err = router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		path := variables.ReplaceAllString(template, "0a1b2c")
+		for _, method := range []string{"GET", "POST", "PUT", "PATCH", "DELETE"} {
+			req, err := http.NewRequest(method, path, nil)
+			if err != nil {
+				return err
+			}
+			var match mux.RouteMatch
+			if router.Match(req, &match) &&
+				match.Route == route &&
+				!public[route.GetName()] {
+				routes = append(routes, protectedRoute{method, path})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unable to walk routes: %s.\n", err.Error())
+	}
+	if len(routes) != 32 {
+		t.Fatalf("Having %d protected routes expecting 32.\n", len(routes))
+	}
+	for _, route := range routes {
+		status, _ := serviceRequest(t, route.method, route.path, token, nil)
+		if status != http.StatusForbidden {
+			t.Fatalf("Users without storage permissions should be refused on %s %s, having status %d expecting %d.\n", route.method, route.path, status, http.StatusForbidden)
+		}
+	}
+	if _, ok := mock.sessions[token]; !ok {
+		t.Fatalf("Refused requests should not reach handlers.\n")
+	}
+}
+
 func verifyJobCompletion(t *testing.T, jobID, token string, timeout time.Duration) []byte {
 	// create error chan
 	var errorCounter wq.AtomicCounter
@@ -386,6 +1218,7 @@ func TestStorageResourceFlow(t *testing.T) {
 		Arguments: &ct.CommandArguments{
 			ResourceID: fileID,
 			Data:       []byte(fileContent),
+			CheckSum:   fileContentCheckSum[:],
 			Permission: Public,
 		},
 	}
@@ -584,6 +1417,7 @@ func TestStorageGetResourceNotAuthenticated(t *testing.T) {
 		Arguments: &ct.CommandArguments{
 			ResourceID: fileID,
 			Data:       []byte(fileContent),
+			CheckSum:   fileContentCheckSum[:],
 			Permission: Public,
 		},
 	}
@@ -611,6 +1445,281 @@ func TestStorageGetResourceNotAuthenticated(t *testing.T) {
 	}
 }
 
+func TestStorageChunkRequestsValidation(t *testing.T) {
+	// Login the user
+	loginBody := ct.LoginRequest{
+		Username: mockUserInfo.Username,
+		Password: mockUserPassword,
+	}
+	body, err := json.Marshal(&loginBody)
+	if err != nil {
+		t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+	}
+
+	client := &http.Client{}
+	req, err := http.NewRequest(
+		"POST",
+		fmt.Sprintf("http://%s:%d/v1/authsession", mockServiceAddress, mockServicePort),
+		bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Unable to prepare the login request: %s.\n", err.Error())
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform login request on server: %s.\n", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unable to access login service, returned %d but expected %d.\n", resp.StatusCode, http.StatusOK)
+	}
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	var session ct.LoginResponse
+	err = json.Unmarshal(respBody, &session)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+	resp.Body.Close()
+
+	chunkURL := fmt.Sprintf("http://%s:%d/v1/storage/chunk/%s", mockServiceAddress, mockServicePort, "chunkvalidation")
+	checksum := sha256.Sum256([]byte(fileContent))
+	var testCases = []struct {
+		method   string
+		token    string
+		checksum string
+		status   int
+	}{
+		{"PUT", "e837ndiefh93h34", hex.EncodeToString(checksum[:]), http.StatusUnauthorized},
+		{"PUT", session.Token, "", http.StatusBadRequest},
+		{"PUT", session.Token, "zz", http.StatusBadRequest},
+		{"PUT", session.Token, hex.EncodeToString(checksum[:4]), http.StatusBadRequest},
+		{"GET", "e837ndiefh93h34", "", http.StatusUnauthorized},
+		{"GET", session.Token, "", http.StatusNotFound},
+	}
+	for idx, tc := range testCases {
+		req, err = http.NewRequest(
+			tc.method,
+			chunkURL,
+			bytes.NewBufferString(fileContent))
+		if err != nil {
+			t.Fatalf("Unable to prepare the chunk request: %s.\n", err.Error())
+		}
+		req.Header.Set(ct.SecurityTokenKey, tc.token)
+		req.Header.Set(ct.CheckSumKey, tc.checksum)
+		resp, err = client.Do(req)
+		if err != nil {
+			t.Fatalf("Unable to perform chunk request on server: %s.\n", err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Fatalf("Test case %d: having status %d expecting %d.\n", idx, resp.StatusCode, tc.status)
+		}
+	}
+
+	// already existing resource
+	err = db.SetFileLog(&FileLog{
+		Id: "chunkvalidation",
+		Ownership: Owner{
+			Username: mockUserInfo.Username,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unable to set file log: %s.\n", err.Error())
+	}
+	defer db.RemoveFileLog("chunkvalidation")
+	req, err = http.NewRequest(
+		"PUT",
+		chunkURL,
+		bytes.NewBufferString(fileContent))
+	if err != nil {
+		t.Fatalf("Unable to prepare the chunk request: %s.\n", err.Error())
+	}
+	req.Header.Set(ct.SecurityTokenKey, session.Token)
+	req.Header.Set(ct.CheckSumKey, hex.EncodeToString(checksum[:]))
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform chunk request on server: %s.\n", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("Having status %d expecting %d.\n", resp.StatusCode, http.StatusConflict)
+	}
+	// incomplete resources can not be downloaded
+	req, err = http.NewRequest(
+		"GET",
+		chunkURL,
+		nil)
+	if err != nil {
+		t.Fatalf("Unable to prepare the chunk request: %s.\n", err.Error())
+	}
+	req.Header.Set(ct.SecurityTokenKey, session.Token)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform chunk request on server: %s.\n", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Having status %d expecting %d.\n", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestStorageChunkFlow(t *testing.T) {
+	// Login the user
+	loginBody := ct.LoginRequest{
+		Username: mockUserInfo.Username,
+		Password: mockUserPassword,
+	}
+	body, err := json.Marshal(&loginBody)
+	if err != nil {
+		t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+	}
+
+	client := &http.Client{}
+	req, err := http.NewRequest(
+		"POST",
+		fmt.Sprintf("http://%s:%d/v1/authsession", mockServiceAddress, mockServicePort),
+		bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Unable to prepare the login request: %s.\n", err.Error())
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform login request on server: %s.\n", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unable to access login service, returned %d but expected %d.\n", resp.StatusCode, http.StatusOK)
+	}
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	var session ct.LoginResponse
+	err = json.Unmarshal(respBody, &session)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+	resp.Body.Close()
+
+	checksum := sha256.Sum256([]byte(fileContent))
+	chunkURL := fmt.Sprintf("http://%s:%d/v1/storage/chunk/%s", mockServiceAddress, mockServicePort, "chunkflow")
+	// corrupted upload
+	req, err = http.NewRequest(
+		"PUT",
+		chunkURL,
+		bytes.NewBufferString("corrupted content"))
+	if err != nil {
+		t.Fatalf("Unable to prepare the chunk request: %s.\n", err.Error())
+	}
+	req.Header.Set(ct.SecurityTokenKey, session.Token)
+	req.Header.Set(ct.CheckSumKey, hex.EncodeToString(checksum[:]))
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform chunk request on server: %s.\n", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Having status %d expecting %d.\n", resp.StatusCode, http.StatusBadRequest)
+	}
+	// wait for the async removal of the corrupted resource
+	for idx := 0; ; idx++ {
+		if _, err := db.GetFileLog("chunkflow"); err != nil {
+			break
+		}
+		if idx == 100 {
+			t.Fatalf("Corrupted resource has not been removed.\n")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// upload
+	req, err = http.NewRequest(
+		"PUT",
+		chunkURL,
+		bytes.NewBufferString(fileContent))
+	if err != nil {
+		t.Fatalf("Unable to prepare the chunk request: %s.\n", err.Error())
+	}
+	req.Header.Set(ct.SecurityTokenKey, session.Token)
+	req.Header.Set(ct.CheckSumKey, hex.EncodeToString(checksum[:]))
+	req.Header.Set(ct.PermissionKey, fmt.Sprintf("%d", Private))
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform chunk request on server: %s.\n", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Having status %d expecting %d.\n", resp.StatusCode, http.StatusCreated)
+	}
+	fl, err := db.GetFileLog("chunkflow")
+	if err != nil {
+		t.Fatalf("Unable to find file log: %s.\n", err.Error())
+	}
+	if fl.Complete != true ||
+		fl.Size != len(fileContent) ||
+		fl.Ownership.Username != mockUserInfo.Username {
+		t.Fatalf("Unexpected file log: %v.\n", fl)
+	}
+
+	// download
+	req, err = http.NewRequest(
+		"GET",
+		chunkURL,
+		nil)
+	if err != nil {
+		t.Fatalf("Unable to prepare the chunk request: %s.\n", err.Error())
+	}
+	req.Header.Set(ct.SecurityTokenKey, session.Token)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform chunk request on server: %s.\n", err.Error())
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Having status %d expecting %d.\n", resp.StatusCode, http.StatusOK)
+	}
+	if resp.Header.Get("Content-Type") != "application/octet-stream" ||
+		resp.Header.Get(ct.CheckSumKey) != hex.EncodeToString(checksum[:]) {
+		t.Fatalf("Unexpected response headers: %v.\n", resp.Header)
+	}
+	if string(data) != fileContent {
+		t.Fatalf("Downloaded data differs from uploaded one.\n")
+	}
+
+	// remove resource with the job API
+	jobDelete := ct.JobPostRequest{
+		Command: "DELETE",
+		Arguments: &ct.CommandArguments{
+			ResourceID: "chunkflow",
+		},
+	}
+	body, err = json.Marshal(&jobDelete)
+	if err != nil {
+		t.Fatalf("Unable to marshal request body: %s.\n", err.Error())
+	}
+	req, err = http.NewRequest(
+		"POST",
+		fmt.Sprintf("http://%s:%d/v1/storage/job", mockServiceAddress, mockServicePort),
+		bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Unable to prepare the storage/job request: %s.\n", err.Error())
+	}
+	req.Header.Set(ct.SecurityTokenKey, session.Token)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Unable to perform job request on server: %s.\n", err.Error())
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Unable to delete resource, returned %d but expected %d.\n", resp.StatusCode, http.StatusAccepted)
+	}
+	respBody, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	var jobDeleteResponse ct.JobPostResponse
+	err = json.Unmarshal(respBody, &jobDeleteResponse)
+	if err != nil {
+		t.Fatalf("Unable to unmarshal response body: %s.\n", err.Error())
+	}
+	verifyJobCompletion(t, jobDeleteResponse.JobID, session.Token, 30*time.Second)
+	if _, err := db.GetFileLog("chunkflow"); err == nil {
+		t.Fatalf("File log should be removed.\n")
+	}
+}
+
 func TestStorageUploadResourceDuplicated(t *testing.T) {
 	// Login the user
 	loginBody := ct.LoginRequest{
@@ -661,6 +1770,7 @@ func TestStorageUploadResourceDuplicated(t *testing.T) {
 		Arguments: &ct.CommandArguments{
 			ResourceID: fileID,
 			Data:       []byte(fileContent),
+			CheckSum:   fileContentCheckSum[:],
 			Permission: Public,
 		},
 	}
@@ -712,6 +1822,7 @@ func TestStorageUploadResourceDuplicated(t *testing.T) {
 		Arguments: &ct.CommandArguments{
 			ResourceID: fileID,
 			Data:       []byte(fileContent),
+			CheckSum:   fileContentCheckSum[:],
 			Permission: Public,
 		},
 	}
@@ -836,6 +1947,7 @@ func TestStorageDeleteJobDuplicated(t *testing.T) {
 		Arguments: &ct.CommandArguments{
 			ResourceID: fileID,
 			Data:       []byte(fileContent),
+			CheckSum:   fileContentCheckSum[:],
 			Permission: Public,
 		},
 	}
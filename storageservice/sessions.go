@@ -0,0 +1,102 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Internal libs
+import (
+	"github.com/nexocrew/3nigm4/lib/auth"
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+// Third party
+import (
+	"github.com/gorilla/mux"
+)
+
+// sessionInfo converts a session, as returned by the auth service,
+// in its REST API representation.
+func sessionInfo(session *auth.SessionInfo) ct.SessionInfo {
+	return ct.SessionInfo{
+		ID:         session.Id,
+		Address:    session.Address,
+		UserAgent:  session.UserAgent,
+		Login:      session.LoginTime,
+		LastSeen:   session.LastSeenTime,
+		Expiration: session.Expiration,
+		Current:    session.Current,
+	}
+}
+
+// listSessions returns, redirecting to auth service, the active
+// sessions of the session user.
+func listSessions(w http.ResponseWriter, r *http.Request) {
+	rawToken, err := sessionToken(r)
+	if err != nil {
+		riseError(http.StatusBadRequest,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	sessions, err := authClient.ListSessions(rawToken)
+	if err != nil {
+		riseError(http.StatusUnauthorized,
+			fmt.Sprintf("unable to list sessions: %s", err.Error()), w,
+			r.RemoteAddr)
+		return
+	}
+	response := ct.SessionsResponse{
+		Sessions: make([]ct.SessionInfo, 0, len(sessions)),
+	}
+	for idx := range sessions {
+		response.Sessions = append(response.Sessions, sessionInfo(&sessions[idx]))
+	}
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// revokeSessions removes, redirecting to auth service, the session
+// identified in the url or, if missing, all the session user
+// sessions except the requesting one.
+func revokeSessions(w http.ResponseWriter, r *http.Request) {
+	// get id from url, if any
+	id := mux.Vars(r)["sessionid"]
+	rawToken, err := sessionToken(r)
+	if err != nil {
+		riseError(http.StatusBadRequest,
+			err.Error(), w,
+			r.RemoteAddr)
+		return
+	}
+	revoked, err := authClient.RevokeSessions(rawToken, id, id == "")
+	if err != nil {
+		riseError(http.StatusNotFound,
+			fmt.Sprintf("unable to revoke sessions: %s", err.Error()), w,
+			r.RemoteAddr)
+		return
+	}
+	if arguments.verbose {
+		log.VerboseLog("Revoked %d sessions.\n", revoked)
+	}
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(
+		&ct.SessionsRevokeResponse{
+			Revoked: revoked,
+		})
+	if err != nil {
+		panic(err)
+	}
+}
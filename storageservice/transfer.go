@@ -0,0 +1,145 @@
+//
+// 3nigm4 storageservice package
+// Author: Guido Ronchetti <dyst0ni3@gmail.com>
+// v1.0 16/06/2016
+//
+
+package main
+
+// Golang std libs
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Internal libs
+import (
+	"github.com/nexocrew/3nigm4/lib/auth"
+	ct "github.com/nexocrew/3nigm4/lib/commons"
+)
+
+const (
+	// transferTokenValidity is the validity of the tokens, returned
+	// by batches, authorising streamed chunk transfers. Tokens are
+	// not revoked by logouts, that's why it should be kept short.
+	transferTokenValidity = 5 * time.Minute
+	// transferTokenDomain separates transfer tokens from download
+	// link ones, both signed with the link secret.
+	transferTokenDomain = "transfer."
+)
+
+// transferClaims are the properties signed in a transfer token: the
+// user's info are the ones returned by the auth service while
+// authorising the batch.
+type transferClaims struct {
+	Id         string                    `json:"id"`  // the resource id;
+	Command    string                    `json:"cmd"` // the authorised command (UPLOAD or DOWNLOAD);
+	UserInfo   *auth.UserInfoResponseArg `json:"usr"` // the authorised user's info;
+	Expiration int64                     `json:"exp"` // expiration time as unix time stamp.
+}
+
+// transferCommands maps the batched commands executed as streamed
+// transfers to the method of the chunk route transferring data.
+var transferCommands = map[string]string{
+	"UPLOAD":   "PUT",
+	"DOWNLOAD": "GET",
+}
+
+// createBatchTransfer authorises, as part of a batch, the streamed
+// transfer of a chunk returning the signed transfer token. Data are
+// never carried by the batch and should be streamed, passing the
+// token, on the chunk routes.
+func createBatchTransfer(r *http.Request, job *ct.JobPostRequest, userInfo *auth.UserInfoResponseArg) ct.BatchJobResult {
+	result := ct.BatchJobResult{
+		ResourceID: job.Arguments.ResourceID,
+	}
+	now := time.Now()
+	switch job.Command {
+	case "UPLOAD":
+		if len(job.Arguments.Data) != 0 {
+			result.Status = http.StatusBadRequest
+			result.Error = "batched uploads data should be streamed on the chunk route"
+			return result
+		}
+	case "DOWNLOAD":
+		// retain db
+		dbSession := db.Copy()
+		defer dbSession.Close()
+		fileLog, err := dbSession.GetFileLog(job.Arguments.ResourceID)
+		if err != nil ||
+			fileLog.Complete == false {
+			result.Status = http.StatusNotFound
+			result.Error = "requested file not found"
+			return result
+		}
+		if fileLogExpired(fileLog, now) {
+			result.Status = http.StatusGone
+			result.Error = "requested file is expired"
+			return result
+		}
+		if !checkAclPermission(userInfo, fileLog) {
+			recordAuditEvent(dbSession, fileLog, userInfo.Username, ct.AuditDownload, ct.AuditDenied, r.RemoteAddr)
+			result.Status = http.StatusForbidden
+			result.Error = "you are not authorised to access this resource"
+			return result
+		}
+	}
+
+	token, err := signToken(transferTokenDomain, &transferClaims{
+		Id:         job.Arguments.ResourceID,
+		Command:    job.Command,
+		UserInfo:   userInfo,
+		Expiration: now.Add(transferTokenValidity).Unix(),
+	})
+	if err != nil {
+		result.Status = http.StatusInternalServerError
+		result.Error = err.Error()
+		return result
+	}
+	result.Status = http.StatusOK
+	result.TransferToken = token
+	return result
+}
+
+// verifyTransferToken verifies the signature and the expiration of
+// a transfer token returning the signed claims.
+func verifyTransferToken(token string, now time.Time) (*transferClaims, error) {
+	var claims transferClaims
+	err := verifyToken(transferTokenDomain, token, &claims)
+	if err != nil {
+		return nil, fmt.Errorf("transfer %s", err.Error())
+	}
+	if !time.Unix(claims.Expiration, 0).After(now) {
+		return nil, fmt.Errorf("transfer token is expired")
+	}
+	if claims.UserInfo == nil {
+		return nil, fmt.Errorf("transfer token has no user info")
+	}
+	return &claims, nil
+}
+
+// authoriseChunkTransfer authorises a chunk request getting the
+// user's info: from the transfer token, if passed, otherwise from the
+// auth service using the session token. Transfer tokens are only
+// valid for the resource and the method they've been issued for and
+// api keys restrictions are verified again on the chunk request.
+func authoriseChunkTransfer(r *http.Request, id, scope string) (*auth.UserInfoResponseArg, error) {
+	token := r.Header.Get(ct.TransferTokenKey)
+	if token == "" {
+		return authoriseGettingUserInfos(r, scope)
+	}
+	claims, err := verifyTransferToken(token, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if claims.Id != id ||
+		transferCommands[claims.Command] != r.Method {
+		return nil, fmt.Errorf("transfer token not valid for the requested resource")
+	}
+	err = claims.UserInfo.Authorise(scope, r.RemoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	return claims.UserInfo, nil
+}
@@ -14,6 +14,7 @@ import (
 // Internal libs
 import (
 	ct "github.com/nexocrew/3nigm4/lib/commons"
+	"github.com/nexocrew/3nigm4/lib/ratelimit"
 )
 
 // Owner the file owner.
@@ -42,15 +43,62 @@ type Acl struct {
 // this record will be used, later on, to manage access to the file and
 // auto-remove policies.
 type FileLog struct {
-	Id         string        `bson:"id"`            // the file name assiciated with S3 saved data;
-	Size       int           `bson:"size"`          // the size of the uploaded data blob;
-	Bucket     string        `bson:"bucket"`        // the S3 bucket where data has been saved;
-	CheckSum   ct.CheckSum   `bson:"checksum"`      // checksum for the uploaded data;
-	Ownership  Owner         `bson:"ownership"`     // info related to the uploading user;
-	Acl        Acl           `bson:"acl"`           // access permissions;
-	Creation   time.Time     `bson:"creation_time"` // time of the upload;
-	TimeToLive time.Duration `bson:"ttl,omitempty"` // time to live for the uploaded file;
-	Complete   bool          `bson:"complete"`      // transaction completed.
+	Id         string        `bson:"id"`                   // the file name assiciated with S3 saved data;
+	Size       int           `bson:"size"`                 // the size of the uploaded data blob;
+	Bucket     string        `bson:"bucket"`               // the S3 bucket where data has been saved;
+	CheckSum   ct.CheckSum   `bson:"checksum"`             // checksum for the uploaded data;
+	Ownership  Owner         `bson:"ownership"`            // info related to the uploading user;
+	Acl        Acl           `bson:"acl"`                  // access permissions;
+	Creation   time.Time     `bson:"creation_time"`        // time of the upload;
+	TimeToLive time.Duration `bson:"ttl,omitempty"`        // time to live for the uploaded file;
+	Expiration time.Time     `bson:"expiration,omitempty"` // expiration time (creation + time to live), if any;
+	KeyVersion uint32        `bson:"keyversion,omitempty"` // master key version wrapping the data key (0 if not server side encrypted);
+	DataKey    []byte        `bson:"datakey,omitempty"`    // wrapped data key used to encrypt stored data;
+	Complete   bool          `bson:"complete"`             // transaction completed.
+}
+
+// DeletionLog records a resource removed by the expired files
+// reaper, it's used to keep track of server side deletions.
+type DeletionLog struct {
+	Id         string    `bson:"id"`              // the removed file name;
+	Bucket     string    `bson:"bucket"`          // the bucket where data was saved;
+	Owner      string    `bson:"owner"`           // the user who uploaded the file;
+	Size       int       `bson:"size"`            // the size of the removed data blob;
+	Creation   time.Time `bson:"creation_time"`   // time of the upload;
+	Expiration time.Time `bson:"expiration_time"` // time of expiration;
+	Deletion   time.Time `bson:"deletion_time"`   // time of the removal.
+}
+
+// Usage aggregates the storage resources allocated by a user:
+// pending uploads are accounted as well.
+type Usage struct {
+	Bytes   int64 `bson:"bytes"`   // sum of stored files size;
+	Objects int64 `bson:"objects"` // number of stored files.
+}
+
+// DownloadLink is a set of Public resources that can be downloaded,
+// without authentication, presenting a signed token until the link
+// expires. Single use links record the downloaded resources.
+type DownloadLink struct {
+	Id         string    `bson:"id"`             // the link id (signed in the token);
+	Owner      string    `bson:"owner"`          // the user who created the link;
+	Resources  []string  `bson:"resources"`      // ids of reachable resources;
+	Creation   time.Time `bson:"creation_time"`  // time of the link creation;
+	Expiration time.Time `bson:"expiration"`     // time of the link expiration;
+	SingleUse  bool      `bson:"singleuse"`      // each resource can be downloaded only once;
+	Used       []string  `bson:"used,omitempty"` // already downloaded resources (single use links).
+}
+
+// AuditEvent is an append-only record of an operation performed,
+// or denied, on a stored resource.
+type AuditEvent struct {
+	Resource string    `bson:"resource"`         // the target resource id;
+	Owner    string    `bson:"owner"`            // the resource owner;
+	User     string    `bson:"user,omitempty"`   // the caller, empty for anonymous link downloads;
+	Action   string    `bson:"action"`           // the performed operation (see commons audit actions);
+	Outcome  string    `bson:"outcome"`          // the operation outcome;
+	OriginIp string    `bson:"ipaddr,omitempty"` // the caller address;
+	Time     time.Time `bson:"time"`             // time of the event.
 }
 
 // AsyncTx is the structure used to temporarly manage async
@@ -64,8 +112,9 @@ type AsyncTx struct {
 	Error     error       `bson:"error,omitempty"`    // error setted on if a transaction error encountered;
 	Data      []byte      `bson:"data,omitempty"`     // data to be returned at the verify step;
 	CheckSum  ct.CheckSum `bson:"checksum,omitempty"` // checksum for the transaction returned data, if any;
+	Size      int         `bson:"size,omitempty"`     // size of the resource, returned by stat transactions;
 	Ownership Owner       `bson:"ownership"`          // info related to the uploading user;
-	TimeStamp time.Time   `bson:"ts"`                 // transaction creation time: tx records can survice at max n mins (see db implementation).
+	TimeStamp time.Time   `bson:"ts"`                 // transaction creation time: tx records can survice at max n mins (see job store implementations).
 }
 
 // Arguments management struct.
@@ -87,6 +136,15 @@ type args struct {
 	// auth rpc service
 	authServiceAddress string
 	authServicePort    int
+	authCA             string
+	authCertificate    string
+	authPrivateKey     string
+	authInsecure       bool
+	authPoolSize       int
+	authCacheSeconds   uint32
+	// storage backend
+	backend string
+	fsRoot  string
 	// s3 backend
 	s3Endpoint         string
 	s3Region           string
@@ -96,4 +154,24 @@ type args struct {
 	s3WorkingQueueSize int
 	s3QueueSize        int
 	s3Bucket           string
+	// expired files reaper
+	reaperScheduleMinutes uint32
+	// default users quotas
+	quotaBytes   int64
+	quotaObjects int64
+	// download links
+	linkSecret string
+	// audit log
+	auditRetentionDays uint32
+	// brute-force protection
+	limits ratelimit.Flags
+	// graceful shutdown
+	shutdownTimeoutSeconds uint32
+	// server side encryption
+	masterKeysFile string
+	// async jobs state
+	jobStore      string
+	redisAddress  string
+	redisPassword string
+	redisDb       int
 }
@@ -11,13 +11,14 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"sync"
 	"time"
 )
 
 // Internal dependencies
 import (
 	ct "github.com/nexocrew/3nigm4/lib/commons"
-	s3c "github.com/nexocrew/3nigm4/lib/s3"
+	sb "github.com/nexocrew/3nigm4/lib/storagebackend"
 )
 
 // generateTranscationId generate a randomised tx id to
@@ -44,7 +45,7 @@ func updateUploadRequestStatus(ur ct.OpResult) {
 	session := db.Copy()
 	defer session.Close()
 
-	at, err := session.GetAsyncTx(ur.RequestID)
+	at, err := jobs.GetAsyncTx(ur.RequestID)
 	if err != nil {
 		log.ErrorLog("Retrieving tx async doc %s produced error %s, ignoring.\n", ur.RequestID, err.Error())
 		return
@@ -55,13 +56,31 @@ func updateUploadRequestStatus(ur ct.OpResult) {
 		return
 	}
 
+	// re-verify data stored in the backend
+	if ur.Error == nil {
+		err = verifyStoredChunk(fl)
+		if err != nil {
+			log.ErrorLog("Uploaded resource %s discarded: %s.\n", fl.Id, err.Error())
+			at.Complete = true
+			at.Error = fmt.Errorf("unable to verify stored resource: %s", err.Error())
+			err = jobs.UpdateAsyncTx(at)
+			if err != nil {
+				log.ErrorLog("Unable to update %s tx async doc cause %s, ignoring.\n", at.Id, err.Error())
+			}
+			now := time.Now()
+			discardChunk(fl, &now)
+			recordAuditEvent(session, fl, at.Ownership.Username, ct.AuditUpload, ct.AuditFailure, at.Ownership.OriginIp)
+			return
+		}
+	}
+
 	// update status
 	at.Complete = true
 	fl.Complete = true
 	at.Error = ur.Error
 
-	// update in the db
-	err = session.UpdateAsyncTx(at)
+	// update in the job store
+	err = jobs.UpdateAsyncTx(at)
 	if err != nil {
 		log.ErrorLog("Unable to update %s tx async doc cause %s, ignoring.\n", at.Id, err.Error())
 		return
@@ -71,6 +90,7 @@ func updateUploadRequestStatus(ur ct.OpResult) {
 		log.ErrorLog("Unable to update %s log doc cause %s, ignoring.\n", at.Id, err.Error())
 		return
 	}
+	recordAuditEvent(session, fl, at.Ownership.Username, ct.AuditUpload, asyncOutcome(ur.Error), at.Ownership.OriginIp)
 }
 
 // updateDownloadRequestStatus manage workingqueue messages from
@@ -79,28 +99,47 @@ func updateDownloadRequestStatus(dr ct.OpResult) {
 	session := db.Copy()
 	defer session.Close()
 
-	at, err := session.GetAsyncTx(dr.RequestID)
+	at, err := jobs.GetAsyncTx(dr.RequestID)
 	if err != nil {
 		log.ErrorLog("Retrieving tx async doc %s produced error %s, ignoring.\n", dr.RequestID, err.Error())
 		return
 	}
 
+	// decrypt data, if required
+	fl, flErr := session.GetFileLog(dr.ID)
+	data := dr.Data
+	if flErr == nil &&
+		dr.Error == nil {
+		key, err := dataKey(fl)
+		if err == nil {
+			data, err = decryptData(key, dr.Data)
+		}
+		if err != nil {
+			log.ErrorLog("Unable to decrypt %s resource: %s.\n", dr.ID, err.Error())
+			data = nil
+			dr.Error = err
+		}
+	}
+
 	// update status
 	at.Complete = true
 	at.Error = dr.Error
-	at.Data = dr.Data
+	at.Data = data
 	hash := sha256.Sum256(at.Data)
 	at.CheckSum = ct.CheckSum{
 		Hash: hash[:],
 		Type: "SHA256",
 	}
 
-	// update in the db
-	err = session.UpdateAsyncTx(at)
+	// update in the job store
+	err = jobs.UpdateAsyncTx(at)
 	if err != nil {
 		log.ErrorLog("Unable to update %s tx async doc cause %s, ignoring.\n", at.Id, err.Error())
 		return
 	}
+	if flErr == nil {
+		recordAuditEvent(session, fl, at.Ownership.Username, ct.AuditDownload, asyncOutcome(dr.Error), at.Ownership.OriginIp)
+	}
 }
 
 // updateDeleteRequestStatus update status related to an async
@@ -109,25 +148,72 @@ func updateDeleteRequestStatus(dr ct.OpResult) {
 	session := db.Copy()
 	defer session.Close()
 
-	at, err := session.GetAsyncTx(dr.RequestID)
+	at, err := jobs.GetAsyncTx(dr.RequestID)
 	if err != nil {
 		log.ErrorLog("Retrieving tx async doc %s produced error %s, ignoring.\n", dr.RequestID, err.Error())
 		return
 	}
 
-	// delete file record in db
-	err = session.RemoveFileLog(dr.ID)
-	if err != nil {
-		log.ErrorLog("Unable to remove required %s doc from the database cause %s, continuing", dr.ID, err.Error())
-		// this error will not block the operation (cause the file on S3 has been already deleted).
+	// files removed by the reaper are recorded in the
+	// deletion log, the other ones in the audit log
+	fl, _ := session.GetFileLog(dr.ID)
+
+	// delete file record in db, only if the file has been removed
+	// from the backend: otherwise it's retained to retry the
+	// deletion (the reaper retries it at the next run).
+	if dr.Error == nil {
+		err = session.RemoveFileLog(dr.ID)
+		if err != nil {
+			log.ErrorLog("Unable to remove required %s doc from the database cause %s, continuing", dr.ID, err.Error())
+			// this error will not block the operation (cause the file on S3 has been already deleted).
+		}
+	}
+
+	if at.Ownership.Username == reaperUsername {
+		recordReapedFile(session, at, fl, dr)
+		return
+	}
+	if fl != nil {
+		recordAuditEvent(session, fl, at.Ownership.Username, ct.AuditDelete, asyncOutcome(dr.Error), at.Ownership.OriginIp)
 	}
 
 	// update status
 	at.Complete = true
 	at.Error = dr.Error
 
-	// update in the db
-	err = session.UpdateAsyncTx(at)
+	// update in the job store
+	err = jobs.UpdateAsyncTx(at)
+	if err != nil {
+		log.ErrorLog("Unable to update %s tx async doc cause %s, ignoring.\n", at.Id, err.Error())
+		return
+	}
+}
+
+// updateStatRequestStatus update status related to an async
+// stat operation.
+func updateStatRequestStatus(sr ct.OpResult) {
+	session := db.Copy()
+	defer session.Close()
+
+	at, err := jobs.GetAsyncTx(sr.RequestID)
+	if err != nil {
+		log.ErrorLog("Retrieving tx async doc %s produced error %s, ignoring.\n", sr.RequestID, err.Error())
+		return
+	}
+
+	// update status
+	at.Complete = true
+	at.Error = sr.Error
+	if sr.Error == nil {
+		// the file log size ignores the encryption overhead
+		fl, err := session.GetFileLog(sr.ID)
+		if err == nil {
+			at.Size = fl.Size
+		}
+	}
+
+	// update in the job store
+	err = jobs.UpdateAsyncTx(at)
 	if err != nil {
 		log.ErrorLog("Unable to update %s tx async doc cause %s, ignoring.\n", at.Id, err.Error())
 		return
@@ -142,10 +228,24 @@ func manageAsyncError(err error) {
 	log.ErrorLog("Error while uploading with S3 working queue: %s.\n", err.Error())
 }
 
-// manageS3chans manages chan messages from working queue
-// async S3 upload/download.
-func manageS3chans(s3backend *s3c.Session) {
-	var errcClosed, uploadedcClosed, downloadedcClosed, deletedcClosed bool
+// asyncUpdates tracks in progress async tx updates: graceful
+// shutdown waits for them before closing the database.
+var asyncUpdates sync.WaitGroup
+
+// trackUpdate executes an async tx update tracking its completion.
+func trackUpdate(update func(ct.OpResult), result ct.OpResult) {
+	asyncUpdates.Add(1)
+	go func() {
+		defer asyncUpdates.Done()
+		update(result)
+	}()
+}
+
+// manageBackendChans manages chan messages from working queue
+// async backend upload/download. When quit is closed results
+// already produced are processed before returning.
+func manageBackendChans(results *sb.Results, quit <-chan struct{}) {
+	var errcClosed, uploadedcClosed, downloadedcClosed, deletedcClosed, statcClosed bool
 	for {
 		if errcClosed == true {
 			log.CriticalLog("S3 error chan is closed, unable to proceed managing chan queue.\n")
@@ -163,32 +263,81 @@ func manageS3chans(s3backend *s3c.Session) {
 			log.CriticalLog("S3 delete chan is closed, unable to proceed managing chan queue.\n")
 			return
 		}
+		if statcClosed == true {
+			log.CriticalLog("Backend stat chan is closed, unable to proceed managing chan queue.\n")
+			return
+		}
 		// select on channels
 		select {
-		case err, errcOk := <-s3backend.ErrorChan:
+		case err, errcOk := <-results.ErrorChan:
 			if !errcOk {
 				errcClosed = true
 			} else {
 				go manageAsyncError(err)
 			}
-		case uploaded, uploadedcOk := <-s3backend.UploadedChan:
+		case uploaded, uploadedcOk := <-results.UploadedChan:
 			if !uploadedcOk {
 				uploadedcClosed = true
 			} else {
-				go updateUploadRequestStatus(uploaded)
+				trackUpdate(updateUploadRequestStatus, uploaded)
 			}
-		case downloaded, downloadedcOk := <-s3backend.DownloadedChan:
+		case downloaded, downloadedcOk := <-results.DownloadedChan:
 			if !downloadedcOk {
 				downloadedcClosed = true
 			} else {
-				go updateDownloadRequestStatus(downloaded)
+				trackUpdate(updateDownloadRequestStatus, downloaded)
 			}
-		case deleted, deletedcOk := <-s3backend.DeletedChan:
+		case deleted, deletedcOk := <-results.DeletedChan:
 			if !deletedcOk {
 				deletedcClosed = true
 			} else {
-				go updateDeleteRequestStatus(deleted)
+				trackUpdate(updateDeleteRequestStatus, deleted)
+			}
+		case stated, statcOk := <-results.StatChan:
+			if !statcOk {
+				statcClosed = true
+			} else {
+				trackUpdate(updateStatRequestStatus, stated)
 			}
+		case <-quit:
+			flushBackendChans(results)
+			return
+		}
+	}
+}
+
+// flushBackendChans processes, without blocking, results still
+// buffered in the backend chans (closed chans stop the flush).
+func flushBackendChans(results *sb.Results) {
+	for {
+		select {
+		case err, ok := <-results.ErrorChan:
+			if !ok {
+				return
+			}
+			manageAsyncError(err)
+		case uploaded, ok := <-results.UploadedChan:
+			if !ok {
+				return
+			}
+			trackUpdate(updateUploadRequestStatus, uploaded)
+		case downloaded, ok := <-results.DownloadedChan:
+			if !ok {
+				return
+			}
+			trackUpdate(updateDownloadRequestStatus, downloaded)
+		case deleted, ok := <-results.DeletedChan:
+			if !ok {
+				return
+			}
+			trackUpdate(updateDeleteRequestStatus, deleted)
+		case stated, ok := <-results.StatChan:
+			if !ok {
+				return
+			}
+			trackUpdate(updateStatRequestStatus, stated)
+		default:
+			return
 		}
 	}
 }